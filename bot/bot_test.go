@@ -0,0 +1,249 @@
+package bot
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+)
+
+type testAction struct {
+	name  string
+	label string
+}
+
+type testApproval struct {
+	message string
+}
+
+type testExecutor struct{}
+
+// testCommand replies with text, attachments and actions and records params of every execution
+type testCommand struct {
+	name        string
+	aliases     []string
+	permissions bool
+	approval    common.Approval
+	text        string
+	attachments []*common.Attachment
+	actions     []common.Action
+	fields      []common.Field
+	mutex       sync.Mutex
+	executed    []common.ExecuteParams
+	triggered   []string
+}
+
+type testProcessor struct {
+	name     string
+	commands []common.Command
+}
+
+func (a *testAction) Name() string {
+	return a.name
+}
+
+func (a *testAction) Label() string {
+	return a.label
+}
+
+func (a *testAction) Template() string {
+	return ""
+}
+
+func (a *testAction) Style() string {
+	return ""
+}
+
+func (a *testApproval) Channel(bot common.Bot, message common.Message, params common.ExecuteParams) string {
+	return ""
+}
+
+func (a *testApproval) Message(bot common.Bot, message common.Message, params common.ExecuteParams) string {
+	return a.message
+}
+
+func (a *testApproval) Reasons() []string {
+	return []string{}
+}
+
+func (a *testApproval) Description() bool {
+	return false
+}
+
+func (a *testApproval) Visible() bool {
+	return true
+}
+
+func (e *testExecutor) Response() common.Response {
+	return nil
+}
+
+func (e *testExecutor) After(message common.Message) error {
+	return nil
+}
+
+func (c *testCommand) Name() string {
+	return c.name
+}
+
+func (c *testCommand) Group() string {
+	return ""
+}
+
+func (c *testCommand) Description() string {
+	return c.name
+}
+
+func (c *testCommand) Params() []string {
+	return []string{}
+}
+
+func (c *testCommand) Aliases() []string {
+	return c.aliases
+}
+
+func (c *testCommand) Confirmation(params common.ExecuteParams) string {
+	return ""
+}
+
+func (c *testCommand) Priority() int {
+	return 0
+}
+
+func (c *testCommand) Wrapper() bool {
+	return false
+}
+
+func (c *testCommand) Schedule() string {
+	return ""
+}
+
+func (c *testCommand) Channel() string {
+	return ""
+}
+
+func (c *testCommand) Response() common.Response {
+	return nil
+}
+
+func (c *testCommand) Actions() []common.Action {
+	return c.actions
+}
+
+func (c *testCommand) Approval() common.Approval {
+	return c.approval
+}
+
+func (c *testCommand) Permissions() bool {
+	return c.permissions
+}
+
+func (c *testCommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.executed = append(c.executed, params)
+	if action != nil {
+		c.triggered = append(c.triggered, action.Name())
+		return &testExecutor{}, "triggered " + action.Name(), nil, nil, nil
+	}
+	return &testExecutor{}, c.text, c.attachments, nil, nil
+}
+
+func (c *testCommand) Fields(bot common.Bot, message common.Message, params common.ExecuteParams, eval []string) []common.Field {
+	return c.fields
+}
+
+func (c *testCommand) calls() ([]common.ExecuteParams, []string) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.executed, c.triggered
+}
+
+func (p *testProcessor) Name() string {
+	return p.name
+}
+
+func (p *testProcessor) Commands() []common.Command {
+	return p.commands
+}
+
+func testObservability() *common.Observability {
+	return common.NewObservability(sreCommon.NewLogs(), sreCommon.NewMetrics())
+}
+
+// testProcessors puts commands into group processor
+func testProcessors(group string, commands ...*testCommand) *common.Processors {
+
+	list := []common.Command{}
+	for _, c := range commands {
+		list = append(list, c)
+	}
+	processors := common.NewProcessors()
+	processors.Add(&testProcessor{name: group, commands: list})
+	return processors
+}
+
+// testWait polls until done is true, for bots handling requests in background
+func testWait(t *testing.T, done func() bool) {
+
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for bot")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testJWKS publishes public part of key under kid
+func testJWKS(key *rsa.PrivateKey, kid string) []byte {
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	return b
+}
+
+// testJWT signs claims with RS256 like token issuers do
+func testJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
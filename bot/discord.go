@@ -0,0 +1,1845 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/jinzhu/copier"
+)
+
+type DiscordOptions struct {
+	BotToken        string
+	GuildID         string
+	Debug           bool
+	DefaultCommand  string
+	UserPermissions string
+	ApprovalAny     bool
+
+	ReactionDoing    string
+	ReactionDone     string
+	ReactionFailed   string
+	ReactionForm     string
+	ReactionApproval string
+
+	ButtonFormCaption    string
+	ButtonCancelCaption  string
+	ButtonApproveCaption string
+	ButtonRejectCaption  string
+
+	FormCancelled string
+	CacheTTL      string
+}
+
+type DiscordMessageKey struct {
+	channelID   string
+	messageID   string
+	referenceID string
+}
+
+type DiscordUser struct {
+	id       string
+	name     string
+	timezone string
+	commands []string
+}
+
+type DiscordChannel struct {
+	id string
+}
+
+type DiscordMessage struct {
+	discord   *Discord
+	cmdText   string
+	cmd       common.Command
+	originKey *DiscordMessageKey
+	key       *DiscordMessageKey
+	user      *DiscordUser
+	caller    *DiscordUser
+	visible   bool
+	text      string
+	actions   []common.Action
+	params    common.ExecuteParams
+	fields    []common.Field
+}
+
+type DiscordForm struct {
+	message *DiscordMessage
+	fields  []common.Field
+	params  common.ExecuteParams
+}
+
+// slash command registered for a processor command
+type DiscordCommand struct {
+	group   string
+	name    string
+	options map[string]string
+}
+
+type Discord struct {
+	options    DiscordOptions
+	processors *common.Processors
+	session    *discordgo.Session
+	logger     sreCommon.Logger
+	meter      sreCommon.Meter
+	messages   *ttlcache.Cache[string, *DiscordMessage]
+	forms      *ttlcache.Cache[string, *DiscordForm]
+	commands   map[string]*DiscordCommand
+}
+
+const (
+	discordMaxTextLength        = 2000
+	discordTrimmed              = "...trimmed"
+	discordMaxNameLength        = 32
+	discordMaxDescriptionLength = 100
+	discordMaxOptions           = 25
+	discordMaxButtonsInRow      = 5
+	discordMaxRows              = 5
+	discordMaxModalInputs       = 5
+	discordMaxModalTitle        = 45
+	discordMaxLabelLength       = 45
+	discordMaxPlaceholderLength = 100
+	discordActionButtonType     = "a"
+	discordApprovalButtonType   = "p"
+	discordFormButtonType       = "fb"
+	discordFormCancelType       = "fc"
+	discordFormModalType        = "f"
+	discordApprovalSubmit       = "approve"
+	discordApprovalCancel       = "reject"
+	discordDateFormat           = "2006-01-02"
+	discordTimeFormat           = "15:04"
+)
+
+var discordNameRegex = regexp.MustCompile(`[^a-z0-9_-]+`)
+var discordMentionRegex = regexp.MustCompile(`<@!?[0-9]+>`)
+
+// DiscordUser
+
+func (du *DiscordUser) ID() string {
+	return du.id
+}
+
+func (du *DiscordUser) Name() string {
+	return du.name
+}
+
+func (du *DiscordUser) TimeZone() string {
+	return du.timezone
+}
+
+func (du *DiscordUser) Commands() []string {
+	return du.commands
+}
+
+// DiscordChannel
+
+func (dc *DiscordChannel) ID() string {
+	return dc.id
+}
+
+// DiscordMessage
+
+func (dm *DiscordMessage) ID() string {
+	if dm.key == nil {
+		return ""
+	}
+	return dm.key.messageID
+}
+
+func (dm *DiscordMessage) Visible() bool {
+	return dm.visible
+}
+
+func (dm *DiscordMessage) User() common.User {
+	return dm.user
+}
+
+func (dm *DiscordMessage) Caller() common.User {
+	return dm.caller
+}
+
+func (dm *DiscordMessage) userID() string {
+	u := dm.user
+	if u == nil {
+		return ""
+	}
+	return u.id
+}
+
+func (dm *DiscordMessage) Channel() common.Channel {
+	if dm.key == nil {
+		return nil
+	}
+	return &DiscordChannel{id: dm.key.channelID}
+}
+
+func (dm *DiscordMessage) ParentID() string {
+	if dm.key == nil {
+		return ""
+	}
+	return dm.key.referenceID
+}
+
+func (dm *DiscordMessage) SetParentID(referenceID string) {
+	if dm.key == nil {
+		return
+	}
+	dm.key.referenceID = referenceID
+}
+
+// DiscordMessageKey
+
+func (dmk *DiscordMessageKey) String() string {
+	return fmt.Sprintf("%s/%s", dmk.channelID, dmk.messageID)
+}
+
+// Discord
+
+func (d *Discord) Name() string {
+	return "Discord"
+}
+
+func (d *Discord) findMessageInCache(key *DiscordMessageKey) *DiscordMessage {
+
+	if key == nil {
+		return nil
+	}
+	item := d.messages.Get(key.String())
+	if item != nil {
+		return item.Value()
+	}
+	return nil
+}
+
+func (d *Discord) putMessageToCache(m *DiscordMessage) {
+
+	if m.key == nil || utils.IsEmpty(m.key.messageID) {
+		return
+	}
+	d.messages.Set(m.key.String(), m, ttlcache.DefaultTTL)
+}
+
+func (d *Discord) cloneMessage(m *DiscordMessage) *DiscordMessage {
+
+	if m == nil {
+		return nil
+	}
+	r := &DiscordMessage{}
+	err := copier.Copy(r, m)
+	if err != nil {
+		d.logger.Error("Discord message copy error: %s", err)
+		return nil
+	}
+	return r
+}
+
+func (d *Discord) encodeCustomID(typ, name string) string {
+	return fmt.Sprintf("%s|%s", typ, name)
+}
+
+func (d *Discord) decodeCustomID(data string) (string, string) {
+
+	arr := strings.SplitN(data, "|", 2)
+	if len(arr) < 2 {
+		return "", ""
+	}
+	return arr[0], arr[1]
+}
+
+func (d *Discord) buttonStyle(style string) discordgo.ButtonStyle {
+
+	switch style {
+	case "primary":
+		return discordgo.PrimaryButton
+	case "danger":
+		return discordgo.DangerButton
+	}
+	return discordgo.SecondaryButton
+}
+
+func (d *Discord) buildRows(buttons []discordgo.MessageComponent) []discordgo.MessageComponent {
+
+	rows := []discordgo.MessageComponent{}
+	for i := 0; i < len(buttons) && len(rows) < discordMaxRows; i += discordMaxButtonsInRow {
+		end := i + discordMaxButtonsInRow
+		if end > len(buttons) {
+			end = len(buttons)
+		}
+		rows = append(rows, discordgo.ActionsRow{Components: buttons[i:end]})
+	}
+	return rows
+}
+
+func (d *Discord) buildComponents(actions []common.Action) []discordgo.MessageComponent {
+
+	buttons := []discordgo.MessageComponent{}
+	for _, a := range actions {
+
+		name := a.Name()
+		if utils.IsEmpty(name) {
+			continue
+		}
+		label := a.Label()
+		if utils.IsEmpty(label) {
+			label = name
+		}
+		buttons = append(buttons, discordgo.Button{
+			Label:    label,
+			Style:    d.buttonStyle(a.Style()),
+			CustomID: d.encodeCustomID(discordActionButtonType, name),
+		})
+	}
+	return d.buildRows(buttons)
+}
+
+func (d *Discord) buildMessageSend(message string, attachments []*common.Attachment, actions []common.Action) *discordgo.MessageSend {
+
+	text := message
+	files := []*discordgo.File{}
+
+	for _, a := range attachments {
+
+		switch a.Type {
+		case common.AttachmentTypeImage, common.AttachmentTypeFile:
+			name := a.Title
+			if utils.IsEmpty(name) {
+				name = fmt.Sprintf("%s.bin", common.UUID())
+				if a.Type == common.AttachmentTypeImage {
+					name = fmt.Sprintf("%s.png", common.UUID())
+				}
+			}
+			files = append(files, &discordgo.File{
+				Name:        name,
+				ContentType: http.DetectContentType(a.Data),
+				Reader:      bytes.NewReader(a.Data),
+			})
+		default:
+			if !utils.IsEmpty(a.Title) {
+				text = fmt.Sprintf("%s\n**%s**", text, a.Title)
+			}
+			text = fmt.Sprintf("%s\n```\n%s\n```", text, string(a.Data))
+		}
+	}
+
+	return &discordgo.MessageSend{
+		Content:    common.LimitText(strings.TrimSpace(text), discordMaxTextLength, discordTrimmed),
+		Components: d.buildComponents(actions),
+		Files:      files,
+	}
+}
+
+func (d *Discord) send(channelID, referenceID string, data *discordgo.MessageSend) (*DiscordMessageKey, string, error) {
+
+	if !utils.IsEmpty(referenceID) {
+		data.Reference = &discordgo.MessageReference{
+			MessageID: referenceID,
+			ChannelID: channelID,
+		}
+	}
+
+	msg, err := d.session.ChannelMessageSendComplex(channelID, data)
+	if err != nil {
+		return nil, "", err
+	}
+	return &DiscordMessageKey{
+		channelID:   msg.ChannelID,
+		messageID:   msg.ID,
+		referenceID: referenceID,
+	}, msg.Content, nil
+}
+
+func (d *Discord) reply(m *DiscordMessage, message string, attachments []*common.Attachment, actions []common.Action,
+	response *common.BotResponse, start *time.Time, error bool) (*DiscordMessageKey, string, error) {
+
+	if m.key == nil {
+		return nil, "", fmt.Errorf("Discord message has no channel")
+	}
+
+	text := message
+	if !utils.IsEmpty(response) {
+
+		if response.Original() && !utils.IsEmpty(m.cmdText) {
+			user := ""
+			if m.user != nil {
+				user = fmt.Sprintf("<@%s> ", m.user.id)
+			}
+			text = fmt.Sprintf("> %s%s\n%s", user, m.cmdText, text)
+		}
+
+		if response.Duration() && start != nil && !error {
+			elapsed := time.Since(*start)
+			text = fmt.Sprintf("[%s] %s", elapsed.Round(time.Millisecond), text)
+		}
+	}
+
+	if error {
+		text = fmt.Sprintf("%s %s", d.options.ReactionFailed, text)
+		attachments = nil
+		actions = nil
+	}
+
+	return d.send(m.key.channelID, m.key.messageID, d.buildMessageSend(text, attachments, actions))
+}
+
+func (d *Discord) replyError(m *DiscordMessage, err error) {
+
+	d.logger.Error("Discord reply error: %s", err)
+	_, _, err = d.reply(m, err.Error(), nil, nil, nil, nil, true)
+	if err != nil {
+		d.logger.Error("Discord couldn't reply error: %s", err)
+	}
+}
+
+func (d *Discord) addReaction(key *DiscordMessageKey, name string) {
+
+	if key == nil || utils.IsEmpty(key.messageID) || utils.IsEmpty(name) {
+		return
+	}
+	err := d.AddReaction(key.channelID, key.messageID, name)
+	if err != nil {
+		d.logger.Error("Discord adding reaction error: %s", err)
+	}
+}
+
+func (d *Discord) removeReaction(key *DiscordMessageKey, name string) {
+
+	if key == nil || utils.IsEmpty(key.messageID) || utils.IsEmpty(name) {
+		return
+	}
+	err := d.RemoveReaction(key.channelID, key.messageID, name)
+	if err != nil {
+		d.logger.Error("Discord removing reaction error: %s", err)
+	}
+}
+
+func (d *Discord) addRemoveReactions(key *DiscordMessageKey, first, second string) {
+	d.addReaction(key, first)
+	d.removeReaction(key, second)
+}
+
+func (d *Discord) AddReaction(channel, ID, name string) error {
+	return d.session.MessageReactionAdd(channel, ID, name)
+}
+
+func (d *Discord) RemoveReaction(channel, ID, name string) error {
+	return d.session.MessageReactionRemove(channel, ID, name, "@me")
+}
+
+func (d *Discord) updateActions(channel, ID string, update func(m *DiscordMessage) []common.Action) error {
+
+	key := &DiscordMessageKey{
+		channelID: channel,
+		messageID: ID,
+	}
+
+	m := d.findMessageInCache(key)
+	if m == nil {
+		err := fmt.Errorf("Discord message not found in %s with %s", channel, ID)
+		d.logger.Error(err)
+		return err
+	}
+
+	m.actions = update(m)
+	d.putMessageToCache(m)
+
+	components := d.buildComponents(m.actions)
+	edit := discordgo.NewMessageEdit(channel, ID)
+	edit.Components = &components
+
+	_, err := d.session.ChannelMessageEditComplex(edit)
+	return err
+}
+
+func (d *Discord) AddAction(channel, ID string, action common.Action) error {
+
+	return d.updateActions(channel, ID, func(m *DiscordMessage) []common.Action {
+		return append(m.actions, action)
+	})
+}
+
+func (d *Discord) AddActions(channel, ID string, actions []common.Action) error {
+
+	return d.updateActions(channel, ID, func(m *DiscordMessage) []common.Action {
+		return append(m.actions, actions...)
+	})
+}
+
+func (d *Discord) RemoveAction(channel, ID, name string) error {
+
+	return d.updateActions(channel, ID, func(m *DiscordMessage) []common.Action {
+		actions := []common.Action{}
+		for _, a := range m.actions {
+			if a.Name() == name {
+				continue
+			}
+			actions = append(actions, a)
+		}
+		return actions
+	})
+}
+
+func (d *Discord) ClearActions(channel, ID string) error {
+
+	return d.updateActions(channel, ID, func(m *DiscordMessage) []common.Action {
+		return nil
+	})
+}
+
+func (d *Discord) DeleteMessage(channel, ID string) error {
+
+	err := d.session.ChannelMessageDelete(channel, ID)
+	if err != nil {
+		d.logger.Error("Failed to delete message: %s", err)
+		return err
+	}
+	d.messages.Delete((&DiscordMessageKey{channelID: channel, messageID: ID}).String())
+	return nil
+}
+
+func (d *Discord) ReadMessage(channel, ID string) (string, error) {
+
+	msg, err := d.session.ChannelMessage(channel, ID)
+	if err != nil {
+		d.logger.Error("Failed to get message: %s", err)
+		return "", err
+	}
+	return msg.Content, nil
+}
+
+func (d *Discord) UpdateMessage(channel, ID, message string) error {
+
+	_, err := d.session.ChannelMessageEdit(channel, ID, common.LimitText(message, discordMaxTextLength, discordTrimmed))
+	if err != nil {
+		d.logger.Error("Failed to update message: %s", err)
+		return err
+	}
+	return nil
+}
+
+func (d *Discord) buildDiscordUser(user *discordgo.User) *DiscordUser {
+
+	if user == nil {
+		return nil
+	}
+
+	u := &DiscordUser{
+		id:   user.ID,
+		name: user.Username,
+	}
+	commands, err := d.processors.UserCommands(d.options.UserPermissions, user.ID, user.Username)
+	if err != nil {
+		d.logger.Error("Discord permissions error: %s", err)
+	}
+	u.commands = commands
+	return u
+}
+
+func (d *Discord) interactionUser(i *discordgo.Interaction) *DiscordUser {
+
+	if i.Member != nil {
+		return d.buildDiscordUser(i.Member.User)
+	}
+	return d.buildDiscordUser(i.User)
+}
+
+func (d *Discord) cachePostUserCommand(m *DiscordMessage, params common.ExecuteParams, action common.Action, response common.Response, overwrite bool) error {
+
+	start := time.Now()
+	executor, message, attachments, actions, err := m.cmd.Execute(d, m, params, action)
+	if err != nil {
+		d.replyError(m, err)
+		return err
+	}
+	if action == nil {
+		actions = common.MergeActions(actions, m.cmd.Actions())
+	}
+
+	r := common.BuildResponse(overwrite, response, executor.Response())
+
+	var key *DiscordMessageKey
+	text := ""
+
+	if !utils.IsEmpty(message) || len(attachments) > 0 {
+
+		mReply := m
+		channel := m.cmd.Channel()
+		if !utils.IsEmpty(channel) && channel != m.key.channelID {
+			mReply = d.cloneMessage(m)
+			mReply.key = &DiscordMessageKey{channelID: channel}
+		}
+
+		k, txt, err := d.reply(mReply, message, attachments, actions, r, &start, r.Error())
+		if err != nil {
+			d.replyError(m, err)
+			return err
+		}
+		key = k
+		text = txt
+	}
+
+	mNew := d.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = key
+	mNew.visible = r.Visible()
+	mNew.text = text
+	mNew.actions = actions
+	mNew.params = params
+
+	d.putMessageToCache(mNew)
+
+	if mNew.key == nil {
+		mNew.key = m.key
+	}
+	return executor.After(mNew)
+}
+
+func (d *Discord) approvalNeeded(m *DiscordMessage, cmd common.Command, params common.ExecuteParams) (string, string) {
+
+	approval := cmd.Approval()
+	if approval == nil {
+		return "", ""
+	}
+
+	chl := strings.TrimSpace(approval.Channel(d, m, params))
+	if utils.IsEmpty(chl) {
+		chl = m.key.channelID
+	}
+
+	message := strings.TrimSpace(approval.Message(d, m, params))
+	if utils.IsEmpty(message) {
+		return "", chl
+	}
+	return message, chl
+}
+
+func (d *Discord) cacheAskApproval(m *DiscordMessage, message, channel string, params common.ExecuteParams) error {
+
+	referenceID := ""
+	if channel == m.key.channelID {
+		referenceID = m.key.messageID
+	}
+
+	buttons := []discordgo.MessageComponent{
+		discordgo.Button{
+			Label:    d.options.ButtonApproveCaption,
+			Style:    discordgo.SuccessButton,
+			CustomID: d.encodeCustomID(discordApprovalButtonType, discordApprovalSubmit),
+		},
+		discordgo.Button{
+			Label:    d.options.ButtonRejectCaption,
+			Style:    discordgo.DangerButton,
+			CustomID: d.encodeCustomID(discordApprovalButtonType, discordApprovalCancel),
+		},
+	}
+
+	data := &discordgo.MessageSend{
+		Content:    common.LimitText(message, discordMaxTextLength, discordTrimmed),
+		Components: d.buildRows(buttons),
+	}
+
+	key, text, err := d.send(channel, referenceID, data)
+	if err != nil {
+		return err
+	}
+
+	mNew := d.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = key
+	mNew.text = text
+	mNew.params = params
+
+	d.putMessageToCache(mNew)
+	return nil
+}
+
+func (d *Discord) executeCommand(m *DiscordMessage, params common.ExecuteParams, reaction string) {
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	err := d.cachePostUserCommand(m, params, nil, r, false)
+	if err != nil {
+		d.logger.Error("Discord couldn't post from %s: %s", m.userID(), err)
+		d.addRemoveReactions(m.key, d.options.ReactionFailed, reaction)
+		return
+	}
+	d.addRemoveReactions(m.key, d.options.ReactionDone, reaction)
+}
+
+func (d *Discord) approveOrExecute(m *DiscordMessage, params common.ExecuteParams, reaction string) {
+
+	message, channel := d.approvalNeeded(m, m.cmd, params)
+	if !utils.IsEmpty(message) {
+		err := d.cacheAskApproval(m, message, channel, params)
+		if err != nil {
+			d.replyError(m, err)
+			d.addRemoveReactions(m.key, d.options.ReactionFailed, reaction)
+			return
+		}
+		d.addRemoveReactions(m.key, d.options.ReactionApproval, reaction)
+		return
+	}
+
+	if reaction != d.options.ReactionDoing {
+		d.addRemoveReactions(m.key, d.options.ReactionDoing, reaction)
+	}
+	d.executeCommand(m, params, d.options.ReactionDoing)
+}
+
+func (d *Discord) evalFields(m *DiscordMessage, params common.ExecuteParams) []common.Field {
+
+	list := []string{common.FieldTypeSelect, common.FieldTypeMultiSelect, common.FieldTypeEdit}
+	only := common.FieldsByType(d, m.cmd, list)
+	return m.cmd.Fields(d, m, params, only)
+}
+
+func (d *Discord) transformParams(fields []common.Field, params common.ExecuteParams) common.ExecuteParams {
+
+	params = common.FieldValues(fields, params)
+	return params
+}
+
+// DiscordForm
+
+func (d *Discord) fieldLabel(field common.Field) string {
+
+	label := field.Name
+	if !utils.IsEmpty(field.Label) {
+		label = field.Label
+	}
+	return common.LimitText(label, discordMaxLabelLength, discordTrimmed)
+}
+
+// check modal text against the field type, modals have only text inputs
+func (d *Discord) fieldValueFromText(field common.Field, text string) (interface{}, error) {
+
+	v := strings.TrimSpace(text)
+	if utils.IsEmpty(v) {
+		return nil, nil
+	}
+
+	switch field.Type {
+	case common.FieldTypeInteger:
+		if _, err := strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("%s is not an integer", v)
+		}
+	case common.FieldTypeFloat:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("%s is not a number", v)
+		}
+	case common.FieldTypeDate:
+		if _, err := time.Parse(discordDateFormat, v); err != nil {
+			return nil, fmt.Errorf("%s is not a date in format %s", v, discordDateFormat)
+		}
+	case common.FieldTypeTime:
+		if _, err := time.Parse(discordTimeFormat, v); err != nil {
+			return nil, fmt.Errorf("%s is not a time in format %s", v, discordTimeFormat)
+		}
+	case common.FieldTypeURL:
+		u, err := url.ParseRequestURI(v)
+		if err != nil || utils.IsEmpty(u.Scheme) || utils.IsEmpty(u.Host) {
+			return nil, fmt.Errorf("%s is not a URL", v)
+		}
+	case common.FieldTypeBool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a boolean", v)
+		}
+		return fmt.Sprintf("%v", b), nil
+	case common.FieldTypeSelect, common.FieldTypeRadionButtons:
+		if len(field.Values) > 0 && !utils.Contains(field.Values, v) {
+			return nil, fmt.Errorf("%s is not one of %s", v, strings.Join(field.Values, ", "))
+		}
+	case common.FieldTypeMultiSelect, common.FieldTypeDynamicMultiSelect, common.FieldTypeCheckboxes,
+		common.FieldTypeMultiUser, common.FieldTypeMultiChannel, common.FieldTypeMultiGroup:
+		return common.RemoveEmptyStrings(strings.Split(v, ",")), nil
+	}
+	return v, nil
+}
+
+func (d *Discord) fieldValueToString(value interface{}) string {
+
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func (d *Discord) fieldPlaceholder(field common.Field) string {
+
+	r := field.Hint
+	switch field.Type {
+	case common.FieldTypeDate:
+		r = discordDateFormat
+	case common.FieldTypeTime:
+		r = discordTimeFormat
+	case common.FieldTypeBool:
+		r = "true, false"
+	}
+	if len(field.Values) > 0 {
+		r = strings.Join(field.Values, ", ")
+	}
+	return common.LimitText(r, discordMaxPlaceholderLength, discordTrimmed)
+}
+
+// modal fields, required empty fields go first as only few inputs are allowed
+func (d *Discord) modalFields(fields []common.Field, params common.ExecuteParams) []common.Field {
+
+	required := []common.Field{}
+	optional := []common.Field{}
+
+	for _, f := range fields {
+		if f.Type == common.FieldTypeMarkdown {
+			continue
+		}
+		if f.Required && utils.IsEmpty(params[f.Name]) {
+			required = append(required, f)
+			continue
+		}
+		optional = append(optional, f)
+	}
+
+	r := append(required, optional...)
+	if len(r) > discordMaxModalInputs {
+		r = r[:discordMaxModalInputs]
+	}
+	return r
+}
+
+func (d *Discord) buildModal(formID, title string, form *DiscordForm) *discordgo.InteractionResponse {
+
+	rows := []discordgo.MessageComponent{}
+	for _, f := range d.modalFields(form.fields, form.params) {
+
+		style := discordgo.TextInputShort
+		if f.Type == common.FieldTypeMultiEdit {
+			style = discordgo.TextInputParagraph
+		}
+
+		value := f.Default
+		if v, ok := form.params[f.Name]; ok && !utils.IsEmpty(v) {
+			value = d.fieldValueToString(v)
+		}
+
+		rows = append(rows, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:    f.Name,
+					Label:       d.fieldLabel(f),
+					Style:       style,
+					Placeholder: d.fieldPlaceholder(f),
+					Value:       value,
+					Required:    f.Required,
+				},
+			},
+		})
+	}
+
+	return &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID:   d.encodeCustomID(discordFormModalType, formID),
+			Title:      common.LimitText(title, discordMaxModalTitle, discordTrimmed),
+			Components: rows,
+		},
+	}
+}
+
+func (d *Discord) newForm(m *DiscordMessage, fields []common.Field, params common.ExecuteParams) string {
+
+	nParams := make(common.ExecuteParams)
+	for _, f := range fields {
+		if !utils.IsEmpty(f.Default) {
+			nParams[f.Name] = common.FieldValue(f, f.Default)
+		}
+	}
+	for k, v := range params {
+		if utils.IsEmpty(v) {
+			continue
+		}
+		nParams[k] = v
+	}
+
+	formID := common.UUID()
+	d.forms.Set(formID, &DiscordForm{
+		message: m,
+		fields:  fields,
+		params:  nParams,
+	}, ttlcache.DefaultTTL)
+	return formID
+}
+
+func (d *Discord) findForm(formID string) *DiscordForm {
+
+	item := d.forms.Get(formID)
+	if item != nil {
+		return item.Value()
+	}
+	return nil
+}
+
+// messages have no interaction to open modal, so ask with a button which opens it
+func (d *Discord) askForm(m *DiscordMessage, fields []common.Field, params common.ExecuteParams) error {
+
+	formID := d.newForm(m, fields, params)
+
+	buttons := []discordgo.MessageComponent{
+		discordgo.Button{
+			Label:    d.options.ButtonFormCaption,
+			Style:    discordgo.PrimaryButton,
+			CustomID: d.encodeCustomID(discordFormButtonType, formID),
+		},
+		discordgo.Button{
+			Label:    d.options.ButtonCancelCaption,
+			Style:    discordgo.SecondaryButton,
+			CustomID: d.encodeCustomID(discordFormCancelType, formID),
+		},
+	}
+
+	data := &discordgo.MessageSend{
+		Content:    common.LimitText(m.cmdText, discordMaxTextLength, discordTrimmed),
+		Components: d.buildRows(buttons),
+	}
+
+	_, _, err := d.send(m.key.channelID, m.key.messageID, data)
+	if err != nil {
+		return err
+	}
+	d.addRemoveReactions(m.key, d.options.ReactionForm, d.options.ReactionDoing)
+	return nil
+}
+
+func (d *Discord) formValues(form *DiscordForm, data discordgo.ModalSubmitInteractionData) error {
+
+	for _, c := range data.Components {
+
+		row, ok := c.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, rc := range row.Components {
+
+			input, ok := rc.(*discordgo.TextInput)
+			if !ok {
+				continue
+			}
+			for _, f := range form.fields {
+				if f.Name != input.CustomID {
+					continue
+				}
+				v, err := d.fieldValueFromText(f, input.Value)
+				if err != nil {
+					return fmt.Errorf("%s: %s", d.fieldLabel(f), err)
+				}
+				if v == nil {
+					delete(form.params, f.Name)
+					continue
+				}
+				form.params[f.Name] = v
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Discord) respond(i *discordgo.Interaction, typ discordgo.InteractionResponseType, data *discordgo.InteractionResponseData) {
+
+	err := d.session.InteractionRespond(i, &discordgo.InteractionResponse{Type: typ, Data: data})
+	if err != nil {
+		d.logger.Error("Discord couldn't respond to interaction: %s", err)
+	}
+}
+
+func (d *Discord) respondError(i *discordgo.Interaction, err error) {
+
+	d.logger.Error(err)
+	d.respond(i, discordgo.InteractionResponseChannelMessageWithSource, &discordgo.InteractionResponseData{
+		Content: common.LimitText(fmt.Sprintf("%s %s", d.options.ReactionFailed, err.Error()), discordMaxTextLength, discordTrimmed),
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+}
+
+// slash commands have no message, so the response echoes the command to react on and reply to
+func (d *Discord) respondCommand(i *discordgo.Interaction, m *DiscordMessage) error {
+
+	err := d.session.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: common.LimitText(fmt.Sprintf("`/%s`", m.cmdText), discordMaxTextLength, discordTrimmed),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err := d.session.InteractionResponse(i)
+	if err != nil {
+		return err
+	}
+
+	m.key = &DiscordMessageKey{
+		channelID: msg.ChannelID,
+		messageID: msg.ID,
+	}
+	d.putMessageToCache(m)
+	return nil
+}
+
+// Application commands
+
+func (d *Discord) commandName(name string) string {
+
+	r := discordNameRegex.ReplaceAllString(strings.ToLower(name), "-")
+	r = strings.Trim(r, "-")
+	if len(r) > discordMaxNameLength {
+		r = r[:discordMaxNameLength]
+	}
+	return r
+}
+
+func (d *Discord) description(text, def string) string {
+
+	r := strings.TrimSpace(text)
+	if utils.IsEmpty(r) {
+		r = def
+	}
+	return common.LimitText(r, discordMaxDescriptionLength, discordTrimmed)
+}
+
+func (d *Discord) paramNames(cmd common.Command) []string {
+
+	r := []string{}
+	for _, p := range cmd.Params() {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			d.logger.Error("Discord couldn't compile param %s: %s", p, err)
+			continue
+		}
+		for _, name := range re.SubexpNames() {
+			if !utils.IsEmpty(name) && !utils.Contains(r, name) {
+				r = append(r, name)
+			}
+		}
+	}
+	return r
+}
+
+func (d *Discord) fieldOptionType(field common.Field) discordgo.ApplicationCommandOptionType {
+
+	switch field.Type {
+	case common.FieldTypeInteger:
+		return discordgo.ApplicationCommandOptionInteger
+	case common.FieldTypeFloat:
+		return discordgo.ApplicationCommandOptionNumber
+	case common.FieldTypeBool:
+		return discordgo.ApplicationCommandOptionBoolean
+	case common.FieldTypeUser:
+		return discordgo.ApplicationCommandOptionUser
+	case common.FieldTypeChannel:
+		return discordgo.ApplicationCommandOptionChannel
+	}
+	return discordgo.ApplicationCommandOptionString
+}
+
+func (d *Discord) buildCommandOptions(cmd common.Command) ([]*discordgo.ApplicationCommandOption, map[string]string) {
+
+	options := []*discordgo.ApplicationCommandOption{}
+	names := make(map[string]string)
+
+	add := func(name string, option *discordgo.ApplicationCommandOption) {
+		oName := d.commandName(name)
+		if utils.IsEmpty(oName) {
+			return
+		}
+		if _, ok := names[oName]; ok {
+			return
+		}
+		option.Name = oName
+		names[oName] = name
+		options = append(options, option)
+	}
+
+	for _, f := range cmd.Fields(d, nil, nil, nil) {
+
+		if f.Type == common.FieldTypeMarkdown {
+			continue
+		}
+		option := &discordgo.ApplicationCommandOption{
+			Type:        d.fieldOptionType(f),
+			Description: d.description(f.Hint, d.fieldLabel(f)),
+			Required:    f.Required,
+		}
+		switch f.Type {
+		case common.FieldTypeSelect, common.FieldTypeRadionButtons:
+			if len(f.Values) <= discordMaxOptions {
+				for _, v := range f.Values {
+					option.Choices = append(option.Choices, &discordgo.ApplicationCommandOptionChoice{Name: v, Value: v})
+				}
+			}
+		}
+		add(f.Name, option)
+	}
+
+	for _, p := range d.paramNames(cmd) {
+		add(p, &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Description: d.description("", p),
+		})
+	}
+
+	// required options must go first
+	sort.SliceStable(options, func(i, j int) bool {
+		return options[i].Required && !options[j].Required
+	})
+
+	if len(options) > discordMaxOptions {
+		options = options[:discordMaxOptions]
+	}
+	return options, names
+}
+
+func (d *Discord) buildApplicationCommands() []*discordgo.ApplicationCommand {
+
+	r := []*discordgo.ApplicationCommand{}
+	commands := make(map[string]*DiscordCommand)
+	exists := func(name string) bool {
+		for _, c := range r {
+			if c.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, p := range d.processors.Items() {
+
+		group := p.Name()
+		gName := d.commandName(group)
+
+		var top *discordgo.ApplicationCommand
+		if !utils.IsEmpty(gName) {
+			if exists(gName) {
+				d.logger.Error("Discord command %s is already registered", gName)
+				continue
+			}
+			top = &discordgo.ApplicationCommand{
+				Name:        gName,
+				Description: d.description("", fmt.Sprintf("%s commands", group)),
+			}
+		}
+
+		for _, c := range p.Commands() {
+
+			cName := d.commandName(c.Name())
+			if utils.IsEmpty(cName) {
+				continue
+			}
+			options, names := d.buildCommandOptions(c)
+			description := d.description(c.Description(), c.Name())
+
+			if top == nil {
+				if exists(cName) {
+					d.logger.Error("Discord command %s is already registered", cName)
+					continue
+				}
+				r = append(r, &discordgo.ApplicationCommand{
+					Name:        cName,
+					Description: description,
+					Options:     options,
+				})
+				commands[cName] = &DiscordCommand{name: c.Name(), options: names}
+				continue
+			}
+
+			if len(top.Options) >= discordMaxOptions {
+				d.logger.Error("Discord command %s has too many subcommands", gName)
+				break
+			}
+			top.Options = append(top.Options, &discordgo.ApplicationCommandOption{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        cName,
+				Description: description,
+				Options:     options,
+			})
+			commands[fmt.Sprintf("%s/%s", gName, cName)] = &DiscordCommand{group: group, name: c.Name(), options: names}
+		}
+
+		if top != nil && len(top.Options) > 0 {
+			r = append(r, top)
+		}
+	}
+
+	d.commands = commands
+	return r
+}
+
+func (d *Discord) registerCommands(appID string) error {
+
+	commands := d.buildApplicationCommands()
+	_, err := d.session.ApplicationCommandBulkOverwrite(appID, d.options.GuildID, commands)
+	if err != nil {
+		return err
+	}
+	d.logger.Info("Discord registered %d commands", len(commands))
+	return nil
+}
+
+func (d *Discord) optionValue(o *discordgo.ApplicationCommandInteractionDataOption) interface{} {
+
+	switch o.Type {
+	case discordgo.ApplicationCommandOptionInteger:
+		return strconv.FormatInt(o.IntValue(), 10)
+	case discordgo.ApplicationCommandOptionNumber:
+		return strconv.FormatFloat(o.FloatValue(), 'f', -1, 64)
+	case discordgo.ApplicationCommandOptionBoolean:
+		return fmt.Sprintf("%v", o.BoolValue())
+	}
+	return fmt.Sprintf("%v", o.Value)
+}
+
+func (d *Discord) findApplicationCommand(data discordgo.ApplicationCommandInteractionData) (*DiscordCommand, []*discordgo.ApplicationCommandInteractionDataOption, string) {
+
+	name := data.Name
+	options := data.Options
+	text := data.Name
+
+	if len(options) > 0 && options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+		name = fmt.Sprintf("%s/%s", data.Name, options[0].Name)
+		text = fmt.Sprintf("%s %s", data.Name, options[0].Name)
+		options = options[0].Options
+	}
+
+	for _, o := range options {
+		text = fmt.Sprintf("%s %s:%v", text, o.Name, o.Value)
+	}
+	return d.commands[name], options, text
+}
+
+// Interactions
+
+func (d *Discord) processApplicationCommand(i *discordgo.Interaction) {
+
+	u := d.interactionUser(i)
+	if u == nil {
+		d.respondError(i, fmt.Errorf("Discord couldn't process command from unknown user"))
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	dc, options, text := d.findApplicationCommand(data)
+	if dc == nil {
+		d.respondError(i, fmt.Errorf("Discord command %s is not found", data.Name))
+		return
+	}
+
+	cmd := d.processors.FindCommand(dc.group, dc.name)
+	if cmd == nil {
+		d.respondError(i, fmt.Errorf("Discord command %s is not found", data.Name))
+		return
+	}
+
+	common.UpdateCounters(d.meter, "discord", dc.group, cmd.Name(), text, u.id)
+
+	groupName := common.GroupName(dc.group, cmd)
+	if !common.Permitted(u, cmd, groupName) {
+		d.respondError(i, fmt.Errorf("Discord user %s is not permitted to execute %s", u.name, groupName))
+		return
+	}
+
+	params := make(common.ExecuteParams)
+	for _, o := range options {
+		name, ok := dc.options[o.Name]
+		if !ok {
+			continue
+		}
+		params[name] = d.optionValue(o)
+	}
+
+	m := &DiscordMessage{
+		discord: d,
+		cmdText: text,
+		cmd:     cmd,
+		key:     &DiscordMessageKey{channelID: i.ChannelID},
+		user:    u,
+		caller:  u,
+		visible: true,
+		text:    text,
+	}
+
+	fields := d.evalFields(m, params)
+	m.fields = fields
+	m.params = params
+
+	if common.FormNeeded(fields, params) {
+		formID := d.newForm(m, fields, params)
+		form := d.findForm(formID)
+		err := d.session.InteractionRespond(i, d.buildModal(formID, text, form))
+		if err != nil {
+			d.logger.Error("Discord couldn't open modal: %s", err)
+		}
+		return
+	}
+
+	err := d.respondCommand(i, m)
+	if err != nil {
+		d.logger.Error("Discord couldn't respond to command: %s", err)
+		return
+	}
+
+	d.addReaction(m.key, d.options.ReactionDoing)
+	d.approveOrExecute(m, d.transformParams(fields, params), d.options.ReactionDoing)
+}
+
+func (d *Discord) processModalSubmit(i *discordgo.Interaction) {
+
+	data := i.ModalSubmitData()
+	typ, formID := d.decodeCustomID(data.CustomID)
+	if typ != discordFormModalType {
+		return
+	}
+
+	form := d.findForm(formID)
+	if form == nil {
+		d.respondError(i, fmt.Errorf("Discord form is not found"))
+		return
+	}
+
+	err := d.formValues(form, data)
+	if err != nil {
+		d.respondError(i, err)
+		return
+	}
+
+	m := form.message
+	if common.FormNeeded(form.fields, form.params) {
+		d.respondError(i, fmt.Errorf("Discord form has empty required fields"))
+		return
+	}
+	d.forms.Delete(formID)
+
+	params := common.MergeInterfaceMaps(m.params, form.params)
+	m.params = params
+
+	reaction := d.options.ReactionDoing
+	if utils.IsEmpty(m.key.messageID) {
+		// slash command
+		err = d.respondCommand(i, m)
+		if err != nil {
+			d.logger.Error("Discord couldn't respond to form: %s", err)
+			return
+		}
+		d.addReaction(m.key, reaction)
+	} else {
+		// message command, close the form prompt
+		d.respond(i, discordgo.InteractionResponseUpdateMessage, &discordgo.InteractionResponseData{
+			Content:    common.LimitText(m.cmdText, discordMaxTextLength, discordTrimmed),
+			Components: []discordgo.MessageComponent{},
+		})
+		reaction = d.options.ReactionForm
+		d.putMessageToCache(m)
+	}
+
+	d.approveOrExecute(m, d.transformParams(form.fields, params), reaction)
+}
+
+func (d *Discord) handleActionButton(i *discordgo.Interaction, m *DiscordMessage, caller *DiscordUser, name string) error {
+
+	if m.cmd == nil {
+		return fmt.Errorf("Discord message has no command")
+	}
+
+	var action common.Action
+	for _, a := range m.actions {
+		if a.Name() == name {
+			action = a
+			break
+		}
+	}
+
+	if action == nil {
+		return fmt.Errorf("Discord action %s is not defined", name)
+	}
+
+	d.respond(i, discordgo.InteractionResponseDeferredMessageUpdate, nil)
+
+	mAction := d.cloneMessage(m)
+	mAction.caller = caller
+	mAction.cmdText = ""
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	return d.cachePostUserCommand(mAction, m.params, action, r, true)
+}
+
+func (d *Discord) handleApprovalButton(i *discordgo.Interaction, m *DiscordMessage, caller *DiscordUser, name string) error {
+
+	if m.cmd == nil || m.originKey == nil {
+		return fmt.Errorf("Discord approval has no command")
+	}
+
+	if !d.options.ApprovalAny && caller.id == m.userID() {
+		return fmt.Errorf("Discord same user cannot approve its action")
+	}
+
+	reaction := d.options.ReactionFailed
+	if name == discordApprovalSubmit {
+		reaction = d.options.ReactionDone
+	}
+
+	// remove buttons to avoid double approval
+	d.respond(i, discordgo.InteractionResponseUpdateMessage, &discordgo.InteractionResponseData{
+		Content:    common.LimitText(fmt.Sprintf("%s\n%s <@%s>", m.text, reaction, caller.id), discordMaxTextLength, discordTrimmed),
+		Components: []discordgo.MessageComponent{},
+	})
+	d.messages.Delete(m.key.String())
+
+	mInit := d.cloneMessage(m)
+	mInit.key = m.originKey
+	mInit.originKey = nil
+
+	if name != discordApprovalSubmit {
+		d.addRemoveReactions(mInit.key, d.options.ReactionFailed, d.options.ReactionApproval)
+		return nil
+	}
+
+	d.addRemoveReactions(mInit.key, d.options.ReactionDoing, d.options.ReactionApproval)
+	d.executeCommand(mInit, m.params, d.options.ReactionDoing)
+	return nil
+}
+
+func (d *Discord) handleFormButton(i *discordgo.Interaction, caller *DiscordUser, typ, formID string) error {
+
+	form := d.findForm(formID)
+	if form == nil {
+		return fmt.Errorf("Discord form is not found")
+	}
+
+	m := form.message
+	if caller.id != m.userID() {
+		return fmt.Errorf("Discord form belongs to another user")
+	}
+
+	if typ == discordFormButtonType {
+		return d.session.InteractionRespond(i, d.buildModal(formID, m.cmdText, form))
+	}
+
+	d.forms.Delete(formID)
+	d.respond(i, discordgo.InteractionResponseUpdateMessage, &discordgo.InteractionResponseData{
+		Content:    common.LimitText(fmt.Sprintf("%s\n%s", m.cmdText, d.options.FormCancelled), discordMaxTextLength, discordTrimmed),
+		Components: []discordgo.MessageComponent{},
+	})
+	d.addRemoveReactions(m.key, d.options.ReactionFailed, d.options.ReactionForm)
+	return nil
+}
+
+func (d *Discord) processMessageComponent(i *discordgo.Interaction) {
+
+	caller := d.interactionUser(i)
+	if caller == nil {
+		d.respondError(i, fmt.Errorf("Discord couldn't process action from unknown user"))
+		return
+	}
+
+	data := i.MessageComponentData()
+	typ, name := d.decodeCustomID(data.CustomID)
+
+	var err error
+	switch typ {
+	case discordFormButtonType, discordFormCancelType:
+		err = d.handleFormButton(i, caller, typ, name)
+	case discordActionButtonType, discordApprovalButtonType:
+
+		if i.Message == nil {
+			return
+		}
+		m := d.findMessageInCache(&DiscordMessageKey{channelID: i.Message.ChannelID, messageID: i.Message.ID})
+		if m == nil {
+			d.respondError(i, fmt.Errorf("Discord message is not found in cache"))
+			return
+		}
+		if typ == discordActionButtonType {
+			err = d.handleActionButton(i, m, caller, name)
+		} else {
+			err = d.handleApprovalButton(i, m, caller, name)
+		}
+	}
+
+	if err != nil {
+		d.respondError(i, err)
+	}
+}
+
+func (d *Discord) interactionCreate(s *discordgo.Session, ic *discordgo.InteractionCreate) {
+
+	i := ic.Interaction
+	if d.options.Debug {
+		d.logger.Debug("Discord interaction: %s", i.Type)
+	}
+
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		d.processApplicationCommand(i)
+	case discordgo.InteractionMessageComponent:
+		d.processMessageComponent(i)
+	case discordgo.InteractionModalSubmit:
+		d.processModalSubmit(i)
+	}
+}
+
+// Messages
+
+// <@bot> group command param1 => group command param1
+func (d *Discord) prepareInputText(text string) string {
+
+	text = discordMentionRegex.ReplaceAllString(text, "")
+	text = strings.TrimPrefix(strings.TrimSpace(text), "/")
+
+	return d.processors.ReplaceAlias(text)
+}
+
+func (d *Discord) mentioned(msg *discordgo.Message) bool {
+
+	// direct messages have no guild
+	if utils.IsEmpty(msg.GuildID) {
+		return true
+	}
+	for _, u := range msg.Mentions {
+		if u.ID == d.session.State.User.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Discord) messageCreate(s *discordgo.Session, mc *discordgo.MessageCreate) {
+
+	msg := mc.Message
+	if msg.Author == nil || msg.Author.Bot || !d.mentioned(msg) {
+		return
+	}
+
+	if d.options.Debug {
+		d.logger.Debug("Discord message: [%s] %s", msg.Author.ID, msg.Content)
+	}
+
+	u := d.buildDiscordUser(msg.Author)
+
+	fText := d.prepareInputText(msg.Content)
+	params, cmd, group, _, _, _, pErr := d.processors.FindParams(false, fText)
+
+	if cmd == nil && !utils.IsEmpty(d.options.DefaultCommand) {
+		cmd = d.processors.FindCommand("", d.options.DefaultCommand)
+		group = ""
+		params = make(common.ExecuteParams)
+	}
+
+	if cmd == nil {
+		params, cmd = d.processors.FindSuggest(fText)
+		group = ""
+	}
+
+	if cmd == nil {
+		d.logger.Debug("Discord command not found for text: %s", msg.Content)
+		common.UpdateCounters(d.meter, "discord", "", "", fText, u.id)
+		return
+	}
+
+	common.UpdateCounters(d.meter, "discord", group, cmd.Name(), fText, u.id)
+
+	groupName := common.GroupName(group, cmd)
+	if !common.Permitted(u, cmd, groupName) {
+		d.logger.Error("Discord user %s is not permitted to execute %s", u.id, groupName)
+		return
+	}
+
+	referenceID := ""
+	if msg.MessageReference != nil {
+		referenceID = msg.MessageReference.MessageID
+	}
+
+	m := &DiscordMessage{
+		discord: d,
+		cmdText: fText,
+		cmd:     cmd,
+		key: &DiscordMessageKey{
+			channelID:   msg.ChannelID,
+			messageID:   msg.ID,
+			referenceID: referenceID,
+		},
+		user:    u,
+		caller:  u,
+		visible: true,
+		text:    msg.Content,
+	}
+
+	d.addReaction(m.key, d.options.ReactionDoing)
+
+	// args couldn't be parsed, so usage is replied before form or approval
+	if pErr != nil {
+		d.replyError(m, pErr)
+		d.addRemoveReactions(m.key, d.options.ReactionFailed, d.options.ReactionDoing)
+		return
+	}
+
+	fields := d.evalFields(m, params)
+	m.fields = fields
+	m.params = params
+	d.putMessageToCache(m)
+
+	if common.FormNeeded(fields, params) {
+		err := d.askForm(m, fields, params)
+		if err != nil {
+			d.replyError(m, err)
+			d.addRemoveReactions(m.key, d.options.ReactionFailed, d.options.ReactionDoing)
+		}
+		return
+	}
+
+	d.approveOrExecute(m, d.transformParams(fields, params), d.options.ReactionDoing)
+}
+
+func (d *Discord) parentMessage(parent common.Message) *DiscordMessage {
+
+	if utils.IsEmpty(parent) {
+		return nil
+	}
+	dm, ok := parent.(*DiscordMessage)
+	if !ok {
+		return nil
+	}
+	if dm.key != nil {
+		if mc := d.findMessageInCache(dm.key); mc != nil {
+			return mc
+		}
+	}
+	return dm
+}
+
+func (d *Discord) Command(channel, text string, user common.User, parent common.Message, response common.Response) error {
+
+	r := common.BuildResponse(true, response)
+
+	mOrigin := d.parentMessage(parent)
+	if mOrigin != nil && mOrigin.cmd != nil {
+		r = common.BuildResponse(false, mOrigin.cmd.Response(), response)
+	}
+
+	var mUser *DiscordUser
+	userID := "unknown"
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*DiscordUser)
+		if ok {
+			mUser = u
+		}
+		userID = user.ID()
+	}
+
+	fText := d.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := d.processors.FindParams(false, fText)
+	if cmd == nil {
+		d.logger.Debug("Discord command not found for text: %s", text)
+		return nil
+	}
+
+	groupName := common.GroupName(group, cmd)
+	if !utils.IsEmpty(user) && !utils.Contains(user.Commands(), groupName) {
+		d.logger.Debug("Discord command user %s is not permitted to execute %s", userID, groupName)
+		return nil
+	}
+
+	if pErr != nil {
+		return pErr
+	}
+
+	fields := cmd.Fields(d, parent, params, nil)
+	if common.FormNeeded(fields, params) {
+		d.logger.Debug("Discord command %s has no support for interaction mode", groupName)
+		return nil
+	}
+
+	key := &DiscordMessageKey{}
+	if mOrigin != nil && mOrigin.key != nil {
+		key.channelID = mOrigin.key.channelID
+		key.messageID = mOrigin.key.messageID
+	}
+	if !utils.IsEmpty(channel) {
+		if channel != key.channelID {
+			key.messageID = ""
+		}
+		key.channelID = channel
+	}
+
+	var m *DiscordMessage
+	if mOrigin != nil {
+		m = d.cloneMessage(mOrigin)
+		m.originKey = mOrigin.key
+	} else {
+		m = &DiscordMessage{
+			discord: d,
+			user:    mUser,
+			caller:  mUser,
+		}
+	}
+	m.cmdText = fText
+	m.cmd = cmd
+	m.key = key
+	m.fields = fields
+	m.params = params
+
+	err := d.cachePostUserCommand(m, params, nil, r, true)
+	if err != nil {
+		d.logger.Error("Discord command %s couldn't post from %s: %s", groupName, userID, err)
+		return err
+	}
+	return nil
+}
+
+func (d *Discord) PostMessage(channel string, message string, attachments []*common.Attachment, actions []common.Action,
+	user common.User, parent common.Message, response common.Response) (string, error) {
+
+	r := common.BuildResponse(true, response)
+
+	referenceID := ""
+	mOrigin := d.parentMessage(parent)
+	if mOrigin != nil && mOrigin.key != nil {
+		referenceID = mOrigin.key.messageID
+		if utils.IsEmpty(channel) {
+			channel = mOrigin.key.channelID
+		}
+		if mOrigin.key.channelID != channel {
+			referenceID = ""
+		}
+	}
+
+	if utils.IsEmpty(channel) {
+		return "", fmt.Errorf("Discord channel is not defined")
+	}
+
+	key, text, err := d.send(channel, referenceID, d.buildMessageSend(message, attachments, actions))
+	if err != nil {
+		return "", err
+	}
+
+	var mUser *DiscordUser
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*DiscordUser)
+		if ok {
+			mUser = u
+		}
+	}
+
+	var m *DiscordMessage
+	if mOrigin != nil {
+		m = d.cloneMessage(mOrigin)
+		m.originKey = mOrigin.key
+	} else {
+		m = &DiscordMessage{
+			discord: d,
+			user:    mUser,
+			caller:  mUser,
+		}
+	}
+	m.key = key
+	m.visible = r.Visible()
+	m.text = text
+	m.actions = actions
+	d.putMessageToCache(m)
+
+	return key.messageID, nil
+}
+
+func (d *Discord) start() {
+
+	session, err := discordgo.New(fmt.Sprintf("Bot %s", d.options.BotToken))
+	if err != nil {
+		d.logger.Error(err)
+		return
+	}
+	if d.options.Debug {
+		session.LogLevel = discordgo.LogDebug
+	}
+	session.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentsDirectMessages | discordgo.IntentsMessageContent
+	session.AddHandler(d.interactionCreate)
+	session.AddHandler(d.messageCreate)
+	d.session = session
+
+	err = session.Open()
+	if err != nil {
+		d.logger.Error(err)
+		return
+	}
+
+	err = d.registerCommands(session.State.User.ID)
+	if err != nil {
+		d.logger.Error("Discord couldn't register commands: %s", err)
+	}
+
+	d.logger.Info("Discord is connected as %s", session.State.User.Username)
+
+	// handlers run in session goroutines, so keep the bot alive
+	select {}
+}
+
+// Reload registers application commands from processors again
+func (d *Discord) Reload() error {
+
+	session := d.session
+	if session == nil || session.State == nil || session.State.User == nil {
+		return nil
+	}
+	return d.registerCommands(session.State.User.ID)
+}
+
+func (d *Discord) Start(wg *sync.WaitGroup) {
+
+	if wg == nil {
+		d.start()
+		return
+	}
+
+	wg.Add(1)
+
+	go func(wg *sync.WaitGroup) {
+
+		defer wg.Done()
+		d.start()
+	}(wg)
+}
+
+func NewDiscord(options DiscordOptions, observability *common.Observability, processors *common.Processors) *Discord {
+
+	if utils.IsEmpty(options.BotToken) {
+		return nil
+	}
+
+	ttl := 1 * 60 * 60 * time.Second
+	if !utils.IsEmpty(options.CacheTTL) {
+		ttl, _ = time.ParseDuration(options.CacheTTL)
+	}
+
+	messages := ttlcache.New[string, *DiscordMessage](ttlcache.WithTTL[string, *DiscordMessage](ttl))
+	go messages.Start()
+
+	forms := ttlcache.New[string, *DiscordForm](ttlcache.WithTTL[string, *DiscordForm](ttl))
+	go forms.Start()
+
+	return &Discord{
+		options:    options,
+		processors: processors,
+		logger:     observability.Logs(),
+		meter:      observability.Metrics(),
+		messages:   messages,
+		forms:      forms,
+		commands:   make(map[string]*DiscordCommand),
+	}
+}
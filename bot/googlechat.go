@@ -0,0 +1,1835 @@
+package bot
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/jinzhu/copier"
+)
+
+type GoogleChatOptions struct {
+	CredentialsFile string
+	Audience        string
+	Listen          string
+	Path            string
+	APIURL          string
+	KeysURL         string
+	Timeout         int
+	Insecure        bool
+	Debug           bool
+	DefaultCommand  string
+	UserPermissions string
+	ApprovalAny     bool
+
+	ButtonFormCaption    string
+	ButtonSubmitCaption  string
+	ButtonCancelCaption  string
+	ButtonApproveCaption string
+	ButtonRejectCaption  string
+
+	FormCancelled string
+	CacheTTL      string
+}
+
+type GoogleChatMessageKey struct {
+	space  string
+	name   string
+	thread string
+}
+
+type GoogleChatUser struct {
+	id       string
+	name     string
+	email    string
+	timezone string
+	commands []string
+}
+
+type GoogleChatChannel struct {
+	id string
+}
+
+type GoogleChatMessage struct {
+	googleChat *GoogleChat
+	cmdText    string
+	cmd        common.Command
+	originKey  *GoogleChatMessageKey
+	key        *GoogleChatMessageKey
+	user       *GoogleChatUser
+	caller     *GoogleChatUser
+	visible    bool
+	text       string
+	actions    []common.Action
+	params     common.ExecuteParams
+	fields     []common.Field
+}
+
+type GoogleChatForm struct {
+	message *GoogleChatMessage
+	key     *GoogleChatMessageKey
+	fields  []common.Field
+	params  common.ExecuteParams
+}
+
+type GoogleChatAccount struct {
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Type        string `json:"type,omitempty"`
+}
+
+type GoogleChatSpace struct {
+	Name            string `json:"name,omitempty"`
+	Type            string `json:"type,omitempty"`
+	SingleUserBotDm bool   `json:"singleUserBotDm,omitempty"`
+}
+
+type GoogleChatThread struct {
+	Name string `json:"name,omitempty"`
+}
+
+type GoogleChatSlashCommand struct {
+	CommandName string `json:"commandName,omitempty"`
+	CommandID   string `json:"commandId,omitempty"`
+}
+
+type GoogleChatAnnotation struct {
+	Type         string                  `json:"type,omitempty"`
+	SlashCommand *GoogleChatSlashCommand `json:"slashCommand,omitempty"`
+}
+
+type GoogleChatCard struct {
+	CardID string      `json:"cardId"`
+	Card   interface{} `json:"card"`
+}
+
+type GoogleChatAPIMessage struct {
+	Name         string                  `json:"name,omitempty"`
+	Sender       *GoogleChatAccount      `json:"sender,omitempty"`
+	Text         string                  `json:"text,omitempty"`
+	ArgumentText string                  `json:"argumentText,omitempty"`
+	Thread       *GoogleChatThread       `json:"thread,omitempty"`
+	Space        *GoogleChatSpace        `json:"space,omitempty"`
+	SlashCommand *GoogleChatSlashCommand `json:"slashCommand,omitempty"`
+	Annotations  []*GoogleChatAnnotation `json:"annotations,omitempty"`
+	CardsV2      []*GoogleChatCard       `json:"cardsV2,omitempty"`
+}
+
+type GoogleChatStringInputs struct {
+	Value []string `json:"value"`
+}
+
+type GoogleChatFormInput struct {
+	StringInputs *GoogleChatStringInputs `json:"stringInputs,omitempty"`
+}
+
+type GoogleChatTimeZone struct {
+	ID string `json:"id"`
+}
+
+type GoogleChatCommonEvent struct {
+	TimeZone        *GoogleChatTimeZone             `json:"timeZone,omitempty"`
+	InvokedFunction string                          `json:"invokedFunction,omitempty"`
+	Parameters      map[string]string               `json:"parameters,omitempty"`
+	FormInputs      map[string]*GoogleChatFormInput `json:"formInputs,omitempty"`
+}
+
+type GoogleChatActionParameter struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type GoogleChatAction struct {
+	ActionMethodName string                       `json:"actionMethodName,omitempty"`
+	Parameters       []*GoogleChatActionParameter `json:"parameters,omitempty"`
+}
+
+type GoogleChatEvent struct {
+	Type            string                 `json:"type"`
+	EventTime       string                 `json:"eventTime,omitempty"`
+	Message         *GoogleChatAPIMessage  `json:"message,omitempty"`
+	User            *GoogleChatAccount     `json:"user,omitempty"`
+	Space           *GoogleChatSpace       `json:"space,omitempty"`
+	Action          *GoogleChatAction      `json:"action,omitempty"`
+	Common          *GoogleChatCommonEvent `json:"common,omitempty"`
+	IsDialogEvent   bool                   `json:"isDialogEvent,omitempty"`
+	DialogEventType string                 `json:"dialogEventType,omitempty"`
+}
+
+type GoogleChatCredentials struct {
+	Type         string `json:"type"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type GoogleChatToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type GoogleChat struct {
+	options     GoogleChatOptions
+	processors  *common.Processors
+	client      *http.Client
+	logger      sreCommon.Logger
+	meter       sreCommon.Meter
+	messages    *ttlcache.Cache[string, *GoogleChatMessage]
+	forms       *ttlcache.Cache[string, *GoogleChatForm]
+	credentials *GoogleChatCredentials
+	privateKey  *rsa.PrivateKey
+
+	tokenMutex   sync.Mutex
+	token        string
+	tokenExpires time.Time
+
+	verifier *common.JWTVerifier
+}
+
+const (
+	googleChatEventMessage          = "MESSAGE"
+	googleChatEventCardClicked      = "CARD_CLICKED"
+	googleChatDialogRequest         = "REQUEST_DIALOG"
+	googleChatDialogSubmit          = "SUBMIT_DIALOG"
+	googleChatDialogCancel          = "CANCEL_DIALOG"
+	googleChatResponseUpdateMessage = "UPDATE_MESSAGE"
+	googleChatResponseDialog        = "DIALOG"
+	googleChatStatusOK              = "OK"
+	googleChatStatusInvalid         = "INVALID_ARGUMENT"
+	googleChatAnnotationSlash       = "SLASH_COMMAND"
+	googleChatInteractionDialog     = "OPEN_DIALOG"
+	googleChatReplyOption           = "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD"
+	googleChatTokenScope            = "https://www.googleapis.com/auth/chat.bot"
+	googleChatTokenGrantType        = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	googleChatTokenIssuer           = "chat@system.gserviceaccount.com"
+	googleChatMaxTextLength         = 4000
+	googleChatTrimmed               = "...trimmed"
+	googleChatParamName             = "name"
+	googleChatParamID               = "id"
+	googleChatActionButtonType      = "a"
+	googleChatApprovalButtonType    = "p"
+	googleChatFormButtonType        = "f"
+	googleChatApprovalSubmit        = "approve"
+	googleChatApprovalCancel        = "reject"
+	googleChatFormSubmit            = "submit"
+	googleChatFormCancel            = "cancel"
+	googleChatTokenLifetime         = 60 * 60
+	googleChatTokenExpiresThreshold = 60
+)
+
+// GoogleChatUser
+
+func (gu *GoogleChatUser) ID() string {
+	return gu.id
+}
+
+func (gu *GoogleChatUser) Name() string {
+	return gu.name
+}
+
+func (gu *GoogleChatUser) TimeZone() string {
+	return gu.timezone
+}
+
+func (gu *GoogleChatUser) Commands() []string {
+	return gu.commands
+}
+
+// GoogleChatChannel
+
+func (gc *GoogleChatChannel) ID() string {
+	return gc.id
+}
+
+// GoogleChatMessage
+
+func (gm *GoogleChatMessage) ID() string {
+	if gm.key == nil {
+		return ""
+	}
+	return gm.key.name
+}
+
+func (gm *GoogleChatMessage) Visible() bool {
+	return gm.visible
+}
+
+func (gm *GoogleChatMessage) User() common.User {
+	return gm.user
+}
+
+func (gm *GoogleChatMessage) Caller() common.User {
+	return gm.caller
+}
+
+func (gm *GoogleChatMessage) userID() string {
+	u := gm.user
+	if u == nil {
+		return ""
+	}
+	return u.id
+}
+
+func (gm *GoogleChatMessage) Channel() common.Channel {
+	if gm.key == nil {
+		return nil
+	}
+	return &GoogleChatChannel{id: gm.key.space}
+}
+
+func (gm *GoogleChatMessage) ParentID() string {
+	if gm.key == nil {
+		return ""
+	}
+	return gm.key.thread
+}
+
+func (gm *GoogleChatMessage) SetParentID(thread string) {
+	if gm.key == nil {
+		return
+	}
+	gm.key.thread = thread
+}
+
+// GoogleChatMessageKey
+
+// message names already contain their spaces
+func (gmk *GoogleChatMessageKey) String() string {
+	return gmk.name
+}
+
+// GoogleChat
+
+func (g *GoogleChat) Name() string {
+	return "GoogleChat"
+}
+
+func (g *GoogleChat) findMessageInCache(key *GoogleChatMessageKey) *GoogleChatMessage {
+
+	if key == nil {
+		return nil
+	}
+	item := g.messages.Get(key.String())
+	if item != nil {
+		return item.Value()
+	}
+	return nil
+}
+
+func (g *GoogleChat) putMessageToCache(m *GoogleChatMessage) {
+
+	if m.key == nil || utils.IsEmpty(m.key.name) {
+		return
+	}
+	g.messages.Set(m.key.String(), m, ttlcache.DefaultTTL)
+}
+
+func (g *GoogleChat) cloneMessage(m *GoogleChatMessage) *GoogleChatMessage {
+
+	if m == nil {
+		return nil
+	}
+	r := &GoogleChatMessage{}
+	err := copier.Copy(r, m)
+	if err != nil {
+		g.logger.Error("GoogleChat message copy error: %s", err)
+		return nil
+	}
+	return r
+}
+
+func (g *GoogleChat) encodeJWTPart(obj interface{}) (string, error) {
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// service account authentication
+
+func (g *GoogleChat) getToken() (string, error) {
+
+	if g.credentials == nil {
+		return "", nil
+	}
+
+	g.tokenMutex.Lock()
+	defer g.tokenMutex.Unlock()
+
+	if !utils.IsEmpty(g.token) && time.Now().Before(g.tokenExpires) {
+		return g.token, nil
+	}
+
+	now := time.Now().Unix()
+	header, err := g.encodeJWTPart(&common.JWTHeader{
+		Alg: "RS256",
+		Typ: "JWT",
+		Kid: g.credentials.PrivateKeyID,
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := g.encodeJWTPart(&common.JWTClaims{
+		Iss:   g.credentials.ClientEmail,
+		Aud:   g.credentials.TokenURI,
+		Scope: googleChatTokenScope,
+		Iat:   now,
+		Exp:   now + googleChatTokenLifetime,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + claims
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, g.privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", googleChatTokenGrantType)
+	form.Set("assertion", fmt.Sprintf("%s.%s", unsigned, base64.RawURLEncoding.EncodeToString(sig)))
+
+	headers := make(map[string]string)
+	headers["Content-Type"] = "application/x-www-form-urlencoded"
+
+	b, err := utils.HttpRequestRawWithHeaders(g.client, http.MethodPost, g.credentials.TokenURI, headers, []byte(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("GoogleChat token error: %s %s", err, string(b))
+	}
+
+	token := &GoogleChatToken{}
+	err = json.Unmarshal(b, token)
+	if err != nil {
+		return "", err
+	}
+
+	g.token = token.AccessToken
+	g.tokenExpires = time.Now().Add(time.Duration(token.ExpiresIn-googleChatTokenExpiresThreshold) * time.Second)
+	return g.token, nil
+}
+
+func (g *GoogleChat) fetchKeys() ([]byte, error) {
+	return utils.HttpRequestRawWithHeaders(g.client, http.MethodGet, g.options.KeysURL, nil, nil)
+}
+
+// Google Chat signs requests with its own account for the project number as audience
+func (g *GoogleChat) verifyToken(authorization string) error {
+	return g.verifier.Verify(authorization, nil)
+}
+
+// spaces.messages API
+
+func (g *GoogleChat) request(method, path string, query url.Values, message *GoogleChatAPIMessage) (*GoogleChatAPIMessage, error) {
+
+	u := fmt.Sprintf("%s/%s", strings.TrimSuffix(g.options.APIURL, "/"), path)
+	if len(query) > 0 {
+		u = fmt.Sprintf("%s?%s", u, query.Encode())
+	}
+
+	token, err := g.getToken()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string)
+	headers["Content-Type"] = "application/json"
+	if !utils.IsEmpty(token) {
+		headers["Authorization"] = fmt.Sprintf("Bearer %s", token)
+	}
+
+	var raw []byte
+	if message != nil {
+		raw, err = json.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := utils.HttpRequestRawWithHeaders(g.client, method, u, headers, raw)
+	if err != nil {
+		return nil, fmt.Errorf("GoogleChat %s %s error: %s %s", method, u, err, string(b))
+	}
+
+	r := &GoogleChatAPIMessage{}
+	if len(b) == 0 {
+		return r, nil
+	}
+	err = json.Unmarshal(b, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (g *GoogleChat) newColor(style string) map[string]interface{} {
+
+	switch style {
+	case "primary":
+		return map[string]interface{}{"red": 0.1, "green": 0.55, "blue": 0.25, "alpha": 1}
+	case "danger":
+		return map[string]interface{}{"red": 0.85, "green": 0.2, "blue": 0.2, "alpha": 1}
+	}
+	return nil
+}
+
+func (g *GoogleChat) newButton(text, style, typ, name, ID string, dialog bool) map[string]interface{} {
+
+	params := []interface{}{
+		map[string]interface{}{"key": googleChatParamName, "value": name},
+	}
+	if !utils.IsEmpty(ID) {
+		params = append(params, map[string]interface{}{"key": googleChatParamID, "value": ID})
+	}
+
+	action := make(map[string]interface{})
+	action["function"] = typ
+	action["parameters"] = params
+	if dialog {
+		action["interaction"] = googleChatInteractionDialog
+	}
+
+	r := make(map[string]interface{})
+	r["text"] = text
+	r["onClick"] = map[string]interface{}{"action": action}
+	if color := g.newColor(style); color != nil {
+		r["color"] = color
+	}
+	return r
+}
+
+func (g *GoogleChat) newButtonList(buttons []interface{}) map[string]interface{} {
+
+	return map[string]interface{}{
+		"buttonList": map[string]interface{}{"buttons": buttons},
+	}
+}
+
+func (g *GoogleChat) newTextParagraph(text string) map[string]interface{} {
+
+	return map[string]interface{}{
+		"textParagraph": map[string]interface{}{"text": text},
+	}
+}
+
+func (g *GoogleChat) newCard(widgets []interface{}) *GoogleChatCard {
+
+	card := make(map[string]interface{})
+	card["sections"] = []interface{}{
+		map[string]interface{}{"widgets": widgets},
+	}
+	return &GoogleChatCard{
+		CardID: common.UUID(),
+		Card:   card,
+	}
+}
+
+func (g *GoogleChat) buildActionsCard(actions []common.Action) *GoogleChatCard {
+
+	list := []interface{}{}
+	for _, a := range actions {
+
+		name := a.Name()
+		if utils.IsEmpty(name) {
+			continue
+		}
+		label := a.Label()
+		if utils.IsEmpty(label) {
+			label = name
+		}
+		list = append(list, g.newButton(label, a.Style(), googleChatActionButtonType, name, "", false))
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return g.newCard([]interface{}{g.newButtonList(list)})
+}
+
+// apps cannot upload media, so only text attachments are inlined
+func (g *GoogleChat) buildMessage(message string, attachments []*common.Attachment, actions []common.Action) *GoogleChatAPIMessage {
+
+	text := message
+	for _, a := range attachments {
+
+		switch a.Type {
+		case common.AttachmentTypeImage, common.AttachmentTypeFile:
+			g.logger.Debug("GoogleChat has no support for %s attachment %s", a.Type, a.Title)
+			continue
+		}
+		if !utils.IsEmpty(a.Title) {
+			text = fmt.Sprintf("%s\n\n*%s*", text, a.Title)
+		}
+		text = fmt.Sprintf("%s\n```\n%s\n```", text, string(a.Data))
+	}
+
+	r := &GoogleChatAPIMessage{
+		Text: common.LimitText(strings.TrimSpace(text), googleChatMaxTextLength, googleChatTrimmed),
+	}
+	card := g.buildActionsCard(actions)
+	if card != nil {
+		r.CardsV2 = []*GoogleChatCard{card}
+	}
+	return r
+}
+
+func (g *GoogleChat) send(space, thread string, message *GoogleChatAPIMessage) (*GoogleChatMessageKey, error) {
+
+	query := url.Values{}
+	if !utils.IsEmpty(thread) {
+		message.Thread = &GoogleChatThread{Name: thread}
+		query.Set("messageReplyOption", googleChatReplyOption)
+	}
+
+	r, err := g.request(http.MethodPost, fmt.Sprintf("%s/messages", space), query, message)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &GoogleChatMessageKey{
+		space:  space,
+		name:   r.Name,
+		thread: thread,
+	}
+	if r.Thread != nil && !utils.IsEmpty(r.Thread.Name) {
+		key.thread = r.Thread.Name
+	}
+	return key, nil
+}
+
+func (g *GoogleChat) update(name string, message *GoogleChatAPIMessage) error {
+
+	query := url.Values{}
+	query.Set("updateMask", "text,cardsV2")
+
+	_, err := g.request(http.MethodPatch, name, query, message)
+	return err
+}
+
+func (g *GoogleChat) reply(m *GoogleChatMessage, message string, attachments []*common.Attachment, actions []common.Action,
+	response *common.BotResponse, start *time.Time, error bool) (*GoogleChatMessageKey, string, error) {
+
+	if m.key == nil {
+		return nil, "", fmt.Errorf("GoogleChat message has no space")
+	}
+
+	text := message
+	if !utils.IsEmpty(response) {
+
+		if response.Original() && !utils.IsEmpty(m.cmdText) {
+			user := ""
+			if m.user != nil && !utils.IsEmpty(m.user.name) {
+				user = fmt.Sprintf("%s: ", m.user.name)
+			}
+			text = fmt.Sprintf("%s`%s`\n\n%s", user, m.cmdText, text)
+		}
+
+		if response.Duration() && start != nil && !error {
+			elapsed := time.Since(*start)
+			text = fmt.Sprintf("[%s] %s", elapsed.Round(time.Millisecond), text)
+		}
+	}
+
+	if error {
+		text = fmt.Sprintf("*Error:* %s", text)
+		attachments = nil
+		actions = nil
+	}
+
+	msg := g.buildMessage(text, attachments, actions)
+	key, err := g.send(m.key.space, m.key.thread, msg)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, msg.Text, nil
+}
+
+func (g *GoogleChat) replyError(m *GoogleChatMessage, err error) {
+
+	g.logger.Error("GoogleChat reply error: %s", err)
+	_, _, err = g.reply(m, err.Error(), nil, nil, nil, nil, true)
+	if err != nil {
+		g.logger.Error("GoogleChat couldn't reply error: %s", err)
+	}
+}
+
+// Google Chat apps have no reactions, so states are only logged
+func (g *GoogleChat) AddReaction(channel, ID, name string) error {
+	g.logger.Debug("GoogleChat has no support for reaction %s on %s in %s", name, ID, channel)
+	return nil
+}
+
+func (g *GoogleChat) RemoveReaction(channel, ID, name string) error {
+	g.logger.Debug("GoogleChat has no support for reaction %s on %s in %s", name, ID, channel)
+	return nil
+}
+
+func (g *GoogleChat) updateActions(channel, ID string, update func(m *GoogleChatMessage) []common.Action) error {
+
+	m := g.findMessageInCache(&GoogleChatMessageKey{space: channel, name: ID})
+	if m == nil {
+		err := fmt.Errorf("GoogleChat message not found in %s with %s", channel, ID)
+		g.logger.Error(err)
+		return err
+	}
+
+	m.actions = update(m)
+	g.putMessageToCache(m)
+
+	msg := g.buildMessage(m.text, nil, m.actions)
+	return g.update(ID, msg)
+}
+
+func (g *GoogleChat) AddAction(channel, ID string, action common.Action) error {
+
+	return g.updateActions(channel, ID, func(m *GoogleChatMessage) []common.Action {
+		return append(m.actions, action)
+	})
+}
+
+func (g *GoogleChat) AddActions(channel, ID string, actions []common.Action) error {
+
+	return g.updateActions(channel, ID, func(m *GoogleChatMessage) []common.Action {
+		return append(m.actions, actions...)
+	})
+}
+
+func (g *GoogleChat) RemoveAction(channel, ID, name string) error {
+
+	return g.updateActions(channel, ID, func(m *GoogleChatMessage) []common.Action {
+		actions := []common.Action{}
+		for _, a := range m.actions {
+			if a.Name() == name {
+				continue
+			}
+			actions = append(actions, a)
+		}
+		return actions
+	})
+}
+
+func (g *GoogleChat) ClearActions(channel, ID string) error {
+
+	return g.updateActions(channel, ID, func(m *GoogleChatMessage) []common.Action {
+		return nil
+	})
+}
+
+func (g *GoogleChat) DeleteMessage(channel, ID string) error {
+
+	_, err := g.request(http.MethodDelete, ID, nil, nil)
+	if err != nil {
+		g.logger.Error("Failed to delete message: %s", err)
+		return err
+	}
+	g.messages.Delete(ID)
+	return nil
+}
+
+func (g *GoogleChat) ReadMessage(channel, ID string) (string, error) {
+
+	r, err := g.request(http.MethodGet, ID, nil, nil)
+	if err != nil {
+		g.logger.Error("Failed to get message: %s", err)
+		return "", err
+	}
+	return r.Text, nil
+}
+
+func (g *GoogleChat) UpdateMessage(channel, ID, message string) error {
+
+	var actions []common.Action
+	m := g.findMessageInCache(&GoogleChatMessageKey{space: channel, name: ID})
+	if m != nil {
+		actions = m.actions
+	}
+
+	msg := g.buildMessage(message, nil, actions)
+	err := g.update(ID, msg)
+	if err != nil {
+		g.logger.Error("Failed to update message: %s", err)
+		return err
+	}
+
+	if m != nil {
+		m.text = msg.Text
+		g.putMessageToCache(m)
+	}
+	return nil
+}
+
+func (g *GoogleChat) buildGoogleChatUser(event *GoogleChatEvent) *GoogleChatUser {
+
+	from := event.User
+	if from == nil && event.Message != nil {
+		from = event.Message.Sender
+	}
+	if from == nil {
+		return nil
+	}
+
+	u := &GoogleChatUser{
+		id:    from.Name,
+		name:  from.DisplayName,
+		email: from.Email,
+	}
+	if event.Common != nil && event.Common.TimeZone != nil {
+		u.timezone = event.Common.TimeZone.ID
+	}
+
+	// email is known to admins, users/123 is not
+	userID := u.email
+	if utils.IsEmpty(userID) {
+		userID = u.id
+	}
+	commands, err := g.processors.UserCommands(g.options.UserPermissions, userID, u.name)
+	if err != nil {
+		g.logger.Error("GoogleChat permissions error: %s", err)
+	}
+	u.commands = commands
+	return u
+}
+
+// /command param1 => command param1
+// @bot group command param1 => group command param1
+func (g *GoogleChat) eventText(message *GoogleChatAPIMessage) string {
+
+	text := message.ArgumentText
+	if utils.IsEmpty(strings.TrimSpace(text)) {
+		text = message.Text
+	}
+
+	if message.SlashCommand == nil {
+		return text
+	}
+	for _, a := range message.Annotations {
+		if a.Type != googleChatAnnotationSlash || a.SlashCommand == nil {
+			continue
+		}
+		return fmt.Sprintf("%s %s", a.SlashCommand.CommandName, strings.TrimSpace(message.ArgumentText))
+	}
+	return message.Text
+}
+
+func (g *GoogleChat) prepareInputText(text string) string {
+
+	text = strings.ReplaceAll(text, "\u00a0", " ")
+	text = strings.TrimPrefix(strings.TrimSpace(text), "/")
+
+	return g.processors.ReplaceAlias(text)
+}
+
+func (g *GoogleChat) cachePostUserCommand(m *GoogleChatMessage, params common.ExecuteParams, action common.Action, response common.Response, overwrite bool) error {
+
+	start := time.Now()
+	executor, message, attachments, actions, err := m.cmd.Execute(g, m, params, action)
+	if err != nil {
+		g.replyError(m, err)
+		return err
+	}
+	if action == nil {
+		actions = common.MergeActions(actions, m.cmd.Actions())
+	}
+
+	r := common.BuildResponse(overwrite, response, executor.Response())
+
+	var key *GoogleChatMessageKey
+	text := ""
+
+	if !utils.IsEmpty(message) || len(attachments) > 0 {
+
+		mReply := m
+		channel := m.cmd.Channel()
+		if !utils.IsEmpty(channel) && channel != m.key.space {
+			mReply = g.cloneMessage(m)
+			mReply.key = &GoogleChatMessageKey{space: channel}
+		}
+
+		k, txt, err := g.reply(mReply, message, attachments, actions, r, &start, r.Error())
+		if err != nil {
+			g.replyError(m, err)
+			return err
+		}
+		key = k
+		text = txt
+	}
+
+	mNew := g.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = key
+	mNew.visible = r.Visible()
+	mNew.text = text
+	mNew.actions = actions
+	mNew.params = params
+
+	g.putMessageToCache(mNew)
+
+	if mNew.key == nil {
+		mNew.key = m.key
+	}
+	return executor.After(mNew)
+}
+
+func (g *GoogleChat) approvalNeeded(m *GoogleChatMessage, cmd common.Command, params common.ExecuteParams) (string, string) {
+
+	approval := cmd.Approval()
+	if approval == nil {
+		return "", ""
+	}
+
+	chl := strings.TrimSpace(approval.Channel(g, m, params))
+	if utils.IsEmpty(chl) {
+		chl = m.key.space
+	}
+
+	message := strings.TrimSpace(approval.Message(g, m, params))
+	if utils.IsEmpty(message) {
+		return "", chl
+	}
+	return message, chl
+}
+
+func (g *GoogleChat) cacheAskApproval(m *GoogleChatMessage, message, channel string, params common.ExecuteParams) error {
+
+	thread := ""
+	if channel == m.key.space {
+		thread = m.key.thread
+	}
+
+	buttons := []interface{}{
+		g.newButton(g.options.ButtonApproveCaption, "primary", googleChatApprovalButtonType, googleChatApprovalSubmit, "", false),
+		g.newButton(g.options.ButtonRejectCaption, "danger", googleChatApprovalButtonType, googleChatApprovalCancel, "", false),
+	}
+
+	msg := &GoogleChatAPIMessage{
+		Text:    common.LimitText(message, googleChatMaxTextLength, googleChatTrimmed),
+		CardsV2: []*GoogleChatCard{g.newCard([]interface{}{g.newButtonList(buttons)})},
+	}
+
+	key, err := g.send(channel, thread, msg)
+	if err != nil {
+		return err
+	}
+
+	mNew := g.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = key
+	mNew.text = msg.Text
+	mNew.params = params
+
+	g.putMessageToCache(mNew)
+	return nil
+}
+
+func (g *GoogleChat) executeCommand(m *GoogleChatMessage, params common.ExecuteParams, action common.Action) {
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	err := g.cachePostUserCommand(m, params, action, r, false)
+	if err != nil {
+		g.logger.Error("GoogleChat couldn't post from %s: %s", m.userID(), err)
+	}
+}
+
+func (g *GoogleChat) approveOrExecute(m *GoogleChatMessage, params common.ExecuteParams) {
+
+	message, channel := g.approvalNeeded(m, m.cmd, params)
+	if !utils.IsEmpty(message) {
+		err := g.cacheAskApproval(m, message, channel, params)
+		if err != nil {
+			g.replyError(m, err)
+		}
+		return
+	}
+	g.executeCommand(m, params, nil)
+}
+
+func (g *GoogleChat) transformParams(fields []common.Field, params common.ExecuteParams) common.ExecuteParams {
+
+	params = common.FieldValues(fields, params)
+	return params
+}
+
+// returns dialog to be shown at once when the event may open it
+func (g *GoogleChat) processCommand(m *GoogleChatMessage, params common.ExecuteParams, dialog bool) interface{} {
+
+	list := []string{common.FieldTypeSelect, common.FieldTypeMultiSelect, common.FieldTypeEdit}
+	only := common.FieldsByType(g, m.cmd, list)
+
+	fields := m.cmd.Fields(g, m, params, only)
+	m.fields = fields
+	m.params = params
+	g.putMessageToCache(m)
+
+	if common.FormNeeded(fields, params) {
+		formID := g.newForm(m, nil, fields, params)
+		if dialog {
+			return g.dialogResponse(formID, g.findForm(formID))
+		}
+		go func() {
+			err := g.askForm(m, formID)
+			if err != nil {
+				g.replyError(m, err)
+			}
+		}()
+		return nil
+	}
+
+	go g.approveOrExecute(m, g.transformParams(fields, params))
+	return nil
+}
+
+// GoogleChatForm
+
+func (g *GoogleChat) fieldValueToString(value interface{}) string {
+
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func (g *GoogleChat) fieldLabel(field common.Field) string {
+
+	if !utils.IsEmpty(field.Label) {
+		return field.Label
+	}
+	return field.Name
+}
+
+func (g *GoogleChat) fieldItems(values []string, value string) []interface{} {
+
+	selected := strings.Split(value, ",")
+	r := []interface{}{}
+	for _, v := range values {
+		item := make(map[string]interface{})
+		item["text"] = v
+		item["value"] = v
+		item["selected"] = utils.Contains(selected, v)
+		r = append(r, item)
+	}
+	return r
+}
+
+func (g *GoogleChat) newSelectionInput(field common.Field, typ string, items []interface{}) map[string]interface{} {
+
+	r := make(map[string]interface{})
+	r["name"] = field.Name
+	r["label"] = g.fieldLabel(field)
+	r["type"] = typ
+	r["items"] = items
+	return map[string]interface{}{"selectionInput": r}
+}
+
+func (g *GoogleChat) formInput(field common.Field, value string) map[string]interface{} {
+
+	switch field.Type {
+	case common.FieldTypeSelect, common.FieldTypeDynamicSelect:
+		return g.newSelectionInput(field, "DROPDOWN", g.fieldItems(field.Values, value))
+	case common.FieldTypeMultiSelect, common.FieldTypeDynamicMultiSelect:
+		return g.newSelectionInput(field, "MULTI_SELECT", g.fieldItems(field.Values, value))
+	case common.FieldTypeRadionButtons:
+		return g.newSelectionInput(field, "RADIO_BUTTON", g.fieldItems(field.Values, value))
+	case common.FieldTypeCheckboxes:
+		return g.newSelectionInput(field, "CHECK_BOX", g.fieldItems(field.Values, value))
+	case common.FieldTypeBool:
+		item := make(map[string]interface{})
+		item["text"] = g.fieldLabel(field)
+		item["value"] = fmt.Sprintf("%v", true)
+		item["selected"] = value == fmt.Sprintf("%v", true)
+		return g.newSelectionInput(field, "SWITCH", []interface{}{item})
+	}
+
+	r := make(map[string]interface{})
+	r["name"] = field.Name
+	r["label"] = g.fieldLabel(field)
+	r["type"] = "SINGLE_LINE"
+	if field.Type == common.FieldTypeMultiEdit {
+		r["type"] = "MULTIPLE_LINE"
+	}
+	if !utils.IsEmpty(field.Hint) {
+		r["hintText"] = field.Hint
+	}
+	if !utils.IsEmpty(value) {
+		r["value"] = value
+	}
+	return map[string]interface{}{"textInput": r}
+}
+
+func (g *GoogleChat) formWidgets(fields []common.Field, params common.ExecuteParams) []interface{} {
+
+	widgets := []interface{}{}
+	for _, field := range fields {
+
+		if field.Type == common.FieldTypeMarkdown {
+			widgets = append(widgets, g.newTextParagraph(field.Default))
+			continue
+		}
+
+		value := field.Default
+		if v, ok := params[field.Name]; ok && !utils.IsEmpty(v) {
+			value = g.fieldValueToString(v)
+		}
+		widgets = append(widgets, g.formInput(field, value))
+	}
+	return widgets
+}
+
+func (g *GoogleChat) newForm(m *GoogleChatMessage, key *GoogleChatMessageKey, fields []common.Field, params common.ExecuteParams) string {
+
+	nParams := make(common.ExecuteParams)
+	for k, v := range params {
+		if utils.IsEmpty(v) {
+			continue
+		}
+		nParams[k] = v
+	}
+
+	formID := common.UUID()
+	g.forms.Set(formID, &GoogleChatForm{
+		message: m,
+		key:     key,
+		fields:  fields,
+		params:  nParams,
+	}, ttlcache.DefaultTTL)
+	return formID
+}
+
+func (g *GoogleChat) findForm(formID string) *GoogleChatForm {
+
+	item := g.forms.Get(formID)
+	if item != nil {
+		return item.Value()
+	}
+	return nil
+}
+
+// messages cannot open dialogs, so ask with a button which opens it
+func (g *GoogleChat) askForm(m *GoogleChatMessage, formID string) error {
+
+	buttons := []interface{}{
+		g.newButton(g.options.ButtonFormCaption, "primary", googleChatFormButtonType, googleChatFormSubmit, formID, true),
+		g.newButton(g.options.ButtonCancelCaption, "", googleChatFormButtonType, googleChatFormCancel, formID, false),
+	}
+
+	msg := &GoogleChatAPIMessage{
+		Text:    common.LimitText(fmt.Sprintf("`%s`", m.cmdText), googleChatMaxTextLength, googleChatTrimmed),
+		CardsV2: []*GoogleChatCard{g.newCard([]interface{}{g.newButtonList(buttons)})},
+	}
+
+	key, err := g.send(m.key.space, m.key.thread, msg)
+	if err != nil {
+		return err
+	}
+
+	// dialog may be handled meanwhile, so form with key replaces the cached one instead of changing it
+	form := g.findForm(formID)
+	if form != nil {
+		f := *form
+		f.key = key
+		g.forms.Set(formID, &f, ttlcache.DefaultTTL)
+	}
+	return nil
+}
+
+func (g *GoogleChat) formValues(form *GoogleChatForm, inputs map[string]*GoogleChatFormInput) {
+
+	for _, field := range form.fields {
+
+		if field.Type == common.FieldTypeMarkdown {
+			continue
+		}
+
+		values := []string{}
+		if input, ok := inputs[field.Name]; ok && input.StringInputs != nil {
+			values = common.RemoveEmptyStrings(input.StringInputs.Value)
+		}
+
+		if len(values) == 0 {
+			// switches send nothing when they are off
+			if field.Type == common.FieldTypeBool {
+				form.params[field.Name] = fmt.Sprintf("%v", false)
+				continue
+			}
+			delete(form.params, field.Name)
+			continue
+		}
+		form.params[field.Name] = common.FieldValue(field, strings.TrimSpace(strings.Join(values, ",")))
+	}
+}
+
+func (g *GoogleChat) dialogResponse(formID string, form *GoogleChatForm) map[string]interface{} {
+
+	header := make(map[string]interface{})
+	header["title"] = form.message.cmdText
+
+	submit := g.newButton(g.options.ButtonSubmitCaption, "primary", googleChatFormButtonType, googleChatFormSubmit, formID, false)
+
+	card := make(map[string]interface{})
+	card["header"] = header
+	card["sections"] = []interface{}{
+		map[string]interface{}{"widgets": g.formWidgets(form.fields, form.params)},
+	}
+	card["fixedFooter"] = map[string]interface{}{"primaryButton": submit}
+
+	dialogAction := make(map[string]interface{})
+	dialogAction["dialog"] = map[string]interface{}{"body": card}
+
+	return map[string]interface{}{
+		"actionResponse": map[string]interface{}{
+			"type":         googleChatResponseDialog,
+			"dialogAction": dialogAction,
+		},
+	}
+}
+
+func (g *GoogleChat) dialogStatus(code, message string) map[string]interface{} {
+
+	status := make(map[string]interface{})
+	status["statusCode"] = code
+	if !utils.IsEmpty(message) {
+		status["userFacingMessage"] = message
+	}
+
+	return map[string]interface{}{
+		"actionResponse": map[string]interface{}{
+			"type": googleChatResponseDialog,
+			"dialogAction": map[string]interface{}{
+				"actionStatus": status,
+			},
+		},
+	}
+}
+
+// replaces the clicked card message with text only
+func (g *GoogleChat) updateResponse(text string) map[string]interface{} {
+
+	return map[string]interface{}{
+		"actionResponse": map[string]interface{}{
+			"type": googleChatResponseUpdateMessage,
+		},
+		"text":    common.LimitText(text, googleChatMaxTextLength, googleChatTrimmed),
+		"cardsV2": []interface{}{},
+	}
+}
+
+func (g *GoogleChat) handleFormButton(event *GoogleChatEvent, caller *GoogleChatUser, name, formID string) (interface{}, error) {
+
+	form := g.findForm(formID)
+	if form == nil {
+		return nil, fmt.Errorf("GoogleChat form is not found")
+	}
+
+	m := form.message
+	if caller.id != m.userID() {
+		return nil, fmt.Errorf("GoogleChat form belongs to another user")
+	}
+
+	switch event.DialogEventType {
+	case googleChatDialogRequest:
+		return g.dialogResponse(formID, form), nil
+	case googleChatDialogCancel:
+		return g.dialogStatus(googleChatStatusOK, ""), nil
+	case googleChatDialogSubmit:
+
+		inputs := make(map[string]*GoogleChatFormInput)
+		if event.Common != nil && event.Common.FormInputs != nil {
+			inputs = event.Common.FormInputs
+		}
+		g.formValues(form, inputs)
+		if common.FormNeeded(form.fields, form.params) {
+			return g.dialogStatus(googleChatStatusInvalid, "GoogleChat form has empty required fields"), nil
+		}
+		g.forms.Delete(formID)
+
+		params := common.MergeInterfaceMaps(m.params, form.params)
+		m.params = params
+		g.putMessageToCache(m)
+
+		go func() {
+			// close the form prompt to avoid double submit
+			if form.key != nil {
+				err := g.update(form.key.name, &GoogleChatAPIMessage{Text: common.LimitText(fmt.Sprintf("`%s`", m.cmdText), googleChatMaxTextLength, googleChatTrimmed)})
+				if err != nil {
+					g.logger.Error("GoogleChat couldn't update form: %s", err)
+				}
+			}
+			g.approveOrExecute(m, g.transformParams(form.fields, params))
+		}()
+		return g.dialogStatus(googleChatStatusOK, ""), nil
+	}
+
+	if name != googleChatFormCancel {
+		return nil, nil
+	}
+	g.forms.Delete(formID)
+	return g.updateResponse(fmt.Sprintf("`%s`\n%s", m.cmdText, g.options.FormCancelled)), nil
+}
+
+func (g *GoogleChat) handleActionButton(m *GoogleChatMessage, caller *GoogleChatUser, name string) error {
+
+	if m.cmd == nil {
+		return fmt.Errorf("GoogleChat message has no command")
+	}
+
+	var action common.Action
+	for _, a := range m.actions {
+		if a.Name() == name {
+			action = a
+			break
+		}
+	}
+
+	if action == nil {
+		return fmt.Errorf("GoogleChat action %s is not defined", name)
+	}
+
+	mAction := g.cloneMessage(m)
+	mAction.caller = caller
+	mAction.cmdText = ""
+
+	go func() {
+		r := common.BuildResponse(false, m.cmd.Response())
+		err := g.cachePostUserCommand(mAction, m.params, action, r, true)
+		if err != nil {
+			g.logger.Error("GoogleChat couldn't execute action %s: %s", name, err)
+		}
+	}()
+	return nil
+}
+
+func (g *GoogleChat) handleApprovalButton(m *GoogleChatMessage, caller *GoogleChatUser, name string) (interface{}, error) {
+
+	if m.cmd == nil || m.originKey == nil {
+		return nil, fmt.Errorf("GoogleChat approval has no command")
+	}
+
+	if !g.options.ApprovalAny && caller.id == m.userID() {
+		return nil, fmt.Errorf("GoogleChat same user cannot approve its action")
+	}
+
+	caption := g.options.ButtonRejectCaption
+	if name == googleChatApprovalSubmit {
+		caption = g.options.ButtonApproveCaption
+	}
+	g.messages.Delete(m.key.String())
+
+	if name == googleChatApprovalSubmit {
+		mInit := g.cloneMessage(m)
+		mInit.key = m.originKey
+		mInit.originKey = nil
+
+		go g.executeCommand(mInit, m.params, nil)
+	}
+
+	// remove buttons to avoid double approval
+	return g.updateResponse(fmt.Sprintf("%s\n\n*%s*: %s", m.text, caption, caller.name)), nil
+}
+
+func (g *GoogleChat) clickParameters(event *GoogleChatEvent) (string, map[string]string) {
+
+	typ := ""
+	params := make(map[string]string)
+
+	if event.Action != nil {
+		typ = event.Action.ActionMethodName
+		for _, p := range event.Action.Parameters {
+			params[p.Key] = p.Value
+		}
+	}
+	if event.Common != nil {
+		if !utils.IsEmpty(event.Common.InvokedFunction) {
+			typ = event.Common.InvokedFunction
+		}
+		for k, v := range event.Common.Parameters {
+			params[k] = v
+		}
+	}
+	return typ, params
+}
+
+func (g *GoogleChat) processClick(event *GoogleChatEvent) interface{} {
+
+	typ, params := g.clickParameters(event)
+	name := params[googleChatParamName]
+	if utils.IsEmpty(typ) {
+		g.logger.Debug("GoogleChat click has no chatops data")
+		return nil
+	}
+
+	caller := g.buildGoogleChatUser(event)
+	if caller == nil {
+		g.logger.Error("GoogleChat couldn't process click from unknown user")
+		return nil
+	}
+
+	if typ == googleChatFormButtonType {
+		r, err := g.handleFormButton(event, caller, name, params[googleChatParamID])
+		if err != nil {
+			g.logger.Error(err)
+			if event.IsDialogEvent {
+				return g.dialogStatus(googleChatStatusInvalid, err.Error())
+			}
+		}
+		return r
+	}
+
+	if event.Message == nil {
+		return nil
+	}
+
+	m := g.findMessageInCache(&GoogleChatMessageKey{space: event.Space.Name, name: event.Message.Name})
+	if m == nil {
+		g.logger.Error("GoogleChat message is not found in cache.")
+		return nil
+	}
+
+	var r interface{}
+	var err error
+	switch typ {
+	case googleChatActionButtonType:
+		err = g.handleActionButton(m, caller, name)
+	case googleChatApprovalButtonType:
+		r, err = g.handleApprovalButton(m, caller, name)
+	}
+
+	if err != nil {
+		g.logger.Error(err)
+		mErr := g.cloneMessage(m)
+		mErr.cmdText = ""
+		go g.replyError(mErr, err)
+	}
+	return r
+}
+
+func (g *GoogleChat) processText(event *GoogleChatEvent) interface{} {
+
+	if event.Message == nil {
+		return nil
+	}
+
+	u := g.buildGoogleChatUser(event)
+	if u == nil {
+		g.logger.Error("GoogleChat couldn't process command from unknown user")
+		return nil
+	}
+
+	text := g.eventText(event.Message)
+	fText := g.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := g.processors.FindParams(false, fText)
+
+	if cmd == nil && !utils.IsEmpty(g.options.DefaultCommand) {
+		cmd = g.processors.FindCommand("", g.options.DefaultCommand)
+		group = ""
+		params = make(common.ExecuteParams)
+	}
+
+	if cmd == nil {
+		params, cmd = g.processors.FindSuggest(fText)
+		group = ""
+	}
+
+	if cmd == nil {
+		g.logger.Debug("GoogleChat command not found for text: %s", text)
+		common.UpdateCounters(g.meter, "googlechat", "", "", fText, u.id)
+		return nil
+	}
+
+	common.UpdateCounters(g.meter, "googlechat", group, cmd.Name(), fText, u.id)
+
+	groupName := common.GroupName(group, cmd)
+	if !common.Permitted(u, cmd, groupName) {
+		g.logger.Error("GoogleChat user %s is not permitted to execute %s", u.id, groupName)
+		return nil
+	}
+
+	key := &GoogleChatMessageKey{
+		space: event.Space.Name,
+		name:  event.Message.Name,
+	}
+	if event.Message.Thread != nil {
+		key.thread = event.Message.Thread.Name
+	}
+
+	m := &GoogleChatMessage{
+		googleChat: g,
+		cmdText:    fText,
+		cmd:        cmd,
+		key:        key,
+		user:       u,
+		caller:     u,
+		visible:    true,
+		text:       text,
+	}
+
+	// args couldn't be parsed, so usage is replied before form or approval
+	if pErr != nil {
+		go g.replyError(m, pErr)
+		return nil
+	}
+
+	dialog := event.IsDialogEvent && event.DialogEventType == googleChatDialogRequest
+	return g.processCommand(m, params, dialog)
+}
+
+func (g *GoogleChat) processEvent(event *GoogleChatEvent) interface{} {
+
+	if event.Space == nil {
+		return nil
+	}
+
+	if g.options.Debug {
+		text := ""
+		if event.Message != nil {
+			text = event.Message.Text
+		}
+		g.logger.Debug("GoogleChat event: %s [%s] %s", event.Type, event.Space.Name, text)
+	}
+
+	switch event.Type {
+	case googleChatEventMessage:
+		return g.processText(event)
+	case googleChatEventCardClicked:
+		return g.processClick(event)
+	}
+	return nil
+}
+
+func (g *GoogleChat) eventsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		g.logger.Error("GoogleChat couldn't read event: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = g.verifyToken(r.Header.Get("Authorization"))
+	if err != nil {
+		g.logger.Error(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	event := &GoogleChatEvent{}
+	err = json.Unmarshal(body, event)
+	if err != nil {
+		g.logger.Error("GoogleChat couldn't decode event: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// dialogs and card updates are answered at once, replies go through the API
+	var res interface{} = struct{}{}
+	if obj := g.processEvent(event); obj != nil {
+		res = obj
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		g.logger.Error("GoogleChat couldn't encode response: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(b); err != nil {
+		g.logger.Error("GoogleChat couldn't write response: %s", err)
+	}
+}
+
+func (g *GoogleChat) parentMessage(parent common.Message) *GoogleChatMessage {
+
+	if utils.IsEmpty(parent) {
+		return nil
+	}
+	gm, ok := parent.(*GoogleChatMessage)
+	if !ok {
+		return nil
+	}
+	if gm.key != nil {
+		if mc := g.findMessageInCache(gm.key); mc != nil {
+			return mc
+		}
+	}
+	return gm
+}
+
+func (g *GoogleChat) Command(channel, text string, user common.User, parent common.Message, response common.Response) error {
+
+	r := common.BuildResponse(true, response)
+
+	mOrigin := g.parentMessage(parent)
+	if mOrigin != nil && mOrigin.cmd != nil {
+		r = common.BuildResponse(false, mOrigin.cmd.Response(), response)
+	}
+
+	var mUser *GoogleChatUser
+	userID := "unknown"
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*GoogleChatUser)
+		if ok {
+			mUser = u
+		}
+		userID = user.ID()
+	}
+
+	fText := g.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := g.processors.FindParams(false, fText)
+	if cmd == nil {
+		g.logger.Debug("GoogleChat command not found for text: %s", text)
+		return nil
+	}
+
+	groupName := common.GroupName(group, cmd)
+	if !utils.IsEmpty(user) && !utils.Contains(user.Commands(), groupName) {
+		g.logger.Debug("GoogleChat command user %s is not permitted to execute %s", userID, groupName)
+		return nil
+	}
+
+	if pErr != nil {
+		return pErr
+	}
+
+	fields := cmd.Fields(g, parent, params, nil)
+	if common.FormNeeded(fields, params) {
+		g.logger.Debug("GoogleChat command %s has no support for interaction mode", groupName)
+		return nil
+	}
+
+	key := &GoogleChatMessageKey{}
+	if mOrigin != nil && mOrigin.key != nil {
+		key.space = mOrigin.key.space
+		key.thread = mOrigin.key.thread
+	}
+	if !utils.IsEmpty(channel) {
+		if channel != key.space {
+			key.thread = ""
+		}
+		key.space = channel
+	}
+
+	var m *GoogleChatMessage
+	if mOrigin != nil {
+		m = g.cloneMessage(mOrigin)
+		m.originKey = mOrigin.key
+	} else {
+		m = &GoogleChatMessage{
+			googleChat: g,
+			user:       mUser,
+			caller:     mUser,
+		}
+	}
+	m.cmdText = fText
+	m.cmd = cmd
+	m.key = key
+	m.fields = fields
+	m.params = params
+
+	err := g.cachePostUserCommand(m, params, nil, r, true)
+	if err != nil {
+		g.logger.Error("GoogleChat command %s couldn't post from %s: %s", groupName, userID, err)
+		return err
+	}
+	return nil
+}
+
+func (g *GoogleChat) PostMessage(channel string, message string, attachments []*common.Attachment, actions []common.Action,
+	user common.User, parent common.Message, response common.Response) (string, error) {
+
+	r := common.BuildResponse(true, response)
+
+	thread := ""
+	mOrigin := g.parentMessage(parent)
+	if mOrigin != nil && mOrigin.key != nil {
+		thread = mOrigin.key.thread
+		if utils.IsEmpty(channel) {
+			channel = mOrigin.key.space
+		}
+		if mOrigin.key.space != channel {
+			thread = ""
+		}
+	}
+
+	if utils.IsEmpty(channel) {
+		return "", fmt.Errorf("GoogleChat space is not defined")
+	}
+
+	msg := g.buildMessage(message, attachments, actions)
+	key, err := g.send(channel, thread, msg)
+	if err != nil {
+		return "", err
+	}
+
+	var mUser *GoogleChatUser
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*GoogleChatUser)
+		if ok {
+			mUser = u
+		}
+	}
+
+	var m *GoogleChatMessage
+	if mOrigin != nil {
+		m = g.cloneMessage(mOrigin)
+		m.originKey = mOrigin.key
+	} else {
+		m = &GoogleChatMessage{
+			googleChat: g,
+			user:       mUser,
+			caller:     mUser,
+		}
+	}
+	m.key = key
+	m.visible = r.Visible()
+	m.text = msg.Text
+	m.actions = actions
+	g.putMessageToCache(m)
+
+	return key.name, nil
+}
+
+func (g *GoogleChat) start() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(g.options.Path, g.eventsHandler)
+
+	g.logger.Info("GoogleChat is listening on %s%s", g.options.Listen, g.options.Path)
+
+	err := http.ListenAndServe(g.options.Listen, mux)
+	if err != nil {
+		g.logger.Error("GoogleChat listen error: %s", err)
+	}
+}
+
+func (g *GoogleChat) Start(wg *sync.WaitGroup) {
+
+	if wg == nil {
+		g.start()
+		return
+	}
+
+	wg.Add(1)
+
+	go func(wg *sync.WaitGroup) {
+
+		defer wg.Done()
+		g.start()
+	}(wg)
+}
+
+func loadGoogleChatCredentials(file string) (*GoogleChatCredentials, *rsa.PrivateKey, error) {
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credentials := &GoogleChatCredentials{}
+	err = json.Unmarshal(b, credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode([]byte(credentials.PrivateKey))
+	if block == nil {
+		return nil, nil, fmt.Errorf("GoogleChat credentials have no private key")
+	}
+
+	var key interface{}
+	key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("GoogleChat private key is not RSA")
+	}
+	return credentials, privateKey, nil
+}
+
+func NewGoogleChat(options GoogleChatOptions, observability *common.Observability, processors *common.Processors) *GoogleChat {
+
+	if utils.IsEmpty(options.Listen) {
+		return nil
+	}
+
+	logger := observability.Logs()
+
+	// events carry user, they can be trusted only when token is verified against project number
+	if utils.IsEmpty(options.Audience) {
+		logger.Error("GoogleChat couldn't start without audience")
+		return nil
+	}
+
+	var credentials *GoogleChatCredentials
+	var privateKey *rsa.PrivateKey
+	if !utils.IsEmpty(options.CredentialsFile) {
+		c, k, err := loadGoogleChatCredentials(options.CredentialsFile)
+		if err != nil {
+			logger.Error("GoogleChat credentials error: %s", err)
+			return nil
+		}
+		credentials = c
+		privateKey = k
+	}
+
+	ttl := 1 * 60 * 60 * time.Second
+	if !utils.IsEmpty(options.CacheTTL) {
+		ttl, _ = time.ParseDuration(options.CacheTTL)
+	}
+
+	messages := ttlcache.New[string, *GoogleChatMessage](ttlcache.WithTTL[string, *GoogleChatMessage](ttl))
+	go messages.Start()
+
+	forms := ttlcache.New[string, *GoogleChatForm](ttlcache.WithTTL[string, *GoogleChatForm](ttl))
+	go forms.Start()
+
+	g := &GoogleChat{
+		options:     options,
+		processors:  processors,
+		client:      utils.NewHttpClient(options.Timeout, options.Insecure),
+		logger:      logger,
+		meter:       observability.Metrics(),
+		messages:    messages,
+		forms:       forms,
+		credentials: credentials,
+		privateKey:  privateKey,
+	}
+
+	g.verifier = common.NewJWTVerifier(common.JWTVerifierOptions{
+		Name:     "GoogleChat",
+		Issuer:   googleChatTokenIssuer,
+		Audience: options.Audience,
+	}, g.fetchKeys)
+	return g
+}
@@ -0,0 +1,438 @@
+package bot
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+)
+
+type testGoogleChatRequest struct {
+	method  string
+	path    string
+	query   string
+	auth    string
+	message *GoogleChatAPIMessage
+}
+
+// testGoogleChatAPI stubs spaces.messages API, token endpoint and Google Chat keys, requests to API are recorded
+type testGoogleChatAPI struct {
+	*httptest.Server
+	chatKey    *rsa.PrivateKey
+	mutex      sync.Mutex
+	requests   []*testGoogleChatRequest
+	assertions []*common.JWTClaims
+}
+
+func (s *testGoogleChatAPI) handle(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case r.URL.Path == "/token":
+		r.ParseForm()
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		claims := &common.JWTClaims{}
+		if len(parts) == 3 {
+			b, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			json.Unmarshal(b, claims)
+		}
+		s.assertions = append(s.assertions, claims)
+		json.NewEncoder(w).Encode(&GoogleChatToken{AccessToken: "access-token", ExpiresIn: 3600})
+	case r.URL.Path == "/keys":
+		w.Write(testJWKS(s.chatKey, "chat"))
+	case strings.HasPrefix(r.URL.Path, "/v1/"):
+		message := &GoogleChatAPIMessage{}
+		json.NewDecoder(r.Body).Decode(message)
+		s.requests = append(s.requests, &testGoogleChatRequest{
+			method:  r.Method,
+			path:    strings.TrimPrefix(r.URL.Path, "/v1/"),
+			query:   r.URL.RawQuery,
+			auth:    r.Header.Get("Authorization"),
+			message: message,
+		})
+		if r.Method != http.MethodPost {
+			return
+		}
+		space := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/"), "/messages")
+		message.Name = fmt.Sprintf("%s/messages/R%d", space, len(s.messages()))
+		if message.Thread == nil {
+			message.Thread = &GoogleChatThread{Name: fmt.Sprintf("%s/threads/N%d", space, len(s.requests))}
+		}
+		json.NewEncoder(w).Encode(message)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// messages returns requests creating messages, mutex is held by caller
+func (s *testGoogleChatAPI) messages() []*testGoogleChatRequest {
+
+	r := []*testGoogleChatRequest{}
+	for _, req := range s.requests {
+		if req.method == http.MethodPost {
+			r = append(r, req)
+		}
+	}
+	return r
+}
+
+func (s *testGoogleChatAPI) recorded() ([]*testGoogleChatRequest, []*testGoogleChatRequest) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	updates := []*testGoogleChatRequest{}
+	for _, req := range s.requests {
+		if req.method == http.MethodPatch {
+			updates = append(updates, req)
+		}
+	}
+	return s.messages(), updates
+}
+
+func (s *testGoogleChatAPI) waitMessages(t *testing.T, count int) []*testGoogleChatRequest {
+
+	t.Helper()
+	testWait(t, func() bool {
+		messages, _ := s.recorded()
+		return len(messages) >= count
+	})
+	messages, _ := s.recorded()
+	return messages
+}
+
+// testGoogleChatCredentials writes service account file with key, token is issued by stub
+func testGoogleChatCredentials(t *testing.T, tokenURI string) string {
+
+	t.Helper()
+	key := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testRSAKey(t))})
+	b, _ := json.Marshal(&GoogleChatCredentials{
+		Type:         "service_account",
+		PrivateKeyID: "sa",
+		PrivateKey:   string(key),
+		ClientEmail:  "chatops@project.iam.gserviceaccount.com",
+		TokenURI:     tokenURI,
+	})
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	err := os.WriteFile(path, b, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestGoogleChat(t *testing.T, options GoogleChatOptions, processors *common.Processors) (*GoogleChat, *testGoogleChatAPI) {
+
+	t.Helper()
+	s := &testGoogleChatAPI{chatKey: testRSAKey(t)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+
+	options.Listen = ":0"
+	options.Path = "/googlechat"
+	options.Audience = "123"
+	options.APIURL = s.URL + "/v1"
+	options.KeysURL = s.URL + "/keys"
+	options.CredentialsFile = testGoogleChatCredentials(t, s.URL+"/token")
+	options.Timeout = 5
+	options.ButtonFormCaption = "Fill"
+	options.ButtonSubmitCaption = "Submit"
+	options.ButtonCancelCaption = "Cancel"
+	options.ButtonApproveCaption = "Approve"
+	options.ButtonRejectCaption = "Reject"
+	options.FormCancelled = "Cancelled"
+
+	g := NewGoogleChat(options, testObservability(), processors)
+	if g == nil {
+		t.Fatal("expected GoogleChat bot")
+	}
+	t.Cleanup(g.messages.Stop)
+	t.Cleanup(g.forms.Stop)
+	return g, s
+}
+
+func testGoogleChatEvent(t *testing.T, name string) *GoogleChatEvent {
+
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "googlechat", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &GoogleChatEvent{}
+	err = json.Unmarshal(b, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// testGoogleChatPost sends event to handler, response is decoded
+func testGoogleChatPost(t *testing.T, g *GoogleChat, event *GoogleChatEvent, authorization string) (int, map[string]interface{}) {
+
+	t.Helper()
+	b, _ := json.Marshal(event)
+	r := httptest.NewRequest(http.MethodPost, g.options.Path, bytes.NewReader(b))
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	g.eventsHandler(w, r)
+
+	res := make(map[string]interface{})
+	if w.Code == http.StatusOK {
+		err := json.Unmarshal(w.Body.Bytes(), &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, res
+}
+
+// token signs claims with Google Chat key
+func (s *testGoogleChatAPI) token(t *testing.T, iss, aud string) string {
+
+	t.Helper()
+	return "Bearer " + testJWT(t, s.chatKey, "chat", map[string]interface{}{
+		"iss": iss,
+		"aud": aud,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+}
+
+// post sends event signed as Google Chat does
+func (s *testGoogleChatAPI) post(t *testing.T, g *GoogleChat, event *GoogleChatEvent) (int, map[string]interface{}) {
+
+	t.Helper()
+	return testGoogleChatPost(t, g, event, s.token(t, googleChatTokenIssuer, g.options.Audience))
+}
+
+func testGoogleChatResponse(res map[string]interface{}) string {
+
+	b, _ := json.Marshal(res)
+	return string(b)
+}
+
+func TestGoogleChatMessage(t *testing.T) {
+
+	c := &testCommand{name: "greet", text: "hello"}
+	g, s := newTestGoogleChat(t, GoogleChatOptions{}, testProcessors("ops", c))
+
+	code, res := s.post(t, g, testGoogleChatEvent(t, "message.json"))
+	if code != http.StatusOK || len(res) != 0 {
+		t.Fatalf("unexpected response %d %v", code, res)
+	}
+
+	messages := s.waitMessages(t, 1)
+	executed, _ := c.calls()
+	if len(executed) != 1 {
+		t.Fatalf("unexpected executions %v", executed)
+	}
+	m := messages[0]
+	if m.path != "spaces/AAAA/messages" || m.message.Text != "hello" || m.message.Thread.Name != "spaces/AAAA/threads/T1" {
+		t.Fatalf("unexpected message %s %v", m.path, m.message)
+	}
+	if m.query != "messageReplyOption="+googleChatReplyOption || m.auth != "Bearer access-token" {
+		t.Fatalf("unexpected request %s %s", m.query, m.auth)
+	}
+
+	if len(s.assertions) != 1 || s.assertions[0].Iss != "chatops@project.iam.gserviceaccount.com" || s.assertions[0].Scope != googleChatTokenScope {
+		t.Fatalf("unexpected token assertions %v", s.assertions)
+	}
+}
+
+func TestGoogleChatSlashCommand(t *testing.T) {
+
+	c := &testCommand{name: "greet", text: "hello"}
+	g, s := newTestGoogleChat(t, GoogleChatOptions{}, testProcessors("ops", c))
+
+	s.post(t, g, testGoogleChatEvent(t, "slash.json"))
+
+	messages := s.waitMessages(t, 1)
+	executed, _ := c.calls()
+	if len(executed) != 1 || messages[0].message.Thread.Name != "spaces/AAAA/threads/T2" {
+		t.Fatalf("unexpected executions %v", executed)
+	}
+}
+
+func TestGoogleChatActionButton(t *testing.T) {
+
+	c := &testCommand{name: "greet", text: "hello", actions: []common.Action{&testAction{name: "again", label: "Again"}}}
+	g, s := newTestGoogleChat(t, GoogleChatOptions{}, testProcessors("ops", c))
+
+	event := testGoogleChatEvent(t, "message.json")
+	event.Message.ArgumentText = "ops greet"
+	s.post(t, g, event)
+
+	messages := s.waitMessages(t, 1)
+	b, _ := json.Marshal(messages[0].message.CardsV2)
+	if !strings.Contains(string(b), `"text":"Again"`) || !strings.Contains(string(b), `"function":"a"`) {
+		t.Fatalf("unexpected cards %s", string(b))
+	}
+
+	s.post(t, g, testGoogleChatEvent(t, "card_clicked.json"))
+	messages = s.waitMessages(t, 2)
+	_, triggered := c.calls()
+	if strings.Join(triggered, ",") != "again" || messages[1].message.Text != "triggered again" {
+		t.Fatalf("unexpected action %v, message %v", triggered, messages[1].message)
+	}
+}
+
+func TestGoogleChatForm(t *testing.T) {
+
+	c := &testCommand{name: "deploy", text: "deployed", fields: []common.Field{
+		{Name: "env", Type: common.FieldTypeSelect, Required: true, Values: []string{"dev", "prod"}},
+	}}
+	g, s := newTestGoogleChat(t, GoogleChatOptions{}, testProcessors("ops", c))
+
+	event := testGoogleChatEvent(t, "message.json")
+	event.Message.ArgumentText = "ops deploy"
+	s.post(t, g, event)
+
+	messages := s.waitMessages(t, 1)
+	if messages[0].message.Text != "`ops deploy`" {
+		t.Fatalf("expected form prompt, got %v", messages[0].message)
+	}
+	keys := g.forms.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected one form, got %v", keys)
+	}
+	// prompt is remembered by form after it's sent
+	testWait(t, func() bool {
+		form := g.findForm(keys[0])
+		return form != nil && form.key != nil
+	})
+
+	request := testGoogleChatEvent(t, "dialog_submit.json")
+	request.DialogEventType = googleChatDialogRequest
+	request.Common.Parameters[googleChatParamID] = keys[0]
+	_, res := s.post(t, g, request)
+	if text := testGoogleChatResponse(res); !strings.Contains(text, `"type":"DIALOG"`) || !strings.Contains(text, `"name":"env"`) {
+		t.Fatalf("expected dialog, got %s", text)
+	}
+
+	// form belongs to requester only
+	other := testGoogleChatEvent(t, "dialog_submit.json")
+	other.User = &GoogleChatAccount{Name: "users/222", DisplayName: "Bob"}
+	other.Common.Parameters[googleChatParamID] = keys[0]
+	_, res = s.post(t, g, other)
+	if text := testGoogleChatResponse(res); !strings.Contains(text, "GoogleChat form belongs to another user") {
+		t.Fatalf("expected form error, got %s", text)
+	}
+
+	submit := testGoogleChatEvent(t, "dialog_submit.json")
+	submit.Common.Parameters[googleChatParamID] = keys[0]
+	_, res = s.post(t, g, submit)
+	if text := testGoogleChatResponse(res); !strings.Contains(text, `"statusCode":"OK"`) {
+		t.Fatalf("expected dialog to be closed, got %s", text)
+	}
+
+	messages = s.waitMessages(t, 2)
+	executed, _ := c.calls()
+	if len(executed) != 1 || executed[0]["env"] != "prod" {
+		t.Fatalf("unexpected executions %v", executed)
+	}
+	if messages[1].message.Text != "deployed" || messages[1].message.Thread.Name != "spaces/AAAA/threads/T1" {
+		t.Fatalf("expected reply in thread, got %v", messages[1].message)
+	}
+	_, updates := s.recorded()
+	if len(updates) != 1 || updates[0].path != messages[0].message.Name || len(updates[0].message.CardsV2) != 0 {
+		t.Fatalf("expected prompt without buttons, got %v", updates)
+	}
+}
+
+func TestGoogleChatApproval(t *testing.T) {
+
+	c := &testCommand{name: "deploy", text: "deployed", approval: &testApproval{message: "Approve deploy?"}}
+	g, s := newTestGoogleChat(t, GoogleChatOptions{}, testProcessors("ops", c))
+
+	event := testGoogleChatEvent(t, "message.json")
+	event.Message.ArgumentText = "ops deploy"
+	s.post(t, g, event)
+
+	messages := s.waitMessages(t, 1)
+	if messages[0].message.Text != "Approve deploy?" || len(messages[0].message.CardsV2) != 1 {
+		t.Fatalf("expected approval, got %v", messages[0].message)
+	}
+
+	click := testGoogleChatEvent(t, "card_clicked.json")
+	click.User = event.User
+	click.Common.InvokedFunction = googleChatApprovalButtonType
+	click.Common.Parameters[googleChatParamName] = googleChatApprovalSubmit
+	_, res := s.post(t, g, click)
+	if len(res) != 0 {
+		t.Fatalf("expected requester not to approve, got %v", res)
+	}
+	messages = s.waitMessages(t, 2)
+	if messages[1].message.Text != "*Error:* GoogleChat same user cannot approve its action" {
+		t.Fatalf("expected same user error, got %v", messages[1].message)
+	}
+
+	click = testGoogleChatEvent(t, "card_clicked.json")
+	click.Common.InvokedFunction = googleChatApprovalButtonType
+	click.Common.Parameters[googleChatParamName] = googleChatApprovalSubmit
+	_, res = s.post(t, g, click)
+	if text := testGoogleChatResponse(res); !strings.Contains(text, `"type":"UPDATE_MESSAGE"`) || res["text"] != "Approve deploy?\n\n*Approve*: Bob" {
+		t.Fatalf("expected approval without buttons, got %s", text)
+	}
+
+	messages = s.waitMessages(t, 3)
+	executed, _ := c.calls()
+	if len(executed) != 1 || messages[2].message.Text != "deployed" {
+		t.Fatalf("expected execution after approval, got %v", executed)
+	}
+}
+
+func TestGoogleChatEventsHandler(t *testing.T) {
+
+	c := &testCommand{name: "greet", text: "hello"}
+	g, s := newTestGoogleChat(t, GoogleChatOptions{}, testProcessors("ops", c))
+
+	event := testGoogleChatEvent(t, "message.json")
+	event.Message.ArgumentText = "ops greet"
+
+	for name, authorization := range map[string]string{
+		"missing":   "",
+		"issuer":    s.token(t, "someone@example.com", "123"),
+		"audience":  s.token(t, googleChatTokenIssuer, "456"),
+		"signature": "Bearer " + testJWT(t, testRSAKey(t), "chat", map[string]interface{}{"iss": googleChatTokenIssuer, "aud": "123", "exp": time.Now().Add(time.Hour).Unix()}),
+	} {
+		if code, _ := testGoogleChatPost(t, g, event, authorization); code != http.StatusUnauthorized {
+			t.Fatalf("expected %s token to be rejected, got %d", name, code)
+		}
+	}
+
+	code, _ := s.post(t, g, event)
+	if code != http.StatusOK {
+		t.Fatalf("expected valid token to be accepted, got %d", code)
+	}
+	s.waitMessages(t, 1)
+	executed, _ := c.calls()
+	if len(executed) != 1 {
+		t.Fatalf("unexpected executions %v", executed)
+	}
+}
+
+func TestGoogleChatAudience(t *testing.T) {
+
+	// without audience events couldn't be verified, so bot doesn't start
+	g := NewGoogleChat(GoogleChatOptions{Listen: ":0"}, testObservability(), testProcessors("ops"))
+	if g != nil {
+		t.Fatal("expected GoogleChat not to start without audience")
+	}
+}
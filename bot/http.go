@@ -0,0 +1,980 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/jinzhu/copier"
+)
+
+type HTTPOptions struct {
+	Listen          string
+	Cert            string
+	Key             string
+	Tokens          string
+	Channel         string
+	Debug           bool
+	UserPermissions string
+	CacheTTL        string
+}
+
+type HTTPUser struct {
+	id       string
+	name     string
+	timezone string
+	commands []string
+}
+
+type HTTPChannel struct {
+	id string
+}
+
+// collects everything posted while a request is processed
+type HTTPResult struct {
+	mutex    *sync.Mutex
+	messages []*HTTPMessageResult
+}
+
+type HTTPMessage struct {
+	http      *HTTP
+	id        string
+	parentID  string
+	channelID string
+	cmdText   string
+	group     string
+	cmd       common.Command
+	user      *HTTPUser
+	caller    *HTTPUser
+	visible   bool
+	text      string
+	actions   []common.Action
+	params    common.ExecuteParams
+	fields    []common.Field
+	result    *HTTPResult
+}
+
+type HTTPCommandRequest struct {
+	Group   string                 `json:"group,omitempty"`
+	Command string                 `json:"command,omitempty"`
+	Text    string                 `json:"text,omitempty"`
+	Channel string                 `json:"channel,omitempty"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+	Message string                 `json:"message,omitempty"`
+	Action  string                 `json:"action,omitempty"`
+}
+
+type HTTPAttachment struct {
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Type  string `json:"type,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+type HTTPAction struct {
+	Name     string `json:"name"`
+	Label    string `json:"label,omitempty"`
+	Template string `json:"template,omitempty"`
+	Style    string `json:"style,omitempty"`
+}
+
+type HTTPMessageResult struct {
+	ID          string            `json:"id"`
+	ParentID    string            `json:"parent_id,omitempty"`
+	Channel     string            `json:"channel,omitempty"`
+	Text        string            `json:"text,omitempty"`
+	Visible     bool              `json:"visible"`
+	Error       bool              `json:"error,omitempty"`
+	Attachments []*HTTPAttachment `json:"attachments,omitempty"`
+	Actions     []*HTTPAction     `json:"actions,omitempty"`
+	Reactions   []string          `json:"reactions,omitempty"`
+}
+
+type HTTPCommandResponse struct {
+	ID       string               `json:"id"`
+	Group    string               `json:"group,omitempty"`
+	Command  string               `json:"command"`
+	Messages []*HTTPMessageResult `json:"messages"`
+}
+
+type HTTPField struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Label        string   `json:"label,omitempty"`
+	Default      string   `json:"default,omitempty"`
+	Hint         string   `json:"hint,omitempty"`
+	Required     bool     `json:"required,omitempty"`
+	Values       []string `json:"values,omitempty"`
+	Dependencies []string `json:"dependencies,omitempty"`
+}
+
+type HTTPCommandInfo struct {
+	Group       string       `json:"group,omitempty"`
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Aliases     []string     `json:"aliases,omitempty"`
+	Params      []string     `json:"params,omitempty"`
+	Fields      []*HTTPField `json:"fields,omitempty"`
+	Approval    bool         `json:"approval,omitempty"`
+}
+
+type HTTPError struct {
+	Error  string   `json:"error"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+type HTTP struct {
+	options    HTTPOptions
+	processors *common.Processors
+	logger     sreCommon.Logger
+	meter      sreCommon.Meter
+	tokens     map[string]string
+	messages   *ttlcache.Cache[string, *HTTPMessage]
+}
+
+const (
+	httpCommandsPath = "/v1/commands"
+	httpBearerPrefix = "Bearer "
+)
+
+// HTTPUser
+
+func (hu *HTTPUser) ID() string {
+	return hu.id
+}
+
+func (hu *HTTPUser) Name() string {
+	return hu.name
+}
+
+func (hu *HTTPUser) TimeZone() string {
+	return hu.timezone
+}
+
+func (hu *HTTPUser) Commands() []string {
+	return hu.commands
+}
+
+// HTTPChannel
+
+func (hc *HTTPChannel) ID() string {
+	return hc.id
+}
+
+// HTTPMessage
+
+func (hm *HTTPMessage) ID() string {
+	return hm.id
+}
+
+func (hm *HTTPMessage) Visible() bool {
+	return hm.visible
+}
+
+func (hm *HTTPMessage) User() common.User {
+	return hm.user
+}
+
+func (hm *HTTPMessage) Caller() common.User {
+	return hm.caller
+}
+
+func (hm *HTTPMessage) Channel() common.Channel {
+	return &HTTPChannel{id: hm.channelID}
+}
+
+func (hm *HTTPMessage) ParentID() string {
+	return hm.parentID
+}
+
+func (hm *HTTPMessage) SetParentID(parentID string) {
+	hm.parentID = parentID
+}
+
+// HTTPResult
+
+func (hr *HTTPResult) add(m *HTTPMessageResult) {
+
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	hr.messages = append(hr.messages, m)
+}
+
+func (hr *HTTPResult) find(ID string) *HTTPMessageResult {
+
+	for _, m := range hr.messages {
+		if m.ID == ID {
+			return m
+		}
+	}
+	return nil
+}
+
+func (hr *HTTPResult) update(ID string, update func(m *HTTPMessageResult)) {
+
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	m := hr.find(ID)
+	if m != nil {
+		update(m)
+	}
+}
+
+func (hr *HTTPResult) list() []*HTTPMessageResult {
+
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	r := []*HTTPMessageResult{}
+	return append(r, hr.messages...)
+}
+
+// HTTP
+
+func (h *HTTP) Name() string {
+	return "HTTP"
+}
+
+func (h *HTTP) findMessageInCache(ID string) *HTTPMessage {
+
+	item := h.messages.Get(ID)
+	if item != nil {
+		return item.Value()
+	}
+	return nil
+}
+
+func (h *HTTP) putMessageToCache(m *HTTPMessage) {
+
+	if utils.IsEmpty(m.id) {
+		return
+	}
+	h.messages.Set(m.id, m, ttlcache.DefaultTTL)
+}
+
+func (h *HTTP) cloneMessage(m *HTTPMessage) *HTTPMessage {
+
+	if m == nil {
+		return nil
+	}
+	r := &HTTPMessage{}
+	err := copier.Copy(r, m)
+	if err != nil {
+		h.logger.Error("HTTP message copy error: %s", err)
+		return nil
+	}
+	return r
+}
+
+func (h *HTTP) buildAttachments(attachments []*common.Attachment) []*HTTPAttachment {
+
+	r := []*HTTPAttachment{}
+	for _, a := range attachments {
+		r = append(r, &HTTPAttachment{
+			Title: a.Title,
+			Text:  a.Text,
+			Type:  string(a.Type),
+			Data:  a.Data,
+		})
+	}
+	return r
+}
+
+func (h *HTTP) buildActions(actions []common.Action) []*HTTPAction {
+
+	r := []*HTTPAction{}
+	for _, a := range actions {
+		r = append(r, &HTTPAction{
+			Name:     a.Name(),
+			Label:    a.Label(),
+			Template: a.Template(),
+			Style:    a.Style(),
+		})
+	}
+	return r
+}
+
+func (h *HTTP) post(m *HTTPMessage, message string, attachments []*common.Attachment, actions []common.Action, error bool) string {
+
+	ID := common.UUID()
+	if m.result != nil {
+		m.result.add(&HTTPMessageResult{
+			ID:          ID,
+			ParentID:    m.id,
+			Channel:     m.channelID,
+			Text:        message,
+			Visible:     m.visible,
+			Error:       error,
+			Attachments: h.buildAttachments(attachments),
+			Actions:     h.buildActions(actions),
+		})
+	}
+	return ID
+}
+
+func (h *HTTP) findMessage(ID string) (*HTTPMessage, error) {
+
+	m := h.findMessageInCache(ID)
+	if m == nil {
+		return nil, fmt.Errorf("HTTP message not found with %s", ID)
+	}
+	return m, nil
+}
+
+func (h *HTTP) AddReaction(channel, ID, name string) error {
+
+	m, err := h.findMessage(ID)
+	if err != nil {
+		return err
+	}
+	if m.result != nil {
+		m.result.update(ID, func(r *HTTPMessageResult) {
+			if !utils.Contains(r.Reactions, name) {
+				r.Reactions = append(r.Reactions, name)
+			}
+		})
+	}
+	return nil
+}
+
+func (h *HTTP) RemoveReaction(channel, ID, name string) error {
+
+	m, err := h.findMessage(ID)
+	if err != nil {
+		return err
+	}
+	if m.result != nil {
+		m.result.update(ID, func(r *HTTPMessageResult) {
+			reactions := []string{}
+			for _, v := range r.Reactions {
+				if v != name {
+					reactions = append(reactions, v)
+				}
+			}
+			r.Reactions = reactions
+		})
+	}
+	return nil
+}
+
+func (h *HTTP) updateActions(channel, ID string, update func(m *HTTPMessage) []common.Action) error {
+
+	m, err := h.findMessage(ID)
+	if err != nil {
+		h.logger.Error(err)
+		return err
+	}
+
+	m.actions = update(m)
+	h.putMessageToCache(m)
+
+	if m.result != nil {
+		m.result.update(ID, func(r *HTTPMessageResult) {
+			r.Actions = h.buildActions(m.actions)
+		})
+	}
+	return nil
+}
+
+func (h *HTTP) AddAction(channel, ID string, action common.Action) error {
+
+	return h.updateActions(channel, ID, func(m *HTTPMessage) []common.Action {
+		return append(m.actions, action)
+	})
+}
+
+func (h *HTTP) AddActions(channel, ID string, actions []common.Action) error {
+
+	return h.updateActions(channel, ID, func(m *HTTPMessage) []common.Action {
+		return append(m.actions, actions...)
+	})
+}
+
+func (h *HTTP) RemoveAction(channel, ID, name string) error {
+
+	return h.updateActions(channel, ID, func(m *HTTPMessage) []common.Action {
+		actions := []common.Action{}
+		for _, a := range m.actions {
+			if a.Name() == name {
+				continue
+			}
+			actions = append(actions, a)
+		}
+		return actions
+	})
+}
+
+func (h *HTTP) ClearActions(channel, ID string) error {
+
+	return h.updateActions(channel, ID, func(m *HTTPMessage) []common.Action {
+		return nil
+	})
+}
+
+func (h *HTTP) DeleteMessage(channel, ID string) error {
+
+	h.messages.Delete(ID)
+	return nil
+}
+
+func (h *HTTP) ReadMessage(channel, ID string) (string, error) {
+
+	m, err := h.findMessage(ID)
+	if err != nil {
+		return "", err
+	}
+	return m.text, nil
+}
+
+func (h *HTTP) UpdateMessage(channel, ID, message string) error {
+
+	m, err := h.findMessage(ID)
+	if err != nil {
+		return err
+	}
+
+	m.text = message
+	h.putMessageToCache(m)
+
+	if m.result != nil {
+		m.result.update(ID, func(r *HTTPMessageResult) {
+			r.Text = message
+		})
+	}
+	return nil
+}
+
+func (h *HTTP) buildHTTPUser(name string) *HTTPUser {
+
+	u := &HTTPUser{
+		id:   name,
+		name: name,
+	}
+	commands, err := h.processors.UserCommands(h.options.UserPermissions, name, name)
+	if err != nil {
+		h.logger.Error("HTTP permissions error: %s", err)
+	}
+	u.commands = commands
+	return u
+}
+
+// Authorization: Bearer token => user
+func (h *HTTP) authorize(r *http.Request) *HTTPUser {
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, httpBearerPrefix) {
+		return nil
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(auth, httpBearerPrefix))
+	name, ok := h.tokens[token]
+	if !ok || utils.IsEmpty(token) {
+		return nil
+	}
+	return h.buildHTTPUser(name)
+}
+
+func (h *HTTP) prepareInputText(text string) string {
+
+	text = strings.TrimPrefix(strings.TrimSpace(text), "/")
+
+	return h.processors.ReplaceAlias(text)
+}
+
+func (h *HTTP) missingFields(fields []common.Field, params common.ExecuteParams) []string {
+
+	r := []string{}
+	for _, f := range fields {
+		if !f.Required || f.Type == common.FieldTypeMarkdown {
+			continue
+		}
+		if params == nil || utils.IsEmpty(params[f.Name]) {
+			r = append(r, f.Name)
+		}
+	}
+	return r
+}
+
+func (h *HTTP) cachePostUserCommand(m *HTTPMessage, params common.ExecuteParams, action common.Action, response common.Response, overwrite bool) error {
+
+	executor, message, attachments, actions, err := m.cmd.Execute(h, m, params, action)
+	if err != nil {
+		h.post(m, err.Error(), nil, nil, true)
+		return err
+	}
+	if action == nil {
+		actions = common.MergeActions(actions, m.cmd.Actions())
+	}
+
+	r := common.BuildResponse(overwrite, response, executor.Response())
+
+	mNew := h.cloneMessage(m)
+	mNew.parentID = m.id
+	mNew.visible = r.Visible()
+	mNew.text = message
+	mNew.actions = actions
+	mNew.params = params
+
+	if !utils.IsEmpty(message) || len(attachments) > 0 {
+		mNew.id = h.post(mNew, message, attachments, actions, r.Error())
+		h.putMessageToCache(mNew)
+	}
+	return executor.After(mNew)
+}
+
+func (h *HTTP) buildFields(fields []common.Field) []*HTTPField {
+
+	r := []*HTTPField{}
+	for _, f := range fields {
+		r = append(r, &HTTPField{
+			Name:         f.Name,
+			Type:         string(f.Type),
+			Label:        f.Label,
+			Default:      f.Default,
+			Hint:         f.Hint,
+			Required:     f.Required,
+			Values:       f.Values,
+			Dependencies: f.Dependencies,
+		})
+	}
+	return r
+}
+
+func (h *HTTP) writeJSON(w http.ResponseWriter, code int, obj interface{}) {
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		h.logger.Error("HTTP couldn't encode response: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(b); err != nil {
+		h.logger.Error("HTTP couldn't write response: %s", err)
+	}
+}
+
+func (h *HTTP) writeError(w http.ResponseWriter, code int, err error, fields ...string) {
+
+	if h.options.Debug {
+		h.logger.Debug("HTTP error: %s", err)
+	}
+	h.writeJSON(w, code, &HTTPError{Error: err.Error(), Fields: fields})
+}
+
+func (h *HTTP) listCommands(w http.ResponseWriter, u *HTTPUser) {
+
+	r := []*HTTPCommandInfo{}
+	for _, p := range h.processors.Items() {
+
+		group := p.Name()
+		for _, c := range p.Commands() {
+
+			if !common.Permitted(u, c, common.GroupName(group, c)) {
+				continue
+			}
+			r = append(r, &HTTPCommandInfo{
+				Group:       group,
+				Name:        c.Name(),
+				Description: c.Description(),
+				Aliases:     c.Aliases(),
+				Params:      c.Params(),
+				Fields:      h.buildFields(c.Fields(h, nil, nil, nil)),
+				Approval:    c.Approval() != nil,
+			})
+		}
+	}
+	h.writeJSON(w, http.StatusOK, r)
+}
+
+func (h *HTTP) findCommand(req *HTTPCommandRequest) (common.Command, string, common.ExecuteParams, string, error) {
+
+	if !utils.IsEmpty(req.Command) {
+		cmd := h.processors.FindCommand(req.Group, req.Command)
+		if cmd == nil {
+			return nil, req.Group, make(common.ExecuteParams), "", nil
+		}
+		// structured params are checked against declared args as text ones are
+		params, err := h.processors.MatchArgs(req.Group, cmd, nil, req.Params)
+		return cmd, req.Group, params, "", err
+	}
+
+	fText := h.prepareInputText(req.Text)
+	params, cmd, group, _, _, _, err := h.processors.FindParams(false, fText)
+	if cmd == nil || err != nil {
+		return cmd, group, params, fText, err
+	}
+	params, err = h.processors.MatchArgs(group, cmd, params, req.Params)
+	return cmd, group, params, fText, err
+}
+
+// run action of a message from previous response
+func (h *HTTP) runAction(w http.ResponseWriter, u *HTTPUser, req *HTTPCommandRequest) {
+
+	m := h.findMessageInCache(req.Message)
+	if m == nil || m.cmd == nil {
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("HTTP message %s is not found", req.Message))
+		return
+	}
+
+	var action common.Action
+	for _, a := range m.actions {
+		if a.Name() == req.Action {
+			action = a
+			break
+		}
+	}
+	if action == nil {
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("HTTP action %s is not defined", req.Action))
+		return
+	}
+
+	// group is kept from command, so actions are permitted by the same path
+	groupName := common.GroupName(m.group, m.cmd)
+	if !common.Permitted(u, m.cmd, groupName) {
+		h.writeError(w, http.StatusForbidden, fmt.Errorf("HTTP user %s is not permitted to execute %s", u.name, groupName))
+		return
+	}
+
+	result := &HTTPResult{mutex: &sync.Mutex{}}
+
+	mAction := h.cloneMessage(m)
+	mAction.caller = u
+	mAction.cmdText = ""
+	mAction.result = result
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	err := h.cachePostUserCommand(mAction, m.params, action, r, true)
+	if err != nil {
+		h.logger.Error("HTTP couldn't run action %s: %s", req.Action, err)
+	}
+
+	h.writeJSON(w, http.StatusOK, &HTTPCommandResponse{
+		ID:       mAction.id,
+		Group:    m.group,
+		Command:  m.cmd.Name(),
+		Messages: result.list(),
+	})
+}
+
+func (h *HTTP) runCommand(w http.ResponseWriter, u *HTTPUser, req *HTTPCommandRequest) {
+
+	cmd, group, params, text, pErr := h.findCommand(req)
+	if cmd == nil {
+		common.UpdateCounters(h.meter, "http", req.Group, req.Command, text, u.id)
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("HTTP command is not found"))
+		return
+	}
+
+	common.UpdateCounters(h.meter, "http", group, cmd.Name(), text, u.id)
+
+	groupName := common.GroupName(group, cmd)
+	if !common.Permitted(u, cmd, groupName) {
+		h.writeError(w, http.StatusForbidden, fmt.Errorf("HTTP user %s is not permitted to execute %s", u.name, groupName))
+		return
+	}
+
+	// args couldn't be parsed, so usage is replied before approval
+	if pErr != nil {
+		h.writeError(w, http.StatusBadRequest, pErr)
+		return
+	}
+
+	if utils.IsEmpty(text) {
+		text = strings.TrimSpace(fmt.Sprintf("%s %s", group, cmd.Name()))
+	}
+
+	channel := req.Channel
+	if utils.IsEmpty(channel) {
+		channel = h.options.Channel
+	}
+
+	result := &HTTPResult{mutex: &sync.Mutex{}}
+	m := &HTTPMessage{
+		http:      h,
+		id:        common.UUID(),
+		channelID: channel,
+		cmdText:   text,
+		group:     group,
+		cmd:       cmd,
+		user:      u,
+		caller:    u,
+		visible:   true,
+		text:      text,
+		result:    result,
+	}
+
+	fields := cmd.Fields(h, m, params, nil)
+	missing := h.missingFields(fields, params)
+	if len(missing) > 0 {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("HTTP command %s requires fields", groupName), missing...)
+		return
+	}
+
+	// approvals are asked in chats only
+	approval := cmd.Approval()
+	if approval != nil && !utils.IsEmpty(strings.TrimSpace(approval.Message(h, m, params))) {
+		h.writeError(w, http.StatusForbidden, fmt.Errorf("HTTP command %s requires approval", groupName))
+		return
+	}
+
+	params = common.FieldValues(fields, params)
+
+	m.fields = fields
+	m.params = params
+	h.putMessageToCache(m)
+
+	r := common.BuildResponse(false, cmd.Response())
+	err := h.cachePostUserCommand(m, params, nil, r, false)
+	if err != nil {
+		h.logger.Error("HTTP couldn't execute %s from %s: %s", groupName, u.id, err)
+	}
+
+	h.writeJSON(w, http.StatusOK, &HTTPCommandResponse{
+		ID:       m.id,
+		Group:    group,
+		Command:  cmd.Name(),
+		Messages: result.list(),
+	})
+}
+
+func (h *HTTP) commandsHandler(w http.ResponseWriter, r *http.Request) {
+
+	u := h.authorize(r)
+	if u == nil {
+		h.writeError(w, http.StatusUnauthorized, fmt.Errorf("HTTP unauthorized"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.listCommands(w, u)
+	case http.MethodPost:
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		req := &HTTPCommandRequest{}
+		err = json.Unmarshal(body, req)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if h.options.Debug {
+			h.logger.Debug("HTTP request from %s: %s", u.id, string(body))
+		}
+
+		if !utils.IsEmpty(req.Action) {
+			h.runAction(w, u, req)
+			return
+		}
+		h.runCommand(w, u, req)
+	default:
+		h.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("HTTP method %s is not allowed", r.Method))
+	}
+}
+
+func (h *HTTP) parentMessage(parent common.Message) *HTTPMessage {
+
+	if utils.IsEmpty(parent) {
+		return nil
+	}
+	hm, ok := parent.(*HTTPMessage)
+	if !ok {
+		return nil
+	}
+	if mc := h.findMessageInCache(hm.id); mc != nil {
+		return mc
+	}
+	return hm
+}
+
+func (h *HTTP) Command(channel, text string, user common.User, parent common.Message, response common.Response) error {
+
+	r := common.BuildResponse(true, response)
+
+	mOrigin := h.parentMessage(parent)
+	if mOrigin != nil && mOrigin.cmd != nil {
+		r = common.BuildResponse(false, mOrigin.cmd.Response(), response)
+	}
+
+	var mUser *HTTPUser
+	userID := "unknown"
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*HTTPUser)
+		if ok {
+			mUser = u
+		}
+		userID = user.ID()
+	}
+
+	fText := h.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := h.processors.FindParams(false, fText)
+	if cmd == nil {
+		h.logger.Debug("HTTP command not found for text: %s", text)
+		return nil
+	}
+
+	groupName := common.GroupName(group, cmd)
+	if !utils.IsEmpty(user) && !utils.Contains(user.Commands(), groupName) {
+		h.logger.Debug("HTTP command user %s is not permitted to execute %s", userID, groupName)
+		return nil
+	}
+
+	if pErr != nil {
+		return pErr
+	}
+
+	fields := cmd.Fields(h, parent, params, nil)
+	if len(h.missingFields(fields, params)) > 0 {
+		h.logger.Debug("HTTP command %s has no support for interaction mode", groupName)
+		return nil
+	}
+
+	var m *HTTPMessage
+	if mOrigin != nil {
+		m = h.cloneMessage(mOrigin)
+		m.parentID = mOrigin.id
+	} else {
+		m = &HTTPMessage{
+			http:   h,
+			user:   mUser,
+			caller: mUser,
+		}
+	}
+	if !utils.IsEmpty(channel) {
+		m.channelID = channel
+	}
+	m.cmdText = fText
+	m.group = group
+	m.cmd = cmd
+	m.fields = fields
+	m.params = params
+
+	err := h.cachePostUserCommand(m, params, nil, r, true)
+	if err != nil {
+		h.logger.Error("HTTP command %s couldn't post from %s: %s", groupName, userID, err)
+		return err
+	}
+	return nil
+}
+
+func (h *HTTP) PostMessage(channel string, message string, attachments []*common.Attachment, actions []common.Action,
+	user common.User, parent common.Message, response common.Response) (string, error) {
+
+	r := common.BuildResponse(true, response)
+
+	var mUser *HTTPUser
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*HTTPUser)
+		if ok {
+			mUser = u
+		}
+	}
+
+	var m *HTTPMessage
+	mOrigin := h.parentMessage(parent)
+	if mOrigin != nil {
+		m = h.cloneMessage(mOrigin)
+	} else {
+		m = &HTTPMessage{
+			http:   h,
+			user:   mUser,
+			caller: mUser,
+		}
+	}
+	if !utils.IsEmpty(channel) {
+		m.channelID = channel
+	}
+	m.visible = r.Visible()
+	m.text = message
+	m.actions = actions
+
+	ID := h.post(m, message, attachments, actions, r.Error())
+	if mOrigin != nil {
+		m.parentID = mOrigin.id
+	}
+	m.id = ID
+	h.putMessageToCache(m)
+
+	return ID, nil
+}
+
+func (h *HTTP) start() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpCommandsPath, h.commandsHandler)
+
+	server := &http.Server{
+		Addr:              h.options.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	h.logger.Info("HTTP is listening on %s", h.options.Listen)
+
+	var err error
+	if !utils.IsEmpty(h.options.Cert) && !utils.IsEmpty(h.options.Key) {
+		err = server.ListenAndServeTLS(h.options.Cert, h.options.Key)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		h.logger.Error("HTTP listen error: %s", err)
+	}
+}
+
+func (h *HTTP) Start(wg *sync.WaitGroup) {
+
+	if wg == nil {
+		h.start()
+		return
+	}
+
+	wg.Add(1)
+
+	go func(wg *sync.WaitGroup) {
+
+		defer wg.Done()
+		h.start()
+	}(wg)
+}
+
+func NewHTTP(options HTTPOptions, observability *common.Observability, processors *common.Processors) *HTTP {
+
+	if utils.IsEmpty(options.Listen) || utils.IsEmpty(options.Tokens) {
+		return nil
+	}
+
+	ttl := 1 * 60 * 60 * time.Second
+	if !utils.IsEmpty(options.CacheTTL) {
+		ttl, _ = time.ParseDuration(options.CacheTTL)
+	}
+
+	messages := ttlcache.New[string, *HTTPMessage](ttlcache.WithTTL[string, *HTTPMessage](ttl))
+	go messages.Start()
+
+	return &HTTP{
+		options:    options,
+		processors: processors,
+		logger:     observability.Logs(),
+		meter:      observability.Metrics(),
+		tokens:     utils.MapGetKeyValues(options.Tokens),
+		messages:   messages,
+	}
+}
@@ -0,0 +1,1840 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/jinzhu/copier"
+)
+
+type MatrixOptions struct {
+	URL             string
+	AccessToken     string
+	Debug           bool
+	Timeout         int
+	Insecure        bool
+	Prefix          string
+	AutoJoin        bool
+	DefaultCommand  string
+	UserPermissions string
+	ApprovalAny     bool
+
+	ReactionDoing    string
+	ReactionDone     string
+	ReactionFailed   string
+	ReactionForm     string
+	ReactionApproval string
+
+	ReplyApprove string
+	ReplyReject  string
+	ReplyCancel  string
+
+	FormInvalid   string
+	FormCancelled string
+
+	SyncTimeout       int
+	ReconnectInterval int
+	CacheTTL          string
+}
+
+type MatrixMessageKey struct {
+	roomID   string
+	eventID  string
+	threadID string
+}
+
+type MatrixUser struct {
+	id       string
+	name     string
+	timezone string
+	commands []string
+}
+
+type MatrixChannel struct {
+	id string
+}
+
+type MatrixMessage struct {
+	matrix    *Matrix
+	cmdText   string
+	cmd       common.Command
+	originKey *MatrixMessageKey
+	key       *MatrixMessageKey
+	user      *MatrixUser
+	caller    *MatrixUser
+	visible   bool
+	approval  bool
+	text      string
+	actions   []common.Action
+	params    common.ExecuteParams
+	fields    []common.Field
+}
+
+type MatrixForm struct {
+	message *MatrixMessage
+	fields  []common.Field
+	params  common.ExecuteParams
+	current int
+	prompt  *MatrixMessageKey
+}
+
+type MatrixInReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+type MatrixRelatesTo struct {
+	RelType       string           `json:"rel_type,omitempty"`
+	EventID       string           `json:"event_id,omitempty"`
+	Key           string           `json:"key,omitempty"`
+	IsFallingBack bool             `json:"is_falling_back,omitempty"`
+	InReplyTo     *MatrixInReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+type MatrixMentions struct {
+	UserIDs []string `json:"user_ids,omitempty"`
+}
+
+type MatrixFileInfo struct {
+	MimeType string `json:"mimetype,omitempty"`
+	Size     int    `json:"size,omitempty"`
+}
+
+type MatrixContent struct {
+	MsgType       string           `json:"msgtype,omitempty"`
+	Body          string           `json:"body,omitempty"`
+	Format        string           `json:"format,omitempty"`
+	FormattedBody string           `json:"formatted_body,omitempty"`
+	URL           string           `json:"url,omitempty"`
+	Info          *MatrixFileInfo  `json:"info,omitempty"`
+	RelatesTo     *MatrixRelatesTo `json:"m.relates_to,omitempty"`
+	Mentions      *MatrixMentions  `json:"m.mentions,omitempty"`
+	NewContent    *MatrixContent   `json:"m.new_content,omitempty"`
+}
+
+type MatrixEvent struct {
+	Type    string         `json:"type"`
+	EventID string         `json:"event_id"`
+	Sender  string         `json:"sender"`
+	RoomID  string         `json:"room_id,omitempty"`
+	Content *MatrixContent `json:"content"`
+}
+
+type MatrixTimeline struct {
+	Events []*MatrixEvent `json:"events"`
+}
+
+type MatrixJoinedRoom struct {
+	Timeline MatrixTimeline `json:"timeline"`
+}
+
+type MatrixRooms struct {
+	Join   map[string]*MatrixJoinedRoom `json:"join"`
+	Invite map[string]interface{}       `json:"invite"`
+}
+
+type MatrixSyncResponse struct {
+	NextBatch string      `json:"next_batch"`
+	Rooms     MatrixRooms `json:"rooms"`
+}
+
+type MatrixWhoAmI struct {
+	UserID string `json:"user_id"`
+}
+
+type MatrixProfile struct {
+	DisplayName string `json:"displayname"`
+}
+
+type MatrixEventResponse struct {
+	EventID string `json:"event_id"`
+}
+
+type MatrixUploadResponse struct {
+	ContentURI string `json:"content_uri"`
+}
+
+type Matrix struct {
+	options     MatrixOptions
+	processors  *common.Processors
+	client      *http.Client
+	syncClient  *http.Client
+	logger      sreCommon.Logger
+	meter       sreCommon.Meter
+	me          string
+	displayName string
+	messages    *ttlcache.Cache[string, *MatrixMessage]
+	reactions   *ttlcache.Cache[string, string]
+	forms       *ttlcache.Cache[string, *MatrixForm]
+}
+
+const (
+	matrixClientPath      = "/_matrix/client/v3"
+	matrixMediaPath       = "/_matrix/media/v3"
+	matrixMaxTextLength   = 32000
+	matrixTrimmed         = "...trimmed"
+	matrixEventMessage    = "m.room.message"
+	matrixEventReaction   = "m.reaction"
+	matrixMsgTypeText     = "m.text"
+	matrixMsgTypeNotice   = "m.notice"
+	matrixMsgTypeImage    = "m.image"
+	matrixMsgTypeFile     = "m.file"
+	matrixFormatHTML      = "org.matrix.custom.html"
+	matrixRelThread       = "m.thread"
+	matrixRelAnnotation   = "m.annotation"
+	matrixRelReplace      = "m.replace"
+	matrixDateFormat      = "2006-01-02"
+	matrixTimeFormat      = "15:04"
+	matrixSyncFilter      = `{"room":{"timeline":{"types":["m.room.message"]}},"presence":{"types":[]},"account_data":{"types":[]}}`
+	matrixInitialFilter   = `{"room":{"timeline":{"limit":0}},"presence":{"types":[]},"account_data":{"types":[]}}`
+	matrixApprovalSubmit  = "approve"
+	matrixApprovalCancel  = "reject"
+	matrixReplyQuoteStart = "> "
+)
+
+// MatrixUser
+
+func (mu *MatrixUser) ID() string {
+	return mu.id
+}
+
+func (mu *MatrixUser) Name() string {
+	return mu.name
+}
+
+func (mu *MatrixUser) TimeZone() string {
+	return mu.timezone
+}
+
+func (mu *MatrixUser) Commands() []string {
+	return mu.commands
+}
+
+// MatrixChannel
+
+func (mc *MatrixChannel) ID() string {
+	return mc.id
+}
+
+// MatrixMessage
+
+func (mm *MatrixMessage) ID() string {
+	if mm.key == nil {
+		return ""
+	}
+	return mm.key.eventID
+}
+
+func (mm *MatrixMessage) Visible() bool {
+	return mm.visible
+}
+
+func (mm *MatrixMessage) User() common.User {
+	return mm.user
+}
+
+func (mm *MatrixMessage) Caller() common.User {
+	return mm.caller
+}
+
+func (mm *MatrixMessage) userID() string {
+	u := mm.user
+	if u == nil {
+		return ""
+	}
+	return u.id
+}
+
+func (mm *MatrixMessage) Channel() common.Channel {
+	if mm.key == nil {
+		return nil
+	}
+	return &MatrixChannel{id: mm.key.roomID}
+}
+
+func (mm *MatrixMessage) ParentID() string {
+	if mm.key == nil {
+		return ""
+	}
+	return mm.key.threadID
+}
+
+func (mm *MatrixMessage) SetParentID(threadID string) {
+	if mm.key == nil {
+		return
+	}
+	mm.key.threadID = threadID
+}
+
+// MatrixMessageKey
+
+func (mmk *MatrixMessageKey) String() string {
+	return fmt.Sprintf("%s/%s", mmk.roomID, mmk.eventID)
+}
+
+// root of the thread where replies go
+func (mmk *MatrixMessageKey) root() string {
+	if !utils.IsEmpty(mmk.threadID) {
+		return mmk.threadID
+	}
+	return mmk.eventID
+}
+
+// Matrix
+
+func (mx *Matrix) Name() string {
+	return "Matrix"
+}
+
+func (mx *Matrix) baseURL() string {
+	return strings.TrimSuffix(mx.options.URL, "/")
+}
+
+func (mx *Matrix) headers(contentType string) map[string]string {
+
+	headers := make(map[string]string)
+	headers["Authorization"] = fmt.Sprintf("Bearer %s", mx.options.AccessToken)
+	headers["Content-Type"] = contentType
+	return headers
+}
+
+func (mx *Matrix) call(client *http.Client, method, path string, in, out interface{}) error {
+
+	var raw []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		raw = b
+	}
+
+	u := fmt.Sprintf("%s%s%s", mx.baseURL(), matrixClientPath, path)
+	b, err := utils.HttpRequestRawWithHeaders(client, method, u, mx.headers("application/json"), raw)
+	if err != nil {
+		return fmt.Errorf("Matrix %s %s error: %s %s", method, path, err, string(b))
+	}
+
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+func (mx *Matrix) request(method, path string, in, out interface{}) error {
+	return mx.call(mx.client, method, path, in, out)
+}
+
+func (mx *Matrix) roomPath(roomID, format string, args ...interface{}) string {
+	return fmt.Sprintf("/rooms/%s%s", url.PathEscape(roomID), fmt.Sprintf(format, args...))
+}
+
+func (mx *Matrix) sendEvent(roomID, eventType string, content *MatrixContent) (string, error) {
+
+	r := &MatrixEventResponse{}
+	path := mx.roomPath(roomID, "/send/%s/%s", eventType, common.UUID())
+	err := mx.request(http.MethodPut, path, content, r)
+	if err != nil {
+		return "", err
+	}
+	return r.EventID, nil
+}
+
+func (mx *Matrix) redact(roomID, eventID string) error {
+
+	path := mx.roomPath(roomID, "/redact/%s/%s", url.PathEscape(eventID), common.UUID())
+	return mx.request(http.MethodPut, path, map[string]string{}, nil)
+}
+
+func (mx *Matrix) upload(a *common.Attachment, name, contentType string) (string, error) {
+
+	u := fmt.Sprintf("%s%s/upload?filename=%s", mx.baseURL(), matrixMediaPath, url.QueryEscape(name))
+	b, err := utils.HttpRequestRawWithHeaders(mx.client, http.MethodPost, u, mx.headers(contentType), a.Data)
+	if err != nil {
+		return "", fmt.Errorf("Matrix upload error: %s %s", err, string(b))
+	}
+
+	r := &MatrixUploadResponse{}
+	err = json.Unmarshal(b, r)
+	if err != nil {
+		return "", err
+	}
+	return r.ContentURI, nil
+}
+
+func (mx *Matrix) findMessageInCache(key *MatrixMessageKey) *MatrixMessage {
+
+	if key == nil {
+		return nil
+	}
+	item := mx.messages.Get(key.String())
+	if item != nil {
+		return item.Value()
+	}
+	return nil
+}
+
+func (mx *Matrix) putMessageToCache(m *MatrixMessage) {
+
+	if m.key == nil || utils.IsEmpty(m.key.eventID) {
+		return
+	}
+	mx.messages.Set(m.key.String(), m, ttlcache.DefaultTTL)
+}
+
+func (mx *Matrix) cloneMessage(m *MatrixMessage) *MatrixMessage {
+
+	if m == nil {
+		return nil
+	}
+	r := &MatrixMessage{}
+	err := copier.Copy(r, m)
+	if err != nil {
+		mx.logger.Error("Matrix message copy error: %s", err)
+		return nil
+	}
+	return r
+}
+
+func (mx *Matrix) htmlText(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+}
+
+func (mx *Matrix) relation(threadID, replyToID string) *MatrixRelatesTo {
+
+	if utils.IsEmpty(threadID) {
+		return nil
+	}
+	r := &MatrixRelatesTo{
+		RelType: matrixRelThread,
+		EventID: threadID,
+	}
+	if utils.IsEmpty(replyToID) {
+		replyToID = threadID
+	}
+	r.IsFallingBack = replyToID == threadID
+	r.InReplyTo = &MatrixInReplyTo{EventID: replyToID}
+	return r
+}
+
+// actions have no buttons in Matrix, so they are listed to reply with
+func (mx *Matrix) actionsText(actions []common.Action) (string, string) {
+
+	names := []string{}
+	for _, a := range actions {
+		if utils.IsEmpty(a.Name()) {
+			continue
+		}
+		names = append(names, a.Name())
+	}
+	if len(names) == 0 {
+		return "", ""
+	}
+
+	codes := []string{}
+	for _, n := range names {
+		codes = append(codes, fmt.Sprintf("<code>%s</code>", html.EscapeString(n)))
+	}
+	return strings.Join(names, ", "), strings.Join(codes, ", ")
+}
+
+func (mx *Matrix) buildContent(message string, attachments []*common.Attachment, actions []common.Action) *MatrixContent {
+
+	body := []string{}
+	formatted := []string{}
+
+	if !utils.IsEmpty(message) {
+		body = append(body, message)
+		formatted = append(formatted, mx.htmlText(message))
+	}
+
+	for _, a := range attachments {
+
+		switch a.Type {
+		case common.AttachmentTypeImage, common.AttachmentTypeFile:
+			continue
+		}
+		if !utils.IsEmpty(a.Title) {
+			body = append(body, a.Title)
+			formatted = append(formatted, fmt.Sprintf("<b>%s</b>", html.EscapeString(a.Title)))
+		}
+		body = append(body, string(a.Data))
+		formatted = append(formatted, fmt.Sprintf("<pre><code>%s</code></pre>", html.EscapeString(string(a.Data))))
+	}
+
+	text, htmlText := mx.actionsText(actions)
+	if !utils.IsEmpty(text) {
+		body = append(body, text)
+		formatted = append(formatted, htmlText)
+	}
+
+	return &MatrixContent{
+		MsgType:       matrixMsgTypeNotice,
+		Body:          common.LimitText(strings.Join(body, "\n\n"), matrixMaxTextLength, matrixTrimmed),
+		Format:        matrixFormatHTML,
+		FormattedBody: common.LimitText(strings.Join(formatted, "<br><br>"), matrixMaxTextLength, matrixTrimmed),
+	}
+}
+
+func (mx *Matrix) sendFiles(roomID string, relatesTo *MatrixRelatesTo, attachments []*common.Attachment) (string, error) {
+
+	first := ""
+	for _, a := range attachments {
+
+		msgType := ""
+		switch a.Type {
+		case common.AttachmentTypeImage:
+			msgType = matrixMsgTypeImage
+		case common.AttachmentTypeFile:
+			msgType = matrixMsgTypeFile
+		default:
+			continue
+		}
+
+		name := a.Title
+		if utils.IsEmpty(name) {
+			name = common.UUID()
+		}
+		contentType := http.DetectContentType(a.Data)
+
+		uri, err := mx.upload(a, name, contentType)
+		if err != nil {
+			return first, err
+		}
+
+		content := &MatrixContent{
+			MsgType:   msgType,
+			Body:      name,
+			URL:       uri,
+			Info:      &MatrixFileInfo{MimeType: contentType, Size: len(a.Data)},
+			RelatesTo: relatesTo,
+		}
+		eventID, err := mx.sendEvent(roomID, matrixEventMessage, content)
+		if err != nil {
+			return first, err
+		}
+		if utils.IsEmpty(first) {
+			first = eventID
+		}
+	}
+	return first, nil
+}
+
+func (mx *Matrix) send(roomID, threadID, replyToID, message string, attachments []*common.Attachment, actions []common.Action) (*MatrixMessageKey, string, error) {
+
+	relatesTo := mx.relation(threadID, replyToID)
+	content := mx.buildContent(message, attachments, actions)
+	content.RelatesTo = relatesTo
+
+	eventID := ""
+	if !utils.IsEmpty(content.Body) {
+		id, err := mx.sendEvent(roomID, matrixEventMessage, content)
+		if err != nil {
+			return nil, "", err
+		}
+		eventID = id
+	}
+
+	first, err := mx.sendFiles(roomID, relatesTo, attachments)
+	if err != nil {
+		return nil, "", err
+	}
+	if utils.IsEmpty(eventID) {
+		eventID = first
+	}
+
+	return &MatrixMessageKey{
+		roomID:   roomID,
+		eventID:  eventID,
+		threadID: threadID,
+	}, message, nil
+}
+
+func (mx *Matrix) edit(roomID, eventID string, content *MatrixContent) error {
+
+	edit := &MatrixContent{
+		MsgType:       content.MsgType,
+		Body:          fmt.Sprintf("* %s", content.Body),
+		Format:        content.Format,
+		FormattedBody: fmt.Sprintf("* %s", content.FormattedBody),
+		NewContent:    content,
+		RelatesTo: &MatrixRelatesTo{
+			RelType: matrixRelReplace,
+			EventID: eventID,
+		},
+	}
+	_, err := mx.sendEvent(roomID, matrixEventMessage, edit)
+	return err
+}
+
+func (mx *Matrix) reply(m *MatrixMessage, message string, attachments []*common.Attachment, actions []common.Action,
+	response *common.BotResponse, start *time.Time, error bool) (*MatrixMessageKey, string, error) {
+
+	if m.key == nil {
+		return nil, "", fmt.Errorf("Matrix message has no room")
+	}
+
+	text := message
+	if !utils.IsEmpty(response) {
+
+		if response.Original() && !utils.IsEmpty(m.cmdText) {
+			user := ""
+			if m.user != nil {
+				user = fmt.Sprintf("%s ", m.user.id)
+			}
+			text = fmt.Sprintf("%s%s%s\n\n%s", matrixReplyQuoteStart, user, m.cmdText, text)
+		}
+
+		if response.Duration() && start != nil && !error {
+			elapsed := time.Since(*start)
+			text = fmt.Sprintf("[%s] %s", elapsed.Round(time.Millisecond), text)
+		}
+	}
+
+	if error {
+		text = fmt.Sprintf("%s %s", mx.options.ReactionFailed, text)
+		attachments = nil
+		actions = nil
+	}
+
+	threadID := ""
+	if !utils.IsEmpty(m.key.eventID) {
+		threadID = m.key.root()
+	}
+	return mx.send(m.key.roomID, threadID, m.key.eventID, text, attachments, actions)
+}
+
+func (mx *Matrix) replyError(m *MatrixMessage, err error) {
+
+	mx.logger.Error("Matrix reply error: %s", err)
+	_, _, err = mx.reply(m, err.Error(), nil, nil, nil, nil, true)
+	if err != nil {
+		mx.logger.Error("Matrix couldn't reply error: %s", err)
+	}
+}
+
+func (mx *Matrix) replyText(m *MatrixMessage, text string) {
+
+	_, _, err := mx.reply(m, text, nil, nil, nil, nil, false)
+	if err != nil {
+		mx.logger.Error("Matrix couldn't reply: %s", err)
+	}
+}
+
+func (mx *Matrix) reactionKey(roomID, eventID, name string) string {
+	return fmt.Sprintf("%s/%s/%s", roomID, eventID, name)
+}
+
+func (mx *Matrix) addReaction(key *MatrixMessageKey, name string) {
+
+	if key == nil || utils.IsEmpty(key.eventID) || utils.IsEmpty(name) {
+		return
+	}
+	err := mx.AddReaction(key.roomID, key.eventID, name)
+	if err != nil {
+		mx.logger.Error("Matrix adding reaction error: %s", err)
+	}
+}
+
+func (mx *Matrix) removeReaction(key *MatrixMessageKey, name string) {
+
+	if key == nil || utils.IsEmpty(key.eventID) || utils.IsEmpty(name) {
+		return
+	}
+	err := mx.RemoveReaction(key.roomID, key.eventID, name)
+	if err != nil {
+		mx.logger.Error("Matrix removing reaction error: %s", err)
+	}
+}
+
+func (mx *Matrix) addRemoveReactions(key *MatrixMessageKey, first, second string) {
+	mx.addReaction(key, first)
+	mx.removeReaction(key, second)
+}
+
+func (mx *Matrix) AddReaction(channel, ID, name string) error {
+
+	content := &MatrixContent{
+		RelatesTo: &MatrixRelatesTo{
+			RelType: matrixRelAnnotation,
+			EventID: ID,
+			Key:     name,
+		},
+	}
+	eventID, err := mx.sendEvent(channel, matrixEventReaction, content)
+	if err != nil {
+		return err
+	}
+	mx.reactions.Set(mx.reactionKey(channel, ID, name), eventID, ttlcache.DefaultTTL)
+	return nil
+}
+
+// reactions are events, so they are removed by redaction of the remembered one
+func (mx *Matrix) RemoveReaction(channel, ID, name string) error {
+
+	key := mx.reactionKey(channel, ID, name)
+	item := mx.reactions.Get(key)
+	if item == nil {
+		return nil
+	}
+	mx.reactions.Delete(key)
+	return mx.redact(channel, item.Value())
+}
+
+func (mx *Matrix) updateActions(channel, ID string, update func(m *MatrixMessage) []common.Action) error {
+
+	key := &MatrixMessageKey{
+		roomID:  channel,
+		eventID: ID,
+	}
+
+	m := mx.findMessageInCache(key)
+	if m == nil {
+		err := fmt.Errorf("Matrix message not found in %s with %s", channel, ID)
+		mx.logger.Error(err)
+		return err
+	}
+
+	m.actions = update(m)
+	mx.putMessageToCache(m)
+
+	return mx.edit(channel, ID, mx.buildContent(m.text, nil, m.actions))
+}
+
+func (mx *Matrix) AddAction(channel, ID string, action common.Action) error {
+
+	return mx.updateActions(channel, ID, func(m *MatrixMessage) []common.Action {
+		return append(m.actions, action)
+	})
+}
+
+func (mx *Matrix) AddActions(channel, ID string, actions []common.Action) error {
+
+	return mx.updateActions(channel, ID, func(m *MatrixMessage) []common.Action {
+		return append(m.actions, actions...)
+	})
+}
+
+func (mx *Matrix) RemoveAction(channel, ID, name string) error {
+
+	return mx.updateActions(channel, ID, func(m *MatrixMessage) []common.Action {
+		actions := []common.Action{}
+		for _, a := range m.actions {
+			if a.Name() == name {
+				continue
+			}
+			actions = append(actions, a)
+		}
+		return actions
+	})
+}
+
+func (mx *Matrix) ClearActions(channel, ID string) error {
+
+	return mx.updateActions(channel, ID, func(m *MatrixMessage) []common.Action {
+		return nil
+	})
+}
+
+func (mx *Matrix) DeleteMessage(channel, ID string) error {
+
+	err := mx.redact(channel, ID)
+	if err != nil {
+		mx.logger.Error("Failed to delete message: %s", err)
+		return err
+	}
+	mx.messages.Delete((&MatrixMessageKey{roomID: channel, eventID: ID}).String())
+	return nil
+}
+
+func (mx *Matrix) ReadMessage(channel, ID string) (string, error) {
+
+	event := &MatrixEvent{}
+	err := mx.request(http.MethodGet, mx.roomPath(channel, "/event/%s", url.PathEscape(ID)), nil, event)
+	if err != nil {
+		mx.logger.Error("Failed to get message: %s", err)
+		return "", err
+	}
+	if event.Content == nil {
+		return "", nil
+	}
+	return event.Content.Body, nil
+}
+
+func (mx *Matrix) UpdateMessage(channel, ID, message string) error {
+
+	err := mx.edit(channel, ID, mx.buildContent(message, nil, nil))
+	if err != nil {
+		mx.logger.Error("Failed to update message: %s", err)
+		return err
+	}
+	return nil
+}
+
+// @alice:example.org => alice
+func (mx *Matrix) localpart(userID string) string {
+
+	name := strings.TrimPrefix(userID, "@")
+	if idx := strings.Index(name, ":"); idx >= 0 {
+		name = name[:idx]
+	}
+	return name
+}
+
+func (mx *Matrix) buildMatrixUser(userID string) *MatrixUser {
+
+	if utils.IsEmpty(userID) {
+		return nil
+	}
+
+	u := &MatrixUser{
+		id:   userID,
+		name: mx.localpart(userID),
+	}
+	commands, err := mx.processors.UserCommands(mx.options.UserPermissions, u.id, u.name)
+	if err != nil {
+		mx.logger.Error("Matrix permissions error: %s", err)
+	}
+	u.commands = commands
+	return u
+}
+
+// replies carry a quote of the original message as fallback
+func (mx *Matrix) stripReplyFallback(text string) string {
+
+	lines := strings.Split(text, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], strings.TrimSpace(matrixReplyQuoteStart)) {
+		i++
+	}
+	if i == 0 {
+		return text
+	}
+	return strings.TrimSpace(strings.Join(lines[i:], "\n"))
+}
+
+func (mx *Matrix) mentionPrefixes() []string {
+
+	r := []string{mx.me, fmt.Sprintf("%s:", mx.localpart(mx.me))}
+	if !utils.IsEmpty(mx.displayName) {
+		r = append(r, fmt.Sprintf("%s:", mx.displayName))
+	}
+	return r
+}
+
+func (mx *Matrix) stripMention(text string) (string, bool) {
+
+	for _, p := range mx.mentionPrefixes() {
+		if strings.HasPrefix(text, p) {
+			return strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(text, p), ":")), true
+		}
+	}
+	return text, false
+}
+
+// is the event addressed to the bot by mention or command prefix
+func (mx *Matrix) addressed(content *MatrixContent, text string) bool {
+
+	if content.Mentions != nil && utils.Contains(content.Mentions.UserIDs, mx.me) {
+		return true
+	}
+	if !utils.IsEmpty(mx.options.Prefix) && strings.HasPrefix(text, mx.options.Prefix) {
+		return true
+	}
+	_, ok := mx.stripMention(text)
+	return ok
+}
+
+// @bot: group command param1 => group command param1
+// !group command param1 => group command param1
+func (mx *Matrix) prepareInputText(text string) string {
+
+	text, _ = mx.stripMention(strings.TrimSpace(text))
+	if !utils.IsEmpty(mx.options.Prefix) {
+		text = strings.TrimPrefix(text, mx.options.Prefix)
+	}
+	text = strings.TrimPrefix(strings.TrimSpace(text), "/")
+
+	return mx.processors.ReplaceAlias(text)
+}
+
+func (mx *Matrix) cachePostUserCommand(m *MatrixMessage, params common.ExecuteParams, action common.Action, response common.Response, overwrite bool) error {
+
+	start := time.Now()
+	executor, message, attachments, actions, err := m.cmd.Execute(mx, m, params, action)
+	if err != nil {
+		mx.replyError(m, err)
+		return err
+	}
+	if action == nil {
+		actions = common.MergeActions(actions, m.cmd.Actions())
+	}
+
+	r := common.BuildResponse(overwrite, response, executor.Response())
+
+	var key *MatrixMessageKey
+	text := ""
+
+	if !utils.IsEmpty(message) || len(attachments) > 0 {
+
+		mReply := m
+		channel := m.cmd.Channel()
+		if !utils.IsEmpty(channel) && channel != m.key.roomID {
+			mReply = mx.cloneMessage(m)
+			mReply.key = &MatrixMessageKey{roomID: channel}
+		}
+
+		k, txt, err := mx.reply(mReply, message, attachments, actions, r, &start, r.Error())
+		if err != nil {
+			mx.replyError(m, err)
+			return err
+		}
+		key = k
+		text = txt
+	}
+
+	mNew := mx.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = key
+	mNew.visible = r.Visible()
+	mNew.text = text
+	mNew.actions = actions
+	mNew.params = params
+
+	mx.putMessageToCache(mNew)
+
+	if mNew.key == nil {
+		mNew.key = m.key
+	}
+	return executor.After(mNew)
+}
+
+func (mx *Matrix) approvalNeeded(m *MatrixMessage, cmd common.Command, params common.ExecuteParams) (string, string) {
+
+	approval := cmd.Approval()
+	if approval == nil {
+		return "", ""
+	}
+
+	chl := strings.TrimSpace(approval.Channel(mx, m, params))
+	if utils.IsEmpty(chl) {
+		chl = m.key.roomID
+	}
+
+	message := strings.TrimSpace(approval.Message(mx, m, params))
+	if utils.IsEmpty(message) {
+		return "", chl
+	}
+	return message, chl
+}
+
+func (mx *Matrix) cacheAskApproval(m *MatrixMessage, message, channel string, params common.ExecuteParams) error {
+
+	threadID := ""
+	replyToID := ""
+	if channel == m.key.roomID {
+		threadID = m.key.root()
+		replyToID = m.key.eventID
+	}
+
+	text := fmt.Sprintf("%s\n\n%s / %s", message, mx.options.ReplyApprove, mx.options.ReplyReject)
+	key, _, err := mx.send(channel, threadID, replyToID, text, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	mNew := mx.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = key
+	mNew.approval = true
+	mNew.text = message
+	mNew.params = params
+
+	mx.putMessageToCache(mNew)
+	return nil
+}
+
+func (mx *Matrix) executeCommand(m *MatrixMessage, params common.ExecuteParams, reaction string) {
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	err := mx.cachePostUserCommand(m, params, nil, r, false)
+	if err != nil {
+		mx.logger.Error("Matrix couldn't post from %s: %s", m.userID(), err)
+		mx.addRemoveReactions(m.key, mx.options.ReactionFailed, reaction)
+		return
+	}
+	mx.addRemoveReactions(m.key, mx.options.ReactionDone, reaction)
+}
+
+func (mx *Matrix) approveOrExecute(m *MatrixMessage, params common.ExecuteParams, reaction string) {
+
+	message, channel := mx.approvalNeeded(m, m.cmd, params)
+	if !utils.IsEmpty(message) {
+		err := mx.cacheAskApproval(m, message, channel, params)
+		if err != nil {
+			mx.replyError(m, err)
+			mx.addRemoveReactions(m.key, mx.options.ReactionFailed, reaction)
+			return
+		}
+		mx.addRemoveReactions(m.key, mx.options.ReactionApproval, reaction)
+		return
+	}
+
+	if reaction != mx.options.ReactionDoing {
+		mx.addRemoveReactions(m.key, mx.options.ReactionDoing, reaction)
+	}
+	mx.executeCommand(m, params, mx.options.ReactionDoing)
+}
+
+func (mx *Matrix) processCommand(m *MatrixMessage, params common.ExecuteParams) {
+
+	list := []string{common.FieldTypeSelect, common.FieldTypeMultiSelect, common.FieldTypeEdit}
+	only := common.FieldsByType(mx, m.cmd, list)
+
+	fields := m.cmd.Fields(mx, m, params, only)
+	m.fields = fields
+	m.params = params
+	mx.putMessageToCache(m)
+
+	if common.FormNeeded(fields, params) {
+		err := mx.startForm(m, fields, params)
+		if err != nil {
+			mx.replyError(m, err)
+			mx.addRemoveReactions(m.key, mx.options.ReactionFailed, mx.options.ReactionDoing)
+		}
+		return
+	}
+
+	params = common.FieldValues(fields, params)
+
+	mx.approveOrExecute(m, params, mx.options.ReactionDoing)
+}
+
+// MatrixForm
+
+func (mx *Matrix) formKey(roomID, userID string) string {
+	return fmt.Sprintf("%s/%s", roomID, userID)
+}
+
+func (mx *Matrix) fieldLabel(field common.Field) string {
+
+	if !utils.IsEmpty(field.Label) {
+		return field.Label
+	}
+	return field.Name
+}
+
+func (mx *Matrix) fieldValues(field common.Field) []string {
+
+	if field.Type == common.FieldTypeBool {
+		return []string{fmt.Sprintf("%v", true), fmt.Sprintf("%v", false)}
+	}
+	return field.Values
+}
+
+func (mx *Matrix) fieldMultiple(field common.Field) bool {
+
+	switch field.Type {
+	case common.FieldTypeMultiSelect, common.FieldTypeDynamicMultiSelect, common.FieldTypeCheckboxes:
+		return true
+	}
+	return false
+}
+
+// values are chosen by their number or by the value itself
+func (mx *Matrix) fieldValueFromList(values []string, text string) (string, error) {
+
+	v := strings.TrimSpace(text)
+	if idx, err := strconv.Atoi(v); err == nil && idx > 0 && idx <= len(values) {
+		return values[idx-1], nil
+	}
+	if utils.Contains(values, v) {
+		return v, nil
+	}
+	return "", fmt.Errorf("%s is not one of %s", v, strings.Join(values, ", "))
+}
+
+// check reply text against the field type, as Slack does with its typed inputs
+func (mx *Matrix) fieldValueFromText(field common.Field, text string) (interface{}, error) {
+
+	v := strings.TrimSpace(text)
+	if utils.IsEmpty(v) {
+		return nil, fmt.Errorf("empty value")
+	}
+
+	values := mx.fieldValues(field)
+	if len(values) > 0 {
+
+		if !mx.fieldMultiple(field) {
+			return mx.fieldValueFromList(values, v)
+		}
+
+		selected := []string{}
+		for _, s := range common.RemoveEmptyStrings(strings.Split(v, ",")) {
+			sv, err := mx.fieldValueFromList(values, s)
+			if err != nil {
+				return nil, err
+			}
+			selected = append(selected, sv)
+		}
+		if field.Type == common.FieldTypeCheckboxes {
+			return strings.Join(selected, ","), nil
+		}
+		return selected, nil
+	}
+
+	switch field.Type {
+	case common.FieldTypeInteger:
+		if _, err := strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("%s is not an integer", v)
+		}
+	case common.FieldTypeFloat:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("%s is not a number", v)
+		}
+	case common.FieldTypeDate:
+		if _, err := time.Parse(matrixDateFormat, v); err != nil {
+			return nil, fmt.Errorf("%s is not a date in format %s", v, matrixDateFormat)
+		}
+	case common.FieldTypeTime:
+		if _, err := time.Parse(matrixTimeFormat, v); err != nil {
+			return nil, fmt.Errorf("%s is not a time in format %s", v, matrixTimeFormat)
+		}
+	case common.FieldTypeURL:
+		u, err := url.ParseRequestURI(v)
+		if err != nil || utils.IsEmpty(u.Scheme) || utils.IsEmpty(u.Host) {
+			return nil, fmt.Errorf("%s is not a URL", v)
+		}
+	case common.FieldTypeMultiSelect, common.FieldTypeDynamicMultiSelect,
+		common.FieldTypeMultiUser, common.FieldTypeMultiChannel, common.FieldTypeMultiGroup:
+		return common.RemoveEmptyStrings(strings.Split(v, ",")), nil
+	}
+	return v, nil
+}
+
+func (mx *Matrix) fieldValueToString(value interface{}) string {
+
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func (mx *Matrix) nextFormField(form *MatrixForm) int {
+
+	for i, f := range form.fields {
+		if !f.Required || f.Type == common.FieldTypeMarkdown {
+			continue
+		}
+		if utils.IsEmpty(form.params[f.Name]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (mx *Matrix) formText(field common.Field) string {
+
+	lines := []string{mx.fieldLabel(field)}
+	if !utils.IsEmpty(field.Hint) {
+		lines = append(lines, field.Hint)
+	}
+	for i, v := range mx.fieldValues(field) {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, v))
+	}
+	lines = append(lines, fmt.Sprintf("(%s)", mx.options.ReplyCancel))
+	return strings.Join(lines, "\n")
+}
+
+func (mx *Matrix) askFormField(form *MatrixForm) error {
+
+	m := form.message
+	field := form.fields[form.current]
+
+	key, _, err := mx.send(m.key.roomID, m.key.root(), m.key.eventID, mx.formText(field), nil, nil)
+	if err != nil {
+		return err
+	}
+	form.prompt = key
+	return nil
+}
+
+func (mx *Matrix) closeFormPrompt(form *MatrixForm, text string) {
+
+	if form.prompt == nil {
+		return
+	}
+	err := mx.edit(form.prompt.roomID, form.prompt.eventID, mx.buildContent(text, nil, nil))
+	if err != nil {
+		mx.logger.Error("Matrix couldn't close form prompt: %s", err)
+	}
+}
+
+func (mx *Matrix) continueForm(form *MatrixForm) error {
+
+	m := form.message
+	next := mx.nextFormField(form)
+	if next < 0 {
+		mx.forms.Delete(mx.formKey(m.key.roomID, m.userID()))
+		mx.closeFormPrompt(form, mx.formSummary(form))
+		mx.finishForm(form)
+		return nil
+	}
+
+	form.current = next
+	mx.forms.Set(mx.formKey(m.key.roomID, m.userID()), form, ttlcache.DefaultTTL)
+	return mx.askFormField(form)
+}
+
+func (mx *Matrix) formSummary(form *MatrixForm) string {
+
+	lines := []string{}
+	for _, f := range form.fields {
+		v := form.params[f.Name]
+		if utils.IsEmpty(v) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", mx.fieldLabel(f), mx.fieldValueToString(v)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (mx *Matrix) startForm(m *MatrixMessage, fields []common.Field, params common.ExecuteParams) error {
+
+	nParams := make(common.ExecuteParams)
+	for _, f := range fields {
+		if !utils.IsEmpty(f.Default) {
+			nParams[f.Name] = common.FieldValue(f, f.Default)
+		}
+	}
+	for k, v := range params {
+		if utils.IsEmpty(v) {
+			continue
+		}
+		nParams[k] = v
+	}
+
+	form := &MatrixForm{
+		message: m,
+		fields:  fields,
+		params:  nParams,
+		current: -1,
+	}
+
+	mx.addRemoveReactions(m.key, mx.options.ReactionForm, mx.options.ReactionDoing)
+	return mx.continueForm(form)
+}
+
+// update fields which depend on the changed one, like Slack.handleFormField does
+func (mx *Matrix) updateFormDependencies(form *MatrixForm, name string) {
+
+	deps := []string{}
+	for _, f := range form.fields {
+		if utils.Contains(f.Dependencies, name) {
+			deps = append(deps, f.Name)
+		}
+	}
+	if len(deps) == 0 {
+		return
+	}
+
+	depFields := form.message.cmd.Fields(mx, form.message, form.params, deps)
+	for _, df := range depFields {
+		if !utils.Contains(deps, df.Name) {
+			continue
+		}
+		for i, f := range form.fields {
+			if f.Name != df.Name {
+				continue
+			}
+			form.fields[i] = df
+			if !utils.IsEmpty(df.Default) {
+				form.params[df.Name] = common.FieldValue(df, df.Default)
+			}
+		}
+	}
+}
+
+func (mx *Matrix) setFormValue(form *MatrixForm, value interface{}) error {
+
+	field := form.fields[form.current]
+	form.params[field.Name] = value
+	mx.updateFormDependencies(form, field.Name)
+	return mx.continueForm(form)
+}
+
+func (mx *Matrix) finishForm(form *MatrixForm) {
+
+	m := form.message
+	params := common.MergeInterfaceMaps(m.params, form.params)
+	m.params = params
+	mx.putMessageToCache(m)
+
+	mx.approveOrExecute(m, params, mx.options.ReactionForm)
+}
+
+func (mx *Matrix) cancelForm(form *MatrixForm) {
+
+	m := form.message
+	mx.forms.Delete(mx.formKey(m.key.roomID, m.userID()))
+	mx.closeFormPrompt(form, mx.options.FormCancelled)
+	mx.addRemoveReactions(m.key, mx.options.ReactionFailed, mx.options.ReactionForm)
+}
+
+func (mx *Matrix) findForm(roomID, userID string) *MatrixForm {
+
+	item := mx.forms.Get(mx.formKey(roomID, userID))
+	if item == nil {
+		return nil
+	}
+	return item.Value()
+}
+
+// next message of the user in the room answers the form prompt
+func (mx *Matrix) processFormText(roomID, userID, eventID, text string) bool {
+
+	form := mx.findForm(roomID, userID)
+	if form == nil || form.current < 0 {
+		return false
+	}
+
+	if strings.EqualFold(text, mx.options.ReplyCancel) {
+		mx.cancelForm(form)
+		return true
+	}
+
+	field := form.fields[form.current]
+	v, err := mx.fieldValueFromText(field, text)
+	if err != nil {
+		mInvalid := &MatrixMessage{
+			matrix: mx,
+			key: &MatrixMessageKey{
+				roomID:   roomID,
+				eventID:  eventID,
+				threadID: form.message.key.root(),
+			},
+		}
+		mx.replyText(mInvalid, fmt.Sprintf("%s: %s", mx.options.FormInvalid, err))
+		return true
+	}
+
+	err = mx.setFormValue(form, v)
+	if err != nil {
+		mx.logger.Error("Matrix couldn't continue form: %s", err)
+	}
+	return true
+}
+
+// Actions and approvals are replies to bot messages
+
+func (mx *Matrix) handleActionReply(m *MatrixMessage, caller *MatrixUser, text string) (bool, error) {
+
+	if m.cmd == nil {
+		return false, nil
+	}
+
+	var action common.Action
+	for _, a := range m.actions {
+		if strings.EqualFold(a.Name(), text) || strings.EqualFold(a.Label(), text) {
+			action = a
+			break
+		}
+	}
+
+	if action == nil {
+		return false, nil
+	}
+
+	mAction := mx.cloneMessage(m)
+	mAction.caller = caller
+	mAction.cmdText = ""
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	return true, mx.cachePostUserCommand(mAction, m.params, action, r, true)
+}
+
+func (mx *Matrix) handleApprovalReply(m *MatrixMessage, caller *MatrixUser, text string) (bool, error) {
+
+	name := ""
+	switch {
+	case strings.EqualFold(text, mx.options.ReplyApprove):
+		name = matrixApprovalSubmit
+	case strings.EqualFold(text, mx.options.ReplyReject):
+		name = matrixApprovalCancel
+	default:
+		return false, nil
+	}
+
+	if m.cmd == nil || m.originKey == nil {
+		return true, fmt.Errorf("Matrix approval has no command")
+	}
+
+	if !mx.options.ApprovalAny && caller.id == m.userID() {
+		return true, fmt.Errorf("Matrix same user cannot approve its action")
+	}
+
+	reaction := mx.options.ReactionFailed
+	if name == matrixApprovalSubmit {
+		reaction = mx.options.ReactionDone
+	}
+
+	// forget approval to avoid double one
+	mx.messages.Delete(m.key.String())
+	err := mx.edit(m.key.roomID, m.key.eventID, mx.buildContent(fmt.Sprintf("%s\n\n%s %s", m.text, reaction, caller.id), nil, nil))
+	if err != nil {
+		mx.logger.Error("Matrix couldn't update approval message: %s", err)
+	}
+
+	mInit := mx.cloneMessage(m)
+	mInit.key = m.originKey
+	mInit.originKey = nil
+	mInit.approval = false
+
+	if name != matrixApprovalSubmit {
+		mx.addRemoveReactions(mInit.key, mx.options.ReactionFailed, mx.options.ReactionApproval)
+		return true, nil
+	}
+
+	mx.addRemoveReactions(mInit.key, mx.options.ReactionDoing, mx.options.ReactionApproval)
+	mx.executeCommand(mInit, m.params, mx.options.ReactionDoing)
+	return true, nil
+}
+
+func (mx *Matrix) processReply(key *MatrixMessageKey, replyToID, userID, text string) bool {
+
+	m := mx.findMessageInCache(&MatrixMessageKey{roomID: key.roomID, eventID: replyToID})
+	if m == nil {
+		return false
+	}
+
+	caller := mx.buildMatrixUser(userID)
+	if caller == nil {
+		return false
+	}
+
+	var handled bool
+	var err error
+	if m.approval {
+		handled, err = mx.handleApprovalReply(m, caller, text)
+	} else {
+		handled, err = mx.handleActionReply(m, caller, text)
+	}
+
+	if err != nil {
+		mx.replyError(&MatrixMessage{matrix: mx, key: key}, err)
+	}
+	return handled
+}
+
+func (mx *Matrix) processText(key *MatrixMessageKey, userID, text string) {
+
+	u := mx.buildMatrixUser(userID)
+	if u == nil {
+		mx.logger.Error("Matrix couldn't process command from unknown user")
+		return
+	}
+
+	fText := mx.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := mx.processors.FindParams(false, fText)
+
+	if cmd == nil && !utils.IsEmpty(mx.options.DefaultCommand) {
+		cmd = mx.processors.FindCommand("", mx.options.DefaultCommand)
+		group = ""
+		params = make(common.ExecuteParams)
+	}
+
+	if cmd == nil {
+		params, cmd = mx.processors.FindSuggest(fText)
+		group = ""
+	}
+
+	if cmd == nil {
+		mx.logger.Debug("Matrix command not found for text: %s", text)
+		common.UpdateCounters(mx.meter, "matrix", "", "", fText, u.id)
+		return
+	}
+
+	common.UpdateCounters(mx.meter, "matrix", group, cmd.Name(), fText, u.id)
+
+	groupName := common.GroupName(group, cmd)
+	if !common.Permitted(u, cmd, groupName) {
+		mx.logger.Error("Matrix user %s is not permitted to execute %s", u.id, groupName)
+		return
+	}
+
+	mx.addReaction(key, mx.options.ReactionDoing)
+
+	m := &MatrixMessage{
+		matrix:  mx,
+		cmdText: fText,
+		cmd:     cmd,
+		key:     key,
+		user:    u,
+		caller:  u,
+		visible: true,
+		text:    text,
+	}
+
+	// args couldn't be parsed, so usage is replied before form or approval
+	if pErr != nil {
+		mx.replyError(m, pErr)
+		mx.addRemoveReactions(key, mx.options.ReactionFailed, mx.options.ReactionDoing)
+		return
+	}
+	mx.processCommand(m, params)
+}
+
+func (mx *Matrix) processEvent(roomID string, event *MatrixEvent) {
+
+	content := event.Content
+	if event.Type != matrixEventMessage || content == nil {
+		return
+	}
+
+	// skip own messages and notices of other bots
+	if event.Sender == mx.me || content.MsgType != matrixMsgTypeText {
+		return
+	}
+
+	key := &MatrixMessageKey{
+		roomID:  roomID,
+		eventID: event.EventID,
+	}
+
+	replyToID := ""
+	if rel := content.RelatesTo; rel != nil {
+		switch rel.RelType {
+		case matrixRelReplace:
+			return
+		case matrixRelThread:
+			key.threadID = rel.EventID
+		}
+		if rel.InReplyTo != nil && !rel.IsFallingBack {
+			replyToID = rel.InReplyTo.EventID
+		}
+	}
+
+	text := mx.stripReplyFallback(strings.TrimSpace(content.Body))
+	if mx.options.Debug {
+		mx.logger.Debug("Matrix message: [%s] %s", event.Sender, text)
+	}
+
+	if mx.processFormText(roomID, event.Sender, event.EventID, text) {
+		return
+	}
+
+	if !utils.IsEmpty(replyToID) && mx.processReply(key, replyToID, event.Sender, text) {
+		return
+	}
+
+	if !mx.addressed(content, text) {
+		return
+	}
+	mx.processText(key, event.Sender, text)
+}
+
+func (mx *Matrix) parentMessage(parent common.Message) *MatrixMessage {
+
+	if utils.IsEmpty(parent) {
+		return nil
+	}
+	mm, ok := parent.(*MatrixMessage)
+	if !ok {
+		return nil
+	}
+	if mm.key != nil {
+		if mc := mx.findMessageInCache(mm.key); mc != nil {
+			return mc
+		}
+	}
+	return mm
+}
+
+func (mx *Matrix) Command(channel, text string, user common.User, parent common.Message, response common.Response) error {
+
+	r := common.BuildResponse(true, response)
+
+	mOrigin := mx.parentMessage(parent)
+	if mOrigin != nil && mOrigin.cmd != nil {
+		r = common.BuildResponse(false, mOrigin.cmd.Response(), response)
+	}
+
+	var mUser *MatrixUser
+	userID := "unknown"
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*MatrixUser)
+		if ok {
+			mUser = u
+		}
+		userID = user.ID()
+	}
+
+	fText := mx.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := mx.processors.FindParams(false, fText)
+	if cmd == nil {
+		mx.logger.Debug("Matrix command not found for text: %s", text)
+		return nil
+	}
+
+	groupName := common.GroupName(group, cmd)
+	if !utils.IsEmpty(user) && !utils.Contains(user.Commands(), groupName) {
+		mx.logger.Debug("Matrix command user %s is not permitted to execute %s", userID, groupName)
+		return nil
+	}
+
+	if pErr != nil {
+		return pErr
+	}
+
+	fields := cmd.Fields(mx, parent, params, nil)
+	if common.FormNeeded(fields, params) {
+		mx.logger.Debug("Matrix command %s has no support for interaction mode", groupName)
+		return nil
+	}
+
+	key := &MatrixMessageKey{}
+	if mOrigin != nil && mOrigin.key != nil {
+		key.roomID = mOrigin.key.roomID
+		key.eventID = mOrigin.key.eventID
+		key.threadID = mOrigin.key.threadID
+	}
+	if !utils.IsEmpty(channel) {
+		if channel != key.roomID {
+			key.eventID = ""
+			key.threadID = ""
+		}
+		key.roomID = channel
+	}
+
+	var m *MatrixMessage
+	if mOrigin != nil {
+		m = mx.cloneMessage(mOrigin)
+		m.originKey = mOrigin.key
+	} else {
+		m = &MatrixMessage{
+			matrix: mx,
+			user:   mUser,
+			caller: mUser,
+		}
+	}
+	m.cmdText = fText
+	m.cmd = cmd
+	m.key = key
+	m.fields = fields
+	m.params = params
+
+	err := mx.cachePostUserCommand(m, params, nil, r, true)
+	if err != nil {
+		mx.logger.Error("Matrix command %s couldn't post from %s: %s", groupName, userID, err)
+		return err
+	}
+	return nil
+}
+
+func (mx *Matrix) PostMessage(channel string, message string, attachments []*common.Attachment, actions []common.Action,
+	user common.User, parent common.Message, response common.Response) (string, error) {
+
+	r := common.BuildResponse(true, response)
+
+	threadID := ""
+	replyToID := ""
+	mOrigin := mx.parentMessage(parent)
+	if mOrigin != nil && mOrigin.key != nil {
+		threadID = mOrigin.key.root()
+		replyToID = mOrigin.key.eventID
+		if utils.IsEmpty(channel) {
+			channel = mOrigin.key.roomID
+		}
+		if mOrigin.key.roomID != channel {
+			threadID = ""
+			replyToID = ""
+		}
+	}
+
+	if utils.IsEmpty(channel) {
+		return "", fmt.Errorf("Matrix room is not defined")
+	}
+
+	key, text, err := mx.send(channel, threadID, replyToID, message, attachments, actions)
+	if err != nil {
+		return "", err
+	}
+
+	var mUser *MatrixUser
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*MatrixUser)
+		if ok {
+			mUser = u
+		}
+	}
+
+	var m *MatrixMessage
+	if mOrigin != nil {
+		m = mx.cloneMessage(mOrigin)
+		m.originKey = mOrigin.key
+	} else {
+		m = &MatrixMessage{
+			matrix: mx,
+			user:   mUser,
+			caller: mUser,
+		}
+	}
+	m.key = key
+	m.visible = r.Visible()
+	m.text = text
+	m.actions = actions
+	mx.putMessageToCache(m)
+
+	return key.eventID, nil
+}
+
+func (mx *Matrix) sync(since string) (*MatrixSyncResponse, error) {
+
+	query := url.Values{}
+	query.Set("timeout", strconv.Itoa(mx.options.SyncTimeout*1000))
+	if utils.IsEmpty(since) {
+		query.Set("filter", matrixInitialFilter)
+	} else {
+		query.Set("since", since)
+		query.Set("filter", matrixSyncFilter)
+	}
+
+	r := &MatrixSyncResponse{}
+	err := mx.call(mx.syncClient, http.MethodGet, fmt.Sprintf("/sync?%s", query.Encode()), nil, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (mx *Matrix) join(roomID string) {
+
+	err := mx.request(http.MethodPost, fmt.Sprintf("/join/%s", url.PathEscape(roomID)), map[string]string{}, nil)
+	if err != nil {
+		mx.logger.Error("Matrix couldn't join %s: %s", roomID, err)
+		return
+	}
+	mx.logger.Info("Matrix joined %s", roomID)
+}
+
+func (mx *Matrix) listen() {
+
+	interval := time.Duration(mx.options.ReconnectInterval) * time.Second
+	since := ""
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		r, err := mx.sync(since)
+		if err != nil {
+			mx.logger.Error("Matrix sync error: %s", err)
+			if interval <= 0 {
+				return
+			}
+			time.Sleep(interval)
+			continue
+		}
+
+		if mx.options.AutoJoin {
+			for roomID := range r.Rooms.Invite {
+				mx.join(roomID)
+			}
+		}
+
+		// skip history which came before the start
+		if !utils.IsEmpty(since) {
+			for roomID, room := range r.Rooms.Join {
+				for _, event := range room.Timeline.Events {
+					wg.Add(1)
+					go func(roomID string, e *MatrixEvent) {
+						defer wg.Done()
+						mx.processEvent(roomID, e)
+					}(roomID, event)
+				}
+			}
+		}
+		since = r.NextBatch
+	}
+}
+
+func (mx *Matrix) start() {
+
+	me := &MatrixWhoAmI{}
+	err := mx.request(http.MethodGet, "/account/whoami", nil, me)
+	if err != nil {
+		mx.logger.Error(err)
+		return
+	}
+	mx.me = me.UserID
+
+	profile := &MatrixProfile{}
+	err = mx.request(http.MethodGet, fmt.Sprintf("/profile/%s/displayname", url.PathEscape(mx.me)), nil, profile)
+	if err != nil {
+		mx.logger.Debug("Matrix couldn't get display name: %s", err)
+	}
+	mx.displayName = profile.DisplayName
+
+	mx.logger.Info("Matrix is connected to %s as %s", mx.options.URL, mx.me)
+	mx.listen()
+}
+
+func (mx *Matrix) Start(wg *sync.WaitGroup) {
+
+	if wg == nil {
+		mx.start()
+		return
+	}
+
+	wg.Add(1)
+
+	go func(wg *sync.WaitGroup) {
+
+		defer wg.Done()
+		mx.start()
+	}(wg)
+}
+
+func NewMatrix(options MatrixOptions, observability *common.Observability, processors *common.Processors) *Matrix {
+
+	if utils.IsEmpty(options.URL) || utils.IsEmpty(options.AccessToken) {
+		return nil
+	}
+
+	ttl := 1 * 60 * 60 * time.Second
+	if !utils.IsEmpty(options.CacheTTL) {
+		ttl, _ = time.ParseDuration(options.CacheTTL)
+	}
+
+	messages := ttlcache.New[string, *MatrixMessage](ttlcache.WithTTL[string, *MatrixMessage](ttl))
+	go messages.Start()
+
+	reactions := ttlcache.New[string, string](ttlcache.WithTTL[string, string](ttl))
+	go reactions.Start()
+
+	forms := ttlcache.New[string, *MatrixForm](ttlcache.WithTTL[string, *MatrixForm](ttl))
+	go forms.Start()
+
+	return &Matrix{
+		options:    options,
+		processors: processors,
+		client:     utils.NewHttpClient(options.Timeout, options.Insecure),
+		syncClient: utils.NewHttpClient(options.Timeout+options.SyncTimeout, options.Insecure),
+		logger:     observability.Logs(),
+		meter:      observability.Metrics(),
+		messages:   messages,
+		reactions:  reactions,
+		forms:      forms,
+	}
+}
@@ -0,0 +1,357 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/devopsext/chatops/common"
+)
+
+type testMatrixEvent struct {
+	typ     string
+	id      string
+	content *MatrixContent
+}
+
+// testMatrixHomeserver stubs client and media API, sync answers with queued responses and fails once they are over
+type testMatrixHomeserver struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	syncs      []*MatrixSyncResponse
+	queries    []string
+	events     []*testMatrixEvent
+	redactions []string
+	joins      []string
+	uploads    []string
+}
+
+func (s *testMatrixHomeserver) handle(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.URL.Path == matrixMediaPath+"/upload" {
+		b, _ := io.ReadAll(r.Body)
+		s.uploads = append(s.uploads, fmt.Sprintf("%s %s %d", r.URL.Query().Get("filename"), r.Header.Get("Content-Type"), len(b)))
+		json.NewEncoder(w).Encode(&MatrixUploadResponse{ContentURI: fmt.Sprintf("mxc://example.org/u%d", len(s.uploads))})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, matrixClientPath)
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "/account/whoami":
+		json.NewEncoder(w).Encode(&MatrixWhoAmI{UserID: "@chatops:example.org"})
+	case strings.HasPrefix(path, "/profile/"):
+		json.NewEncoder(w).Encode(&MatrixProfile{DisplayName: "ChatOps"})
+	case path == "/sync":
+		s.queries = append(s.queries, r.URL.Query().Get("since")+" "+r.URL.Query().Get("filter"))
+		if len(s.syncs) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(s.syncs[0])
+		s.syncs = s.syncs[1:]
+	case strings.HasPrefix(path, "/join/"):
+		s.joins = append(s.joins, strings.TrimPrefix(path, "/join/"))
+	case len(parts) == 6 && parts[3] == "send":
+		content := &MatrixContent{}
+		json.NewDecoder(r.Body).Decode(content)
+		e := &testMatrixEvent{typ: parts[4], id: fmt.Sprintf("$e%d", len(s.events)+1), content: content}
+		s.events = append(s.events, e)
+		json.NewEncoder(w).Encode(&MatrixEventResponse{EventID: e.id})
+	case len(parts) == 6 && parts[3] == "redact":
+		s.redactions = append(s.redactions, parts[4])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// recorded returns messages and reactions, added ones with + and then redacted ones with -
+func (s *testMatrixHomeserver) recorded() ([]*testMatrixEvent, []string) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := []*testMatrixEvent{}
+	reactions := []string{}
+	for _, e := range s.events {
+		if e.typ == matrixEventMessage {
+			messages = append(messages, e)
+			continue
+		}
+		reactions = append(reactions, "+"+e.content.RelatesTo.Key)
+	}
+	for _, id := range s.redactions {
+		for _, e := range s.events {
+			if e.id == id {
+				reactions = append(reactions, "-"+e.content.RelatesTo.Key)
+			}
+		}
+	}
+	return messages, reactions
+}
+
+func newTestMatrix(t *testing.T, options MatrixOptions, processors *common.Processors) (*Matrix, *testMatrixHomeserver) {
+
+	t.Helper()
+	s := &testMatrixHomeserver{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+
+	options.URL = s.URL
+	options.AccessToken = "token"
+	options.Timeout = 5
+	options.ReactionDoing = "👀"
+	options.ReactionDone = "✅"
+	options.ReactionFailed = "❌"
+	options.ReactionForm = "📝"
+	options.ReactionApproval = "🙏"
+	options.ReplyApprove = "approve"
+	options.ReplyReject = "reject"
+	options.ReplyCancel = "cancel"
+	options.FormInvalid = "Invalid value"
+	options.FormCancelled = "Cancelled"
+
+	mx := NewMatrix(options, testObservability(), processors)
+	t.Cleanup(mx.messages.Stop)
+	t.Cleanup(mx.reactions.Stop)
+	t.Cleanup(mx.forms.Stop)
+	mx.me = "@chatops:example.org"
+	mx.displayName = "ChatOps"
+	return mx, s
+}
+
+func testMatrixText(id, sender, body string, relatesTo *MatrixRelatesTo) *MatrixEvent {
+	return &MatrixEvent{
+		Type:    matrixEventMessage,
+		EventID: id,
+		Sender:  sender,
+		Content: &MatrixContent{MsgType: matrixMsgTypeText, Body: body, RelatesTo: relatesTo},
+	}
+}
+
+// testMatrixThread relates event to thread root, replyToID makes it a real reply instead of fallback
+func testMatrixThread(rootID, replyToID string) *MatrixRelatesTo {
+
+	r := &MatrixRelatesTo{RelType: matrixRelThread, EventID: rootID, IsFallingBack: true, InReplyTo: &MatrixInReplyTo{EventID: rootID}}
+	if replyToID != "" {
+		r.IsFallingBack = false
+		r.InReplyTo.EventID = replyToID
+	}
+	return r
+}
+
+func TestMatrixSync(t *testing.T) {
+
+	c := &testCommand{name: "status", text: "all good"}
+	mx, s := newTestMatrix(t, MatrixOptions{AutoJoin: true, Prefix: "!"}, testProcessors("ops", c))
+	mx.me = ""
+
+	room := func(events ...*MatrixEvent) map[string]*MatrixJoinedRoom {
+		return map[string]*MatrixJoinedRoom{"!ops:example.org": {Timeline: MatrixTimeline{Events: events}}}
+	}
+	s.syncs = []*MatrixSyncResponse{
+		{NextBatch: "b1", Rooms: MatrixRooms{
+			Join:   room(testMatrixText("$history", "@alice:example.org", "!ops status", nil)),
+			Invite: map[string]interface{}{"!new:example.org": map[string]interface{}{}},
+		}},
+		{NextBatch: "b2", Rooms: MatrixRooms{Join: room(
+			testMatrixText("$own", "@chatops:example.org", "!ops status", nil),
+			testMatrixText("$chat", "@alice:example.org", "ops status", nil),
+			testMatrixText("$prefix", "@alice:example.org", "!ops status", nil),
+			testMatrixText("$edit", "@alice:example.org", "!ops status", &MatrixRelatesTo{RelType: matrixRelReplace, EventID: "$prefix"}),
+		)}},
+		{NextBatch: "b3", Rooms: MatrixRooms{Join: room(
+			testMatrixText("$mention", "@bob:example.org", "ChatOps: ops status", nil),
+		)}},
+	}
+
+	// without reconnect interval homeserver error stops sync loop
+	mx.start()
+
+	if mx.me != "@chatops:example.org" || mx.displayName != "ChatOps" {
+		t.Fatalf("unexpected bot identity %s %s", mx.me, mx.displayName)
+	}
+	if strings.Join(s.joins, ",") != "!new:example.org" {
+		t.Fatalf("expected invite to be joined, got %v", s.joins)
+	}
+	if len(s.queries) != 4 || s.queries[0] != " "+matrixInitialFilter || s.queries[1] != "b1 "+matrixSyncFilter || s.queries[3] != "b3 "+matrixSyncFilter {
+		t.Fatalf("unexpected sync requests %v", s.queries)
+	}
+
+	messages, _ := s.recorded()
+	replied := []string{}
+	for _, m := range messages {
+		replied = append(replied, m.content.RelatesTo.EventID)
+	}
+	if strings.Join(replied, ",") != "$prefix,$mention" {
+		t.Fatalf("expected replies to prefix and display name mention only, got %v", replied)
+	}
+}
+
+func TestMatrixThreads(t *testing.T) {
+
+	c := &testCommand{name: "status", text: "all <good>"}
+	mx, s := newTestMatrix(t, MatrixOptions{}, testProcessors("ops", c))
+
+	mx.processEvent("!ops", testMatrixText("$root", "@alice:example.org", "@chatops:example.org ops status", nil))
+
+	// reply fallback quote is not a part of command
+	quoted := testMatrixText("$inner", "@bob:example.org", "> <@alice:example.org> deploy failed\n\nchatops: ops status", testMatrixThread("$root", "$other"))
+	mx.processEvent("!ops", quoted)
+
+	messages, reactions := s.recorded()
+	if len(messages) != 2 {
+		t.Fatalf("expected two replies, got %d", len(messages))
+	}
+
+	// reply to thread root starts the thread, reply inside the thread answers the command
+	top := messages[0].content
+	if top.RelatesTo.RelType != matrixRelThread || top.RelatesTo.EventID != "$root" || !top.RelatesTo.IsFallingBack || top.RelatesTo.InReplyTo.EventID != "$root" {
+		t.Fatalf("unexpected thread relation %+v", top.RelatesTo)
+	}
+	inner := messages[1].content
+	if inner.RelatesTo.EventID != "$root" || inner.RelatesTo.IsFallingBack || inner.RelatesTo.InReplyTo.EventID != "$inner" {
+		t.Fatalf("unexpected thread relation %+v", inner.RelatesTo)
+	}
+	if top.MsgType != matrixMsgTypeNotice || top.Body != "all <good>" || top.Format != matrixFormatHTML || top.FormattedBody != "all &lt;good&gt;" {
+		t.Fatalf("unexpected content %+v", top)
+	}
+	if strings.Join(reactions, ",") != "+👀,+✅,+👀,+✅,-👀,-👀" {
+		t.Fatalf("unexpected reactions %v", reactions)
+	}
+}
+
+func TestMatrixForm(t *testing.T) {
+
+	c := &testCommand{name: "scale", text: "scaled", fields: []common.Field{
+		{Name: "service", Label: "Service", Type: common.FieldTypeSelect, Required: true, Values: []string{"api", "web"}},
+		{Name: "replicas", Label: "Replicas", Type: common.FieldTypeInteger, Required: true},
+	}}
+	mx, s := newTestMatrix(t, MatrixOptions{}, testProcessors("ops", c))
+
+	mx.processEvent("!ops", testMatrixText("$origin", "@alice:example.org", "chatops: ops scale", nil))
+
+	messages, _ := s.recorded()
+	if len(messages) != 1 || messages[0].content.Body != "Service\n1. api\n2. web\n(cancel)" || messages[0].content.RelatesTo.EventID != "$origin" {
+		t.Fatalf("expected service prompt in thread, got %v", messages)
+	}
+
+	// other users in the room don't answer the form
+	mx.processEvent("!ops", testMatrixText("$bob", "@bob:example.org", "1", testMatrixThread("$origin", "")))
+	mx.processEvent("!ops", testMatrixText("$a1", "@alice:example.org", "2", testMatrixThread("$origin", "")))
+	mx.processEvent("!ops", testMatrixText("$a2", "@alice:example.org", "three", testMatrixThread("$origin", "")))
+	mx.processEvent("!ops", testMatrixText("$a3", "@alice:example.org", "3", testMatrixThread("$origin", "")))
+
+	executed, _ := c.calls()
+	if len(executed) != 1 || executed[0]["service"] != "web" || executed[0]["replicas"] != "3" {
+		t.Fatalf("unexpected executions %v", executed)
+	}
+
+	messages, reactions := s.recorded()
+	bodies := []string{}
+	for _, m := range messages {
+		bodies = append(bodies, m.content.Body)
+	}
+	expected := []string{
+		"Service\n1. api\n2. web\n(cancel)",
+		"Replicas\n(cancel)",
+		"Invalid value: three is not an integer",
+		"* Service: web\nReplicas: 3",
+		"scaled",
+	}
+	if strings.Join(bodies, "|") != strings.Join(expected, "|") {
+		t.Fatalf("unexpected form messages %q", bodies)
+	}
+	// last prompt is replaced with summary once the form is complete
+	if edit := messages[3].content.RelatesTo; edit.RelType != matrixRelReplace || edit.EventID != messages[1].id {
+		t.Fatalf("expected prompt to be edited, got %+v", edit)
+	}
+	if strings.Join(reactions, ",") != "+👀,+📝,+👀,+✅,-👀,-📝,-👀" {
+		t.Fatalf("unexpected reactions %v", reactions)
+	}
+}
+
+func TestMatrixApproval(t *testing.T) {
+
+	c := &testCommand{name: "restart", text: "restarted", approval: &testApproval{message: "Restart api?"},
+		actions: []common.Action{&testAction{name: "logs", label: "Logs"}}}
+	mx, s := newTestMatrix(t, MatrixOptions{}, testProcessors("ops", c))
+
+	mx.processEvent("!ops", testMatrixText("$origin", "@alice:example.org", "chatops: ops restart", nil))
+
+	messages, _ := s.recorded()
+	if len(messages) != 1 || messages[0].content.Body != "Restart api?\n\napprove / reject" {
+		t.Fatalf("expected approval message, got %v", messages)
+	}
+	approvalID := messages[0].id
+
+	mx.processEvent("!ops", testMatrixText("$self", "@alice:example.org", "approve", testMatrixThread("$origin", approvalID)))
+	executed, _ := c.calls()
+	messages, _ = s.recorded()
+	if len(executed) != 0 || messages[len(messages)-1].content.Body != "❌ Matrix same user cannot approve its action" {
+		t.Fatalf("expected requester not to approve, got %v", executed)
+	}
+
+	mx.processEvent("!ops", testMatrixText("$bob", "@bob:example.org", "Approve", testMatrixThread("$origin", approvalID)))
+	executed, _ = c.calls()
+	if len(executed) != 1 {
+		t.Fatalf("expected execution after approval, got %v", executed)
+	}
+
+	// approval is forgotten, so it can't be approved twice
+	mx.processEvent("!ops", testMatrixText("$again", "@carol:example.org", "approve", testMatrixThread("$origin", approvalID)))
+	executed, _ = c.calls()
+	if len(executed) != 1 {
+		t.Fatalf("expected single execution, got %v", executed)
+	}
+
+	messages, reactions := s.recorded()
+	edit := messages[len(messages)-2].content
+	if edit.RelatesTo.RelType != matrixRelReplace || edit.RelatesTo.EventID != approvalID || edit.NewContent.Body != "Restart api?\n\n✅ @bob:example.org" {
+		t.Fatalf("expected approval to be edited, got %+v", edit)
+	}
+	reply := messages[len(messages)-1]
+	if reply.content.Body != "restarted\n\nlogs" || reply.content.RelatesTo.InReplyTo.EventID != "$origin" {
+		t.Fatalf("expected reply with actions to origin, got %+v", reply.content)
+	}
+	if strings.Join(reactions, ",") != "+👀,+🙏,+👀,+✅,-👀,-🙏,-👀" {
+		t.Fatalf("unexpected reactions %v", reactions)
+	}
+
+	// actions are taken by replying to bot message with action name
+	mx.processEvent("!ops", testMatrixText("$logs", "@bob:example.org", "logs", testMatrixThread("$origin", reply.id)))
+	_, triggered := c.calls()
+	if strings.Join(triggered, ",") != "logs" {
+		t.Fatalf("unexpected actions %v", triggered)
+	}
+}
+
+func TestMatrixAttachments(t *testing.T) {
+
+	c := &testCommand{name: "graph", attachments: []*common.Attachment{
+		{Title: "latency.png", Type: common.AttachmentTypeImage, Data: []byte("\x89PNG\r\n\x1a\n")},
+	}}
+	mx, s := newTestMatrix(t, MatrixOptions{}, testProcessors("ops", c))
+
+	mx.processEvent("!ops", testMatrixText("$origin", "@alice:example.org", "chatops: ops graph", nil))
+
+	if strings.Join(s.uploads, ",") != "latency.png image/png 8" {
+		t.Fatalf("unexpected uploads %v", s.uploads)
+	}
+	messages, _ := s.recorded()
+	if len(messages) != 1 {
+		t.Fatalf("expected image only, got %d messages", len(messages))
+	}
+	image := messages[0].content
+	if image.MsgType != matrixMsgTypeImage || image.URL != "mxc://example.org/u1" || image.Info.MimeType != "image/png" || image.RelatesTo.EventID != "$origin" {
+		t.Fatalf("unexpected image %+v", image)
+	}
+}
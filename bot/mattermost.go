@@ -0,0 +1,1460 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	"github.com/gorilla/websocket"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/jinzhu/copier"
+)
+
+type MattermostOptions struct {
+	URL             string
+	BotToken        string
+	Debug           bool
+	Timeout         int
+	Insecure        bool
+	Listen          string
+	ActionsURL      string
+	SlashToken      string
+	DefaultCommand  string
+	UserPermissions string
+	ApprovalAny     bool
+
+	ReactionDoing    string
+	ReactionDone     string
+	ReactionFailed   string
+	ReactionApproval string
+
+	ButtonApproveCaption string
+	ButtonRejectCaption  string
+
+	ReconnectInterval int
+	CacheTTL          string
+}
+
+type MattermostMessageKey struct {
+	channelID string
+	postID    string
+	rootID    string
+}
+
+type MattermostUser struct {
+	id       string
+	name     string
+	timezone string
+	commands []string
+}
+
+type MattermostChannel struct {
+	id string
+}
+
+type MattermostMessage struct {
+	mattermost *Mattermost
+	cmdText    string
+	cmd        common.Command
+	originKey  *MattermostMessageKey
+	key        *MattermostMessageKey
+	user       *MattermostUser
+	caller     *MattermostUser
+	visible    bool
+	text       string
+	actions    []common.Action
+	params     common.ExecuteParams
+	fields     []common.Field
+}
+
+type MattermostTimezone struct {
+	UseAutomaticTimezone string `json:"useAutomaticTimezone"`
+	AutomaticTimezone    string `json:"automaticTimezone"`
+	ManualTimezone       string `json:"manualTimezone"`
+}
+
+type MattermostUserInfo struct {
+	ID       string             `json:"id"`
+	Username string             `json:"username"`
+	Timezone MattermostTimezone `json:"timezone"`
+}
+
+type MattermostIntegration struct {
+	URL     string                 `json:"url"`
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+type MattermostPostAction struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type,omitempty"`
+	Style       string                 `json:"style,omitempty"`
+	Integration *MattermostIntegration `json:"integration,omitempty"`
+}
+
+type MattermostAttachment struct {
+	Title   string                  `json:"title,omitempty"`
+	Text    string                  `json:"text,omitempty"`
+	Color   string                  `json:"color,omitempty"`
+	Actions []*MattermostPostAction `json:"actions,omitempty"`
+}
+
+type MattermostPost struct {
+	ID        string                 `json:"id,omitempty"`
+	ChannelID string                 `json:"channel_id,omitempty"`
+	RootID    string                 `json:"root_id,omitempty"`
+	UserID    string                 `json:"user_id,omitempty"`
+	Message   string                 `json:"message"`
+	FileIDs   []string               `json:"file_ids,omitempty"`
+	Props     map[string]interface{} `json:"props,omitempty"`
+}
+
+type MattermostFileInfo struct {
+	ID string `json:"id"`
+}
+
+type MattermostUploadResponse struct {
+	FileInfos []*MattermostFileInfo `json:"file_infos"`
+}
+
+type MattermostReaction struct {
+	UserID    string `json:"user_id"`
+	PostID    string `json:"post_id"`
+	EmojiName string `json:"emoji_name"`
+}
+
+type MattermostEvent struct {
+	Event string                 `json:"event"`
+	Data  map[string]interface{} `json:"data"`
+	Seq   int64                  `json:"seq"`
+}
+
+type MattermostActionRequest struct {
+	UserID    string                 `json:"user_id"`
+	UserName  string                 `json:"user_name"`
+	ChannelID string                 `json:"channel_id"`
+	PostID    string                 `json:"post_id"`
+	TriggerID string                 `json:"trigger_id"`
+	Context   map[string]interface{} `json:"context"`
+}
+
+type MattermostActionResponse struct {
+	EphemeralText string `json:"ephemeral_text,omitempty"`
+}
+
+type MattermostSlashResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text,omitempty"`
+}
+
+type Mattermost struct {
+	options    MattermostOptions
+	processors *common.Processors
+	client     *http.Client
+	me         *MattermostUserInfo
+	logger     sreCommon.Logger
+	meter      sreCommon.Meter
+	messages   *ttlcache.Cache[string, *MattermostMessage]
+}
+
+const (
+	mattermostAPIPath            = "/api/v4"
+	mattermostWebSocketPath      = "/websocket"
+	mattermostActionsPath        = "/actions"
+	mattermostCommandsPath       = "/commands"
+	mattermostEventPosted        = "posted"
+	mattermostChannelDirect      = "D"
+	mattermostMaxMessageLength   = 16383
+	mattermostTrimmed            = "...trimmed :broken_heart:"
+	mattermostActionButtonType   = "action"
+	mattermostApprovalButtonType = "approval"
+	mattermostApprovalSubmit     = "approve"
+	mattermostApprovalCancel     = "reject"
+	mattermostResponseInChannel  = "in_channel"
+	mattermostContextType        = "type"
+	mattermostContextName        = "name"
+)
+
+// MattermostUser
+
+func (mu *MattermostUser) ID() string {
+	return mu.id
+}
+
+func (mu *MattermostUser) Name() string {
+	return mu.name
+}
+
+func (mu *MattermostUser) TimeZone() string {
+	return mu.timezone
+}
+
+func (mu *MattermostUser) Commands() []string {
+	return mu.commands
+}
+
+// MattermostChannel
+
+func (mc *MattermostChannel) ID() string {
+	return mc.id
+}
+
+// MattermostMessage
+
+func (mm *MattermostMessage) ID() string {
+	if mm.key == nil {
+		return ""
+	}
+	return mm.key.postID
+}
+
+func (mm *MattermostMessage) Visible() bool {
+	return mm.visible
+}
+
+func (mm *MattermostMessage) User() common.User {
+	return mm.user
+}
+
+func (mm *MattermostMessage) Caller() common.User {
+	return mm.caller
+}
+
+func (mm *MattermostMessage) userID() string {
+	u := mm.user
+	if u == nil {
+		return ""
+	}
+	return u.id
+}
+
+func (mm *MattermostMessage) Channel() common.Channel {
+	if mm.key == nil {
+		return nil
+	}
+	return &MattermostChannel{id: mm.key.channelID}
+}
+
+func (mm *MattermostMessage) ParentID() string {
+	if mm.key == nil {
+		return ""
+	}
+	return mm.key.rootID
+}
+
+func (mm *MattermostMessage) SetParentID(rootID string) {
+	if mm.key == nil {
+		return
+	}
+	mm.key.rootID = rootID
+}
+
+// MattermostMessageKey
+
+func (mmk *MattermostMessageKey) String() string {
+	return fmt.Sprintf("%s/%s", mmk.channelID, mmk.postID)
+}
+
+// Mattermost
+
+func (m *Mattermost) Name() string {
+	return "Mattermost"
+}
+
+func (m *Mattermost) apiURL(path string) string {
+	return fmt.Sprintf("%s%s%s", strings.TrimSuffix(m.options.URL, "/"), mattermostAPIPath, path)
+}
+
+func (m *Mattermost) headers() map[string]string {
+
+	headers := make(map[string]string)
+	headers["Authorization"] = fmt.Sprintf("Bearer %s", m.options.BotToken)
+	headers["Content-Type"] = "application/json"
+	return headers
+}
+
+func (m *Mattermost) request(method, path string, in, out interface{}) error {
+
+	var raw []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		raw = b
+	}
+
+	b, err := utils.HttpRequestRawWithHeaders(m.client, method, m.apiURL(path), m.headers(), raw)
+	if err != nil {
+		return fmt.Errorf("Mattermost %s %s error: %s %s", method, path, err, string(b))
+	}
+
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+func (m *Mattermost) findMessageInCache(key *MattermostMessageKey) *MattermostMessage {
+
+	if key == nil {
+		return nil
+	}
+	item := m.messages.Get(key.String())
+	if item != nil {
+		return item.Value()
+	}
+	return nil
+}
+
+func (m *Mattermost) putMessageToCache(msg *MattermostMessage) {
+
+	if msg.key == nil || utils.IsEmpty(msg.key.postID) {
+		return
+	}
+	m.messages.Set(msg.key.String(), msg, ttlcache.DefaultTTL)
+}
+
+func (m *Mattermost) cloneMessage(msg *MattermostMessage) *MattermostMessage {
+
+	if msg == nil {
+		return nil
+	}
+	r := &MattermostMessage{}
+	err := copier.Copy(r, msg)
+	if err != nil {
+		m.logger.Error("Mattermost message copy error: %s", err)
+		return nil
+	}
+	return r
+}
+
+func (m *Mattermost) buildPostAction(id, label, style, typ, name string) *MattermostPostAction {
+
+	context := make(map[string]interface{})
+	context[mattermostContextType] = typ
+	context[mattermostContextName] = name
+
+	return &MattermostPostAction{
+		ID:    id,
+		Name:  label,
+		Type:  "button",
+		Style: style,
+		Integration: &MattermostIntegration{
+			URL:     fmt.Sprintf("%s%s", strings.TrimSuffix(m.options.ActionsURL, "/"), mattermostActionsPath),
+			Context: context,
+		},
+	}
+}
+
+func (m *Mattermost) buildActions(actions []common.Action) []*MattermostPostAction {
+
+	r := []*MattermostPostAction{}
+	if utils.IsEmpty(m.options.ActionsURL) {
+		return r
+	}
+
+	for i, a := range actions {
+
+		aName := a.Name()
+		if utils.IsEmpty(aName) {
+			continue
+		}
+
+		label := aName
+		aLabel := a.Label()
+		if !utils.IsEmpty(aLabel) {
+			label = aLabel
+		}
+		// action ID allows only alphanumeric characters
+		id := fmt.Sprintf("action%d", i)
+		r = append(r, m.buildPostAction(id, label, a.Style(), mattermostActionButtonType, aName))
+	}
+	return r
+}
+
+func (m *Mattermost) buildProps(attachments []*common.Attachment, actions []common.Action) map[string]interface{} {
+
+	atts := []*MattermostAttachment{}
+	for _, a := range attachments {
+
+		switch a.Type {
+		case common.AttachmentTypeImage, common.AttachmentTypeFile:
+			continue
+		}
+		atts = append(atts, &MattermostAttachment{
+			Title: a.Title,
+			Text:  string(a.Data),
+		})
+	}
+
+	buttons := m.buildActions(actions)
+	if len(buttons) > 0 {
+		atts = append(atts, &MattermostAttachment{Actions: buttons})
+	}
+
+	props := make(map[string]interface{})
+	props["attachments"] = atts
+	return props
+}
+
+func (m *Mattermost) uploadFiles(channelID string, attachments []*common.Attachment) ([]string, error) {
+
+	r := []string{}
+	for _, a := range attachments {
+
+		switch a.Type {
+		case common.AttachmentTypeImage, common.AttachmentTypeFile:
+		default:
+			continue
+		}
+
+		name := a.Title
+		if utils.IsEmpty(name) {
+			name = fmt.Sprintf("%s-%s", m.me.Username, time.Now().Format("20060102T150405"))
+		}
+
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		err := writer.WriteField("channel_id", channelID)
+		if err != nil {
+			return r, err
+		}
+		part, err := writer.CreateFormFile("files", name)
+		if err != nil {
+			return r, err
+		}
+		_, err = part.Write(a.Data)
+		if err != nil {
+			return r, err
+		}
+		err = writer.Close()
+		if err != nil {
+			return r, err
+		}
+
+		headers := m.headers()
+		headers["Content-Type"] = writer.FormDataContentType()
+
+		b, err := utils.HttpRequestRawWithHeaders(m.client, http.MethodPost, m.apiURL("/files"), headers, body.Bytes())
+		if err != nil {
+			return r, fmt.Errorf("Mattermost upload file error: %s %s", err, string(b))
+		}
+
+		var resp MattermostUploadResponse
+		err = json.Unmarshal(b, &resp)
+		if err != nil {
+			return r, err
+		}
+		for _, fi := range resp.FileInfos {
+			r = append(r, fi.ID)
+		}
+	}
+	return r, nil
+}
+
+func (m *Mattermost) createPost(channelID, rootID, message string, attachments []*common.Attachment, actions []common.Action) (*MattermostPost, error) {
+
+	fileIDs, err := m.uploadFiles(channelID, attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	post := &MattermostPost{
+		ChannelID: channelID,
+		RootID:    rootID,
+		Message:   common.LimitText(message, mattermostMaxMessageLength, mattermostTrimmed),
+		FileIDs:   fileIDs,
+		Props:     m.buildProps(attachments, actions),
+	}
+
+	r := &MattermostPost{}
+	err = m.request(http.MethodPost, "/posts", post, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (m *Mattermost) patchPost(postID string, patch map[string]interface{}) error {
+	return m.request(http.MethodPut, fmt.Sprintf("/posts/%s/patch", postID), patch, nil)
+}
+
+func (m *Mattermost) reply(msg *MattermostMessage, message string, attachments []*common.Attachment, actions []common.Action,
+	response *common.BotResponse, start *time.Time, error bool) (*MattermostMessageKey, string, error) {
+
+	if msg.key == nil {
+		return nil, "", fmt.Errorf("Mattermost message has no channel")
+	}
+
+	text := message
+	if !utils.IsEmpty(response) {
+
+		if response.Original() && !utils.IsEmpty(msg.cmdText) {
+			user := ""
+			if msg.user != nil && !utils.IsEmpty(msg.user.name) {
+				user = fmt.Sprintf("@%s ", msg.user.name)
+			}
+			text = fmt.Sprintf("> %s%s\n\n%s", user, msg.cmdText, text)
+		}
+
+		if response.Duration() && start != nil && !error {
+			elapsed := time.Since(*start)
+			text = fmt.Sprintf("[%s] %s", elapsed.Round(time.Millisecond), text)
+		}
+	}
+
+	rootID := msg.key.rootID
+	if utils.IsEmpty(rootID) {
+		rootID = msg.key.postID
+	}
+
+	if error {
+		text = fmt.Sprintf(":%s: %s", m.options.ReactionFailed, text)
+		attachments = nil
+		actions = nil
+	}
+
+	post, err := m.createPost(msg.key.channelID, rootID, text, attachments, actions)
+	if err != nil {
+		return nil, "", err
+	}
+	return &MattermostMessageKey{
+		channelID: post.ChannelID,
+		postID:    post.ID,
+		rootID:    rootID,
+	}, post.Message, nil
+}
+
+func (m *Mattermost) replyError(msg *MattermostMessage, err error) {
+
+	m.logger.Error("Mattermost reply error: %s", err)
+	_, _, err = m.reply(msg, err.Error(), nil, nil, nil, nil, true)
+	if err != nil {
+		m.logger.Error("Mattermost couldn't reply error: %s", err)
+	}
+}
+
+func (m *Mattermost) addReaction(key *MattermostMessageKey, name string) {
+
+	if key == nil || utils.IsEmpty(key.postID) || utils.IsEmpty(name) {
+		return
+	}
+	err := m.AddReaction(key.channelID, key.postID, name)
+	if err != nil {
+		m.logger.Error("Mattermost adding reaction error: %s", err)
+	}
+}
+
+func (m *Mattermost) removeReaction(key *MattermostMessageKey, name string) {
+
+	if key == nil || utils.IsEmpty(key.postID) || utils.IsEmpty(name) {
+		return
+	}
+	err := m.RemoveReaction(key.channelID, key.postID, name)
+	if err != nil {
+		m.logger.Error("Mattermost removing reaction error: %s", err)
+	}
+}
+
+func (m *Mattermost) addRemoveReactions(key *MattermostMessageKey, first, second string) {
+	m.addReaction(key, first)
+	m.removeReaction(key, second)
+}
+
+func (m *Mattermost) AddReaction(channel, ID, name string) error {
+
+	reaction := &MattermostReaction{
+		UserID:    m.me.ID,
+		PostID:    ID,
+		EmojiName: name,
+	}
+	return m.request(http.MethodPost, "/reactions", reaction, nil)
+}
+
+func (m *Mattermost) RemoveReaction(channel, ID, name string) error {
+	return m.request(http.MethodDelete, fmt.Sprintf("/users/%s/posts/%s/reactions/%s", m.me.ID, ID, url.PathEscape(name)), nil, nil)
+}
+
+func (m *Mattermost) updateActions(channel, ID string, update func(msg *MattermostMessage) []common.Action) error {
+
+	key := &MattermostMessageKey{
+		channelID: channel,
+		postID:    ID,
+	}
+
+	msg := m.findMessageInCache(key)
+	if msg == nil {
+		err := fmt.Errorf("Mattermost message not found in %s with %s", channel, ID)
+		m.logger.Error(err)
+		return err
+	}
+
+	msg.actions = update(msg)
+	m.putMessageToCache(msg)
+
+	patch := make(map[string]interface{})
+	patch["props"] = m.buildProps(nil, msg.actions)
+	return m.patchPost(ID, patch)
+}
+
+func (m *Mattermost) AddAction(channel, ID string, action common.Action) error {
+
+	return m.updateActions(channel, ID, func(msg *MattermostMessage) []common.Action {
+		return append(msg.actions, action)
+	})
+}
+
+func (m *Mattermost) AddActions(channel, ID string, actions []common.Action) error {
+
+	return m.updateActions(channel, ID, func(msg *MattermostMessage) []common.Action {
+		return append(msg.actions, actions...)
+	})
+}
+
+func (m *Mattermost) RemoveAction(channel, ID, name string) error {
+
+	return m.updateActions(channel, ID, func(msg *MattermostMessage) []common.Action {
+		actions := []common.Action{}
+		for _, a := range msg.actions {
+			if a.Name() == name {
+				continue
+			}
+			actions = append(actions, a)
+		}
+		return actions
+	})
+}
+
+func (m *Mattermost) ClearActions(channel, ID string) error {
+
+	return m.updateActions(channel, ID, func(msg *MattermostMessage) []common.Action {
+		return nil
+	})
+}
+
+func (m *Mattermost) DeleteMessage(channel, ID string) error {
+
+	err := m.request(http.MethodDelete, fmt.Sprintf("/posts/%s", ID), nil, nil)
+	if err != nil {
+		m.logger.Error("Failed to delete message: %s", err)
+		return err
+	}
+	m.messages.Delete((&MattermostMessageKey{channelID: channel, postID: ID}).String())
+	return nil
+}
+
+func (m *Mattermost) ReadMessage(channel, ID string) (string, error) {
+
+	post := &MattermostPost{}
+	err := m.request(http.MethodGet, fmt.Sprintf("/posts/%s", ID), nil, post)
+	if err != nil {
+		m.logger.Error("Failed to get message: %s", err)
+		return "", err
+	}
+	return post.Message, nil
+}
+
+func (m *Mattermost) UpdateMessage(channel, ID, message string) error {
+
+	patch := make(map[string]interface{})
+	patch["message"] = common.LimitText(message, mattermostMaxMessageLength, mattermostTrimmed)
+
+	err := m.patchPost(ID, patch)
+	if err != nil {
+		m.logger.Error("Failed to update message: %s", err)
+		return err
+	}
+	return nil
+}
+
+func (m *Mattermost) newMattermostUser(userID string) *MattermostUser {
+
+	if utils.IsEmpty(userID) {
+		return nil
+	}
+
+	info := &MattermostUserInfo{}
+	err := m.request(http.MethodGet, fmt.Sprintf("/users/%s", userID), nil, info)
+	if err != nil {
+		m.logger.Error("Mattermost couldn't get user for %s: %s", userID, err)
+		return nil
+	}
+
+	timezone := info.Timezone.ManualTimezone
+	if info.Timezone.UseAutomaticTimezone == "true" {
+		timezone = info.Timezone.AutomaticTimezone
+	}
+
+	u := &MattermostUser{
+		id:       info.ID,
+		name:     info.Username,
+		timezone: timezone,
+	}
+	commands, err := m.processors.UserCommands(m.options.UserPermissions, info.ID, info.Username)
+	if err != nil {
+		m.logger.Error("Mattermost permissions error: %s", err)
+	}
+	u.commands = commands
+	return u
+}
+
+// @bot group command param1 => group command param1
+func (m *Mattermost) prepareInputText(text string) string {
+
+	text = strings.TrimSpace(text)
+	mention := fmt.Sprintf("@%s", m.me.Username)
+	if strings.HasPrefix(text, mention) {
+		text = strings.TrimSpace(strings.TrimPrefix(text, mention))
+	}
+	text = strings.TrimPrefix(text, "/")
+
+	return m.processors.ReplaceAlias(text)
+}
+
+func (m *Mattermost) cachePostUserCommand(msg *MattermostMessage, params common.ExecuteParams, action common.Action, response common.Response, overwrite bool) error {
+
+	start := time.Now()
+	executor, message, attachments, actions, err := msg.cmd.Execute(m, msg, params, action)
+	if err != nil {
+		m.replyError(msg, err)
+		return err
+	}
+	if action == nil {
+		actions = common.MergeActions(actions, msg.cmd.Actions())
+	}
+
+	r := common.BuildResponse(overwrite, response, executor.Response())
+
+	var key *MattermostMessageKey
+	text := ""
+
+	if !utils.IsEmpty(message) || len(attachments) > 0 {
+
+		mReply := msg
+		channel := msg.cmd.Channel()
+		if !utils.IsEmpty(channel) && channel != msg.key.channelID {
+			mReply = m.cloneMessage(msg)
+			mReply.key = &MattermostMessageKey{channelID: channel}
+		}
+
+		k, txt, err := m.reply(mReply, message, attachments, actions, r, &start, r.Error())
+		if err != nil {
+			m.replyError(msg, err)
+			return err
+		}
+		key = k
+		text = txt
+	}
+
+	mNew := m.cloneMessage(msg)
+	mNew.originKey = msg.key
+	mNew.key = key
+	mNew.visible = r.Visible()
+	mNew.text = text
+	mNew.actions = actions
+	mNew.params = params
+
+	m.putMessageToCache(mNew)
+
+	if mNew.key == nil {
+		mNew.key = msg.key
+	}
+	return executor.After(mNew)
+}
+
+func (m *Mattermost) approvalNeeded(msg *MattermostMessage, cmd common.Command, params common.ExecuteParams) (string, string) {
+
+	approval := cmd.Approval()
+	if approval == nil {
+		return "", ""
+	}
+
+	chl := strings.TrimSpace(approval.Channel(m, msg, params))
+	if utils.IsEmpty(chl) {
+		chl = msg.key.channelID
+	}
+
+	message := strings.TrimSpace(approval.Message(m, msg, params))
+	if utils.IsEmpty(message) {
+		return "", chl
+	}
+	return message, chl
+}
+
+func (m *Mattermost) cacheAskApproval(msg *MattermostMessage, message, channel string, params common.ExecuteParams) error {
+
+	if utils.IsEmpty(m.options.ActionsURL) {
+		return fmt.Errorf("Mattermost approval requires actions URL")
+	}
+
+	rootID := ""
+	if channel == msg.key.channelID {
+		rootID = msg.key.rootID
+		if utils.IsEmpty(rootID) {
+			rootID = msg.key.postID
+		}
+	}
+
+	buttons := []*MattermostPostAction{
+		m.buildPostAction("approve", m.options.ButtonApproveCaption, "primary", mattermostApprovalButtonType, mattermostApprovalSubmit),
+		m.buildPostAction("reject", m.options.ButtonRejectCaption, "danger", mattermostApprovalButtonType, mattermostApprovalCancel),
+	}
+	props := make(map[string]interface{})
+	props["attachments"] = []*MattermostAttachment{{Actions: buttons}}
+
+	post := &MattermostPost{
+		ChannelID: channel,
+		RootID:    rootID,
+		Message:   common.LimitText(message, mattermostMaxMessageLength, mattermostTrimmed),
+		Props:     props,
+	}
+
+	r := &MattermostPost{}
+	err := m.request(http.MethodPost, "/posts", post, r)
+	if err != nil {
+		return err
+	}
+
+	mNew := m.cloneMessage(msg)
+	mNew.originKey = msg.key
+	mNew.key = &MattermostMessageKey{
+		channelID: r.ChannelID,
+		postID:    r.ID,
+		rootID:    rootID,
+	}
+	mNew.text = r.Message
+	mNew.params = params
+
+	m.putMessageToCache(mNew)
+	return nil
+}
+
+func (m *Mattermost) executeCommand(msg *MattermostMessage, params common.ExecuteParams, reaction string) {
+
+	r := common.BuildResponse(false, msg.cmd.Response())
+	err := m.cachePostUserCommand(msg, params, nil, r, false)
+	if err != nil {
+		m.logger.Error("Mattermost couldn't post from %s: %s", msg.userID(), err)
+		m.addRemoveReactions(msg.key, m.options.ReactionFailed, reaction)
+		return
+	}
+	m.addRemoveReactions(msg.key, m.options.ReactionDone, reaction)
+}
+
+func (m *Mattermost) processCommand(msg *MattermostMessage, params common.ExecuteParams) {
+
+	fields := msg.cmd.Fields(m, msg, params, nil)
+	msg.fields = fields
+	msg.params = params
+	m.putMessageToCache(msg)
+
+	if common.FormNeeded(fields, params) {
+		m.replyError(msg, fmt.Errorf("Mattermost command %s requires fields which are not supported", msg.cmd.Name()))
+		m.addRemoveReactions(msg.key, m.options.ReactionFailed, m.options.ReactionDoing)
+		return
+	}
+
+	message, channel := m.approvalNeeded(msg, msg.cmd, params)
+	if !utils.IsEmpty(message) {
+		err := m.cacheAskApproval(msg, message, channel, params)
+		if err != nil {
+			m.replyError(msg, err)
+			m.addRemoveReactions(msg.key, m.options.ReactionFailed, m.options.ReactionDoing)
+			return
+		}
+		m.addRemoveReactions(msg.key, m.options.ReactionApproval, m.options.ReactionDoing)
+		return
+	}
+
+	m.executeCommand(msg, params, m.options.ReactionDoing)
+}
+
+func (m *Mattermost) processText(key *MattermostMessageKey, userID, text string) {
+
+	u := m.newMattermostUser(userID)
+	if u == nil {
+		m.logger.Error("Mattermost couldn't process command from unknown user")
+		return
+	}
+
+	fText := m.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := m.processors.FindParams(false, fText)
+
+	if cmd == nil && !utils.IsEmpty(m.options.DefaultCommand) {
+		cmd = m.processors.FindCommand("", m.options.DefaultCommand)
+		group = ""
+		params = make(common.ExecuteParams)
+	}
+
+	if cmd == nil {
+		params, cmd = m.processors.FindSuggest(fText)
+		group = ""
+	}
+
+	if cmd == nil {
+		m.logger.Debug("Mattermost command not found for text: %s", text)
+		common.UpdateCounters(m.meter, "mattermost", "", "", fText, u.id)
+		return
+	}
+
+	common.UpdateCounters(m.meter, "mattermost", group, cmd.Name(), fText, u.id)
+
+	groupName := common.GroupName(group, cmd)
+	if !common.Permitted(u, cmd, groupName) {
+		m.logger.Error("Mattermost user %s is not permitted to execute %s", u.id, groupName)
+		return
+	}
+
+	m.addReaction(key, m.options.ReactionDoing)
+
+	msg := &MattermostMessage{
+		mattermost: m,
+		cmdText:    fText,
+		cmd:        cmd,
+		key:        key,
+		user:       u,
+		caller:     u,
+		visible:    true,
+		text:       text,
+	}
+
+	// args couldn't be parsed, so usage is replied before form or approval
+	if pErr != nil {
+		m.replyError(msg, pErr)
+		m.addRemoveReactions(key, m.options.ReactionFailed, m.options.ReactionDoing)
+		return
+	}
+	m.processCommand(msg, params)
+}
+
+func (m *Mattermost) parentMessage(parent common.Message) *MattermostMessage {
+
+	if utils.IsEmpty(parent) {
+		return nil
+	}
+	mm, ok := parent.(*MattermostMessage)
+	if !ok {
+		return nil
+	}
+	if mm.key != nil {
+		if mc := m.findMessageInCache(mm.key); mc != nil {
+			return mc
+		}
+	}
+	return mm
+}
+
+func (m *Mattermost) Command(channel, text string, user common.User, parent common.Message, response common.Response) error {
+
+	r := common.BuildResponse(true, response)
+
+	mOrigin := m.parentMessage(parent)
+	if mOrigin != nil && mOrigin.cmd != nil {
+		r = common.BuildResponse(false, mOrigin.cmd.Response(), response)
+	}
+
+	var mUser *MattermostUser
+	userID := "unknown"
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*MattermostUser)
+		if ok {
+			mUser = u
+		}
+		userID = user.ID()
+	}
+
+	fText := m.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := m.processors.FindParams(false, fText)
+	if cmd == nil {
+		m.logger.Debug("Mattermost command not found for text: %s", text)
+		return nil
+	}
+
+	groupName := common.GroupName(group, cmd)
+	if !utils.IsEmpty(user) && !utils.Contains(user.Commands(), groupName) {
+		m.logger.Debug("Mattermost command user %s is not permitted to execute %s", userID, groupName)
+		return nil
+	}
+
+	if pErr != nil {
+		return pErr
+	}
+
+	fields := cmd.Fields(m, parent, params, nil)
+	if common.FormNeeded(fields, params) {
+		m.logger.Debug("Mattermost command %s has no support for interaction mode", groupName)
+		return nil
+	}
+
+	key := &MattermostMessageKey{}
+	if mOrigin != nil && mOrigin.key != nil {
+		key.channelID = mOrigin.key.channelID
+		key.rootID = mOrigin.key.rootID
+		if utils.IsEmpty(key.rootID) {
+			key.rootID = mOrigin.key.postID
+		}
+	}
+	if !utils.IsEmpty(channel) {
+		if channel != key.channelID {
+			key.rootID = ""
+		}
+		key.channelID = channel
+	}
+
+	var mm *MattermostMessage
+	if mOrigin != nil {
+		mm = m.cloneMessage(mOrigin)
+		mm.originKey = mOrigin.key
+	} else {
+		mm = &MattermostMessage{
+			mattermost: m,
+			user:       mUser,
+			caller:     mUser,
+		}
+	}
+	mm.cmdText = fText
+	mm.cmd = cmd
+	mm.key = key
+	mm.fields = fields
+	mm.params = params
+
+	err := m.cachePostUserCommand(mm, params, nil, r, true)
+	if err != nil {
+		m.logger.Error("Mattermost command %s couldn't post from %s: %s", groupName, userID, err)
+		return err
+	}
+	return nil
+}
+
+func (m *Mattermost) PostMessage(channel string, message string, attachments []*common.Attachment, actions []common.Action,
+	user common.User, parent common.Message, response common.Response) (string, error) {
+
+	r := common.BuildResponse(true, response)
+
+	rootID := ""
+	mOrigin := m.parentMessage(parent)
+	if mOrigin != nil && mOrigin.key != nil {
+		rootID = mOrigin.key.rootID
+		if utils.IsEmpty(rootID) {
+			rootID = mOrigin.key.postID
+		}
+		if utils.IsEmpty(channel) {
+			channel = mOrigin.key.channelID
+		}
+		if mOrigin.key.channelID != channel {
+			rootID = ""
+		}
+	}
+
+	if utils.IsEmpty(channel) {
+		return "", fmt.Errorf("Mattermost channel is not defined")
+	}
+
+	post, err := m.createPost(channel, rootID, message, attachments, actions)
+	if err != nil {
+		return "", err
+	}
+
+	var mUser *MattermostUser
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*MattermostUser)
+		if ok {
+			mUser = u
+		}
+	}
+
+	var mm *MattermostMessage
+	if mOrigin != nil {
+		mm = m.cloneMessage(mOrigin)
+		mm.originKey = mOrigin.key
+	} else {
+		mm = &MattermostMessage{
+			mattermost: m,
+			user:       mUser,
+			caller:     mUser,
+		}
+	}
+	mm.key = &MattermostMessageKey{
+		channelID: post.ChannelID,
+		postID:    post.ID,
+		rootID:    rootID,
+	}
+	mm.visible = r.Visible()
+	mm.text = post.Message
+	mm.actions = actions
+	m.putMessageToCache(mm)
+
+	return post.ID, nil
+}
+
+func (m *Mattermost) decodeStrings(v interface{}) []string {
+
+	r := []string{}
+	s, ok := v.(string)
+	if !ok || utils.IsEmpty(s) {
+		return r
+	}
+	err := json.Unmarshal([]byte(s), &r)
+	if err != nil {
+		m.logger.Debug("Mattermost couldn't decode %s: %s", s, err)
+	}
+	return r
+}
+
+func (m *Mattermost) processPosted(event *MattermostEvent) {
+
+	raw, ok := event.Data["post"].(string)
+	if !ok {
+		return
+	}
+
+	post := &MattermostPost{}
+	err := json.Unmarshal([]byte(raw), post)
+	if err != nil {
+		m.logger.Error("Mattermost couldn't decode post: %s", err)
+		return
+	}
+
+	// skip own posts
+	if post.UserID == m.me.ID {
+		return
+	}
+
+	channelType, _ := event.Data["channel_type"].(string)
+	mentions := m.decodeStrings(event.Data["mentions"])
+	mention := fmt.Sprintf("@%s", m.me.Username)
+
+	direct := channelType == mattermostChannelDirect
+	mentioned := utils.Contains(mentions, m.me.ID) || strings.HasPrefix(strings.TrimSpace(post.Message), mention)
+	if !direct && !mentioned {
+		return
+	}
+
+	m.logger.Debug("Mattermost post: [%s] %s", post.UserID, post.Message)
+
+	key := &MattermostMessageKey{
+		channelID: post.ChannelID,
+		postID:    post.ID,
+		rootID:    post.RootID,
+	}
+	m.processText(key, post.UserID, post.Message)
+}
+
+func (m *Mattermost) handleActionButton(msg *MattermostMessage, caller *MattermostUser, name string) error {
+
+	if msg.cmd == nil {
+		return fmt.Errorf("Mattermost message has no command")
+	}
+
+	var action common.Action
+	for _, a := range msg.actions {
+		if a.Name() == name {
+			action = a
+			break
+		}
+	}
+
+	if action == nil {
+		return fmt.Errorf("Mattermost action %s is not defined", name)
+	}
+
+	mAction := m.cloneMessage(msg)
+	mAction.caller = caller
+	mAction.cmdText = ""
+
+	r := common.BuildResponse(false, msg.cmd.Response())
+	return m.cachePostUserCommand(mAction, msg.params, action, r, true)
+}
+
+func (m *Mattermost) handleApprovalButton(msg *MattermostMessage, caller *MattermostUser, name string) error {
+
+	if msg.cmd == nil || msg.originKey == nil {
+		return fmt.Errorf("Mattermost approval has no command")
+	}
+
+	if !m.options.ApprovalAny && caller.id == msg.userID() {
+		return fmt.Errorf("Mattermost same user cannot approve its action")
+	}
+
+	reaction := m.options.ReactionFailed
+	if name == mattermostApprovalSubmit {
+		reaction = m.options.ReactionDone
+	}
+
+	// remove buttons to avoid double approval
+	patch := make(map[string]interface{})
+	patch["message"] = fmt.Sprintf("%s\n\n:%s: @%s", msg.text, reaction, caller.name)
+	patch["props"] = m.buildProps(nil, nil)
+	err := m.patchPost(msg.key.postID, patch)
+	if err != nil {
+		m.logger.Error("Mattermost couldn't update approval message: %s", err)
+	}
+
+	mInit := m.cloneMessage(msg)
+	mInit.key = msg.originKey
+	mInit.originKey = nil
+
+	if name != mattermostApprovalSubmit {
+		m.addRemoveReactions(mInit.key, m.options.ReactionFailed, m.options.ReactionApproval)
+		return nil
+	}
+
+	m.addRemoveReactions(mInit.key, m.options.ReactionDoing, m.options.ReactionApproval)
+	m.executeCommand(mInit, msg.params, m.options.ReactionDoing)
+	return nil
+}
+
+func (m *Mattermost) writeJSON(w http.ResponseWriter, obj interface{}) {
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		m.logger.Error("Mattermost couldn't encode response: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(b); err != nil {
+		m.logger.Error("Mattermost couldn't write response: %s", err)
+	}
+}
+
+func (m *Mattermost) actionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		m.logger.Error("Mattermost couldn't read action: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	req := &MattermostActionRequest{}
+	err = json.Unmarshal(body, req)
+	if err != nil {
+		m.logger.Error("Mattermost couldn't decode action: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp := &MattermostActionResponse{}
+	defer m.writeJSON(w, resp)
+
+	key := &MattermostMessageKey{
+		channelID: req.ChannelID,
+		postID:    req.PostID,
+	}
+
+	msg := m.findMessageInCache(key)
+	if msg == nil {
+		m.logger.Error("Mattermost message is not found in cache.")
+		return
+	}
+
+	caller := m.newMattermostUser(req.UserID)
+	if caller == nil {
+		m.logger.Error("Mattermost couldn't process action from unknown user")
+		return
+	}
+
+	typ, _ := req.Context[mattermostContextType].(string)
+	name, _ := req.Context[mattermostContextName].(string)
+	if utils.IsEmpty(name) {
+		m.logger.Error("Mattermost action name is empty.")
+		return
+	}
+
+	switch typ {
+	case mattermostActionButtonType:
+		err = m.handleActionButton(msg, caller, name)
+	case mattermostApprovalButtonType:
+		err = m.handleApprovalButton(msg, caller, name)
+	}
+
+	if err != nil {
+		m.logger.Error(err)
+		resp.EphemeralText = err.Error()
+	}
+}
+
+func (m *Mattermost) commandsHandler(w http.ResponseWriter, r *http.Request) {
+
+	err := r.ParseForm()
+	if err != nil {
+		m.logger.Error("Mattermost couldn't parse command: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !utils.IsEmpty(m.options.SlashToken) && r.PostForm.Get("token") != m.options.SlashToken {
+		m.logger.Error("Mattermost slash command has wrong token")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	channelID := r.PostForm.Get("channel_id")
+	userID := r.PostForm.Get("user_id")
+	text := r.PostForm.Get("text")
+	command := strings.TrimPrefix(r.PostForm.Get("command"), "/")
+
+	echo := fmt.Sprintf("`/%s`", strings.TrimSpace(fmt.Sprintf("%s %s", command, text)))
+
+	// /chatops group command => group command, /alias param => alias param
+	_, cmd := m.processors.FindCommandByAlias(command)
+	if cmd != nil {
+		text = strings.TrimSpace(fmt.Sprintf("%s %s", command, text))
+	}
+
+	// slash commands have no post to reply to, so an echo post starts the thread
+	post, err := m.createPost(channelID, r.PostForm.Get("root_id"), echo, nil, nil)
+	if err != nil {
+		m.logger.Error("Mattermost couldn't post slash command: %s", err)
+		m.writeJSON(w, &MattermostSlashResponse{Text: err.Error()})
+		return
+	}
+	m.writeJSON(w, &MattermostSlashResponse{ResponseType: mattermostResponseInChannel})
+
+	key := &MattermostMessageKey{
+		channelID: post.ChannelID,
+		postID:    post.ID,
+		rootID:    post.RootID,
+	}
+	go m.processText(key, userID, text)
+}
+
+func (m *Mattermost) webSocketURL() string {
+
+	u := m.apiURL(mattermostWebSocketPath)
+	u = strings.Replace(u, "https://", "wss://", 1)
+	u = strings.Replace(u, "http://", "ws://", 1)
+	return u
+}
+
+func (m *Mattermost) listen() error {
+
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", m.options.BotToken))
+
+	dialer := websocket.DefaultDialer
+	conn, _, err := dialer.Dial(m.webSocketURL(), header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m.logger.Info("Mattermost is connected to %s", m.options.URL)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		event := &MattermostEvent{}
+		err := conn.ReadJSON(event)
+		if err != nil {
+			return err
+		}
+
+		if m.options.Debug {
+			m.logger.Debug("Mattermost event: %s", event.Event)
+		}
+
+		switch event.Event {
+		case mattermostEventPosted:
+			wg.Add(1)
+			go func(e *MattermostEvent) {
+				defer wg.Done()
+				m.processPosted(e)
+			}(event)
+		}
+	}
+}
+
+func (m *Mattermost) startServer() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(mattermostActionsPath, m.actionsHandler)
+	mux.HandleFunc(mattermostCommandsPath, m.commandsHandler)
+
+	go func() {
+		err := http.ListenAndServe(m.options.Listen, mux)
+		if err != nil {
+			m.logger.Error("Mattermost listen error: %s", err)
+		}
+	}()
+}
+
+func (m *Mattermost) start() {
+
+	me := &MattermostUserInfo{}
+	err := m.request(http.MethodGet, "/users/me", nil, me)
+	if err != nil {
+		m.logger.Error(err)
+		return
+	}
+	m.me = me
+
+	if !utils.IsEmpty(m.options.Listen) {
+		m.startServer()
+	}
+
+	interval := time.Duration(m.options.ReconnectInterval) * time.Second
+	for {
+		err := m.listen()
+		if err != nil {
+			m.logger.Error("Mattermost websocket error: %s", err)
+		}
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (m *Mattermost) Start(wg *sync.WaitGroup) {
+
+	if wg == nil {
+		m.start()
+		return
+	}
+
+	wg.Add(1)
+
+	go func(wg *sync.WaitGroup) {
+
+		defer wg.Done()
+		m.start()
+	}(wg)
+}
+
+func NewMattermost(options MattermostOptions, observability *common.Observability, processors *common.Processors) *Mattermost {
+
+	if utils.IsEmpty(options.URL) || utils.IsEmpty(options.BotToken) {
+		return nil
+	}
+
+	ttl := 1 * 60 * 60 * time.Second
+	if !utils.IsEmpty(options.CacheTTL) {
+		ttl, _ = time.ParseDuration(options.CacheTTL)
+	}
+
+	messages := ttlcache.New[string, *MattermostMessage](ttlcache.WithTTL[string, *MattermostMessage](ttl))
+	go messages.Start()
+
+	return &Mattermost{
+		options:    options,
+		processors: processors,
+		client:     utils.NewHttpClient(options.Timeout, options.Insecure),
+		logger:     observability.Logs(),
+		meter:      observability.Metrics(),
+		messages:   messages,
+	}
+}
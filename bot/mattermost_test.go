@@ -0,0 +1,327 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/devopsext/chatops/common"
+	"github.com/gorilla/websocket"
+)
+
+// testMattermostServer stubs API v4 and websocket, websocket sends queued events and closes
+type testMattermostServer struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	events    []*MattermostEvent
+	posts     []*MattermostPost
+	patches   []map[string]interface{}
+	reactions []string
+	uploads   []string
+}
+
+func (s *testMattermostServer) handle(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, mattermostAPIPath)
+	switch {
+	case path == "/users/me":
+		json.NewEncoder(w).Encode(&MattermostUserInfo{ID: "bot", Username: "chatops"})
+	case r.Method == http.MethodDelete && strings.Contains(path, "/reactions/"):
+		s.reactions = append(s.reactions, "-"+path[strings.LastIndex(path, "/")+1:])
+	case strings.HasPrefix(path, "/users/"):
+		id := strings.TrimPrefix(path, "/users/")
+		json.NewEncoder(w).Encode(&MattermostUserInfo{ID: id, Username: "user-" + id})
+	case path == "/files":
+		file, header, err := r.FormFile("files")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		s.uploads = append(s.uploads, r.FormValue("channel_id")+"/"+header.Filename)
+		json.NewEncoder(w).Encode(&MattermostUploadResponse{FileInfos: []*MattermostFileInfo{{ID: fmt.Sprintf("f%d", len(s.uploads))}}})
+	case path == "/posts":
+		post := &MattermostPost{}
+		json.NewDecoder(r.Body).Decode(post)
+		post.ID = fmt.Sprintf("p%d", len(s.posts)+1)
+		post.UserID = "bot"
+		s.posts = append(s.posts, post)
+		json.NewEncoder(w).Encode(post)
+	case strings.HasSuffix(path, "/patch"):
+		patch := make(map[string]interface{})
+		json.NewDecoder(r.Body).Decode(&patch)
+		s.patches = append(s.patches, patch)
+	case path == "/reactions":
+		reaction := &MattermostReaction{}
+		json.NewDecoder(r.Body).Decode(reaction)
+		s.reactions = append(s.reactions, "+"+reaction.EmojiName)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *testMattermostServer) websocket(w http.ResponseWriter, r *http.Request) {
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	s.mutex.Lock()
+	events := s.events
+	s.mutex.Unlock()
+
+	for _, e := range events {
+		conn.WriteJSON(e)
+	}
+}
+
+// posted queues websocket event the way server sends it, with post encoded as string
+func (s *testMattermostServer) posted(post *MattermostPost, channelType string, mentions ...string) {
+
+	b, _ := json.Marshal(post)
+	data := map[string]interface{}{"post": string(b), "channel_type": channelType}
+	if len(mentions) > 0 {
+		m, _ := json.Marshal(mentions)
+		data["mentions"] = string(m)
+	}
+	s.events = append(s.events, &MattermostEvent{Event: mattermostEventPosted, Data: data})
+}
+
+func (s *testMattermostServer) recorded() ([]*MattermostPost, []map[string]interface{}, []string) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.posts, s.patches, s.reactions
+}
+
+func newTestMattermost(t *testing.T, options MattermostOptions, processors *common.Processors) (*Mattermost, *testMattermostServer) {
+
+	t.Helper()
+	s := &testMattermostServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc(mattermostAPIPath+mattermostWebSocketPath, s.websocket)
+	mux.HandleFunc("/", s.handle)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	options.URL = s.URL
+	options.BotToken = "token"
+	options.Timeout = 5
+	options.ReactionDoing = "eyes"
+	options.ReactionDone = "white_check_mark"
+	options.ReactionFailed = "x"
+	options.ReactionApproval = "pray"
+	options.ButtonApproveCaption = "Approve"
+	options.ButtonRejectCaption = "Reject"
+
+	m := NewMattermost(options, testObservability(), processors)
+	t.Cleanup(m.messages.Stop)
+	m.me = &MattermostUserInfo{ID: "bot", Username: "chatops"}
+	return m, s
+}
+
+// testMattermostAction presses interactive button of post as user, ephemeral text is returned
+func testMattermostAction(t *testing.T, m *Mattermost, post *MattermostPost, userID, typ, name string) string {
+
+	t.Helper()
+	b, _ := json.Marshal(&MattermostActionRequest{
+		UserID:    userID,
+		ChannelID: post.ChannelID,
+		PostID:    post.ID,
+		Context: map[string]interface{}{
+			mattermostContextType: typ,
+			mattermostContextName: name,
+		},
+	})
+	w := httptest.NewRecorder()
+	m.actionsHandler(w, httptest.NewRequest(http.MethodPost, mattermostActionsPath, bytes.NewReader(b)))
+
+	resp := &MattermostActionResponse{}
+	err := json.Unmarshal(w.Body.Bytes(), resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.EphemeralText
+}
+
+func TestMattermostStart(t *testing.T) {
+
+	c := &testCommand{name: "status", text: "all good"}
+	m, s := newTestMattermost(t, MattermostOptions{}, testProcessors("ops", c))
+	m.me = nil
+
+	s.posted(&MattermostPost{ID: "own", ChannelID: "c1", UserID: "bot", Message: "@chatops ops status"}, "O")
+	s.posted(&MattermostPost{ID: "chat", ChannelID: "c1", UserID: "u1", Message: "ops status"}, "O")
+	s.posted(&MattermostPost{ID: "direct", ChannelID: "d1", UserID: "u1", Message: "ops status"}, mattermostChannelDirect)
+	s.posted(&MattermostPost{ID: "mention", ChannelID: "c1", UserID: "u1", Message: "@chatops ops status"}, "O")
+	s.posted(&MattermostPost{ID: "threaded", ChannelID: "c1", RootID: "root", UserID: "u1", Message: "ops status"}, "O", "bot")
+
+	// stub closes websocket once events are sent, without reconnect interval start returns
+	m.start()
+
+	if m.me == nil || m.me.Username != "chatops" {
+		t.Fatalf("expected bot user from API, got %v", m.me)
+	}
+	executed, _ := c.calls()
+	if len(executed) != 3 {
+		t.Fatalf("expected direct, mention and threaded posts to run, got %d", len(executed))
+	}
+
+	posts, _, _ := s.recorded()
+	roots := make(map[string]string)
+	for _, p := range posts {
+		roots[p.RootID] = p.ChannelID
+	}
+	if len(posts) != 3 || roots["direct"] != "d1" || roots["mention"] != "c1" || roots["root"] != "c1" {
+		t.Fatalf("expected replies in threads of posts, got %v", roots)
+	}
+}
+
+func TestMattermostSlashCommand(t *testing.T) {
+
+	c := &testCommand{name: "status", aliases: []string{"st"}, text: "all good"}
+	m, s := newTestMattermost(t, MattermostOptions{SlashToken: "secret"}, testProcessors("ops", c))
+
+	post := func(token, command, text string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {token}, "channel_id": {"c1"}, "user_id": {"u1"}, "command": {command}, "text": {text}}
+		r := httptest.NewRequest(http.MethodPost, mattermostCommandsPath, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		m.commandsHandler(w, r)
+		return w
+	}
+
+	w := post("wrong", "/chatops", "ops status")
+	posts, _, _ := s.recorded()
+	if w.Code != http.StatusUnauthorized || len(posts) != 0 {
+		t.Fatalf("expected wrong token to be rejected, got %d and %v", w.Code, posts)
+	}
+
+	w = post("secret", "/st", "")
+	if !strings.Contains(w.Body.String(), mattermostResponseInChannel) {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+
+	// slash command has no post, so reply goes to thread of echo post
+	testWait(t, func() bool {
+		posts, _, _ := s.recorded()
+		return len(posts) == 2
+	})
+	posts, _, _ = s.recorded()
+	if posts[0].Message != "`/st`" || posts[1].Message != "all good" || posts[1].RootID != posts[0].ID {
+		t.Fatalf("unexpected posts %v", posts)
+	}
+}
+
+func TestMattermostAttachments(t *testing.T) {
+
+	c := &testCommand{name: "graph", text: "latency", attachments: []*common.Attachment{
+		{Title: "latency.png", Type: common.AttachmentTypeImage, Data: []byte("png")},
+		{Title: "query", Type: common.AttachmentTypeText, Data: []byte("rate(http_requests_total[5m])")},
+	}}
+	m, s := newTestMattermost(t, MattermostOptions{}, testProcessors("ops", c))
+
+	m.processText(&MattermostMessageKey{channelID: "c1", postID: "origin"}, "u1", "@chatops ops graph")
+
+	posts, _, _ := s.recorded()
+	if len(posts) != 1 || strings.Join(posts[0].FileIDs, ",") != "f1" {
+		t.Fatalf("expected post with uploaded image, got %v", posts)
+	}
+	if strings.Join(s.uploads, ",") != "c1/latency.png" {
+		t.Fatalf("unexpected uploads %v", s.uploads)
+	}
+	b, _ := json.Marshal(posts[0].Props)
+	if !strings.Contains(string(b), `"title":"query"`) || strings.Contains(string(b), "latency.png") {
+		t.Fatalf("expected text attachment in props only, got %s", string(b))
+	}
+}
+
+func TestMattermostApproval(t *testing.T) {
+
+	c := &testCommand{name: "restart", text: "restarted", approval: &testApproval{message: "Restart api?"},
+		actions: []common.Action{&testAction{name: "logs", label: "Logs"}}}
+	m, s := newTestMattermost(t, MattermostOptions{ActionsURL: "http://chatops"}, testProcessors("ops", c))
+
+	m.processText(&MattermostMessageKey{channelID: "c1", postID: "origin"}, "u1", "ops restart")
+
+	posts, _, _ := s.recorded()
+	if len(posts) != 1 || posts[0].Message != "Restart api?" || posts[0].RootID != "origin" {
+		t.Fatalf("expected approval post, got %v", posts)
+	}
+	b, _ := json.Marshal(posts[0].Props)
+	if !strings.Contains(string(b), `"url":"http://chatops/actions"`) || !strings.Contains(string(b), `"name":"Approve"`) {
+		t.Fatalf("unexpected approval buttons %s", string(b))
+	}
+
+	text := testMattermostAction(t, m, posts[0], "u1", mattermostApprovalButtonType, mattermostApprovalSubmit)
+	executed, _ := c.calls()
+	if text != "Mattermost same user cannot approve its action" || len(executed) != 0 {
+		t.Fatalf("expected requester not to approve, got %q and %v", text, executed)
+	}
+
+	testMattermostAction(t, m, posts[0], "u2", mattermostApprovalButtonType, mattermostApprovalSubmit)
+	executed, _ = c.calls()
+	if len(executed) != 1 {
+		t.Fatalf("expected execution after approval, got %v", executed)
+	}
+
+	posts, patches, reactions := s.recorded()
+	b, _ = json.Marshal(patches)
+	if len(patches) != 1 || patches[0]["message"] != "Restart api?\n\n:white_check_mark: @user-u2" || strings.Contains(string(b), "Approve") {
+		t.Fatalf("expected approval post without buttons, got %s", string(b))
+	}
+	if len(posts) != 2 || posts[1].Message != "restarted" || posts[1].RootID != "origin" {
+		t.Fatalf("expected reply in origin thread, got %v", posts)
+	}
+	if strings.Join(reactions, ",") != "+eyes,+pray,-eyes,+eyes,-pray,+white_check_mark,-eyes" {
+		t.Fatalf("unexpected reactions %v", reactions)
+	}
+
+	// reply post keeps command actions, unknown ones are reported back to caller
+	text = testMattermostAction(t, m, posts[1], "u2", mattermostActionButtonType, "deploy")
+	if text != "Mattermost action deploy is not defined" {
+		t.Fatalf("unexpected action error %q", text)
+	}
+	testMattermostAction(t, m, posts[1], "u2", mattermostActionButtonType, "logs")
+	_, triggered := c.calls()
+	if strings.Join(triggered, ",") != "logs" {
+		t.Fatalf("unexpected actions %v", triggered)
+	}
+}
+
+func TestMattermostPermissions(t *testing.T) {
+
+	c := &testCommand{name: "restart", permissions: true, text: "restarted"}
+	m, s := newTestMattermost(t, MattermostOptions{UserPermissions: "user-u1=^ops/restart$"}, testProcessors("ops", c))
+
+	m.processText(&MattermostMessageKey{channelID: "c1", postID: "origin"}, "u2", "ops restart")
+	executed, _ := c.calls()
+	posts, _, _ := s.recorded()
+	if len(executed) != 0 || len(posts) != 0 {
+		t.Fatalf("expected u2 to be denied, got %v and %v", executed, posts)
+	}
+
+	// permissions match user name from API as well as ID
+	m.processText(&MattermostMessageKey{channelID: "c1", postID: "origin"}, "u1", "ops restart")
+	executed, _ = c.calls()
+	if len(executed) != 1 {
+		t.Fatalf("expected u1 to be permitted, got %v", executed)
+	}
+}
@@ -0,0 +1,862 @@
+package bot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	"github.com/jinzhu/copier"
+)
+
+type ShellOptions struct {
+	Prompt         string
+	User           string
+	Channel        string
+	DefaultCommand string
+	AttachmentsDir string
+
+	ReactionDoing    string
+	ReactionDone     string
+	ReactionFailed   string
+	ReactionApproval string
+}
+
+type ShellUser struct {
+	id       string
+	name     string
+	timezone string
+	commands []string
+}
+
+type ShellChannel struct {
+	id string
+}
+
+type ShellMessage struct {
+	shell     *Shell
+	id        string
+	parentID  string
+	channelID string
+	cmdText   string
+	cmd       common.Command
+	user      *ShellUser
+	caller    *ShellUser
+	visible   bool
+	text      string
+	actions   []common.Action
+	params    common.ExecuteParams
+	fields    []common.Field
+}
+
+type Shell struct {
+	options    ShellOptions
+	processors *common.Processors
+	logger     sreCommon.Logger
+	meter      sreCommon.Meter
+	in         *bufio.Scanner
+	out        io.Writer
+	mutex      *sync.Mutex
+	messages   map[string]*ShellMessage
+	last       *ShellMessage
+	counter    int
+	user       *ShellUser
+}
+
+const (
+	shellDateFormat = "2006-01-02"
+	shellTimeFormat = "15:04"
+	shellExit       = "exit"
+	shellQuit       = "quit"
+	shellYes        = "y"
+)
+
+// ShellUser
+
+func (su *ShellUser) ID() string {
+	return su.id
+}
+
+func (su *ShellUser) Name() string {
+	return su.name
+}
+
+func (su *ShellUser) TimeZone() string {
+	return su.timezone
+}
+
+func (su *ShellUser) Commands() []string {
+	return su.commands
+}
+
+// ShellChannel
+
+func (sc *ShellChannel) ID() string {
+	return sc.id
+}
+
+// ShellMessage
+
+func (sm *ShellMessage) ID() string {
+	return sm.id
+}
+
+func (sm *ShellMessage) Visible() bool {
+	return sm.visible
+}
+
+func (sm *ShellMessage) User() common.User {
+	return sm.user
+}
+
+func (sm *ShellMessage) Caller() common.User {
+	return sm.caller
+}
+
+func (sm *ShellMessage) Channel() common.Channel {
+	return &ShellChannel{id: sm.channelID}
+}
+
+func (sm *ShellMessage) ParentID() string {
+	return sm.parentID
+}
+
+func (sm *ShellMessage) SetParentID(parentID string) {
+	sm.parentID = parentID
+}
+
+// Shell
+
+func (s *Shell) Name() string {
+	return "Shell"
+}
+
+func (s *Shell) printf(format string, args ...interface{}) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := fmt.Fprintf(s.out, format, args...)
+	if err != nil {
+		s.logger.Error("Shell couldn't write: %s", err)
+	}
+}
+
+func (s *Shell) readLine(prompt string) (string, bool) {
+
+	s.printf("%s", prompt)
+	if !s.in.Scan() {
+		return "", false
+	}
+	return strings.TrimSpace(s.in.Text()), true
+}
+
+func (s *Shell) nextID() string {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.counter++
+	return strconv.Itoa(s.counter)
+}
+
+func (s *Shell) findMessage(ID string) *ShellMessage {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.messages[ID]
+}
+
+func (s *Shell) putMessage(m *ShellMessage) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.messages[m.id] = m
+	if len(m.actions) > 0 {
+		s.last = m
+	}
+}
+
+func (s *Shell) cloneMessage(m *ShellMessage) *ShellMessage {
+
+	if m == nil {
+		return nil
+	}
+	r := &ShellMessage{}
+	err := copier.Copy(r, m)
+	if err != nil {
+		s.logger.Error("Shell message copy error: %s", err)
+		return nil
+	}
+	return r
+}
+
+func (s *Shell) actionNames(actions []common.Action) []string {
+
+	r := []string{}
+	for _, a := range actions {
+		if !utils.IsEmpty(a.Name()) {
+			r = append(r, a.Name())
+		}
+	}
+	return r
+}
+
+func (s *Shell) saveAttachment(a *common.Attachment) (string, error) {
+
+	name := a.Title
+	if utils.IsEmpty(name) {
+		name = common.UUID()
+	}
+	path := filepath.Join(s.options.AttachmentsDir, filepath.Base(name))
+	err := os.WriteFile(path, a.Data, 0644)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (s *Shell) print(ID, parentID, message string, attachments []*common.Attachment, actions []common.Action) {
+
+	lines := []string{}
+
+	header := fmt.Sprintf("[%s]", ID)
+	if !utils.IsEmpty(parentID) {
+		header = fmt.Sprintf("[%s <- %s]", ID, parentID)
+	}
+	if !utils.IsEmpty(message) {
+		lines = append(lines, fmt.Sprintf("%s %s", header, message))
+	} else {
+		lines = append(lines, header)
+	}
+
+	for _, a := range attachments {
+
+		title := a.Title
+		if utils.IsEmpty(title) {
+			title = string(a.Type)
+		}
+
+		switch a.Type {
+		case common.AttachmentTypeImage, common.AttachmentTypeFile:
+			if utils.IsEmpty(s.options.AttachmentsDir) {
+				lines = append(lines, fmt.Sprintf("--- %s (%d bytes)", title, len(a.Data)))
+				continue
+			}
+			path, err := s.saveAttachment(a)
+			if err != nil {
+				lines = append(lines, fmt.Sprintf("--- %s couldn't be saved: %s", title, err))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("--- %s saved to %s", title, path))
+		default:
+			lines = append(lines, fmt.Sprintf("--- %s", title))
+			lines = append(lines, string(a.Data))
+		}
+	}
+
+	names := s.actionNames(actions)
+	if len(names) > 0 {
+		lines = append(lines, fmt.Sprintf("Actions: %s", strings.Join(names, ", ")))
+	}
+
+	s.printf("%s\n", strings.Join(lines, "\n"))
+}
+
+func (s *Shell) AddReaction(channel, ID, name string) error {
+
+	if utils.IsEmpty(name) {
+		return nil
+	}
+	s.printf("[%s] %s\n", ID, name)
+	return nil
+}
+
+func (s *Shell) RemoveReaction(channel, ID, name string) error {
+	return nil
+}
+
+func (s *Shell) updateActions(channel, ID string, update func(m *ShellMessage) []common.Action) error {
+
+	m := s.findMessage(ID)
+	if m == nil {
+		return fmt.Errorf("Shell message not found with %s", ID)
+	}
+
+	m.actions = update(m)
+	s.putMessage(m)
+
+	s.printf("[%s] Actions: %s\n", ID, strings.Join(s.actionNames(m.actions), ", "))
+	return nil
+}
+
+func (s *Shell) AddAction(channel, ID string, action common.Action) error {
+
+	return s.updateActions(channel, ID, func(m *ShellMessage) []common.Action {
+		return append(m.actions, action)
+	})
+}
+
+func (s *Shell) AddActions(channel, ID string, actions []common.Action) error {
+
+	return s.updateActions(channel, ID, func(m *ShellMessage) []common.Action {
+		return append(m.actions, actions...)
+	})
+}
+
+func (s *Shell) RemoveAction(channel, ID, name string) error {
+
+	return s.updateActions(channel, ID, func(m *ShellMessage) []common.Action {
+		actions := []common.Action{}
+		for _, a := range m.actions {
+			if a.Name() == name {
+				continue
+			}
+			actions = append(actions, a)
+		}
+		return actions
+	})
+}
+
+func (s *Shell) ClearActions(channel, ID string) error {
+
+	return s.updateActions(channel, ID, func(m *ShellMessage) []common.Action {
+		return nil
+	})
+}
+
+func (s *Shell) DeleteMessage(channel, ID string) error {
+
+	s.mutex.Lock()
+	delete(s.messages, ID)
+	s.mutex.Unlock()
+
+	s.printf("[%s] deleted\n", ID)
+	return nil
+}
+
+func (s *Shell) ReadMessage(channel, ID string) (string, error) {
+
+	m := s.findMessage(ID)
+	if m == nil {
+		return "", fmt.Errorf("Shell message not found with %s", ID)
+	}
+	return m.text, nil
+}
+
+func (s *Shell) UpdateMessage(channel, ID, message string) error {
+
+	m := s.findMessage(ID)
+	if m != nil {
+		m.text = message
+	}
+	s.printf("[%s] updated: %s\n", ID, message)
+	return nil
+}
+
+// /group command param1 => group command param1
+func (s *Shell) prepareInputText(text string) string {
+
+	text = strings.TrimPrefix(strings.TrimSpace(text), "/")
+
+	return s.processors.ReplaceAlias(text)
+}
+
+func (s *Shell) reply(m *ShellMessage, message string, attachments []*common.Attachment, actions []common.Action,
+	response *common.BotResponse, start *time.Time, error bool) string {
+
+	text := message
+	if !utils.IsEmpty(response) {
+
+		if response.Original() && !utils.IsEmpty(m.cmdText) {
+			text = fmt.Sprintf("> %s\n%s", m.cmdText, text)
+		}
+
+		if response.Duration() && start != nil && !error {
+			elapsed := time.Since(*start)
+			text = fmt.Sprintf("[%s] %s", elapsed.Round(time.Millisecond), text)
+		}
+	}
+
+	if error {
+		text = fmt.Sprintf("%s %s", s.options.ReactionFailed, text)
+		attachments = nil
+		actions = nil
+	}
+
+	ID := s.nextID()
+	s.print(ID, m.id, text, attachments, actions)
+	return ID
+}
+
+func (s *Shell) cachePostUserCommand(m *ShellMessage, params common.ExecuteParams, action common.Action, response common.Response, overwrite bool) error {
+
+	start := time.Now()
+	executor, message, attachments, actions, err := m.cmd.Execute(s, m, params, action)
+	if err != nil {
+		s.reply(m, err.Error(), nil, nil, nil, nil, true)
+		return err
+	}
+	if action == nil {
+		actions = common.MergeActions(actions, m.cmd.Actions())
+	}
+
+	r := common.BuildResponse(overwrite, response, executor.Response())
+
+	mNew := s.cloneMessage(m)
+	mNew.parentID = m.id
+	mNew.visible = r.Visible()
+	mNew.actions = actions
+	mNew.params = params
+
+	if !utils.IsEmpty(message) || len(attachments) > 0 {
+		mNew.id = s.reply(m, message, attachments, actions, r, &start, r.Error())
+		mNew.text = message
+		s.putMessage(mNew)
+	}
+	return executor.After(mNew)
+}
+
+func (s *Shell) approve(m *ShellMessage, params common.ExecuteParams) bool {
+
+	approval := m.cmd.Approval()
+	if approval == nil {
+		return true
+	}
+
+	message := strings.TrimSpace(approval.Message(s, m, params))
+	if utils.IsEmpty(message) {
+		return true
+	}
+
+	s.AddReaction(m.channelID, m.id, s.options.ReactionApproval)
+	answer, ok := s.readLine(fmt.Sprintf("%s [%s/N]: ", message, shellYes))
+	return ok && strings.EqualFold(answer, shellYes)
+}
+
+func (s *Shell) executeCommand(m *ShellMessage, params common.ExecuteParams, action common.Action) {
+
+	s.AddReaction(m.channelID, m.id, s.options.ReactionDoing)
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	err := s.cachePostUserCommand(m, params, action, r, action != nil)
+	if err != nil {
+		s.logger.Error("Shell couldn't execute %s: %s", m.cmdText, err)
+		s.AddReaction(m.channelID, m.id, s.options.ReactionFailed)
+		return
+	}
+	s.AddReaction(m.channelID, m.id, s.options.ReactionDone)
+}
+
+// Fields
+
+func (s *Shell) fieldLabel(field common.Field) string {
+
+	if !utils.IsEmpty(field.Label) {
+		return field.Label
+	}
+	return field.Name
+}
+
+func (s *Shell) fieldValues(field common.Field) []string {
+
+	if field.Type == common.FieldTypeBool {
+		return []string{fmt.Sprintf("%v", true), fmt.Sprintf("%v", false)}
+	}
+	return field.Values
+}
+
+func (s *Shell) fieldMultiple(field common.Field) bool {
+
+	switch field.Type {
+	case common.FieldTypeMultiSelect, common.FieldTypeDynamicMultiSelect, common.FieldTypeCheckboxes:
+		return true
+	}
+	return false
+}
+
+// values are chosen by their number or by the value itself
+func (s *Shell) fieldValueFromList(values []string, text string) (string, error) {
+
+	v := strings.TrimSpace(text)
+	if idx, err := strconv.Atoi(v); err == nil && idx > 0 && idx <= len(values) {
+		return values[idx-1], nil
+	}
+	if utils.Contains(values, v) {
+		return v, nil
+	}
+	return "", fmt.Errorf("%s is not one of %s", v, strings.Join(values, ", "))
+}
+
+// check input against the field type, as Slack does with its typed inputs
+func (s *Shell) fieldValueFromText(field common.Field, text string) (interface{}, error) {
+
+	v := strings.TrimSpace(text)
+
+	values := s.fieldValues(field)
+	if len(values) > 0 {
+
+		if !s.fieldMultiple(field) {
+			return s.fieldValueFromList(values, v)
+		}
+
+		selected := []string{}
+		for _, item := range common.RemoveEmptyStrings(strings.Split(v, ",")) {
+			sv, err := s.fieldValueFromList(values, item)
+			if err != nil {
+				return nil, err
+			}
+			selected = append(selected, sv)
+		}
+		if field.Type == common.FieldTypeCheckboxes {
+			return strings.Join(selected, ","), nil
+		}
+		return selected, nil
+	}
+
+	switch field.Type {
+	case common.FieldTypeInteger:
+		if _, err := strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("%s is not an integer", v)
+		}
+	case common.FieldTypeFloat:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("%s is not a number", v)
+		}
+	case common.FieldTypeDate:
+		if _, err := time.Parse(shellDateFormat, v); err != nil {
+			return nil, fmt.Errorf("%s is not a date in format %s", v, shellDateFormat)
+		}
+	case common.FieldTypeTime:
+		if _, err := time.Parse(shellTimeFormat, v); err != nil {
+			return nil, fmt.Errorf("%s is not a time in format %s", v, shellTimeFormat)
+		}
+	case common.FieldTypeURL:
+		u, err := url.ParseRequestURI(v)
+		if err != nil || utils.IsEmpty(u.Scheme) || utils.IsEmpty(u.Host) {
+			return nil, fmt.Errorf("%s is not a URL", v)
+		}
+	case common.FieldTypeMultiUser, common.FieldTypeMultiChannel, common.FieldTypeMultiGroup:
+		return common.RemoveEmptyStrings(strings.Split(v, ",")), nil
+	}
+	return v, nil
+}
+
+func (s *Shell) fieldPrompt(field common.Field) string {
+
+	lines := []string{}
+	if !utils.IsEmpty(field.Hint) {
+		lines = append(lines, field.Hint)
+	}
+	for i, v := range s.fieldValues(field) {
+		lines = append(lines, fmt.Sprintf("  %d. %s", i+1, v))
+	}
+
+	prompt := s.fieldLabel(field)
+	if field.Required {
+		prompt = fmt.Sprintf("%s*", prompt)
+	}
+	if !utils.IsEmpty(field.Default) {
+		prompt = fmt.Sprintf("%s [%s]", prompt, field.Default)
+	}
+	lines = append(lines, fmt.Sprintf("%s: ", prompt))
+	return strings.Join(lines, "\n")
+}
+
+// update fields which depend on the changed one, like Slack.handleFormField does
+func (s *Shell) updateFieldDependencies(m *ShellMessage, fields []common.Field, params common.ExecuteParams, name string) {
+
+	deps := []string{}
+	for _, f := range fields {
+		if utils.Contains(f.Dependencies, name) {
+			deps = append(deps, f.Name)
+		}
+	}
+	if len(deps) == 0 {
+		return
+	}
+
+	depFields := m.cmd.Fields(s, m, params, deps)
+	for _, df := range depFields {
+		for i, f := range fields {
+			if f.Name == df.Name && utils.Contains(deps, df.Name) {
+				fields[i] = df
+			}
+		}
+	}
+}
+
+// ask all fields which are not passed by params, empty input keeps the default
+func (s *Shell) askFields(m *ShellMessage, fields []common.Field, params common.ExecuteParams) (common.ExecuteParams, bool) {
+
+	for i := 0; i < len(fields); i++ {
+
+		f := fields[i]
+		if f.Type == common.FieldTypeMarkdown {
+			if !utils.IsEmpty(f.Default) {
+				s.printf("%s\n", f.Default)
+			}
+			continue
+		}
+		if !utils.IsEmpty(params[f.Name]) {
+			continue
+		}
+
+		for {
+			text, ok := s.readLine(s.fieldPrompt(f))
+			if !ok {
+				return params, false
+			}
+			if utils.IsEmpty(text) {
+				text = f.Default
+			}
+			if utils.IsEmpty(text) {
+				if f.Required {
+					s.printf("%s is required\n", s.fieldLabel(f))
+					continue
+				}
+				break
+			}
+
+			v, err := s.fieldValueFromText(f, text)
+			if err != nil {
+				s.printf("%s\n", err)
+				continue
+			}
+			params[f.Name] = v
+			s.updateFieldDependencies(m, fields, params, f.Name)
+			break
+		}
+	}
+
+	params = common.FieldValues(fields, params)
+	return params, true
+}
+
+// REPL
+
+func (s *Shell) processAction(text string) bool {
+
+	s.mutex.Lock()
+	m := s.last
+	s.mutex.Unlock()
+
+	if m == nil || m.cmd == nil {
+		return false
+	}
+
+	var action common.Action
+	for _, a := range m.actions {
+		if a.Name() == text {
+			action = a
+			break
+		}
+	}
+	if action == nil {
+		return false
+	}
+
+	mAction := s.cloneMessage(m)
+	mAction.caller = s.user
+	mAction.cmdText = ""
+	s.executeCommand(mAction, m.params, action)
+	return true
+}
+
+func (s *Shell) processText(text string) {
+
+	fText := s.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := s.processors.FindParams(false, fText)
+
+	if cmd == nil && !utils.IsEmpty(s.options.DefaultCommand) {
+		cmd = s.processors.FindCommand("", s.options.DefaultCommand)
+		group = ""
+		params = make(common.ExecuteParams)
+	}
+
+	if cmd == nil {
+		params, cmd = s.processors.FindSuggest(fText)
+		group = ""
+	}
+
+	if cmd == nil {
+		s.printf("Command not found: %s\n", text)
+		common.UpdateCounters(s.meter, "shell", "", "", fText, s.user.id)
+		return
+	}
+
+	common.UpdateCounters(s.meter, "shell", group, cmd.Name(), fText, s.user.id)
+
+	m := &ShellMessage{
+		shell:     s,
+		id:        s.nextID(),
+		channelID: s.options.Channel,
+		cmdText:   fText,
+		cmd:       cmd,
+		user:      s.user,
+		caller:    s.user,
+		visible:   true,
+		text:      text,
+	}
+
+	// args couldn't be parsed, so usage is replied before fields are asked
+	if pErr != nil {
+		s.putMessage(m)
+		s.reply(m, pErr.Error(), nil, nil, nil, nil, true)
+		return
+	}
+
+	list := []string{common.FieldTypeSelect, common.FieldTypeMultiSelect, common.FieldTypeEdit}
+	only := common.FieldsByType(s, cmd, list)
+
+	fields := cmd.Fields(s, m, params, only)
+	params, ok := s.askFields(m, fields, params)
+	if !ok {
+		return
+	}
+	m.fields = fields
+	m.params = params
+	s.putMessage(m)
+
+	if !s.approve(m, params) {
+		s.AddReaction(m.channelID, m.id, s.options.ReactionFailed)
+		return
+	}
+	s.executeCommand(m, params, nil)
+}
+
+func (s *Shell) Command(channel, text string, user common.User, parent common.Message, response common.Response) error {
+
+	fText := s.prepareInputText(text)
+	params, cmd, _, _, _, _, pErr := s.processors.FindParams(false, fText)
+	if cmd == nil {
+		s.logger.Debug("Shell command not found for text: %s", text)
+		return nil
+	}
+	if pErr != nil {
+		return pErr
+	}
+
+	fields := cmd.Fields(s, parent, params, nil)
+	for _, f := range fields {
+		if f.Required && utils.IsEmpty(params[f.Name]) {
+			s.logger.Debug("Shell command %s has no support for interaction mode", cmd.Name())
+			return nil
+		}
+	}
+
+	m := &ShellMessage{
+		shell:     s,
+		channelID: channel,
+		user:      s.user,
+		caller:    s.user,
+	}
+	if sm, ok := parent.(*ShellMessage); ok && sm != nil {
+		m = s.cloneMessage(sm)
+		m.parentID = sm.id
+	}
+	m.id = s.nextID()
+	m.cmdText = fText
+	m.cmd = cmd
+	m.fields = fields
+	m.params = params
+
+	r := common.BuildResponse(true, response)
+	return s.cachePostUserCommand(m, params, nil, r, true)
+}
+
+func (s *Shell) PostMessage(channel string, message string, attachments []*common.Attachment, actions []common.Action,
+	user common.User, parent common.Message, response common.Response) (string, error) {
+
+	r := common.BuildResponse(true, response)
+
+	m := &ShellMessage{
+		shell:     s,
+		channelID: channel,
+		user:      s.user,
+		caller:    s.user,
+	}
+	if sm, ok := parent.(*ShellMessage); ok && sm != nil {
+		m = s.cloneMessage(sm)
+		m.parentID = sm.id
+	}
+	m.id = s.nextID()
+	m.visible = r.Visible()
+	m.text = message
+	m.actions = actions
+	s.putMessage(m)
+
+	s.print(m.id, m.parentID, message, attachments, actions)
+	return m.id, nil
+}
+
+func (s *Shell) start() {
+
+	s.printf("Type a command, an action name of the last message or %s\n", shellExit)
+
+	for {
+		text, ok := s.readLine(s.options.Prompt)
+		if !ok {
+			return
+		}
+		if utils.IsEmpty(text) {
+			continue
+		}
+		if text == shellExit || text == shellQuit {
+			return
+		}
+		if s.processAction(text) {
+			continue
+		}
+		s.processText(text)
+	}
+}
+
+func (s *Shell) Start(wg *sync.WaitGroup) {
+
+	if wg == nil {
+		s.start()
+		return
+	}
+
+	wg.Add(1)
+
+	go func(wg *sync.WaitGroup) {
+
+		defer wg.Done()
+		s.start()
+	}(wg)
+}
+
+func NewShell(options ShellOptions, observability *common.Observability, processors *common.Processors, in io.Reader, out io.Writer) *Shell {
+
+	s := &Shell{
+		options:    options,
+		processors: processors,
+		logger:     observability.Logs(),
+		meter:      observability.Metrics(),
+		in:         bufio.NewScanner(in),
+		out:        out,
+		mutex:      &sync.Mutex{},
+		messages:   make(map[string]*ShellMessage),
+	}
+	s.user = &ShellUser{
+		id:   options.User,
+		name: options.User,
+	}
+	s.user.commands = processors.ListCommands(func(groupName string) bool {
+		return false
+	})
+	return s
+}
@@ -21,6 +21,7 @@ import (
 )
 
 type SlackOptions struct {
+	Name             string
 	BotToken         string
 	AppToken         string
 	Debug            bool
@@ -138,8 +139,13 @@ type Slack struct {
 	meter             sreCommon.Meter
 	defaultDefinition *slacker.CommandDefinition
 	helpDefinition    *slacker.CommandDefinition
+	suggestDefinition *slacker.CommandDefinition
 	messages          *ttlcache.Cache[string, *SlackMessage]
 	userGroups        SlackUserGroups
+	userGroupsStop    chan bool
+	cancel            context.CancelFunc
+	reloading         bool
+	mutex             sync.Mutex
 }
 
 type SlackRichTextQuoteElement struct {
@@ -350,7 +356,10 @@ func (smk *SlackMessageKey) String() string {
 // Slack
 
 func (s *Slack) Name() string {
-	return "Slack"
+	if utils.IsEmpty(s.options.Name) {
+		return "Slack"
+	}
+	return fmt.Sprintf("Slack/%s", s.options.Name)
 }
 
 func (s *Slack) getNewKey(channelID string, key *SlackMessageKey) *SlackMessageKey {
@@ -996,7 +1005,7 @@ func (s *Slack) denyGroupAccess(userID, command string, groups []slack.UserGroup
 			return true
 		}
 
-		mCommand := reCommand.MatchString(command)
+		mCommand := common.MatchCommand(reCommand, command)
 		if !mCommand {
 			continue
 		}
@@ -1040,7 +1049,7 @@ func (s *Slack) denyUserAccess(userID, userName string, command string) bool {
 			return true
 		}
 
-		mCommand := reCommand.MatchString(command)
+		mCommand := common.MatchCommand(reCommand, command)
 		if !mCommand {
 			continue
 		}
@@ -1089,180 +1098,6 @@ func (s *Slack) listUserCommands(userID string, groups []slack.UserGroup) []stri
 	return commands
 }
 
-func (s *Slack) matchParam(text, param string) (map[string]string, []string) {
-
-	r := make(map[string]string)
-	re := regexp.MustCompile(param)
-	match := re.FindStringSubmatch(text)
-	if len(match) == 0 {
-		return r, []string{}
-	}
-
-	names := re.SubexpNames()
-	for i, name := range names {
-		if i != 0 && name != "" {
-			r[name] = match[i]
-		}
-	}
-	return r, names
-}
-
-func (s *Slack) findParams(wrapper bool, text string) (common.ExecuteParams, common.Command, string, common.ExecuteParams, common.Command, string) {
-
-	ep := make(common.ExecuteParams)
-	wp := make(common.ExecuteParams)
-
-	// group command param1 param2
-	// command param1 param2
-
-	// find group, command, params
-
-	delim := " "
-	arr := strings.Split(text, delim)
-
-	if len(arr) == 0 {
-		return ep, nil, "", wp, nil, ""
-	}
-
-	if !wrapper {
-
-		egr := ""
-		ec := ""
-		eps := ""
-
-		if len(arr) > 0 {
-			egr = strings.TrimSpace(arr[0])
-		}
-		if len(arr) > 1 {
-			ec = strings.TrimSpace(arr[1])
-		}
-		if len(arr) > 2 {
-			eps = strings.TrimSpace(strings.Join(arr[2:], delim))
-		}
-
-		ecm := s.processors.FindCommand(egr, ec)
-		if ecm == nil {
-			if len(arr) > 1 {
-				eps = strings.TrimSpace(strings.Join(arr[1:], delim))
-			}
-			ec = egr
-			egr = ""
-			ecm = s.processors.FindCommand(egr, ec)
-		}
-
-		if ecm == nil {
-			return ep, nil, "", wp, nil, ""
-		}
-
-		if !utils.IsEmpty(eps) {
-			for _, p := range ecm.Params() {
-
-				values, _ := s.matchParam(eps, p)
-				for k, v := range values {
-					ep[k] = v
-				}
-				if len(ep) > 0 {
-					break
-				}
-			}
-		}
-		return ep, ecm, egr, wp, nil, ""
-	}
-
-	// wrappergroup wrapper group command param1 param2
-	// wrappergroup wrapper command param1 param2
-	// wrapper command param1 param2
-
-	// find wrapper group, command, params
-
-	egr := ""
-	ec := ""
-	eps := ""
-
-	if len(arr) > 0 {
-		egr = strings.TrimSpace(arr[0])
-	}
-	if len(arr) > 1 {
-		ec = strings.TrimSpace(arr[1])
-	}
-	if len(arr) > 2 {
-		eps = strings.TrimSpace(strings.Join(arr[2:], delim))
-	}
-
-	ecm := s.processors.FindCommand(egr, ec)
-	if ecm == nil {
-		if len(arr) > 1 {
-			eps = strings.TrimSpace(strings.Join(arr[1:], delim))
-		}
-		ec = egr
-		egr = ""
-		ecm = s.processors.FindCommand(egr, ec)
-	}
-
-	if ecm == nil {
-		return ep, ecm, egr, wp, nil, ""
-	}
-
-	// find wrapped group, command, params
-
-	arr = strings.Split(eps, delim)
-	wgr := ""
-	wc := ""
-	wps := ""
-
-	if len(arr) > 0 {
-		wgr = strings.TrimSpace(arr[0])
-	}
-	if len(arr) > 1 {
-		wc = strings.TrimSpace(arr[1])
-	}
-	if len(arr) > 2 {
-		wps = strings.TrimSpace(strings.Join(arr[2:], delim))
-	}
-
-	wcm := s.processors.FindCommand(wgr, wc)
-	if wcm == nil {
-		if len(arr) > 1 {
-			wps = strings.TrimSpace(strings.Join(arr[1:], delim))
-		}
-		wc = wgr
-		wgr = ""
-		eps = wc
-		wcm = s.processors.FindCommand(wgr, wc)
-	}
-
-	if wcm == nil {
-		return ep, ecm, egr, wp, nil, ""
-	}
-
-	if !utils.IsEmpty(wps) {
-		for _, p := range wcm.Params() {
-
-			values, _ := s.matchParam(wps, p)
-			for k, v := range values {
-				wp[k] = v
-			}
-			if len(wp) > 0 {
-				break
-			}
-		}
-	}
-
-	if !utils.IsEmpty(eps) {
-		for _, p := range ecm.Params() {
-
-			values, _ := s.matchParam(eps, p)
-			for k, v := range values {
-				ep[k] = v
-			}
-			if len(ep) > 0 {
-				break
-			}
-		}
-	}
-	return ep, ecm, egr, wp, wcm, wgr
-}
-
 func (s *Slack) updateCounters(group, command, text, userID string) {
 
 	labels := make(map[string]string)
@@ -1273,7 +1108,7 @@ func (s *Slack) updateCounters(group, command, text, userID string) {
 		labels["command"] = command
 	}
 	if !utils.IsEmpty(text) {
-		labels["text"] = text
+		labels["text"] = common.LabelValue(text)
 	}
 	labels["user_id"] = userID
 
@@ -1368,6 +1203,15 @@ func (s *Slack) unsupportedCommandHandler(cc *slacker.CommandContext) {
 		s.defaultDefinition.Handler(cc)
 		return
 	}
+
+	// suggest only for unknown text, not permitted commands get here too
+	if s.suggestDefinition != nil {
+		_, cmd, _, _, _, _, _ := s.processors.FindParams(false, s.prepareInputText(cc.Event().Text, cc.Event().Type))
+		if cmd == nil {
+			s.suggestDefinition.Handler(cc)
+			return
+		}
+	}
 	s.updateCounters("", "", text, cc.Event().UserID)
 }
 
@@ -2124,39 +1968,6 @@ func (s *Slack) cacheAskApproval(m *SlackMessage, message, channel string,
 	return nil
 }
 
-func (s *Slack) mergeActions(one []common.Action, two []common.Action) []common.Action {
-
-	actions := []common.Action{}
-
-	for _, a1 := range one {
-		found := false
-		for _, a2 := range two {
-			if a1.Name() == a2.Name() {
-				found = true
-				break
-			}
-		}
-		if !found {
-			actions = append(actions, a1)
-		}
-	}
-
-	for _, a2 := range two {
-		found := false
-		for _, a1 := range actions {
-			if a2.Name() == a1.Name() {
-				found = true
-				break
-			}
-		}
-		if !found {
-			actions = append(actions, a2)
-		}
-	}
-
-	return actions
-}
-
 func (s *Slack) buildResponse(overwrite bool, list ...common.Response) *SlackResponse {
 
 	r := &SlackResponse{}
@@ -2258,7 +2069,7 @@ func (s *Slack) cachePostUserCommand(m *SlackMessage, callback *slack.Interactio
 		return err
 	}
 	if action == nil {
-		actions = s.mergeActions(actions, m.cmd.Actions())
+		actions = common.MergeActions(actions, m.cmd.Actions())
 	}
 
 	r := s.buildResponse(overwrite, response, executor.Response())
@@ -2490,10 +2301,13 @@ func (s *Slack) commandDefinition(cmd common.Command, group string) *slacker.Com
 		text := s.prepareInputText(event.Text, event.Type)
 
 		wrapper := cmd.Wrapper()
-		eParams, eCmd, eGroup, wrappedParams, wrappedCmd, wrappedGroup := s.findParams(wrapper, text)
+		eParams, eCmd, eGroup, wrappedParams, wrappedCmd, wrappedGroup, pErr := s.processors.FindParams(wrapper, text)
 		if eCmd == nil {
 			eCmd = cmd
 			eGroup = group
+			if def == s.suggestDefinition {
+				eParams, _ = s.processors.FindSuggest(text)
+			}
 		}
 
 		if wrappedCmd != nil {
@@ -2582,6 +2396,13 @@ func (s *Slack) commandDefinition(cmd common.Command, group string) *slacker.Com
 			approvalParams = rParams
 		}
 
+		// args couldn't be parsed, so usage is replied before form or approval
+		if pErr != nil {
+			s.replyError(m, replier, pErr, "", nil, nil)
+			s.addRemoveReactions(m.typ, m.key, s.options.ReactionFailed, s.options.ReactionDoing)
+			return
+		}
+
 		m.fields = rFields
 		m.params = rParams
 		s.putMessageToCache(m)
@@ -2713,7 +2534,7 @@ func (s *Slack) Command(channel, text string, user common.User, parent common.Me
 	}
 
 	fText := s.prepareInputText(text, slackMessageType)
-	params, cmd, group, _, _, _ := s.findParams(false, fText)
+	params, cmd, group, _, _, _, pErr := s.processors.FindParams(false, fText)
 	if cmd == nil {
 		s.logger.Debug("Slack command not found for text: %s", text)
 		return nil
@@ -2732,6 +2553,10 @@ func (s *Slack) Command(channel, text string, user common.User, parent common.Me
 		}
 	}
 
+	if pErr != nil {
+		return pErr
+	}
+
 	fields := cmd.Fields(s, parent, params, nil)
 	if s.formNeeded(fields, params) {
 		s.logger.Debug("Slack command %s has no support for interaction mode", groupName)
@@ -3606,6 +3431,7 @@ func (s *Slack) start() {
 
 	s.defaultDefinition = nil
 	s.helpDefinition = nil
+	s.suggestDefinition = nil
 
 	items := s.processors.Items()
 
@@ -3647,7 +3473,8 @@ func (s *Slack) start() {
 		if utils.IsEmpty(pName) {
 			continue
 		}
-		group = client.AddCommandGroup(pName)
+		// nested groups are typed as words
+		group = client.AddCommandGroup(strings.ReplaceAll(pName, "/", " "))
 
 		sort.Slice(commands, func(i, j int) bool {
 			return commands[i].Priority() < commands[j].Priority()
@@ -3697,6 +3524,9 @@ func (s *Slack) start() {
 					s.helpDefinition = def
 					client.Help(def)
 				}
+				if _, ok := c.(common.SuggestCommand); ok {
+					s.suggestDefinition = def
+				}
 				groupRoot.AddCommand(def)
 				if len(c.Fields(s, nil, nil, nil)) > 0 {
 					client.AddInteraction(s.newInteraction(c.Name(), ""))
@@ -3733,25 +3563,65 @@ func (s *Slack) start() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// reload could be asked while commands were registered
+	s.mutex.Lock()
 	s.ctx = ctx
+	s.cancel = cancel
+	if s.reloading {
+		cancel()
+	}
+	s.mutex.Unlock()
 
 	s.userGroups.slack = s
-	s.userGroups.refresh()
-	if s.options.UserGroupsInterval > 0 {
-		common.Schedule(s.userGroups.refresh, time.Duration(s.options.UserGroupsInterval)*time.Second)
+	if s.userGroupsStop == nil {
+		s.userGroups.refresh()
+		if s.options.UserGroupsInterval > 0 {
+			s.userGroupsStop = common.Schedule(s.userGroups.refresh, time.Duration(s.options.UserGroupsInterval)*time.Second)
+		}
 	}
 
 	err = client.Listen(ctx)
-	if err != nil {
+	if err != nil && ctx.Err() == nil {
 		s.logger.Error("Slack listen error: %s", err)
 		return
 	}
 }
 
+// run starts client again while it's stopped by reload
+func (s *Slack) run() {
+
+	for {
+		s.start()
+
+		s.mutex.Lock()
+		reloading := s.reloading
+		s.reloading = false
+		s.mutex.Unlock()
+
+		if !reloading {
+			return
+		}
+		s.logger.Info("Slack is reloading commands...")
+	}
+}
+
+// Reload stops client, so commands, interactions and jobs are registered from processors again
+func (s *Slack) Reload() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.reloading = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
 func (t *Slack) Start(wg *sync.WaitGroup) {
 
 	if wg == nil {
-		t.start()
+		t.run()
 		return
 	}
 
@@ -3760,7 +3630,7 @@ func (t *Slack) Start(wg *sync.WaitGroup) {
 	go func(wg *sync.WaitGroup) {
 
 		defer wg.Done()
-		t.start()
+		t.run()
 	}(wg)
 }
 
@@ -0,0 +1,1626 @@
+package bot
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/jinzhu/copier"
+)
+
+type TeamsOptions struct {
+	AppID           string
+	AppPassword     string
+	TenantID        string
+	Listen          string
+	Path            string
+	ServiceURL      string
+	OpenIDURL       string
+	Timeout         int
+	Insecure        bool
+	Debug           bool
+	DefaultCommand  string
+	UserPermissions string
+	ApprovalAny     bool
+
+	ButtonSubmitCaption  string
+	ButtonCancelCaption  string
+	ButtonApproveCaption string
+	ButtonRejectCaption  string
+
+	FormCancelled string
+	CacheTTL      string
+}
+
+type TeamsMessageKey struct {
+	conversationID string
+	activityID     string
+	replyToID      string
+}
+
+type TeamsUser struct {
+	id       string
+	name     string
+	objectID string
+	timezone string
+	commands []string
+}
+
+type TeamsChannel struct {
+	id string
+}
+
+type TeamsMessage struct {
+	teams     *Teams
+	cmdText   string
+	cmd       common.Command
+	originKey *TeamsMessageKey
+	key       *TeamsMessageKey
+	user      *TeamsUser
+	caller    *TeamsUser
+	visible   bool
+	text      string
+	actions   []common.Action
+	params    common.ExecuteParams
+	fields    []common.Field
+}
+
+type TeamsAccount struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	AadObjectID string `json:"aadObjectId,omitempty"`
+}
+
+type TeamsConversation struct {
+	ID               string `json:"id"`
+	IsGroup          bool   `json:"isGroup,omitempty"`
+	ConversationType string `json:"conversationType,omitempty"`
+	TenantID         string `json:"tenantId,omitempty"`
+}
+
+type TeamsAttachment struct {
+	ContentType string      `json:"contentType"`
+	ContentURL  string      `json:"contentUrl,omitempty"`
+	Content     interface{} `json:"content,omitempty"`
+	Name        string      `json:"name,omitempty"`
+}
+
+type TeamsActivity struct {
+	Type          string                 `json:"type"`
+	ID            string                 `json:"id,omitempty"`
+	Timestamp     string                 `json:"timestamp,omitempty"`
+	LocalTimezone string                 `json:"localTimezone,omitempty"`
+	ServiceURL    string                 `json:"serviceUrl,omitempty"`
+	ChannelID     string                 `json:"channelId,omitempty"`
+	From          *TeamsAccount          `json:"from,omitempty"`
+	Recipient     *TeamsAccount          `json:"recipient,omitempty"`
+	Conversation  *TeamsConversation     `json:"conversation,omitempty"`
+	ReplyToID     string                 `json:"replyToId,omitempty"`
+	Text          string                 `json:"text,omitempty"`
+	TextFormat    string                 `json:"textFormat,omitempty"`
+	Attachments   []*TeamsAttachment     `json:"attachments,omitempty"`
+	Value         map[string]interface{} `json:"value,omitempty"`
+}
+
+type TeamsResourceResponse struct {
+	ID string `json:"id"`
+}
+
+type TeamsToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type TeamsOpenIDConfiguration struct {
+	JwksURI string `json:"jwks_uri"`
+}
+
+type TeamsJWTClaims struct {
+	ServiceURL string `json:"serviceurl"`
+}
+
+type Teams struct {
+	options       TeamsOptions
+	processors    *common.Processors
+	client        *http.Client
+	logger        sreCommon.Logger
+	meter         sreCommon.Meter
+	messages      *ttlcache.Cache[string, *TeamsMessage]
+	conversations *ttlcache.Cache[string, string]
+
+	tokenMutex   sync.Mutex
+	token        string
+	tokenExpires time.Time
+
+	verifier *common.JWTVerifier
+}
+
+const (
+	teamsActivityMessage       = "message"
+	teamsActivityTyping        = "typing"
+	teamsTextFormatMarkdown    = "markdown"
+	teamsContentTypeCard       = "application/vnd.microsoft.card.adaptive"
+	teamsCardSchema            = "http://adaptivecards.io/schemas/adaptive-card.json"
+	teamsCardVersion           = "1.4"
+	teamsTokenURL              = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+	teamsTokenScope            = "https://api.botframework.com/.default"
+	teamsTokenTenant           = "botframework.com"
+	teamsTokenIssuer           = "https://api.botframework.com"
+	teamsMaxTextLength         = 28000
+	teamsTrimmed               = "...trimmed"
+	teamsDataType              = "chatops_type"
+	teamsDataName              = "chatops_name"
+	teamsActionButtonType      = "a"
+	teamsApprovalButtonType    = "p"
+	teamsFormButtonType        = "f"
+	teamsApprovalSubmit        = "approve"
+	teamsApprovalCancel        = "reject"
+	teamsFormSubmit            = "submit"
+	teamsFormCancel            = "cancel"
+	teamsTokenExpiresThreshold = 60
+)
+
+var teamsMentionRegex = regexp.MustCompile(`<at>[^<]*</at>`)
+var teamsTagRegex = regexp.MustCompile(`<[^>]+>`)
+
+// TeamsUser
+
+func (tu *TeamsUser) ID() string {
+	return tu.id
+}
+
+func (tu *TeamsUser) Name() string {
+	return tu.name
+}
+
+func (tu *TeamsUser) TimeZone() string {
+	return tu.timezone
+}
+
+func (tu *TeamsUser) Commands() []string {
+	return tu.commands
+}
+
+// TeamsChannel
+
+func (tc *TeamsChannel) ID() string {
+	return tc.id
+}
+
+// TeamsMessage
+
+func (tm *TeamsMessage) ID() string {
+	if tm.key == nil {
+		return ""
+	}
+	return tm.key.activityID
+}
+
+func (tm *TeamsMessage) Visible() bool {
+	return tm.visible
+}
+
+func (tm *TeamsMessage) User() common.User {
+	return tm.user
+}
+
+func (tm *TeamsMessage) Caller() common.User {
+	return tm.caller
+}
+
+func (tm *TeamsMessage) userID() string {
+	u := tm.user
+	if u == nil {
+		return ""
+	}
+	return u.id
+}
+
+func (tm *TeamsMessage) Channel() common.Channel {
+	if tm.key == nil {
+		return nil
+	}
+	return &TeamsChannel{id: tm.key.conversationID}
+}
+
+func (tm *TeamsMessage) ParentID() string {
+	if tm.key == nil {
+		return ""
+	}
+	return tm.key.replyToID
+}
+
+func (tm *TeamsMessage) SetParentID(replyToID string) {
+	if tm.key == nil {
+		return
+	}
+	tm.key.replyToID = replyToID
+}
+
+// TeamsMessageKey
+
+func (tmk *TeamsMessageKey) String() string {
+	return fmt.Sprintf("%s/%s", tmk.conversationID, tmk.activityID)
+}
+
+// Teams
+
+func (t *Teams) Name() string {
+	return "Teams"
+}
+
+func (t *Teams) findMessageInCache(key *TeamsMessageKey) *TeamsMessage {
+
+	if key == nil {
+		return nil
+	}
+	item := t.messages.Get(key.String())
+	if item != nil {
+		return item.Value()
+	}
+	return nil
+}
+
+func (t *Teams) putMessageToCache(m *TeamsMessage) {
+
+	if m.key == nil || utils.IsEmpty(m.key.activityID) {
+		return
+	}
+	t.messages.Set(m.key.String(), m, ttlcache.DefaultTTL)
+}
+
+func (t *Teams) cloneMessage(m *TeamsMessage) *TeamsMessage {
+
+	if m == nil {
+		return nil
+	}
+	r := &TeamsMessage{}
+	err := copier.Copy(r, m)
+	if err != nil {
+		t.logger.Error("Teams message copy error: %s", err)
+		return nil
+	}
+	return r
+}
+
+// Bot Framework authentication
+
+func (t *Teams) getToken() (string, error) {
+
+	t.tokenMutex.Lock()
+	defer t.tokenMutex.Unlock()
+
+	if !utils.IsEmpty(t.token) && time.Now().Before(t.tokenExpires) {
+		return t.token, nil
+	}
+
+	tenant := t.options.TenantID
+	if utils.IsEmpty(tenant) {
+		tenant = teamsTokenTenant
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", t.options.AppID)
+	form.Set("client_secret", t.options.AppPassword)
+	form.Set("scope", teamsTokenScope)
+
+	headers := make(map[string]string)
+	headers["Content-Type"] = "application/x-www-form-urlencoded"
+
+	b, err := utils.HttpRequestRawWithHeaders(t.client, http.MethodPost, fmt.Sprintf(teamsTokenURL, tenant), headers, []byte(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("Teams token error: %s %s", err, string(b))
+	}
+
+	token := &TeamsToken{}
+	err = json.Unmarshal(b, token)
+	if err != nil {
+		return "", err
+	}
+
+	t.token = token.AccessToken
+	t.tokenExpires = time.Now().Add(time.Duration(token.ExpiresIn-teamsTokenExpiresThreshold) * time.Second)
+	return t.token, nil
+}
+
+func (t *Teams) getJSON(URL string, obj interface{}) error {
+
+	b, err := utils.HttpRequestRawWithHeaders(t.client, http.MethodGet, URL, nil, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, obj)
+}
+
+// keys are taken from jwks_uri of OpenID metadata
+func (t *Teams) fetchKeys() ([]byte, error) {
+
+	config := &TeamsOpenIDConfiguration{}
+	err := t.getJSON(t.options.OpenIDURL, config)
+	if err != nil {
+		return nil, err
+	}
+	return utils.HttpRequestRawWithHeaders(t.client, http.MethodGet, config.JwksURI, nil, nil)
+}
+
+func (t *Teams) verifyToken(authorization, serviceURL string) error {
+
+	claims := &TeamsJWTClaims{}
+	err := t.verifier.Verify(authorization, claims)
+	if err != nil {
+		return err
+	}
+	// service URL of activity is used for replies, so it must be the one signed by connector
+	if utils.IsEmpty(claims.ServiceURL) {
+		return fmt.Errorf("Teams token has no service URL")
+	}
+	if claims.ServiceURL != serviceURL {
+		return fmt.Errorf("Teams token service URL %s is invalid", claims.ServiceURL)
+	}
+	return nil
+}
+
+// Bot Framework connector
+
+func (t *Teams) getServiceURL(conversationID string) string {
+
+	item := t.conversations.Get(conversationID)
+	if item != nil {
+		return item.Value()
+	}
+	return t.options.ServiceURL
+}
+
+func (t *Teams) activitiesURL(conversationID, activityID string) (string, error) {
+
+	serviceURL := t.getServiceURL(conversationID)
+	if utils.IsEmpty(serviceURL) {
+		return "", fmt.Errorf("Teams service URL for %s is not defined", conversationID)
+	}
+
+	u := fmt.Sprintf("%s/v3/conversations/%s/activities", strings.TrimSuffix(serviceURL, "/"), url.PathEscape(conversationID))
+	if !utils.IsEmpty(activityID) {
+		u = fmt.Sprintf("%s/%s", u, url.PathEscape(activityID))
+	}
+	return u, nil
+}
+
+func (t *Teams) request(method, conversationID, activityID string, activity *TeamsActivity) (string, error) {
+
+	u, err := t.activitiesURL(conversationID, activityID)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := t.getToken()
+	if err != nil {
+		return "", err
+	}
+
+	headers := make(map[string]string)
+	headers["Content-Type"] = "application/json"
+	if !utils.IsEmpty(token) {
+		headers["Authorization"] = fmt.Sprintf("Bearer %s", token)
+	}
+
+	var raw []byte
+	if activity != nil {
+		raw, err = json.Marshal(activity)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	b, err := utils.HttpRequestRawWithHeaders(t.client, method, u, headers, raw)
+	if err != nil {
+		return "", fmt.Errorf("Teams %s %s error: %s %s", method, u, err, string(b))
+	}
+	if len(b) == 0 {
+		return activityID, nil
+	}
+
+	r := &TeamsResourceResponse{}
+	err = json.Unmarshal(b, r)
+	if err != nil {
+		return "", err
+	}
+	if utils.IsEmpty(r.ID) {
+		return activityID, nil
+	}
+	return r.ID, nil
+}
+
+func (t *Teams) newCard(body []interface{}, actions []interface{}) *TeamsAttachment {
+
+	card := make(map[string]interface{})
+	card["type"] = "AdaptiveCard"
+	card["$schema"] = teamsCardSchema
+	card["version"] = teamsCardVersion
+	card["body"] = body
+	if len(actions) > 0 {
+		card["actions"] = actions
+	}
+
+	return &TeamsAttachment{
+		ContentType: teamsContentTypeCard,
+		Content:     card,
+	}
+}
+
+func (t *Teams) newSubmit(title, style, typ, name string) map[string]interface{} {
+
+	data := make(map[string]interface{})
+	data[teamsDataType] = typ
+	data[teamsDataName] = name
+
+	r := make(map[string]interface{})
+	r["type"] = "Action.Submit"
+	r["title"] = title
+	r["data"] = data
+	switch style {
+	case "primary":
+		r["style"] = "positive"
+	case "danger":
+		r["style"] = "destructive"
+	}
+	return r
+}
+
+func (t *Teams) newTextBlock(text string) map[string]interface{} {
+
+	r := make(map[string]interface{})
+	r["type"] = "TextBlock"
+	r["text"] = text
+	r["wrap"] = true
+	return r
+}
+
+func (t *Teams) buildActionsCard(actions []common.Action) *TeamsAttachment {
+
+	list := []interface{}{}
+	for _, a := range actions {
+
+		name := a.Name()
+		if utils.IsEmpty(name) {
+			continue
+		}
+		label := a.Label()
+		if utils.IsEmpty(label) {
+			label = name
+		}
+		list = append(list, t.newSubmit(label, a.Style(), teamsActionButtonType, name))
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return t.newCard([]interface{}{}, list)
+}
+
+func (t *Teams) buildActivity(message string, attachments []*common.Attachment, actions []common.Action) *TeamsActivity {
+
+	text := message
+	atts := []*TeamsAttachment{}
+
+	for _, a := range attachments {
+
+		switch a.Type {
+		case common.AttachmentTypeImage, common.AttachmentTypeFile:
+			contentType := http.DetectContentType(a.Data)
+			atts = append(atts, &TeamsAttachment{
+				ContentType: contentType,
+				ContentURL:  fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(a.Data)),
+				Name:        a.Title,
+			})
+		default:
+			if !utils.IsEmpty(a.Title) {
+				text = fmt.Sprintf("%s\n\n**%s**", text, a.Title)
+			}
+			text = fmt.Sprintf("%s\n\n```\n%s\n```", text, string(a.Data))
+		}
+	}
+
+	card := t.buildActionsCard(actions)
+	if card != nil {
+		atts = append(atts, card)
+	}
+
+	return &TeamsActivity{
+		Type:        teamsActivityMessage,
+		Text:        common.LimitText(strings.TrimSpace(text), teamsMaxTextLength, teamsTrimmed),
+		TextFormat:  teamsTextFormatMarkdown,
+		Attachments: atts,
+	}
+}
+
+func (t *Teams) send(conversationID, replyToID string, activity *TeamsActivity) (*TeamsMessageKey, error) {
+
+	activity.Conversation = &TeamsConversation{ID: conversationID}
+	activity.ReplyToID = replyToID
+
+	ID, err := t.request(http.MethodPost, conversationID, replyToID, activity)
+	if err != nil {
+		return nil, err
+	}
+	return &TeamsMessageKey{
+		conversationID: conversationID,
+		activityID:     ID,
+		replyToID:      replyToID,
+	}, nil
+}
+
+func (t *Teams) reply(m *TeamsMessage, message string, attachments []*common.Attachment, actions []common.Action,
+	response *common.BotResponse, start *time.Time, error bool) (*TeamsMessageKey, string, error) {
+
+	if m.key == nil {
+		return nil, "", fmt.Errorf("Teams message has no conversation")
+	}
+
+	text := message
+	if !utils.IsEmpty(response) {
+
+		if response.Original() && !utils.IsEmpty(m.cmdText) {
+			user := ""
+			if m.user != nil && !utils.IsEmpty(m.user.name) {
+				user = fmt.Sprintf("%s: ", m.user.name)
+			}
+			text = fmt.Sprintf("> %s%s\n\n%s", user, m.cmdText, text)
+		}
+
+		if response.Duration() && start != nil && !error {
+			elapsed := time.Since(*start)
+			text = fmt.Sprintf("[%s] %s", elapsed.Round(time.Millisecond), text)
+		}
+	}
+
+	if error {
+		text = fmt.Sprintf("**Error:** %s", text)
+		attachments = nil
+		actions = nil
+	}
+
+	replyToID := m.key.replyToID
+	if utils.IsEmpty(replyToID) {
+		replyToID = m.key.activityID
+	}
+
+	activity := t.buildActivity(text, attachments, actions)
+	key, err := t.send(m.key.conversationID, replyToID, activity)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, activity.Text, nil
+}
+
+func (t *Teams) replyError(m *TeamsMessage, err error) {
+
+	t.logger.Error("Teams reply error: %s", err)
+	_, _, err = t.reply(m, err.Error(), nil, nil, nil, nil, true)
+	if err != nil {
+		t.logger.Error("Teams couldn't reply error: %s", err)
+	}
+}
+
+func (t *Teams) sendTyping(key *TeamsMessageKey) {
+
+	if key == nil {
+		return
+	}
+	activity := &TeamsActivity{
+		Type:         teamsActivityTyping,
+		Conversation: &TeamsConversation{ID: key.conversationID},
+	}
+	_, err := t.request(http.MethodPost, key.conversationID, "", activity)
+	if err != nil {
+		t.logger.Debug("Teams couldn't send typing: %s", err)
+	}
+}
+
+// Teams has no reactions for bots, so states are only logged
+func (t *Teams) AddReaction(channel, ID, name string) error {
+	t.logger.Debug("Teams has no support for reaction %s on %s in %s", name, ID, channel)
+	return nil
+}
+
+func (t *Teams) RemoveReaction(channel, ID, name string) error {
+	t.logger.Debug("Teams has no support for reaction %s on %s in %s", name, ID, channel)
+	return nil
+}
+
+func (t *Teams) updateActions(channel, ID string, update func(m *TeamsMessage) []common.Action) error {
+
+	key := &TeamsMessageKey{
+		conversationID: channel,
+		activityID:     ID,
+	}
+
+	m := t.findMessageInCache(key)
+	if m == nil {
+		err := fmt.Errorf("Teams message not found in %s with %s", channel, ID)
+		t.logger.Error(err)
+		return err
+	}
+
+	m.actions = update(m)
+	t.putMessageToCache(m)
+
+	activity := t.buildActivity(m.text, nil, m.actions)
+	activity.ID = ID
+	activity.Conversation = &TeamsConversation{ID: channel}
+
+	_, err := t.request(http.MethodPut, channel, ID, activity)
+	return err
+}
+
+func (t *Teams) AddAction(channel, ID string, action common.Action) error {
+
+	return t.updateActions(channel, ID, func(m *TeamsMessage) []common.Action {
+		return append(m.actions, action)
+	})
+}
+
+func (t *Teams) AddActions(channel, ID string, actions []common.Action) error {
+
+	return t.updateActions(channel, ID, func(m *TeamsMessage) []common.Action {
+		return append(m.actions, actions...)
+	})
+}
+
+func (t *Teams) RemoveAction(channel, ID, name string) error {
+
+	return t.updateActions(channel, ID, func(m *TeamsMessage) []common.Action {
+		actions := []common.Action{}
+		for _, a := range m.actions {
+			if a.Name() == name {
+				continue
+			}
+			actions = append(actions, a)
+		}
+		return actions
+	})
+}
+
+func (t *Teams) ClearActions(channel, ID string) error {
+
+	return t.updateActions(channel, ID, func(m *TeamsMessage) []common.Action {
+		return nil
+	})
+}
+
+func (t *Teams) DeleteMessage(channel, ID string) error {
+
+	_, err := t.request(http.MethodDelete, channel, ID, nil)
+	if err != nil {
+		t.logger.Error("Failed to delete message: %s", err)
+		return err
+	}
+	t.messages.Delete((&TeamsMessageKey{conversationID: channel, activityID: ID}).String())
+	return nil
+}
+
+// Bot Framework has no API to read activities, so only cached messages are available
+func (t *Teams) ReadMessage(channel, ID string) (string, error) {
+
+	m := t.findMessageInCache(&TeamsMessageKey{conversationID: channel, activityID: ID})
+	if m == nil {
+		err := fmt.Errorf("Teams message not found in %s with %s", channel, ID)
+		t.logger.Error("Failed to get message: %s", err)
+		return "", err
+	}
+	return m.text, nil
+}
+
+func (t *Teams) UpdateMessage(channel, ID, message string) error {
+
+	var actions []common.Action
+	m := t.findMessageInCache(&TeamsMessageKey{conversationID: channel, activityID: ID})
+	if m != nil {
+		actions = m.actions
+	}
+
+	activity := t.buildActivity(message, nil, actions)
+	activity.ID = ID
+	activity.Conversation = &TeamsConversation{ID: channel}
+
+	_, err := t.request(http.MethodPut, channel, ID, activity)
+	if err != nil {
+		t.logger.Error("Failed to update message: %s", err)
+		return err
+	}
+
+	if m != nil {
+		m.text = activity.Text
+		t.putMessageToCache(m)
+	}
+	return nil
+}
+
+func (t *Teams) buildTeamsUser(activity *TeamsActivity) *TeamsUser {
+
+	from := activity.From
+	if from == nil {
+		return nil
+	}
+
+	u := &TeamsUser{
+		id:       from.ID,
+		name:     from.Name,
+		objectID: from.AadObjectID,
+		timezone: activity.LocalTimezone,
+	}
+
+	// Azure AD object ID is stable across tenants and known to admins
+	userID := u.objectID
+	if utils.IsEmpty(userID) {
+		userID = u.id
+	}
+	commands, err := t.processors.UserCommands(t.options.UserPermissions, userID, u.name)
+	if err != nil {
+		t.logger.Error("Teams permissions error: %s", err)
+	}
+	u.commands = commands
+	return u
+}
+
+// <at>bot</at> group command param1 => group command param1
+func (t *Teams) prepareInputText(text string) string {
+
+	text = teamsMentionRegex.ReplaceAllString(text, "")
+	text = teamsTagRegex.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	text = strings.ReplaceAll(text, "\u00a0", " ")
+	text = strings.TrimPrefix(strings.TrimSpace(text), "/")
+
+	return t.processors.ReplaceAlias(text)
+}
+
+func (t *Teams) cachePostUserCommand(m *TeamsMessage, params common.ExecuteParams, action common.Action, response common.Response, overwrite bool) error {
+
+	start := time.Now()
+	executor, message, attachments, actions, err := m.cmd.Execute(t, m, params, action)
+	if err != nil {
+		t.replyError(m, err)
+		return err
+	}
+	if action == nil {
+		actions = common.MergeActions(actions, m.cmd.Actions())
+	}
+
+	r := common.BuildResponse(overwrite, response, executor.Response())
+
+	var key *TeamsMessageKey
+	text := ""
+
+	if !utils.IsEmpty(message) || len(attachments) > 0 {
+
+		mReply := m
+		channel := m.cmd.Channel()
+		if !utils.IsEmpty(channel) && channel != m.key.conversationID {
+			mReply = t.cloneMessage(m)
+			mReply.key = &TeamsMessageKey{conversationID: channel}
+		}
+
+		k, txt, err := t.reply(mReply, message, attachments, actions, r, &start, r.Error())
+		if err != nil {
+			t.replyError(m, err)
+			return err
+		}
+		key = k
+		text = txt
+	}
+
+	mNew := t.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = key
+	mNew.visible = r.Visible()
+	mNew.text = text
+	mNew.actions = actions
+	mNew.params = params
+
+	t.putMessageToCache(mNew)
+
+	if mNew.key == nil {
+		mNew.key = m.key
+	}
+	return executor.After(mNew)
+}
+
+func (t *Teams) approvalNeeded(m *TeamsMessage, cmd common.Command, params common.ExecuteParams) (string, string) {
+
+	approval := cmd.Approval()
+	if approval == nil {
+		return "", ""
+	}
+
+	chl := strings.TrimSpace(approval.Channel(t, m, params))
+	if utils.IsEmpty(chl) {
+		chl = m.key.conversationID
+	}
+
+	message := strings.TrimSpace(approval.Message(t, m, params))
+	if utils.IsEmpty(message) {
+		return "", chl
+	}
+	return message, chl
+}
+
+func (t *Teams) cacheAskApproval(m *TeamsMessage, message, channel string, params common.ExecuteParams) error {
+
+	replyToID := ""
+	if channel == m.key.conversationID {
+		replyToID = m.key.replyToID
+		if utils.IsEmpty(replyToID) {
+			replyToID = m.key.activityID
+		}
+	}
+
+	body := []interface{}{t.newTextBlock(common.LimitText(message, teamsMaxTextLength, teamsTrimmed))}
+	actions := []interface{}{
+		t.newSubmit(t.options.ButtonApproveCaption, "primary", teamsApprovalButtonType, teamsApprovalSubmit),
+		t.newSubmit(t.options.ButtonRejectCaption, "danger", teamsApprovalButtonType, teamsApprovalCancel),
+	}
+
+	activity := &TeamsActivity{
+		Type:        teamsActivityMessage,
+		Attachments: []*TeamsAttachment{t.newCard(body, actions)},
+	}
+
+	key, err := t.send(channel, replyToID, activity)
+	if err != nil {
+		return err
+	}
+
+	mNew := t.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = key
+	mNew.text = message
+	mNew.params = params
+
+	t.putMessageToCache(mNew)
+	return nil
+}
+
+func (t *Teams) executeCommand(m *TeamsMessage, params common.ExecuteParams, action common.Action) {
+
+	t.sendTyping(m.key)
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	err := t.cachePostUserCommand(m, params, action, r, false)
+	if err != nil {
+		t.logger.Error("Teams couldn't post from %s: %s", m.userID(), err)
+	}
+}
+
+func (t *Teams) approveOrExecute(m *TeamsMessage, params common.ExecuteParams) {
+
+	message, channel := t.approvalNeeded(m, m.cmd, params)
+	if !utils.IsEmpty(message) {
+		err := t.cacheAskApproval(m, message, channel, params)
+		if err != nil {
+			t.replyError(m, err)
+		}
+		return
+	}
+	t.executeCommand(m, params, nil)
+}
+
+func (t *Teams) processCommand(m *TeamsMessage, params common.ExecuteParams) {
+
+	list := []string{common.FieldTypeSelect, common.FieldTypeMultiSelect, common.FieldTypeEdit}
+	only := common.FieldsByType(t, m.cmd, list)
+
+	fields := m.cmd.Fields(t, m, params, only)
+	m.fields = fields
+	m.params = params
+	t.putMessageToCache(m)
+
+	if common.FormNeeded(fields, params) {
+		err := t.cacheReplyForm(m, fields, params)
+		if err != nil {
+			t.replyError(m, err)
+		}
+		return
+	}
+
+	params = common.FieldValues(fields, params)
+
+	t.approveOrExecute(m, params)
+}
+
+// TeamsForm
+
+func (t *Teams) fieldValueToString(value interface{}) string {
+
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func (t *Teams) fieldLabel(field common.Field) string {
+
+	if !utils.IsEmpty(field.Label) {
+		return field.Label
+	}
+	return field.Name
+}
+
+func (t *Teams) fieldChoices(values []string) []interface{} {
+
+	r := []interface{}{}
+	for _, v := range values {
+		choice := make(map[string]interface{})
+		choice["title"] = v
+		choice["value"] = v
+		r = append(r, choice)
+	}
+	return r
+}
+
+func (t *Teams) formInput(field common.Field, value string) map[string]interface{} {
+
+	r := make(map[string]interface{})
+	r["id"] = field.Name
+	r["label"] = t.fieldLabel(field)
+	r["isRequired"] = field.Required
+	if !utils.IsEmpty(field.Hint) {
+		r["placeholder"] = field.Hint
+	}
+	if !utils.IsEmpty(value) {
+		r["value"] = value
+	}
+
+	switch field.Type {
+	case common.FieldTypeMultiEdit:
+		r["type"] = "Input.Text"
+		r["isMultiline"] = true
+	case common.FieldTypeInteger, common.FieldTypeFloat:
+		r["type"] = "Input.Number"
+	case common.FieldTypeURL:
+		r["type"] = "Input.Text"
+		r["style"] = "url"
+	case common.FieldTypeDate:
+		r["type"] = "Input.Date"
+	case common.FieldTypeTime:
+		r["type"] = "Input.Time"
+	case common.FieldTypeSelect, common.FieldTypeDynamicSelect:
+		r["type"] = "Input.ChoiceSet"
+		r["style"] = "compact"
+		r["choices"] = t.fieldChoices(field.Values)
+	case common.FieldTypeMultiSelect, common.FieldTypeDynamicMultiSelect:
+		r["type"] = "Input.ChoiceSet"
+		r["style"] = "compact"
+		r["isMultiSelect"] = true
+		r["choices"] = t.fieldChoices(field.Values)
+	case common.FieldTypeRadionButtons:
+		r["type"] = "Input.ChoiceSet"
+		r["style"] = "expanded"
+		r["choices"] = t.fieldChoices(field.Values)
+	case common.FieldTypeCheckboxes:
+		r["type"] = "Input.ChoiceSet"
+		r["style"] = "expanded"
+		r["isMultiSelect"] = true
+		r["choices"] = t.fieldChoices(field.Values)
+	case common.FieldTypeBool:
+		r["type"] = "Input.Toggle"
+		r["title"] = t.fieldLabel(field)
+		r["valueOn"] = fmt.Sprintf("%v", true)
+		r["valueOff"] = fmt.Sprintf("%v", false)
+	default:
+		r["type"] = "Input.Text"
+	}
+	return r
+}
+
+func (t *Teams) formBody(fields []common.Field, params common.ExecuteParams) []interface{} {
+
+	body := []interface{}{}
+	for _, field := range fields {
+
+		if field.Type == common.FieldTypeMarkdown {
+			body = append(body, t.newTextBlock(field.Default))
+			continue
+		}
+
+		value := field.Default
+		if v, ok := params[field.Name]; ok && !utils.IsEmpty(v) {
+			value = t.fieldValueToString(v)
+		}
+		body = append(body, t.formInput(field, value))
+	}
+	return body
+}
+
+func (t *Teams) cacheReplyForm(m *TeamsMessage, fields []common.Field, params common.ExecuteParams) error {
+
+	body := []interface{}{t.newTextBlock(fmt.Sprintf("**%s**", m.cmdText))}
+	body = append(body, t.formBody(fields, params)...)
+
+	actions := []interface{}{
+		t.newSubmit(t.options.ButtonSubmitCaption, "primary", teamsFormButtonType, teamsFormSubmit),
+		t.newSubmit(t.options.ButtonCancelCaption, "", teamsFormButtonType, teamsFormCancel),
+	}
+
+	replyToID := m.key.replyToID
+	if utils.IsEmpty(replyToID) {
+		replyToID = m.key.activityID
+	}
+
+	activity := &TeamsActivity{
+		Type:        teamsActivityMessage,
+		Attachments: []*TeamsAttachment{t.newCard(body, actions)},
+	}
+
+	key, err := t.send(m.key.conversationID, replyToID, activity)
+	if err != nil {
+		return err
+	}
+
+	mNew := t.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = key
+	mNew.fields = fields
+	mNew.params = params
+
+	t.putMessageToCache(mNew)
+	return nil
+}
+
+func (t *Teams) formValues(fields []common.Field, value map[string]interface{}) common.ExecuteParams {
+
+	r := make(common.ExecuteParams)
+	for _, field := range fields {
+
+		v, ok := value[field.Name]
+		if !ok {
+			continue
+		}
+		s := strings.TrimSpace(fmt.Sprintf("%v", v))
+		if utils.IsEmpty(s) {
+			continue
+		}
+		r[field.Name] = common.FieldValue(field, s)
+	}
+	return r
+}
+
+// replace form card with its values to avoid double submit
+func (t *Teams) closeForm(m *TeamsMessage, params common.ExecuteParams, text string) {
+
+	body := []interface{}{t.newTextBlock(fmt.Sprintf("**%s**", m.cmdText))}
+	for _, field := range m.fields {
+		v, ok := params[field.Name]
+		if !ok || utils.IsEmpty(v) {
+			continue
+		}
+		body = append(body, t.newTextBlock(fmt.Sprintf("%s: %s", t.fieldLabel(field), t.fieldValueToString(v))))
+	}
+	if !utils.IsEmpty(text) {
+		body = append(body, t.newTextBlock(text))
+	}
+
+	activity := &TeamsActivity{
+		Type:         teamsActivityMessage,
+		ID:           m.key.activityID,
+		Conversation: &TeamsConversation{ID: m.key.conversationID},
+		Attachments:  []*TeamsAttachment{t.newCard(body, nil)},
+	}
+
+	_, err := t.request(http.MethodPut, m.key.conversationID, m.key.activityID, activity)
+	if err != nil {
+		t.logger.Error("Teams couldn't update form: %s", err)
+	}
+}
+
+func (t *Teams) handleFormButton(m *TeamsMessage, caller *TeamsUser, name string, value map[string]interface{}) error {
+
+	if m.cmd == nil || m.originKey == nil {
+		return fmt.Errorf("Teams form has no command")
+	}
+
+	if caller.id != m.userID() {
+		return fmt.Errorf("Teams form belongs to another user")
+	}
+
+	mInit := t.cloneMessage(m)
+	mInit.key = m.originKey
+	mInit.originKey = nil
+
+	if name == teamsFormCancel {
+		t.messages.Delete(m.key.String())
+		t.closeForm(m, m.params, t.options.FormCancelled)
+		return nil
+	}
+
+	params := common.MergeInterfaceMaps(m.params, t.formValues(m.fields, value))
+	if common.FormNeeded(m.fields, params) {
+		return fmt.Errorf("Teams form has empty required fields")
+	}
+
+	t.messages.Delete(m.key.String())
+	t.closeForm(m, params, "")
+
+	mInit.params = params
+	t.putMessageToCache(mInit)
+
+	t.approveOrExecute(mInit, params)
+	return nil
+}
+
+func (t *Teams) handleActionButton(m *TeamsMessage, caller *TeamsUser, name string) error {
+
+	if m.cmd == nil {
+		return fmt.Errorf("Teams message has no command")
+	}
+
+	var action common.Action
+	for _, a := range m.actions {
+		if a.Name() == name {
+			action = a
+			break
+		}
+	}
+
+	if action == nil {
+		return fmt.Errorf("Teams action %s is not defined", name)
+	}
+
+	mAction := t.cloneMessage(m)
+	mAction.caller = caller
+	mAction.cmdText = ""
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	return t.cachePostUserCommand(mAction, m.params, action, r, true)
+}
+
+func (t *Teams) handleApprovalButton(m *TeamsMessage, caller *TeamsUser, name string) error {
+
+	if m.cmd == nil || m.originKey == nil {
+		return fmt.Errorf("Teams approval has no command")
+	}
+
+	if !t.options.ApprovalAny && caller.id == m.userID() {
+		return fmt.Errorf("Teams same user cannot approve its action")
+	}
+
+	caption := t.options.ButtonRejectCaption
+	if name == teamsApprovalSubmit {
+		caption = t.options.ButtonApproveCaption
+	}
+
+	// remove buttons to avoid double approval
+	body := []interface{}{
+		t.newTextBlock(common.LimitText(m.text, teamsMaxTextLength, teamsTrimmed)),
+		t.newTextBlock(fmt.Sprintf("**%s**: %s", caption, caller.name)),
+	}
+	activity := &TeamsActivity{
+		Type:         teamsActivityMessage,
+		ID:           m.key.activityID,
+		Conversation: &TeamsConversation{ID: m.key.conversationID},
+		Attachments:  []*TeamsAttachment{t.newCard(body, nil)},
+	}
+	_, err := t.request(http.MethodPut, m.key.conversationID, m.key.activityID, activity)
+	if err != nil {
+		t.logger.Error("Teams couldn't update approval message: %s", err)
+	}
+	t.messages.Delete(m.key.String())
+
+	if name != teamsApprovalSubmit {
+		return nil
+	}
+
+	mInit := t.cloneMessage(m)
+	mInit.key = m.originKey
+	mInit.originKey = nil
+
+	t.executeCommand(mInit, m.params, nil)
+	return nil
+}
+
+func (t *Teams) processSubmit(activity *TeamsActivity) {
+
+	typ, _ := activity.Value[teamsDataType].(string)
+	name, _ := activity.Value[teamsDataName].(string)
+	if utils.IsEmpty(typ) || utils.IsEmpty(name) {
+		t.logger.Debug("Teams submit has no chatops data")
+		return
+	}
+
+	key := &TeamsMessageKey{
+		conversationID: activity.Conversation.ID,
+		activityID:     activity.ReplyToID,
+	}
+
+	m := t.findMessageInCache(key)
+	if m == nil {
+		t.logger.Error("Teams message is not found in cache.")
+		return
+	}
+
+	caller := t.buildTeamsUser(activity)
+	if caller == nil {
+		t.logger.Error("Teams couldn't process submit from unknown user")
+		return
+	}
+
+	var err error
+	switch typ {
+	case teamsActionButtonType:
+		err = t.handleActionButton(m, caller, name)
+	case teamsApprovalButtonType:
+		err = t.handleApprovalButton(m, caller, name)
+	case teamsFormButtonType:
+		err = t.handleFormButton(m, caller, name, activity.Value)
+	}
+
+	if err != nil {
+		t.logger.Error(err)
+		mErr := t.cloneMessage(m)
+		mErr.cmdText = ""
+		t.replyError(mErr, err)
+	}
+}
+
+func (t *Teams) processText(activity *TeamsActivity) {
+
+	u := t.buildTeamsUser(activity)
+	if u == nil {
+		t.logger.Error("Teams couldn't process command from unknown user")
+		return
+	}
+
+	fText := t.prepareInputText(activity.Text)
+	params, cmd, group, _, _, _, pErr := t.processors.FindParams(false, fText)
+
+	if cmd == nil && !utils.IsEmpty(t.options.DefaultCommand) {
+		cmd = t.processors.FindCommand("", t.options.DefaultCommand)
+		group = ""
+		params = make(common.ExecuteParams)
+	}
+
+	if cmd == nil {
+		params, cmd = t.processors.FindSuggest(fText)
+		group = ""
+	}
+
+	if cmd == nil {
+		t.logger.Debug("Teams command not found for text: %s", activity.Text)
+		common.UpdateCounters(t.meter, "teams", "", "", fText, u.id)
+		return
+	}
+
+	common.UpdateCounters(t.meter, "teams", group, cmd.Name(), fText, u.id)
+
+	groupName := common.GroupName(group, cmd)
+	if !common.Permitted(u, cmd, groupName) {
+		t.logger.Error("Teams user %s is not permitted to execute %s", u.id, groupName)
+		return
+	}
+
+	m := &TeamsMessage{
+		teams:   t,
+		cmdText: fText,
+		cmd:     cmd,
+		key: &TeamsMessageKey{
+			conversationID: activity.Conversation.ID,
+			activityID:     activity.ID,
+			replyToID:      activity.ReplyToID,
+		},
+		user:    u,
+		caller:  u,
+		visible: true,
+		text:    activity.Text,
+	}
+
+	// args couldn't be parsed, so usage is replied before form or approval
+	if pErr != nil {
+		t.replyError(m, pErr)
+		return
+	}
+	t.processCommand(m, params)
+}
+
+func (t *Teams) processActivity(activity *TeamsActivity) {
+
+	if activity.Conversation == nil {
+		return
+	}
+
+	if !utils.IsEmpty(activity.ServiceURL) {
+		t.conversations.Set(activity.Conversation.ID, activity.ServiceURL, ttlcache.DefaultTTL)
+	}
+
+	if activity.Type != teamsActivityMessage {
+		return
+	}
+
+	if t.options.Debug {
+		t.logger.Debug("Teams activity: [%s] %s", activity.Conversation.ID, activity.Text)
+	}
+
+	if len(activity.Value) > 0 {
+		t.processSubmit(activity)
+		return
+	}
+	t.processText(activity)
+}
+
+func (t *Teams) messagesHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.logger.Error("Teams couldn't read activity: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	activity := &TeamsActivity{}
+	err = json.Unmarshal(body, activity)
+	if err != nil {
+		t.logger.Error("Teams couldn't decode activity: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = t.verifyToken(r.Header.Get("Authorization"), activity.ServiceURL)
+	if err != nil {
+		t.logger.Error(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// replies go through the connector, so acknowledge the activity at once
+	w.WriteHeader(http.StatusOK)
+	go t.processActivity(activity)
+}
+
+func (t *Teams) parentMessage(parent common.Message) *TeamsMessage {
+
+	if utils.IsEmpty(parent) {
+		return nil
+	}
+	tm, ok := parent.(*TeamsMessage)
+	if !ok {
+		return nil
+	}
+	if tm.key != nil {
+		if mc := t.findMessageInCache(tm.key); mc != nil {
+			return mc
+		}
+	}
+	return tm
+}
+
+func (t *Teams) Command(channel, text string, user common.User, parent common.Message, response common.Response) error {
+
+	r := common.BuildResponse(true, response)
+
+	mOrigin := t.parentMessage(parent)
+	if mOrigin != nil && mOrigin.cmd != nil {
+		r = common.BuildResponse(false, mOrigin.cmd.Response(), response)
+	}
+
+	var mUser *TeamsUser
+	userID := "unknown"
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*TeamsUser)
+		if ok {
+			mUser = u
+		}
+		userID = user.ID()
+	}
+
+	fText := t.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := t.processors.FindParams(false, fText)
+	if cmd == nil {
+		t.logger.Debug("Teams command not found for text: %s", text)
+		return nil
+	}
+
+	groupName := common.GroupName(group, cmd)
+	if !utils.IsEmpty(user) && !utils.Contains(user.Commands(), groupName) {
+		t.logger.Debug("Teams command user %s is not permitted to execute %s", userID, groupName)
+		return nil
+	}
+
+	if pErr != nil {
+		return pErr
+	}
+
+	fields := cmd.Fields(t, parent, params, nil)
+	if common.FormNeeded(fields, params) {
+		t.logger.Debug("Teams command %s has no support for interaction mode", groupName)
+		return nil
+	}
+
+	key := &TeamsMessageKey{}
+	if mOrigin != nil && mOrigin.key != nil {
+		key.conversationID = mOrigin.key.conversationID
+		key.replyToID = mOrigin.key.replyToID
+		if utils.IsEmpty(key.replyToID) {
+			key.replyToID = mOrigin.key.activityID
+		}
+	}
+	if !utils.IsEmpty(channel) {
+		if channel != key.conversationID {
+			key.replyToID = ""
+		}
+		key.conversationID = channel
+	}
+
+	var m *TeamsMessage
+	if mOrigin != nil {
+		m = t.cloneMessage(mOrigin)
+		m.originKey = mOrigin.key
+	} else {
+		m = &TeamsMessage{
+			teams:  t,
+			user:   mUser,
+			caller: mUser,
+		}
+	}
+	m.cmdText = fText
+	m.cmd = cmd
+	m.key = key
+	m.fields = fields
+	m.params = params
+
+	err := t.cachePostUserCommand(m, params, nil, r, true)
+	if err != nil {
+		t.logger.Error("Teams command %s couldn't post from %s: %s", groupName, userID, err)
+		return err
+	}
+	return nil
+}
+
+func (t *Teams) PostMessage(channel string, message string, attachments []*common.Attachment, actions []common.Action,
+	user common.User, parent common.Message, response common.Response) (string, error) {
+
+	r := common.BuildResponse(true, response)
+
+	replyToID := ""
+	mOrigin := t.parentMessage(parent)
+	if mOrigin != nil && mOrigin.key != nil {
+		replyToID = mOrigin.key.replyToID
+		if utils.IsEmpty(replyToID) {
+			replyToID = mOrigin.key.activityID
+		}
+		if utils.IsEmpty(channel) {
+			channel = mOrigin.key.conversationID
+		}
+		if mOrigin.key.conversationID != channel {
+			replyToID = ""
+		}
+	}
+
+	if utils.IsEmpty(channel) {
+		return "", fmt.Errorf("Teams conversation is not defined")
+	}
+
+	activity := t.buildActivity(message, attachments, actions)
+	key, err := t.send(channel, replyToID, activity)
+	if err != nil {
+		return "", err
+	}
+
+	var mUser *TeamsUser
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*TeamsUser)
+		if ok {
+			mUser = u
+		}
+	}
+
+	var m *TeamsMessage
+	if mOrigin != nil {
+		m = t.cloneMessage(mOrigin)
+		m.originKey = mOrigin.key
+	} else {
+		m = &TeamsMessage{
+			teams:  t,
+			user:   mUser,
+			caller: mUser,
+		}
+	}
+	m.key = key
+	m.visible = r.Visible()
+	m.text = activity.Text
+	m.actions = actions
+	t.putMessageToCache(m)
+
+	return key.activityID, nil
+}
+
+func (t *Teams) start() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(t.options.Path, t.messagesHandler)
+
+	t.logger.Info("Teams is listening on %s%s", t.options.Listen, t.options.Path)
+
+	err := http.ListenAndServe(t.options.Listen, mux)
+	if err != nil {
+		t.logger.Error("Teams listen error: %s", err)
+	}
+}
+
+func (t *Teams) Start(wg *sync.WaitGroup) {
+
+	if wg == nil {
+		t.start()
+		return
+	}
+
+	wg.Add(1)
+
+	go func(wg *sync.WaitGroup) {
+
+		defer wg.Done()
+		t.start()
+	}(wg)
+}
+
+func NewTeams(options TeamsOptions, observability *common.Observability, processors *common.Processors) *Teams {
+
+	if utils.IsEmpty(options.Listen) {
+		return nil
+	}
+
+	logger := observability.Logs()
+
+	// activities carry user and service URL, they can be trusted only when token is verified against app ID
+	if utils.IsEmpty(options.AppID) {
+		logger.Error("Teams couldn't start without app ID")
+		return nil
+	}
+
+	ttl := 1 * 60 * 60 * time.Second
+	if !utils.IsEmpty(options.CacheTTL) {
+		ttl, _ = time.ParseDuration(options.CacheTTL)
+	}
+
+	messages := ttlcache.New[string, *TeamsMessage](ttlcache.WithTTL[string, *TeamsMessage](ttl))
+	go messages.Start()
+
+	// service URL is refreshed by incoming activities only, replies don't prolong it
+	conversations := ttlcache.New[string, string](
+		ttlcache.WithTTL[string, string](ttl),
+		ttlcache.WithDisableTouchOnHit[string, string](),
+	)
+	go conversations.Start()
+
+	t := &Teams{
+		options:       options,
+		processors:    processors,
+		client:        utils.NewHttpClient(options.Timeout, options.Insecure),
+		logger:        logger,
+		meter:         observability.Metrics(),
+		messages:      messages,
+		conversations: conversations,
+	}
+
+	t.verifier = common.NewJWTVerifier(common.JWTVerifierOptions{
+		Name:     "Teams",
+		Issuer:   teamsTokenIssuer,
+		Audience: options.AppID,
+	}, t.fetchKeys)
+	return t
+}
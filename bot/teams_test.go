@@ -0,0 +1,377 @@
+package bot
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+)
+
+type testTeamsRequest struct {
+	method   string
+	path     string
+	auth     string
+	activity *TeamsActivity
+}
+
+// testTeamsConnector stands in for Bot Framework connector under any region prefix and OpenID metadata
+type testTeamsConnector struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	mutex    sync.Mutex
+	requests []*testTeamsRequest
+	fetches  int
+}
+
+func (s *testTeamsConnector) handle(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case r.URL.Path == "/openid":
+		json.NewEncoder(w).Encode(&TeamsOpenIDConfiguration{JwksURI: s.URL + "/keys"})
+	case r.URL.Path == "/keys":
+		s.fetches++
+		w.Write(testJWKS(s.key, "k1"))
+	case strings.Contains(r.URL.Path, "/v3/conversations/"):
+		activity := &TeamsActivity{}
+		json.NewDecoder(r.Body).Decode(activity)
+		if activity.Type == teamsActivityTyping {
+			return
+		}
+		s.requests = append(s.requests, &testTeamsRequest{
+			method:   r.Method,
+			path:     r.URL.Path,
+			auth:     r.Header.Get("Authorization"),
+			activity: activity,
+		})
+		json.NewEncoder(w).Encode(&TeamsResourceResponse{ID: fmt.Sprintf("a%d", len(s.requests))})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// recorded returns posted activities and card updates separately
+func (s *testTeamsConnector) recorded() ([]*testTeamsRequest, []*testTeamsRequest) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	posts := []*testTeamsRequest{}
+	updates := []*testTeamsRequest{}
+	for _, r := range s.requests {
+		switch r.method {
+		case http.MethodPost:
+			posts = append(posts, r)
+		case http.MethodPut:
+			updates = append(updates, r)
+		}
+	}
+	return posts, updates
+}
+
+func newTestTeams(t *testing.T, options TeamsOptions, processors *common.Processors) (*Teams, *testTeamsConnector) {
+
+	t.Helper()
+	s := &testTeamsConnector{key: testRSAKey(t)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+
+	options.Listen = ":0"
+	options.Path = "/api/messages"
+	options.AppID = "app"
+	options.ServiceURL = s.URL + "/default/"
+	options.OpenIDURL = s.URL + "/openid"
+	options.Timeout = 5
+	options.ButtonSubmitCaption = "Submit"
+	options.ButtonCancelCaption = "Cancel"
+	options.ButtonApproveCaption = "Approve"
+	options.ButtonRejectCaption = "Reject"
+	options.FormCancelled = "Cancelled"
+
+	tm := NewTeams(options, testObservability(), processors)
+	t.Cleanup(tm.messages.Stop)
+	t.Cleanup(tm.conversations.Stop)
+
+	// connector token is issued by login.microsoftonline.com, so it's cached in advance
+	tm.token = "bot-token"
+	tm.tokenExpires = time.Now().Add(time.Hour)
+	return tm, s
+}
+
+func testTeamsActivity(serviceURL, userID, text string) *TeamsActivity {
+	return &TeamsActivity{
+		Type:         teamsActivityMessage,
+		ID:           "origin",
+		ServiceURL:   serviceURL,
+		From:         &TeamsAccount{ID: userID, Name: "user-" + userID},
+		Conversation: &TeamsConversation{ID: "c1"},
+		Text:         text,
+	}
+}
+
+// testTeamsSubmit presses card button of activity with inputs of card
+func testTeamsSubmit(serviceURL, userID, activityID, typ, name string, inputs map[string]interface{}) *TeamsActivity {
+
+	a := testTeamsActivity(serviceURL, userID, "")
+	a.ID = "submit"
+	a.ReplyToID = activityID
+	a.Value = map[string]interface{}{teamsDataType: typ, teamsDataName: name}
+	for k, v := range inputs {
+		a.Value[k] = v
+	}
+	return a
+}
+
+func testTeamsCard(t *testing.T, a *TeamsActivity) string {
+
+	t.Helper()
+	if len(a.Attachments) == 0 || a.Attachments[len(a.Attachments)-1].ContentType != teamsContentTypeCard {
+		t.Fatalf("expected adaptive card, got %v", a.Attachments)
+	}
+	b, _ := json.Marshal(a.Attachments[len(a.Attachments)-1].Content)
+	return string(b)
+}
+
+func TestTeamsMessagesHandler(t *testing.T) {
+
+	c := &testCommand{name: "status", text: "all good"}
+	tm, s := newTestTeams(t, TeamsOptions{}, testProcessors("ops", c))
+
+	claims := func(iss, aud, serviceURL string, exp time.Duration) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":        iss,
+			"aud":        aud,
+			"exp":        time.Now().Add(exp).Unix(),
+			"serviceurl": serviceURL,
+		}
+	}
+	serviceURL := s.URL + "/emea/"
+	post := func(authorization string) int {
+		b, _ := json.Marshal(testTeamsActivity(serviceURL, "u1", "ops status"))
+		r := httptest.NewRequest(http.MethodPost, tm.options.Path, bytes.NewReader(b))
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		tm.messagesHandler(w, r)
+		return w.Code
+	}
+
+	for name, authorization := range map[string]string{
+		"missing":     "",
+		"malformed":   "Bearer token",
+		"issuer":      "Bearer " + testJWT(t, s.key, "k1", claims("https://evil", "app", serviceURL, time.Hour)),
+		"audience":    "Bearer " + testJWT(t, s.key, "k1", claims(teamsTokenIssuer, "other", serviceURL, time.Hour)),
+		"expired":     "Bearer " + testJWT(t, s.key, "k1", claims(teamsTokenIssuer, "app", serviceURL, -time.Hour)),
+		"service URL": "Bearer " + testJWT(t, s.key, "k1", claims(teamsTokenIssuer, "app", "https://evil/", time.Hour)),
+		"no service":  "Bearer " + testJWT(t, s.key, "k1", claims(teamsTokenIssuer, "app", "", time.Hour)),
+		"signature":   "Bearer " + testJWT(t, testRSAKey(t), "k1", claims(teamsTokenIssuer, "app", serviceURL, time.Hour)),
+	} {
+		if code := post(authorization); code != http.StatusUnauthorized {
+			t.Fatalf("expected %s token to be rejected, got %d", name, code)
+		}
+	}
+	posts, _ := s.recorded()
+	if len(posts) != 0 || tm.conversations.Get("c1") != nil {
+		t.Fatalf("expected rejected activities to be dropped, got %v", posts)
+	}
+
+	token := "Bearer " + testJWT(t, s.key, "k1", claims(teamsTokenIssuer, "app", serviceURL, time.Hour))
+	for i := 0; i < 2; i++ {
+		if code := post(token); code != http.StatusOK {
+			t.Fatalf("expected valid token to be accepted, got %d", code)
+		}
+	}
+	testWait(t, func() bool {
+		posts, _ := s.recorded()
+		return len(posts) == 2
+	})
+
+	posts, _ = s.recorded()
+	if posts[0].auth != "Bearer bot-token" || !strings.HasPrefix(posts[0].path, "/emea/v3/conversations/c1/activities") {
+		t.Fatalf("expected reply through activity service URL, got %s %s", posts[0].auth, posts[0].path)
+	}
+	s.mutex.Lock()
+	fetches := s.fetches
+	s.mutex.Unlock()
+	if fetches != 1 {
+		t.Fatalf("expected signing keys to be cached, got %d fetches", fetches)
+	}
+}
+
+func TestTeamsAppID(t *testing.T) {
+
+	// without app ID activities couldn't be verified, so bot doesn't start
+	tm := NewTeams(TeamsOptions{Listen: ":0"}, testObservability(), testProcessors("ops"))
+	if tm != nil {
+		t.Fatal("expected Teams not to start without app ID")
+	}
+}
+
+func TestTeamsServiceURL(t *testing.T) {
+
+	c := &testCommand{name: "status", text: "all good"}
+	tm, s := newTestTeams(t, TeamsOptions{CacheTTL: "200ms"}, testProcessors("ops", c))
+
+	// conversation remembers service URL of its region for later messages
+	tm.processActivity(testTeamsActivity(s.URL+"/emea/", "u1", "<at>chatops</at>&nbsp;ops status"))
+
+	_, err := tm.PostMessage("c1", "scheduled", nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tm.PostMessage("c2", "scheduled", nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	executed, _ := c.calls()
+	posts, _ := s.recorded()
+	if len(executed) != 1 || len(posts) != 3 {
+		t.Fatalf("expected mention to run command, got %v and %d posts", executed, len(posts))
+	}
+	for i, prefix := range []string{"/emea/v3/conversations/c1/", "/emea/v3/conversations/c1/", "/default/v3/conversations/c2/"} {
+		if !strings.HasPrefix(posts[i].path, prefix) {
+			t.Fatalf("expected post %d under %s, got %s", i, prefix, posts[i].path)
+		}
+	}
+	if posts[0].activity.ReplyToID != "origin" || posts[0].activity.Text != "all good" {
+		t.Fatalf("unexpected reply %v", posts[0].activity)
+	}
+
+	// service URL is forgotten with cache, so default one is used again
+	testWait(t, func() bool {
+		return tm.conversations.Get("c1") == nil
+	})
+	_, err = tm.PostMessage("c1", "scheduled", nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts, _ = s.recorded()
+	if len(posts) != 4 || !strings.HasPrefix(posts[3].path, "/default/v3/conversations/c1/") {
+		t.Fatalf("expected post under default service URL, got %s", posts[len(posts)-1].path)
+	}
+}
+
+func TestTeamsForm(t *testing.T) {
+
+	c := &testCommand{name: "scale", text: "scaled", fields: []common.Field{
+		{Name: "services", Type: common.FieldTypeMultiSelect, Required: true, Values: []string{"api", "web", "worker"}},
+		{Name: "replicas", Type: common.FieldTypeEdit, Required: true, Default: "2"},
+	}}
+	tm, s := newTestTeams(t, TeamsOptions{}, testProcessors("ops", c))
+
+	tm.processActivity(testTeamsActivity(s.URL, "u1", "ops scale"))
+
+	posts, _ := s.recorded()
+	card := testTeamsCard(t, posts[0].activity)
+	if len(posts) != 1 || !strings.Contains(card, `"isMultiSelect":true`) || !strings.Contains(card, `"value":"2"`) {
+		t.Fatalf("expected form card, got %s", card)
+	}
+
+	// form belongs to requester, required inputs are checked on submit
+	tm.processActivity(testTeamsSubmit(s.URL, "u2", "a1", teamsFormButtonType, teamsFormSubmit, map[string]interface{}{"services": "api", "replicas": "3"}))
+	tm.processActivity(testTeamsSubmit(s.URL, "u1", "a1", teamsFormButtonType, teamsFormSubmit, map[string]interface{}{"services": "", "replicas": "3"}))
+
+	posts, _ = s.recorded()
+	if len(posts) != 3 || posts[1].activity.Text != "**Error:** Teams form belongs to another user" || posts[2].activity.Text != "**Error:** Teams form has empty required fields" {
+		t.Fatalf("expected form errors, got %d posts", len(posts))
+	}
+
+	tm.processActivity(testTeamsSubmit(s.URL, "u1", "a1", teamsFormButtonType, teamsFormSubmit, map[string]interface{}{"services": "api,web", "replicas": "3"}))
+
+	executed, _ := c.calls()
+	if len(executed) != 1 || fmt.Sprintf("%v", executed[0]["services"]) != "[api web]" || executed[0]["replicas"] != "3" {
+		t.Fatalf("expected card inputs as params, got %v", executed)
+	}
+	posts, updates := s.recorded()
+	if len(updates) != 1 || !strings.Contains(testTeamsCard(t, updates[0].activity), "services: api,web") || strings.Contains(testTeamsCard(t, updates[0].activity), "Action.Submit") {
+		t.Fatalf("expected form to be closed, got %v", updates)
+	}
+	if len(posts) != 4 || posts[3].activity.Text != "scaled" || posts[3].activity.ReplyToID != "origin" {
+		t.Fatalf("expected reply to origin, got %d posts", len(posts))
+	}
+
+	// closed form can't be submitted twice
+	tm.processActivity(testTeamsSubmit(s.URL, "u1", "a1", teamsFormButtonType, teamsFormSubmit, map[string]interface{}{"services": "api", "replicas": "3"}))
+	executed, _ = c.calls()
+	if len(executed) != 1 {
+		t.Fatalf("expected single execution, got %v", executed)
+	}
+}
+
+func TestTeamsApproval(t *testing.T) {
+
+	c := &testCommand{name: "restart", text: "restarted", approval: &testApproval{message: "Restart api?"},
+		actions: []common.Action{&testAction{name: "logs", label: "Logs"}}}
+	tm, s := newTestTeams(t, TeamsOptions{}, testProcessors("ops", c))
+
+	tm.processActivity(testTeamsActivity(s.URL, "u1", "ops restart"))
+
+	posts, _ := s.recorded()
+	if len(posts) != 1 || !strings.Contains(testTeamsCard(t, posts[0].activity), "Restart api?") {
+		t.Fatalf("expected approval card, got %d posts", len(posts))
+	}
+
+	tm.processActivity(testTeamsSubmit(s.URL, "u1", "a1", teamsApprovalButtonType, teamsApprovalSubmit, nil))
+	executed, _ := c.calls()
+	posts, _ = s.recorded()
+	if len(executed) != 0 || posts[len(posts)-1].activity.Text != "**Error:** Teams same user cannot approve its action" {
+		t.Fatalf("expected requester not to approve, got %v", executed)
+	}
+
+	tm.processActivity(testTeamsSubmit(s.URL, "u2", "a1", teamsApprovalButtonType, teamsApprovalSubmit, nil))
+	executed, _ = c.calls()
+	if len(executed) != 1 {
+		t.Fatalf("expected execution after approval, got %v", executed)
+	}
+	posts, updates := s.recorded()
+	card := testTeamsCard(t, updates[0].activity)
+	if len(updates) != 1 || !strings.Contains(card, "**Approve**: user-u2") || strings.Contains(card, "Action.Submit") {
+		t.Fatalf("expected approval card without buttons, got %s", card)
+	}
+	reply := posts[len(posts)-1]
+	if reply.activity.Text != "restarted" || reply.activity.ReplyToID != "origin" || !strings.Contains(testTeamsCard(t, reply.activity), `"title":"Logs"`) {
+		t.Fatalf("expected reply with actions after approval, got %v", reply.activity)
+	}
+
+	// reply actions run with params of approved command
+	tm.processActivity(testTeamsSubmit(s.URL, "u2", fmt.Sprintf("a%d", len(posts)+len(updates)), teamsActionButtonType, "logs", nil))
+	_, triggered := c.calls()
+	if strings.Join(triggered, ",") != "logs" {
+		t.Fatalf("unexpected actions %v", triggered)
+	}
+}
+
+func TestTeamsAttachments(t *testing.T) {
+
+	c := &testCommand{name: "graph", text: "latency", attachments: []*common.Attachment{
+		{Title: "latency.png", Type: common.AttachmentTypeImage, Data: []byte("\x89PNG\r\n\x1a\n")},
+		{Title: "query", Type: common.AttachmentTypeText, Data: []byte("rate(http_requests_total[5m])")},
+	}}
+	tm, s := newTestTeams(t, TeamsOptions{}, testProcessors("ops", c))
+
+	tm.processActivity(testTeamsActivity(s.URL, "u1", "ops graph"))
+
+	posts, _ := s.recorded()
+	if len(posts) != 1 {
+		t.Fatalf("expected reply, got %d posts", len(posts))
+	}
+	a := posts[0].activity
+	if len(a.Attachments) != 1 || a.Attachments[0].ContentType != "image/png" || !strings.HasPrefix(a.Attachments[0].ContentURL, "data:image/png;base64,") {
+		t.Fatalf("expected inline image, got %v", a.Attachments)
+	}
+	if a.Text != "latency\n\n**query**\n\n```\nrate(http_requests_total[5m])\n```" || a.TextFormat != teamsTextFormatMarkdown {
+		t.Fatalf("expected text attachment as code block, got %q", a.Text)
+	}
+}
@@ -1,70 +1,1654 @@
 package bot
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/devopsext/chatops/common"
 	sreCommon "github.com/devopsext/sre/common"
 	"github.com/devopsext/utils"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jellydator/ttlcache/v3"
 	"github.com/jinzhu/copier"
 )
 
 type TelegramOptions struct {
-	BotToken string
-	Debug    bool
-	Timeout  int
-	Offset   int
+	BotToken        string
+	Debug           bool
+	Timeout         int
+	Offset          int
+	DefaultCommand  string
+	UserPermissions string
+	ApprovalAny     bool
+
+	ReactionDoing    string
+	ReactionDone     string
+	ReactionFailed   string
+	ReactionForm     string
+	ReactionApproval string
+
+	ButtonSubmitCaption  string
+	ButtonCancelCaption  string
+	ButtonApproveCaption string
+	ButtonRejectCaption  string
+
+	FormSelected  string
+	FormInvalid   string
+	FormCancelled string
+
+	CacheTTL string
+}
+
+type TelegramMessageKey struct {
+	chatID    int64
+	messageID int
+	replyToID int
+}
+
+type TelegramUser struct {
+	id       string
+	name     string
+	timezone string
+	commands []string
+}
+
+type TelegramChannel struct {
+	id string
+}
+
+type TelegramMessage struct {
+	telegram  *Telegram
+	cmdText   string
+	cmd       common.Command
+	originKey *TelegramMessageKey
+	key       *TelegramMessageKey
+	user      *TelegramUser
+	caller    *TelegramUser
+	visible   bool
+	text      string
+	actions   []common.Action
+	params    common.ExecuteParams
+	fields    []common.Field
+}
+
+type TelegramForm struct {
+	message  *TelegramMessage
+	fields   []common.Field
+	params   common.ExecuteParams
+	current  int
+	prompt   *TelegramMessageKey
+	selected []string
+}
+
+type TelegramReaction struct {
+	Type  string `json:"type"`
+	Emoji string `json:"emoji"`
+}
+
+type Telegram struct {
+	options    TelegramOptions
+	processors *common.Processors
+	bot        *tgbotapi.BotAPI
+	logger     sreCommon.Logger
+	meter      sreCommon.Meter
+	messages   *ttlcache.Cache[string, *TelegramMessage]
+	reactions  *ttlcache.Cache[string, string]
+	forms      *ttlcache.Cache[string, *TelegramForm]
+}
+
+const (
+	telegramMaxTextLength      = 4096
+	telegramMaxCallbackData    = 64
+	telegramTrimmed            = "...trimmed"
+	telegramSetMessageReaction = "setMessageReaction"
+	telegramReactionTypeEmoji  = "emoji"
+	telegramActionButtonType   = "a"
+	telegramActionIDType       = "ai"
+	telegramApprovalButtonType = "p"
+	telegramApprovalSubmit     = "approve"
+	telegramApprovalCancel     = "reject"
+	telegramFormValueType      = "f"
+	telegramFormButtonType     = "fb"
+	telegramFormSubmit         = "submit"
+	telegramFormCancel         = "cancel"
+	telegramDateFormat         = "2006-01-02"
+	telegramTimeFormat         = "15:04"
+	telegramCommandPrefix      = "/"
+)
+
+// TelegramUser
+
+func (tu *TelegramUser) ID() string {
+	return tu.id
+}
+
+func (tu *TelegramUser) Name() string {
+	return tu.name
+}
+
+func (tu *TelegramUser) TimeZone() string {
+	return tu.timezone
+}
+
+func (tu *TelegramUser) Commands() []string {
+	return tu.commands
+}
+
+// TelegramChannel
+
+func (tc *TelegramChannel) ID() string {
+	return tc.id
+}
+
+// TelegramMessage
+
+func (tm *TelegramMessage) ID() string {
+	if tm.key == nil || tm.key.messageID == 0 {
+		return ""
+	}
+	return strconv.Itoa(tm.key.messageID)
+}
+
+func (tm *TelegramMessage) Visible() bool {
+	return tm.visible
+}
+
+func (tm *TelegramMessage) User() common.User {
+	return tm.user
+}
+
+func (tm *TelegramMessage) Caller() common.User {
+	return tm.caller
+}
+
+func (tm *TelegramMessage) userID() string {
+	u := tm.user
+	if u == nil {
+		return ""
+	}
+	return u.id
+}
+
+func (tm *TelegramMessage) Channel() common.Channel {
+	if tm.key == nil {
+		return nil
+	}
+	return &TelegramChannel{id: strconv.FormatInt(tm.key.chatID, 10)}
+}
+
+func (tm *TelegramMessage) ParentID() string {
+	if tm.key == nil || tm.key.replyToID == 0 {
+		return ""
+	}
+	return strconv.Itoa(tm.key.replyToID)
+}
+
+func (tm *TelegramMessage) SetParentID(parentID string) {
+	if tm.key == nil {
+		return
+	}
+	id, err := strconv.Atoi(parentID)
+	if err != nil {
+		return
+	}
+	tm.key.replyToID = id
+}
+
+// TelegramMessageKey
+
+func (tmk *TelegramMessageKey) String() string {
+	return fmt.Sprintf("%d/%d", tmk.chatID, tmk.messageID)
+}
+
+// Telegram
+
+func (t *Telegram) Name() string {
+	return "Telegram"
+}
+
+func (t *Telegram) parseChatID(channel string) (int64, error) {
+
+	id, err := strconv.ParseInt(strings.TrimSpace(channel), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Telegram invalid chat ID %s: %s", channel, err)
+	}
+	return id, nil
+}
+
+func (t *Telegram) parseKey(channel, ID string) (*TelegramMessageKey, error) {
+
+	chatID, err := t.parseChatID(channel)
+	if err != nil {
+		return nil, err
+	}
+	messageID, err := strconv.Atoi(strings.TrimSpace(ID))
+	if err != nil {
+		return nil, fmt.Errorf("Telegram invalid message ID %s: %s", ID, err)
+	}
+	return &TelegramMessageKey{chatID: chatID, messageID: messageID}, nil
+}
+
+func (t *Telegram) findMessageInCache(key *TelegramMessageKey) *TelegramMessage {
+
+	if key == nil {
+		return nil
+	}
+	item := t.messages.Get(key.String())
+	if item != nil {
+		return item.Value()
+	}
+	return nil
+}
+
+func (t *Telegram) putMessageToCache(msg *TelegramMessage) {
+
+	if msg.key == nil || msg.key.messageID == 0 {
+		return
+	}
+	t.messages.Set(msg.key.String(), msg, ttlcache.DefaultTTL)
+}
+
+func (t *Telegram) cloneMessage(m *TelegramMessage) *TelegramMessage {
+
+	if m == nil {
+		return nil
+	}
+	r := &TelegramMessage{}
+	err := copier.Copy(r, m)
+	if err != nil {
+		t.logger.Error("Telegram message copy error: %s", err)
+		return nil
+	}
+	return r
+}
+
+// actionID is a short stable ID of action name which doesn't fit into callback data
+func (t *Telegram) actionID(name string) string {
+
+	h := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(h[:16])
+}
+
+func (t *Telegram) encodeCallbackData(typ, name string) string {
+	return fmt.Sprintf("%s|%s", typ, name)
+}
+
+// encodeActionData keeps action name if it fits into callback data, otherwise its ID is used to be matched later
+func (t *Telegram) encodeActionData(name string) string {
+
+	r := t.encodeCallbackData(telegramActionButtonType, name)
+	if len(r) > telegramMaxCallbackData {
+		r = t.encodeCallbackData(telegramActionIDType, t.actionID(name))
+	}
+	return r
+}
+
+func (t *Telegram) decodeCallbackData(data string) (string, string) {
+
+	if utils.IsEmpty(data) {
+		return "", ""
+	}
+	arr := strings.SplitN(data, "|", 2)
+	if len(arr) < 2 {
+		return "", ""
+	}
+	return arr[0], arr[1]
+}
+
+func (t *Telegram) buildKeyboard(actions []common.Action) *tgbotapi.InlineKeyboardMarkup {
+
+	if len(actions) == 0 {
+		return nil
+	}
+
+	buttons := []tgbotapi.InlineKeyboardButton{}
+	for _, a := range actions {
+
+		aName := a.Name()
+		if utils.IsEmpty(aName) {
+			continue
+		}
+
+		label := aName
+		aLabel := a.Label()
+		if !utils.IsEmpty(aLabel) {
+			label = aLabel
+		}
+		data := t.encodeActionData(aName)
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(label, data))
+	}
+
+	if len(buttons) == 0 {
+		return nil
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(buttons...))
+	return &keyboard
+}
+
+func (t *Telegram) buildText(message string, attachments []*common.Attachment) string {
+
+	text := message
+	for _, a := range attachments {
+
+		switch a.Type {
+		case common.AttachmentTypeImage, common.AttachmentTypeFile:
+			continue
+		}
+
+		if !utils.IsEmpty(a.Title) {
+			text = fmt.Sprintf("%s\n\n%s", text, a.Title)
+		}
+		if !utils.IsEmpty(a.Data) {
+			text = fmt.Sprintf("%s\n%s", text, string(a.Data))
+		}
+	}
+	return common.LimitText(strings.TrimSpace(text), telegramMaxTextLength, telegramTrimmed)
+}
+
+func (t *Telegram) sendAttachments(chatID int64, replyToID int, attachments []*common.Attachment) error {
+
+	for _, a := range attachments {
+
+		stamp := time.Now().Format("20060102T150405")
+		file := tgbotapi.FileBytes{
+			Name:  fmt.Sprintf("%s-%s", t.bot.Self.UserName, stamp),
+			Bytes: a.Data,
+		}
+
+		var c tgbotapi.Chattable
+		switch a.Type {
+		case common.AttachmentTypeImage:
+			photo := tgbotapi.NewPhotoUpload(chatID, file)
+			photo.Caption = a.Title
+			photo.ReplyToMessageID = replyToID
+			c = photo
+		case common.AttachmentTypeFile:
+			doc := tgbotapi.NewDocumentUpload(chatID, file)
+			doc.Caption = a.Title
+			doc.ReplyToMessageID = replyToID
+			c = doc
+		default:
+			continue
+		}
+
+		_, err := t.bot.Send(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Telegram) send(chatID int64, replyToID int, message string, attachments []*common.Attachment, actions []common.Action) (*TelegramMessageKey, string, error) {
+
+	text := t.buildText(message, attachments)
+
+	if !utils.IsEmpty(text) {
+
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ReplyToMessageID = replyToID
+		msg.DisableWebPagePreview = true
+
+		keyboard := t.buildKeyboard(actions)
+		if keyboard != nil {
+			msg.ReplyMarkup = keyboard
+		}
+
+		sent, err := t.bot.Send(msg)
+		if err != nil {
+			return nil, "", err
+		}
+
+		err = t.sendAttachments(chatID, sent.MessageID, attachments)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return &TelegramMessageKey{
+			chatID:    chatID,
+			messageID: sent.MessageID,
+			replyToID: replyToID,
+		}, text, nil
+	}
+
+	err := t.sendAttachments(chatID, replyToID, attachments)
+	if err != nil {
+		return nil, "", err
+	}
+	return nil, "", nil
+}
+
+func (t *Telegram) reply(m *TelegramMessage, message string, attachments []*common.Attachment, actions []common.Action,
+	response *common.BotResponse, start *time.Time, error bool) (*TelegramMessageKey, string, error) {
+
+	if m.key == nil {
+		return nil, "", fmt.Errorf("Telegram message has no chat")
+	}
+
+	text := message
+	if !utils.IsEmpty(response) {
+
+		if response.Original() && !utils.IsEmpty(m.cmdText) {
+			user := ""
+			if m.user != nil && !utils.IsEmpty(m.user.name) {
+				user = fmt.Sprintf("@%s ", m.user.name)
+			}
+			text = fmt.Sprintf("> %s%s\n\n%s", user, m.cmdText, text)
+		}
+
+		if response.Duration() && start != nil && !error {
+			elapsed := time.Since(*start)
+			text = fmt.Sprintf("[%s] %s", elapsed.Round(time.Millisecond), text)
+		}
+	}
+
+	replyToID := m.key.replyToID
+	if replyToID == 0 {
+		replyToID = m.key.messageID
+	}
+
+	if error {
+		return t.send(m.key.chatID, replyToID, text, nil, nil)
+	}
+	return t.send(m.key.chatID, replyToID, text, attachments, actions)
+}
+
+func (t *Telegram) replyError(m *TelegramMessage, err error) {
+
+	t.logger.Error("Telegram reply error: %s", err)
+	_, _, err = t.reply(m, err.Error(), nil, nil, nil, nil, true)
+	if err != nil {
+		t.logger.Error("Telegram couldn't reply error: %s", err)
+	}
+}
+
+func (t *Telegram) setReaction(key *TelegramMessageKey, name string) error {
+
+	reactions := []*TelegramReaction{}
+	if !utils.IsEmpty(name) {
+		reactions = append(reactions, &TelegramReaction{Type: telegramReactionTypeEmoji, Emoji: name})
+	}
+
+	b, err := json.Marshal(reactions)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Add("chat_id", strconv.FormatInt(key.chatID, 10))
+	params.Add("message_id", strconv.Itoa(key.messageID))
+	params.Add("reaction", string(b))
+
+	_, err = t.bot.MakeRequest(telegramSetMessageReaction, params)
+	if err != nil {
+		return err
+	}
+
+	if utils.IsEmpty(name) {
+		t.reactions.Delete(key.String())
+	} else {
+		t.reactions.Set(key.String(), name, ttlcache.DefaultTTL)
+	}
+	return nil
+}
+
+// bots can keep only one reaction on a message, so removing is possible only for the current one
+func (t *Telegram) unsetReaction(key *TelegramMessageKey, name string) error {
+
+	item := t.reactions.Get(key.String())
+	if item == nil || item.Value() != name {
+		return nil
+	}
+	return t.setReaction(key, "")
+}
+
+func (t *Telegram) addReaction(key *TelegramMessageKey, name string) {
+
+	if key == nil || utils.IsEmpty(name) {
+		return
+	}
+	err := t.setReaction(key, name)
+	if err != nil {
+		t.logger.Error("Telegram adding reaction error: %s", err)
+	}
+}
+
+func (t *Telegram) removeReaction(key *TelegramMessageKey, name string) {
+
+	if key == nil || utils.IsEmpty(name) {
+		return
+	}
+	err := t.unsetReaction(key, name)
+	if err != nil {
+		t.logger.Error("Telegram removing reaction error: %s", err)
+	}
+}
+
+func (t *Telegram) AddReaction(channel, ID, name string) error {
+
+	key, err := t.parseKey(channel, ID)
+	if err != nil {
+		return err
+	}
+	err = t.setReaction(key, name)
+	if err != nil {
+		t.logger.Error("Telegram adding reaction error: %s", err)
+		return err
+	}
+	return nil
+}
+
+func (t *Telegram) RemoveReaction(channel, ID, name string) error {
+
+	key, err := t.parseKey(channel, ID)
+	if err != nil {
+		return err
+	}
+	err = t.unsetReaction(key, name)
+	if err != nil {
+		t.logger.Error("Telegram removing reaction error: %s", err)
+		return err
+	}
+	return nil
+}
+
+func (t *Telegram) updateActions(channel, ID string, update func(m *TelegramMessage) []common.Action) error {
+
+	key, err := t.parseKey(channel, ID)
+	if err != nil {
+		return err
+	}
+
+	m := t.findMessageInCache(key)
+	if m == nil {
+		err := fmt.Errorf("Telegram message not found in %s with %s", channel, ID)
+		t.logger.Error(err)
+		return err
+	}
+
+	m.actions = update(m)
+	t.putMessageToCache(m)
+
+	keyboard := t.buildKeyboard(m.actions)
+	if keyboard == nil {
+		keyboard = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	}
+
+	_, err = t.bot.Send(tgbotapi.NewEditMessageReplyMarkup(key.chatID, key.messageID, *keyboard))
+	return err
+}
+
+func (t *Telegram) AddAction(channel, ID string, action common.Action) error {
+
+	return t.updateActions(channel, ID, func(m *TelegramMessage) []common.Action {
+		return append(m.actions, action)
+	})
+}
+
+func (t *Telegram) AddActions(channel, ID string, actions []common.Action) error {
+
+	return t.updateActions(channel, ID, func(m *TelegramMessage) []common.Action {
+		return append(m.actions, actions...)
+	})
+}
+
+func (t *Telegram) RemoveAction(channel, ID, name string) error {
+
+	return t.updateActions(channel, ID, func(m *TelegramMessage) []common.Action {
+		actions := []common.Action{}
+		for _, a := range m.actions {
+			if a.Name() == name {
+				continue
+			}
+			actions = append(actions, a)
+		}
+		return actions
+	})
+}
+
+func (t *Telegram) ClearActions(channel, ID string) error {
+
+	return t.updateActions(channel, ID, func(m *TelegramMessage) []common.Action {
+		return nil
+	})
+}
+
+func (t *Telegram) DeleteMessage(channel, ID string) error {
+
+	key, err := t.parseKey(channel, ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.bot.DeleteMessage(tgbotapi.NewDeleteMessage(key.chatID, key.messageID))
+	if err != nil {
+		t.logger.Error("Failed to delete message: %s", err)
+		return err
+	}
+	t.messages.Delete(key.String())
+	return nil
+}
+
+// Bot API has no method to get a message by ID, so only cached messages can be read
+func (t *Telegram) ReadMessage(channel, ID string) (string, error) {
+
+	key, err := t.parseKey(channel, ID)
+	if err != nil {
+		return "", err
+	}
+
+	m := t.findMessageInCache(key)
+	if m == nil {
+		err := fmt.Errorf("message not found")
+		t.logger.Error("Failed to get message: %s", err)
+		return "", err
+	}
+	return m.text, nil
+}
+
+func (t *Telegram) UpdateMessage(channel, ID, message string) error {
+
+	key, err := t.parseKey(channel, ID)
+	if err != nil {
+		return err
+	}
+
+	edit := tgbotapi.NewEditMessageText(key.chatID, key.messageID, common.LimitText(message, telegramMaxTextLength, telegramTrimmed))
+	m := t.findMessageInCache(key)
+	if m != nil {
+		edit.ReplyMarkup = t.buildKeyboard(m.actions)
+	}
+
+	_, err = t.bot.Send(edit)
+	if err != nil {
+		t.logger.Error("Failed to update message: %s", err)
+		return err
+	}
+
+	if m != nil {
+		m.text = message
+		t.putMessageToCache(m)
+	}
+	return nil
+}
+
+func (t *Telegram) buildTelegramUser(user *tgbotapi.User) *TelegramUser {
+
+	if user == nil {
+		return nil
+	}
+
+	id := strconv.Itoa(user.ID)
+	name := user.UserName
+	if utils.IsEmpty(name) {
+		name = strings.TrimSpace(fmt.Sprintf("%s %s", user.FirstName, user.LastName))
+	}
+
+	u := &TelegramUser{
+		id:   id,
+		name: name,
+	}
+	commands, err := t.processors.UserCommands(t.options.UserPermissions, id, user.UserName)
+	if err != nil {
+		t.logger.Error("Telegram permissions error: %s", err)
+	}
+	u.commands = commands
+	return u
+}
+
+// /group command param1 => group command param1
+// /group@bot command param1 => group command param1
+// /alias param1 => group command param1
+func (t *Telegram) prepareInputText(m *tgbotapi.Message) string {
+
+	command := m.Command()
+	args := strings.TrimSpace(m.CommandArguments())
+
+	return t.processors.ReplaceAlias(strings.TrimSpace(fmt.Sprintf("%s %s", command, args)))
+}
+
+func (t *Telegram) getMessageChatID(m *TelegramMessage) int64 {
+
+	if m.cmd != nil {
+		channel := m.cmd.Channel()
+		if !utils.IsEmpty(channel) {
+			id, err := t.parseChatID(channel)
+			if err == nil {
+				return id
+			}
+			t.logger.Error(err)
+		}
+	}
+	return m.key.chatID
+}
+
+func (t *Telegram) cachePostUserCommand(m *TelegramMessage, params common.ExecuteParams, action common.Action, response common.Response, overwrite bool) error {
+
+	start := time.Now()
+	executor, message, attachments, actions, err := m.cmd.Execute(t, m, params, action)
+	if err != nil {
+		t.replyError(m, err)
+		return err
+	}
+	if action == nil {
+		actions = common.MergeActions(actions, m.cmd.Actions())
+	}
+
+	r := common.BuildResponse(overwrite, response, executor.Response())
+
+	var key *TelegramMessageKey
+	text := ""
+
+	if !utils.IsEmpty(message) || len(attachments) > 0 {
+
+		chatID := t.getMessageChatID(m)
+		mReply := m
+		if chatID != m.key.chatID {
+			mReply = t.cloneMessage(m)
+			mReply.key = &TelegramMessageKey{chatID: chatID}
+		}
+
+		k, txt, err := t.reply(mReply, message, attachments, actions, r, &start, r.Error())
+		if err != nil {
+			t.replyError(m, err)
+			return err
+		}
+		key = k
+		text = txt
+	}
+
+	mNew := t.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = key
+	// keep next messages in the same reply chain
+	if mNew.key != nil && mNew.key.replyToID == 0 {
+		mNew.key.replyToID = mNew.key.messageID
+	}
+	mNew.visible = r.Visible()
+	mNew.text = text
+	mNew.actions = actions
+	mNew.params = params
+
+	t.putMessageToCache(mNew)
+
+	if mNew.key == nil {
+		mNew.key = m.key
+	}
+	return executor.After(mNew)
+}
+
+func (t *Telegram) approvalNeeded(m *TelegramMessage, cmd common.Command, params common.ExecuteParams) (string, string) {
+
+	approval := cmd.Approval()
+	if approval == nil {
+		return "", ""
+	}
+
+	chl := strings.TrimSpace(approval.Channel(t, m, params))
+	if utils.IsEmpty(chl) {
+		chl = strconv.FormatInt(m.key.chatID, 10)
+	}
+
+	message := strings.TrimSpace(approval.Message(t, m, params))
+	if utils.IsEmpty(message) {
+		return "", chl
+	}
+	return message, chl
+}
+
+func (t *Telegram) cacheAskApproval(m *TelegramMessage, message, channel string, params common.ExecuteParams) error {
+
+	chatID, err := t.parseChatID(channel)
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, common.LimitText(message, telegramMaxTextLength, telegramTrimmed))
+	if chatID == m.key.chatID {
+		msg.ReplyToMessageID = m.key.messageID
+	}
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(t.options.ButtonApproveCaption, t.encodeCallbackData(telegramApprovalButtonType, telegramApprovalSubmit)),
+		tgbotapi.NewInlineKeyboardButtonData(t.options.ButtonRejectCaption, t.encodeCallbackData(telegramApprovalButtonType, telegramApprovalCancel)),
+	))
+
+	sent, err := t.bot.Send(msg)
+	if err != nil {
+		return err
+	}
+
+	mNew := t.cloneMessage(m)
+	mNew.originKey = m.key
+	mNew.key = &TelegramMessageKey{
+		chatID:    chatID,
+		messageID: sent.MessageID,
+	}
+	mNew.text = sent.Text
+	mNew.params = params
+
+	t.putMessageToCache(mNew)
+	return nil
+}
+
+func (t *Telegram) executeCommand(m *TelegramMessage, params common.ExecuteParams, action common.Action, reaction string) {
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	err := t.cachePostUserCommand(m, params, action, r, false)
+	if err != nil {
+		t.logger.Error("Telegram couldn't post from %s: %s", m.userID(), err)
+		t.addReaction(m.key, t.options.ReactionFailed)
+		return
+	}
+	t.removeReaction(m.key, reaction)
+	t.addReaction(m.key, t.options.ReactionDone)
+}
+
+func (t *Telegram) approveOrExecute(m *TelegramMessage, params common.ExecuteParams, reaction string) {
+
+	message, channel := t.approvalNeeded(m, m.cmd, params)
+	if !utils.IsEmpty(message) {
+		err := t.cacheAskApproval(m, message, channel, params)
+		if err != nil {
+			t.replyError(m, err)
+			t.addReaction(m.key, t.options.ReactionFailed)
+			return
+		}
+		t.addReaction(m.key, t.options.ReactionApproval)
+		return
+	}
+
+	t.addReaction(m.key, t.options.ReactionDoing)
+	t.executeCommand(m, params, nil, reaction)
+}
+
+func (t *Telegram) processCommand(m *TelegramMessage, params common.ExecuteParams) {
+
+	list := []string{common.FieldTypeSelect, common.FieldTypeMultiSelect, common.FieldTypeEdit}
+	only := common.FieldsByType(t, m.cmd, list)
+
+	fields := m.cmd.Fields(t, m, params, only)
+	m.fields = fields
+	m.params = params
+	t.putMessageToCache(m)
+
+	if common.FormNeeded(fields, params) {
+		err := t.startForm(m, fields, params)
+		if err != nil {
+			t.replyError(m, err)
+			t.addReaction(m.key, t.options.ReactionFailed)
+		}
+		return
+	}
+
+	params = common.FieldValues(fields, params)
+
+	t.approveOrExecute(m, params, t.options.ReactionDoing)
+}
+
+// TelegramForm
+
+func (t *Telegram) formKey(chatID int64, userID string) string {
+	return fmt.Sprintf("%d/%s", chatID, userID)
+}
+
+func (t *Telegram) fieldLabel(field common.Field) string {
+
+	if !utils.IsEmpty(field.Label) {
+		return field.Label
+	}
+	return field.Name
+}
+
+// check reply text against the field type, as Slack does with its typed inputs
+func (t *Telegram) fieldValueFromText(field common.Field, text string) (interface{}, error) {
+
+	v := strings.TrimSpace(text)
+	if utils.IsEmpty(v) {
+		return nil, fmt.Errorf("empty value")
+	}
+
+	switch field.Type {
+	case common.FieldTypeInteger:
+		if _, err := strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("%s is not an integer", v)
+		}
+	case common.FieldTypeFloat:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("%s is not a number", v)
+		}
+	case common.FieldTypeDate:
+		if _, err := time.Parse(telegramDateFormat, v); err != nil {
+			return nil, fmt.Errorf("%s is not a date in format %s", v, telegramDateFormat)
+		}
+	case common.FieldTypeTime:
+		if _, err := time.Parse(telegramTimeFormat, v); err != nil {
+			return nil, fmt.Errorf("%s is not a time in format %s", v, telegramTimeFormat)
+		}
+	case common.FieldTypeURL:
+		u, err := url.ParseRequestURI(v)
+		if err != nil || utils.IsEmpty(u.Scheme) || utils.IsEmpty(u.Host) {
+			return nil, fmt.Errorf("%s is not a URL", v)
+		}
+	case common.FieldTypeMultiSelect, common.FieldTypeDynamicMultiSelect,
+		common.FieldTypeMultiUser, common.FieldTypeMultiChannel, common.FieldTypeMultiGroup:
+		return common.RemoveEmptyStrings(strings.Split(v, ",")), nil
+	}
+	return v, nil
+}
+
+func (t *Telegram) fieldHasKeyboard(field common.Field) bool {
+
+	switch field.Type {
+	case common.FieldTypeSelect, common.FieldTypeMultiSelect, common.FieldTypeRadionButtons,
+		common.FieldTypeCheckboxes, common.FieldTypeBool:
+		return true
+	case common.FieldTypeDynamicSelect, common.FieldTypeDynamicMultiSelect:
+		return len(field.Values) > 0
+	}
+	return false
+}
+
+func (t *Telegram) fieldMultiple(field common.Field) bool {
+
+	switch field.Type {
+	case common.FieldTypeMultiSelect, common.FieldTypeDynamicMultiSelect, common.FieldTypeCheckboxes:
+		return true
+	}
+	return false
+}
+
+func (t *Telegram) fieldValues(field common.Field) []string {
+
+	if field.Type == common.FieldTypeBool {
+		return []string{fmt.Sprintf("%v", true), fmt.Sprintf("%v", false)}
+	}
+	return field.Values
+}
+
+func (t *Telegram) nextFormField(form *TelegramForm) int {
+
+	for i, f := range form.fields {
+		if !f.Required || f.Type == common.FieldTypeMarkdown {
+			continue
+		}
+		if utils.IsEmpty(form.params[f.Name]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *Telegram) formKeyboard(form *TelegramForm, field common.Field) tgbotapi.InlineKeyboardMarkup {
+
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+
+	if t.fieldHasKeyboard(field) {
+
+		multiple := t.fieldMultiple(field)
+		for i, v := range t.fieldValues(field) {
+
+			label := v
+			if multiple && utils.Contains(form.selected, v) {
+				label = fmt.Sprintf("%s %s", t.options.FormSelected, v)
+			}
+			data := t.encodeCallbackData(telegramFormValueType, strconv.Itoa(i))
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, data)))
+		}
+
+		if multiple {
+			submit := tgbotapi.NewInlineKeyboardButtonData(t.options.ButtonSubmitCaption, t.encodeCallbackData(telegramFormButtonType, telegramFormSubmit))
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(submit))
+		}
+	}
+
+	cancel := tgbotapi.NewInlineKeyboardButtonData(t.options.ButtonCancelCaption, t.encodeCallbackData(telegramFormButtonType, telegramFormCancel))
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(cancel))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-type Telegram struct {
-	options    TelegramOptions
-	processors *common.Processors
-	bot        *tgbotapi.BotAPI
-	logger     sreCommon.Logger
-	//metricer sre.MetricsCounter
+func (t *Telegram) formText(field common.Field) string {
+
+	text := t.fieldLabel(field)
+	if !utils.IsEmpty(field.Hint) {
+		text = fmt.Sprintf("%s\n%s", text, field.Hint)
+	}
+	return text
 }
 
-func (t *Telegram) Name() string {
-	return "Telegram"
+func (t *Telegram) askFormField(form *TelegramForm) error {
+
+	field := form.fields[form.current]
+	text := t.formText(field)
+	keyboard := t.formKeyboard(form, field)
+
+	if form.prompt != nil {
+		edit := tgbotapi.NewEditMessageText(form.prompt.chatID, form.prompt.messageID, text)
+		edit.ReplyMarkup = &keyboard
+		_, err := t.bot.Send(edit)
+		return err
+	}
+
+	m := form.message
+	msg := tgbotapi.NewMessage(m.key.chatID, text)
+	msg.ReplyToMessageID = m.key.messageID
+	msg.ReplyMarkup = keyboard
+
+	sent, err := t.bot.Send(msg)
+	if err != nil {
+		return err
+	}
+	form.prompt = &TelegramMessageKey{
+		chatID:    sent.Chat.ID,
+		messageID: sent.MessageID,
+		replyToID: m.key.messageID,
+	}
+	return nil
 }
 
-func (t *Telegram) sendTyping(m *tgbotapi.Message) {
+func (t *Telegram) closeFormPrompt(form *TelegramForm, text string) {
 
-	t.bot.Send(tgbotapi.NewChatAction(m.Chat.ID, tgbotapi.ChatTyping))
+	if form.prompt == nil {
+		return
+	}
+	edit := tgbotapi.NewEditMessageText(form.prompt.chatID, form.prompt.messageID, text)
+	_, err := t.bot.Send(edit)
+	if err != nil {
+		t.logger.Error("Telegram couldn't close form prompt: %s", err)
+	}
 }
 
-func (t *Telegram) sendMessage(m *tgbotapi.Message, text string) {
+func (t *Telegram) continueForm(form *TelegramForm) error {
 
-	if !utils.IsEmpty(text) {
-		response := tgbotapi.NewMessage(m.Chat.ID, text)
-		t.bot.Send(response)
+	m := form.message
+	next := t.nextFormField(form)
+	if next < 0 {
+		t.forms.Delete(t.formKey(m.key.chatID, m.userID()))
+		t.closeFormPrompt(form, t.formSummary(form))
+		t.finishForm(form)
+		return nil
+	}
+
+	// keep the prompt message when the same field is asked again
+	if next != form.current {
+		form.current = next
+		form.selected = []string{}
 	}
+	t.forms.Set(t.formKey(m.key.chatID, m.userID()), form, ttlcache.DefaultTTL)
+	return t.askFormField(form)
 }
 
-/*
-func (t *Telegram) processMessage(m *tgbotapi.Message) {
+func (t *Telegram) formSummary(form *TelegramForm) string {
 
-		command := m.Command()
-		executor := t.processors.Executor(command)
-		if executor == nil {
-			t.logger.Debug("Command %s is not found", command)
-			return
+	lines := []string{}
+	for _, f := range form.fields {
+		v := form.params[f.Name]
+		if utils.IsEmpty(v) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", t.fieldLabel(f), t.fieldValueToString(v)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (t *Telegram) fieldValueToString(value interface{}) string {
+
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func (t *Telegram) startForm(m *TelegramMessage, fields []common.Field, params common.ExecuteParams) error {
+
+	nParams := make(common.ExecuteParams)
+	for _, f := range fields {
+		if !utils.IsEmpty(f.Default) {
+			nParams[f.Name] = common.FieldValue(f, f.Default)
+		}
+	}
+	for k, v := range params {
+		if utils.IsEmpty(v) {
+			continue
+		}
+		nParams[k] = v
+	}
+
+	form := &TelegramForm{
+		message:  m,
+		fields:   fields,
+		params:   nParams,
+		current:  -1,
+		selected: []string{},
+	}
+
+	t.removeReaction(m.key, t.options.ReactionDoing)
+	t.addReaction(m.key, t.options.ReactionForm)
+	return t.continueForm(form)
+}
+
+// update fields which depend on the changed one, like Slack.handleFormField does
+func (t *Telegram) updateFormDependencies(form *TelegramForm, name string) {
+
+	deps := []string{}
+	for _, f := range form.fields {
+		if utils.Contains(f.Dependencies, name) {
+			deps = append(deps, f.Name)
+		}
+	}
+	if len(deps) == 0 {
+		return
+	}
+
+	depFields := form.message.cmd.Fields(t, form.message, form.params, deps)
+	for _, df := range depFields {
+		if !utils.Contains(deps, df.Name) {
+			continue
+		}
+		for i, f := range form.fields {
+			if f.Name != df.Name {
+				continue
+			}
+			form.fields[i] = df
+			if !utils.IsEmpty(df.Default) {
+				form.params[df.Name] = common.FieldValue(df, df.Default)
+			}
+		}
+	}
+}
+
+func (t *Telegram) setFormValue(form *TelegramForm, value interface{}) error {
+
+	field := form.fields[form.current]
+	form.params[field.Name] = value
+	t.updateFormDependencies(form, field.Name)
+	return t.continueForm(form)
+}
+
+func (t *Telegram) finishForm(form *TelegramForm) {
+
+	m := form.message
+	params := common.MergeInterfaceMaps(m.params, form.params)
+	m.params = params
+	t.putMessageToCache(m)
+
+	t.removeReaction(m.key, t.options.ReactionForm)
+	t.approveOrExecute(m, params, t.options.ReactionDoing)
+}
+
+func (t *Telegram) cancelForm(form *TelegramForm) {
+
+	m := form.message
+	t.forms.Delete(t.formKey(m.key.chatID, m.userID()))
+	t.closeFormPrompt(form, t.options.FormCancelled)
+	t.removeReaction(m.key, t.options.ReactionForm)
+	t.addReaction(m.key, t.options.ReactionFailed)
+}
+
+func (t *Telegram) handleFormButton(form *TelegramForm, typ, name string) error {
+
+	field := form.fields[form.current]
+
+	switch typ {
+	case telegramFormButtonType:
+
+		switch name {
+		case telegramFormSubmit:
+			if len(form.selected) == 0 {
+				return fmt.Errorf("%s", t.options.FormInvalid)
+			}
+			if field.Type == common.FieldTypeCheckboxes {
+				return t.setFormValue(form, strings.Join(form.selected, ","))
+			}
+			return t.setFormValue(form, form.selected)
+		default:
+			t.cancelForm(form)
+			return nil
+		}
+
+	case telegramFormValueType:
+
+		values := t.fieldValues(field)
+		idx, err := strconv.Atoi(name)
+		if err != nil || idx < 0 || idx >= len(values) {
+			return fmt.Errorf("%s", t.options.FormInvalid)
+		}
+		v := values[idx]
+
+		if !t.fieldMultiple(field) {
+			return t.setFormValue(form, v)
+		}
+
+		selected := []string{}
+		for _, s := range form.selected {
+			if s != v {
+				selected = append(selected, s)
+			}
+		}
+		if len(selected) == len(form.selected) {
+			selected = append(selected, v)
+		}
+		form.selected = selected
+		t.forms.Set(t.formKey(form.message.key.chatID, form.message.userID()), form, ttlcache.DefaultTTL)
+		return t.askFormField(form)
+	}
+	return nil
+}
+
+func (t *Telegram) findForm(chatID int64, userID string) *TelegramForm {
+
+	item := t.forms.Get(t.formKey(chatID, userID))
+	if item == nil {
+		return nil
+	}
+	return item.Value()
+}
+
+func (t *Telegram) processFormCallback(query *tgbotapi.CallbackQuery, typ, name string) error {
+
+	form := t.findForm(query.Message.Chat.ID, strconv.Itoa(query.From.ID))
+	if form == nil || form.prompt == nil || form.prompt.messageID != query.Message.MessageID {
+		return fmt.Errorf("Telegram form is not found")
+	}
+	return t.handleFormButton(form, typ, name)
+}
+
+// reply to a text prompt of the form which is waiting for the user
+func (t *Telegram) processFormText(msg *tgbotapi.Message) bool {
+
+	if msg.From == nil {
+		return false
+	}
+
+	form := t.findForm(msg.Chat.ID, strconv.Itoa(msg.From.ID))
+	if form == nil || form.current < 0 {
+		return false
+	}
+
+	field := form.fields[form.current]
+	if t.fieldHasKeyboard(field) {
+		return false
+	}
+
+	v, err := t.fieldValueFromText(field, msg.Text)
+	if err != nil {
+		reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("%s: %s", t.options.FormInvalid, err))
+		reply.ReplyToMessageID = msg.MessageID
+		_, err = t.bot.Send(reply)
+		if err != nil {
+			t.logger.Error("Telegram couldn't reply to form: %s", err)
+		}
+		return true
+	}
+
+	err = t.setFormValue(form, v)
+	if err != nil {
+		t.logger.Error("Telegram couldn't continue form: %s", err)
+	}
+	return true
+}
+
+func (t *Telegram) processMessage(msg *tgbotapi.Message) {
+
+	key := &TelegramMessageKey{
+		chatID:    msg.Chat.ID,
+		messageID: msg.MessageID,
+	}
+	if msg.ReplyToMessage != nil {
+		key.replyToID = msg.ReplyToMessage.MessageID
+	}
+
+	u := t.buildTelegramUser(msg.From)
+	if u == nil {
+		t.logger.Error("Telegram couldn't process command from unknown user")
+		return
+	}
+
+	text := t.prepareInputText(msg)
+	params, cmd, group, _, _, _, pErr := t.processors.FindParams(false, text)
+
+	if cmd == nil && !utils.IsEmpty(t.options.DefaultCommand) {
+		cmd = t.processors.FindCommand("", t.options.DefaultCommand)
+		group = ""
+		params = make(common.ExecuteParams)
+	}
+
+	if cmd == nil {
+		params, cmd = t.processors.FindSuggest(text)
+		group = ""
+	}
+
+	if cmd == nil {
+		t.logger.Debug("Telegram command not found for text: %s", text)
+		common.UpdateCounters(t.meter, "telegram", "", "", text, u.id)
+		return
+	}
+
+	common.UpdateCounters(t.meter, "telegram", group, cmd.Name(), text, u.id)
+
+	groupName := common.GroupName(group, cmd)
+	if !common.Permitted(u, cmd, groupName) {
+		t.logger.Error("Telegram user %s is not permitted to execute %s", u.id, groupName)
+		return
+	}
+
+	t.sendTyping(msg.Chat.ID)
+
+	m := &TelegramMessage{
+		telegram: t,
+		cmdText:  msg.Text,
+		cmd:      cmd,
+		key:      key,
+		user:     u,
+		caller:   u,
+		visible:  true,
+		text:     msg.Text,
+	}
+
+	// args couldn't be parsed, so usage is replied before form or approval
+	if pErr != nil {
+		t.replyError(m, pErr)
+		t.addReaction(m.key, t.options.ReactionFailed)
+		return
+	}
+	t.processCommand(m, params)
+}
+
+func (t *Telegram) handleActionButton(m *TelegramMessage, caller *TelegramUser, typ, name string) error {
+
+	if m.cmd == nil {
+		return fmt.Errorf("Telegram message has no command")
+	}
+
+	var action common.Action
+	for _, a := range m.actions {
+		if (typ == telegramActionButtonType && a.Name() == name) ||
+			(typ == telegramActionIDType && t.actionID(a.Name()) == name) {
+			action = a
+			break
 		}
+	}
+
+	if action == nil {
+		return fmt.Errorf("Telegram action %s is not defined", name)
+	}
+
+	mAction := t.cloneMessage(m)
+	mAction.caller = caller
+	mAction.cmdText = ""
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	return t.cachePostUserCommand(mAction, m.params, action, r, true)
+}
+
+func (t *Telegram) handleApprovalButton(m *TelegramMessage, caller *TelegramUser, name string) error {
+
+	if m.cmd == nil || m.originKey == nil {
+		return fmt.Errorf("Telegram approval has no command")
+	}
+
+	if !t.options.ApprovalAny && caller.id == m.userID() {
+		return fmt.Errorf("Telegram same user cannot approve its action")
+	}
+
+	// remove buttons to avoid double approval
+	_, err := t.bot.Send(tgbotapi.NewEditMessageReplyMarkup(m.key.chatID, m.key.messageID,
+		tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+	if err != nil {
+		t.logger.Error("Telegram couldn't update approval message: %s", err)
+	}
+
+	mInit := t.cloneMessage(m)
+	mInit.key = m.originKey
+	mInit.originKey = nil
+
+	if name != telegramApprovalSubmit {
+		t.removeReaction(mInit.key, t.options.ReactionApproval)
+		t.addReaction(mInit.key, t.options.ReactionFailed)
+		return nil
+	}
+
+	t.removeReaction(mInit.key, t.options.ReactionApproval)
+	t.addReaction(mInit.key, t.options.ReactionDoing)
+	t.executeCommand(mInit, m.params, nil, t.options.ReactionDoing)
+	return nil
+}
 
-		t.sendTyping(m)
+func (t *Telegram) processCallback(query *tgbotapi.CallbackQuery) {
 
-		_, err := executor.Execute(t, command, "", m.CommandArguments(), func(text string) bool {
+	answer := ""
+	defer func() {
+		_, err := t.bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, answer))
+		if err != nil {
+			t.logger.Error("Telegram couldn't answer callback: %s", err)
+		}
+	}()
 
-			t.sendMessage(m, text)
-			return true
-		})
+	typ, name := t.decodeCallbackData(query.Data)
+	if utils.IsEmpty(name) {
+		t.logger.Error("Telegram callback name is empty.")
+		return
+	}
 
+	switch typ {
+	case telegramFormValueType, telegramFormButtonType:
+		err := t.processFormCallback(query, typ, name)
 		if err != nil {
 			t.logger.Error(err)
+			answer = err.Error()
+		}
+		return
+	}
+
+	key := &TelegramMessageKey{
+		chatID:    query.Message.Chat.ID,
+		messageID: query.Message.MessageID,
+	}
+
+	m := t.findMessageInCache(key)
+	if m == nil {
+		t.logger.Error("Telegram message is not found in cache.")
+		return
+	}
+
+	caller := t.buildTelegramUser(query.From)
+	if caller == nil {
+		t.logger.Error("Telegram couldn't process callback from unknown user")
+		return
+	}
+
+	var err error
+	switch typ {
+	case telegramActionButtonType, telegramActionIDType:
+		err = t.handleActionButton(m, caller, typ, name)
+	case telegramApprovalButtonType:
+		err = t.handleApprovalButton(m, caller, name)
+	}
+
+	if err != nil {
+		t.logger.Error(err)
+		answer = err.Error()
+	}
+}
+
+func (t *Telegram) sendTyping(chatID int64) {
+
+	_, err := t.bot.Send(tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping))
+	if err != nil {
+		t.logger.Debug("Telegram couldn't send typing: %s", err)
+	}
+}
+
+// this method primarily used in custom command executions
+func (t *Telegram) Command(channel, text string, user common.User, parent common.Message, response common.Response) error {
+
+	r := common.BuildResponse(true, response)
+
+	var mOrigin *TelegramMessage
+	if !utils.IsEmpty(parent) {
+		m, ok := parent.(*TelegramMessage)
+		if ok {
+			mOrigin = m
+			if m.key != nil {
+				if mc := t.findMessageInCache(m.key); mc != nil {
+					mOrigin = mc
+				}
+			}
+			if m.cmd != nil {
+				r = common.BuildResponse(false, m.cmd.Response(), response)
+			}
+		}
+	}
+
+	var mUser *TelegramUser
+	userID := "unknown"
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*TelegramUser)
+		if ok {
+			mUser = u
+		}
+		userID = user.ID()
+	}
+
+	fText := strings.TrimPrefix(strings.TrimSpace(text), telegramCommandPrefix)
+	params, cmd, group, _, _, _, pErr := t.processors.FindParams(false, fText)
+	if cmd == nil {
+		t.logger.Debug("Telegram command not found for text: %s", text)
+		return nil
+	}
+
+	groupName := common.GroupName(group, cmd)
+	if !utils.IsEmpty(user) && !utils.Contains(user.Commands(), groupName) {
+		t.logger.Debug("Telegram command user %s is not permitted to execute %s", userID, groupName)
+		return nil
+	}
+
+	if pErr != nil {
+		return pErr
+	}
+
+	fields := cmd.Fields(t, parent, params, nil)
+	if common.FormNeeded(fields, params) {
+		t.logger.Debug("Telegram command %s has no support for interaction mode", groupName)
+		return nil
+	}
+
+	key := &TelegramMessageKey{}
+	if mOrigin != nil && mOrigin.key != nil {
+		key.chatID = mOrigin.key.chatID
+		key.replyToID = mOrigin.key.replyToID
+	}
+	if !utils.IsEmpty(channel) {
+		chatID, err := t.parseChatID(channel)
+		if err != nil {
+			return err
+		}
+		if chatID != key.chatID {
+			key.replyToID = 0
+		}
+		key.chatID = chatID
+	}
+
+	var m *TelegramMessage
+	if mOrigin != nil {
+		m = t.cloneMessage(mOrigin)
+		m.originKey = mOrigin.key
+	} else {
+		m = &TelegramMessage{
+			telegram: t,
+			user:     mUser,
+			caller:   mUser,
+		}
+	}
+	m.cmdText = fText
+	m.cmd = cmd
+	m.key = key
+	m.fields = fields
+	m.params = params
+
+	err := t.cachePostUserCommand(m, params, nil, r, true)
+	if err != nil {
+		t.logger.Error("Telegram command %s couldn't post from %s: %s", groupName, userID, err)
+		return err
+	}
+	return nil
+}
+
+func (t *Telegram) PostMessage(channel string, message string, attachments []*common.Attachment, actions []common.Action,
+	user common.User, parent common.Message, response common.Response) (string, error) {
+
+	r := common.BuildResponse(true, response)
+
+	var mOrigin *TelegramMessage
+	replyToID := 0
+	if !utils.IsEmpty(parent) {
+		m, ok := parent.(*TelegramMessage)
+		if ok {
+			mOrigin = m
+			if m.key != nil {
+				if mc := t.findMessageInCache(m.key); mc != nil {
+					mOrigin = mc
+				}
+				replyToID = m.key.replyToID
+				if utils.IsEmpty(channel) {
+					channel = strconv.FormatInt(m.key.chatID, 10)
+				}
+			}
+		}
+	}
+
+	chatID, err := t.parseChatID(channel)
+	if err != nil {
+		return "", err
+	}
+	if mOrigin != nil && mOrigin.key != nil && mOrigin.key.chatID != chatID {
+		replyToID = 0
+	}
+
+	key, text, err := t.send(chatID, replyToID, message, attachments, actions)
+	if err != nil {
+		return "", err
+	}
+	if key == nil {
+		return "", nil
+	}
+
+	var mUser *TelegramUser
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*TelegramUser)
+		if ok {
+			mUser = u
+		}
+	}
+
+	var m *TelegramMessage
+	if mOrigin != nil {
+		m = t.cloneMessage(mOrigin)
+		m.originKey = mOrigin.key
+	} else {
+		m = &TelegramMessage{
+			telegram: t,
+			user:     mUser,
+			caller:   mUser,
 		}
 	}
-*/
+	m.key = key
+	m.visible = r.Visible()
+	m.text = text
+	m.actions = actions
+	t.putMessageToCache(m)
+
+	return strconv.Itoa(key.messageID), nil
+}
+
 func (t *Telegram) start() {
 
 	bot, err := tgbotapi.NewBotAPI(t.options.BotToken)
@@ -87,6 +1671,17 @@ func (t *Telegram) start() {
 	}
 
 	for update := range updates {
+		if update.Message != nil && !update.Message.IsCommand() && !utils.IsEmpty(update.Message.Text) {
+
+			m := tgbotapi.Message{}
+			copier.Copy(&m, update.Message)
+
+			wg.Add(1)
+			go func(m *tgbotapi.Message) {
+				defer wg.Done()
+				t.processFormText(m)
+			}(&m)
+		}
 		if update.Message != nil && update.Message.IsCommand() {
 			t.logger.Debug("Message: [%s] %s", update.Message.From.UserName, update.Message.Text)
 
@@ -96,13 +1691,23 @@ func (t *Telegram) start() {
 			wg.Add(1)
 			go func(m *tgbotapi.Message) {
 				defer wg.Done()
-				//t.processMessage(update.Message)
+				t.processMessage(m)
 			}(&m)
 		}
 		if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
-			t.logger.Debug("Callback: [%s] %s", update.CallbackQuery.Message.From.UserName, update.CallbackQuery.Message.Text)
+			t.logger.Debug("Callback: [%s] %s", update.CallbackQuery.From.UserName, update.CallbackQuery.Data)
+
+			q := tgbotapi.CallbackQuery{}
+			copier.Copy(&q, update.CallbackQuery)
+
+			wg.Add(1)
+			go func(q *tgbotapi.CallbackQuery) {
+				defer wg.Done()
+				t.processCallback(q)
+			}(&q)
 		}
 	}
+	wg.Wait()
 }
 
 func (t *Telegram) Start(wg *sync.WaitGroup) {
@@ -123,10 +1728,31 @@ func (t *Telegram) Start(wg *sync.WaitGroup) {
 
 func NewTelegram(options TelegramOptions, observability *common.Observability, processors *common.Processors) *Telegram {
 
+	if utils.IsEmpty(options.BotToken) {
+		return nil
+	}
+
+	ttl := 1 * 60 * 60 * time.Second
+	if !utils.IsEmpty(options.CacheTTL) {
+		ttl, _ = time.ParseDuration(options.CacheTTL)
+	}
+
+	messages := ttlcache.New[string, *TelegramMessage](ttlcache.WithTTL[string, *TelegramMessage](ttl))
+	go messages.Start()
+
+	reactions := ttlcache.New[string, string](ttlcache.WithTTL[string, string](ttl))
+	go reactions.Start()
+
+	forms := ttlcache.New[string, *TelegramForm](ttlcache.WithTTL[string, *TelegramForm](ttl))
+	go forms.Start()
+
 	return &Telegram{
 		options:    options,
 		processors: processors,
 		logger:     observability.Logs(),
-		//metrics: observability.Metrics().Counter(),
+		meter:      observability.Metrics(),
+		messages:   messages,
+		reactions:  reactions,
+		forms:      forms,
 	}
 }
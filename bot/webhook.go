@@ -0,0 +1,896 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/jinzhu/copier"
+)
+
+type WebhookOptions struct {
+	Listen          string
+	Path            string
+	Token           string
+	TokenPath       string
+	TextPath        string
+	UserIDPath      string
+	UserNamePath    string
+	ChannelPath     string
+	ThreadPath      string
+	MessageIDPath   string
+	Prefix          string
+	ReplyField      string
+	Async           bool
+	IncomingURL     string
+	ChannelField    string
+	ThreadField     string
+	Timeout         int
+	Insecure        bool
+	Debug           bool
+	DefaultCommand  string
+	UserPermissions string
+	CacheTTL        string
+}
+
+type WebhookUser struct {
+	id       string
+	name     string
+	timezone string
+	commands []string
+}
+
+type WebhookChannel struct {
+	id string
+}
+
+// collects replies while the request waits for them
+type WebhookResult struct {
+	mutex  *sync.Mutex
+	texts  []string
+	closed bool
+}
+
+type WebhookMessage struct {
+	webhook   *Webhook
+	id        string
+	threadID  string
+	channelID string
+	cmdText   string
+	cmd       common.Command
+	user      *WebhookUser
+	caller    *WebhookUser
+	visible   bool
+	text      string
+	actions   []common.Action
+	params    common.ExecuteParams
+	fields    []common.Field
+	result    *WebhookResult
+}
+
+type Webhook struct {
+	options    WebhookOptions
+	processors *common.Processors
+	client     *http.Client
+	logger     sreCommon.Logger
+	meter      sreCommon.Meter
+	messages   *ttlcache.Cache[string, *WebhookMessage]
+}
+
+const (
+	webhookMaxTextLength = 10000
+	webhookTrimmed       = "...trimmed"
+	webhookPathDelimiter = "."
+)
+
+// WebhookUser
+
+func (wu *WebhookUser) ID() string {
+	return wu.id
+}
+
+func (wu *WebhookUser) Name() string {
+	return wu.name
+}
+
+func (wu *WebhookUser) TimeZone() string {
+	return wu.timezone
+}
+
+func (wu *WebhookUser) Commands() []string {
+	return wu.commands
+}
+
+// WebhookChannel
+
+func (wc *WebhookChannel) ID() string {
+	return wc.id
+}
+
+// WebhookMessage
+
+func (wm *WebhookMessage) ID() string {
+	return wm.id
+}
+
+func (wm *WebhookMessage) Visible() bool {
+	return wm.visible
+}
+
+func (wm *WebhookMessage) User() common.User {
+	return wm.user
+}
+
+func (wm *WebhookMessage) Caller() common.User {
+	return wm.caller
+}
+
+func (wm *WebhookMessage) userID() string {
+	u := wm.user
+	if u == nil {
+		return ""
+	}
+	return u.id
+}
+
+func (wm *WebhookMessage) Channel() common.Channel {
+	return &WebhookChannel{id: wm.channelID}
+}
+
+func (wm *WebhookMessage) ParentID() string {
+	return wm.threadID
+}
+
+func (wm *WebhookMessage) SetParentID(threadID string) {
+	wm.threadID = threadID
+}
+
+// WebhookResult
+
+// returns false when the request is already answered
+func (wr *WebhookResult) add(text string) bool {
+
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+
+	if wr.closed {
+		return false
+	}
+	wr.texts = append(wr.texts, text)
+	return true
+}
+
+func (wr *WebhookResult) close() string {
+
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+
+	wr.closed = true
+	return strings.Join(wr.texts, "\n\n")
+}
+
+// Webhook
+
+func (w *Webhook) Name() string {
+	return "Webhook"
+}
+
+func (w *Webhook) findMessageInCache(ID string) *WebhookMessage {
+
+	item := w.messages.Get(ID)
+	if item != nil {
+		return item.Value()
+	}
+	return nil
+}
+
+func (w *Webhook) putMessageToCache(m *WebhookMessage) {
+
+	if utils.IsEmpty(m.id) {
+		return
+	}
+	w.messages.Set(m.id, m, ttlcache.DefaultTTL)
+}
+
+func (w *Webhook) cloneMessage(m *WebhookMessage) *WebhookMessage {
+
+	if m == nil {
+		return nil
+	}
+	r := &WebhookMessage{}
+	err := copier.Copy(r, m)
+	if err != nil {
+		w.logger.Error("Webhook message copy error: %s", err)
+		return nil
+	}
+	return r
+}
+
+// message.sender.id => value of the nested field, arrays are indexed by numbers
+func (w *Webhook) valueByPath(obj interface{}, path string) string {
+
+	if utils.IsEmpty(path) {
+		return ""
+	}
+
+	v := obj
+	for _, key := range strings.Split(path, webhookPathDelimiter) {
+		switch o := v.(type) {
+		case map[string]interface{}:
+			v = o[key]
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(o) {
+				return ""
+			}
+			v = o[idx]
+		default:
+			return ""
+		}
+	}
+
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func (w *Webhook) buildText(message string, attachments []*common.Attachment) string {
+
+	text := message
+	for _, a := range attachments {
+
+		switch a.Type {
+		case common.AttachmentTypeImage, common.AttachmentTypeFile:
+			if !utils.IsEmpty(a.Title) {
+				text = fmt.Sprintf("%s\n%s", text, a.Title)
+			}
+			continue
+		}
+		if !utils.IsEmpty(a.Title) {
+			text = fmt.Sprintf("%s\n%s", text, a.Title)
+		}
+		text = fmt.Sprintf("%s\n```\n%s\n```", text, string(a.Data))
+	}
+	return common.LimitText(strings.TrimSpace(text), webhookMaxTextLength, webhookTrimmed)
+}
+
+func (w *Webhook) sendIncoming(channel, threadID, text string) error {
+
+	if utils.IsEmpty(w.options.IncomingURL) {
+		return fmt.Errorf("Webhook incoming URL is not defined")
+	}
+
+	payload := make(map[string]interface{})
+	payload[w.options.ReplyField] = text
+	if !utils.IsEmpty(w.options.ChannelField) && !utils.IsEmpty(channel) {
+		payload[w.options.ChannelField] = channel
+	}
+	if !utils.IsEmpty(w.options.ThreadField) && !utils.IsEmpty(threadID) {
+		payload[w.options.ThreadField] = threadID
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	headers := make(map[string]string)
+	headers["Content-Type"] = "application/json"
+
+	res, err := utils.HttpRequestRawWithHeaders(w.client, http.MethodPost, w.options.IncomingURL, headers, b)
+	if err != nil {
+		return fmt.Errorf("Webhook incoming error: %s %s", err, string(res))
+	}
+	return nil
+}
+
+// reply within the request when it waits, otherwise through the incoming webhook
+func (w *Webhook) send(m *WebhookMessage, text string) (string, error) {
+
+	if utils.IsEmpty(text) {
+		return "", nil
+	}
+
+	ID := common.UUID()
+	if m.result != nil && m.result.add(text) {
+		return ID, nil
+	}
+	return ID, w.sendIncoming(m.channelID, m.threadID, text)
+}
+
+func (w *Webhook) reply(m *WebhookMessage, message string, attachments []*common.Attachment,
+	response *common.BotResponse, start *time.Time, error bool) (string, error) {
+
+	text := message
+	if !utils.IsEmpty(response) {
+
+		if response.Original() && !utils.IsEmpty(m.cmdText) {
+			text = fmt.Sprintf("> %s\n\n%s", m.cmdText, text)
+		}
+
+		if response.Duration() && start != nil && !error {
+			elapsed := time.Since(*start)
+			text = fmt.Sprintf("[%s] %s", elapsed.Round(time.Millisecond), text)
+		}
+	}
+
+	if error {
+		attachments = nil
+	}
+	return w.send(m, w.buildText(text, attachments))
+}
+
+func (w *Webhook) replyError(m *WebhookMessage, err error) {
+
+	w.logger.Error("Webhook reply error: %s", err)
+	_, err = w.reply(m, err.Error(), nil, nil, nil, true)
+	if err != nil {
+		w.logger.Error("Webhook couldn't reply error: %s", err)
+	}
+}
+
+// webhooks have no way to react on messages
+func (w *Webhook) AddReaction(channel, ID, name string) error {
+	return nil
+}
+
+func (w *Webhook) RemoveReaction(channel, ID, name string) error {
+	return nil
+}
+
+func (w *Webhook) updateActions(channel, ID string, update func(m *WebhookMessage) []common.Action) error {
+
+	m := w.findMessageInCache(ID)
+	if m == nil {
+		err := fmt.Errorf("Webhook message not found with %s", ID)
+		w.logger.Error(err)
+		return err
+	}
+
+	m.actions = update(m)
+	w.putMessageToCache(m)
+	return nil
+}
+
+func (w *Webhook) AddAction(channel, ID string, action common.Action) error {
+
+	return w.updateActions(channel, ID, func(m *WebhookMessage) []common.Action {
+		return append(m.actions, action)
+	})
+}
+
+func (w *Webhook) AddActions(channel, ID string, actions []common.Action) error {
+
+	return w.updateActions(channel, ID, func(m *WebhookMessage) []common.Action {
+		return append(m.actions, actions...)
+	})
+}
+
+func (w *Webhook) RemoveAction(channel, ID, name string) error {
+
+	return w.updateActions(channel, ID, func(m *WebhookMessage) []common.Action {
+		actions := []common.Action{}
+		for _, a := range m.actions {
+			if a.Name() == name {
+				continue
+			}
+			actions = append(actions, a)
+		}
+		return actions
+	})
+}
+
+func (w *Webhook) ClearActions(channel, ID string) error {
+
+	return w.updateActions(channel, ID, func(m *WebhookMessage) []common.Action {
+		return nil
+	})
+}
+
+func (w *Webhook) DeleteMessage(channel, ID string) error {
+
+	w.messages.Delete(ID)
+	return nil
+}
+
+func (w *Webhook) ReadMessage(channel, ID string) (string, error) {
+
+	m := w.findMessageInCache(ID)
+	if m == nil {
+		return "", fmt.Errorf("Webhook message not found with %s", ID)
+	}
+	return m.text, nil
+}
+
+func (w *Webhook) UpdateMessage(channel, ID, message string) error {
+
+	m := w.findMessageInCache(ID)
+	if m == nil {
+		return fmt.Errorf("Webhook message not found with %s", ID)
+	}
+	m.text = message
+	w.putMessageToCache(m)
+	return nil
+}
+
+func (w *Webhook) buildWebhookUser(userID, userName string) *WebhookUser {
+
+	if utils.IsEmpty(userID) {
+		userID = userName
+	}
+	if utils.IsEmpty(userName) {
+		userName = userID
+	}
+	if utils.IsEmpty(userID) {
+		return nil
+	}
+
+	u := &WebhookUser{
+		id:   userID,
+		name: userName,
+	}
+	commands, err := w.processors.UserCommands(w.options.UserPermissions, userID, userName)
+	if err != nil {
+		w.logger.Error("Webhook permissions error: %s", err)
+	}
+	u.commands = commands
+	return u
+}
+
+// !chatops group command param1 => group command param1
+func (w *Webhook) prepareInputText(text string) string {
+
+	text = strings.TrimSpace(text)
+	if !utils.IsEmpty(w.options.Prefix) {
+		text = strings.TrimSpace(strings.TrimPrefix(text, w.options.Prefix))
+	}
+	text = strings.TrimPrefix(text, "/")
+
+	return w.processors.ReplaceAlias(text)
+}
+
+func (w *Webhook) missingFields(fields []common.Field, params common.ExecuteParams) []string {
+
+	r := []string{}
+	for _, f := range fields {
+		if !f.Required || f.Type == common.FieldTypeMarkdown {
+			continue
+		}
+		if params == nil || utils.IsEmpty(params[f.Name]) {
+			r = append(r, f.Name)
+		}
+	}
+	return r
+}
+
+func (w *Webhook) cachePostUserCommand(m *WebhookMessage, params common.ExecuteParams, action common.Action, response common.Response, overwrite bool) error {
+
+	start := time.Now()
+	executor, message, attachments, actions, err := m.cmd.Execute(w, m, params, action)
+	if err != nil {
+		w.replyError(m, err)
+		return err
+	}
+	if action == nil {
+		actions = common.MergeActions(actions, m.cmd.Actions())
+	}
+
+	r := common.BuildResponse(overwrite, response, executor.Response())
+
+	mNew := w.cloneMessage(m)
+	mNew.visible = r.Visible()
+	mNew.text = message
+	mNew.actions = actions
+	mNew.params = params
+
+	if !utils.IsEmpty(message) || len(attachments) > 0 {
+		ID, err := w.reply(m, message, attachments, r, &start, r.Error())
+		if err != nil {
+			w.logger.Error("Webhook couldn't reply: %s", err)
+			return err
+		}
+		mNew.id = ID
+		w.putMessageToCache(mNew)
+	}
+	return executor.After(mNew)
+}
+
+func (w *Webhook) processCommand(m *WebhookMessage, params common.ExecuteParams) {
+
+	fields := m.cmd.Fields(w, m, params, nil)
+	m.fields = fields
+	m.params = params
+	w.putMessageToCache(m)
+
+	missing := w.missingFields(fields, params)
+	if len(missing) > 0 {
+		w.replyError(m, fmt.Errorf("Webhook command %s requires fields: %s", m.cmd.Name(), strings.Join(missing, ", ")))
+		return
+	}
+
+	// approvals have no buttons to be answered with
+	approval := m.cmd.Approval()
+	if approval != nil && !utils.IsEmpty(strings.TrimSpace(approval.Message(w, m, params))) {
+		w.replyError(m, fmt.Errorf("Webhook command %s requires approval", m.cmd.Name()))
+		return
+	}
+
+	r := common.BuildResponse(false, m.cmd.Response())
+	err := w.cachePostUserCommand(m, params, nil, r, false)
+	if err != nil {
+		w.logger.Error("Webhook couldn't post from %s: %s", m.userID(), err)
+	}
+}
+
+func (w *Webhook) processText(m *WebhookMessage, text string) {
+
+	u := m.user
+	fText := w.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := w.processors.FindParams(false, fText)
+
+	if cmd == nil && !utils.IsEmpty(w.options.DefaultCommand) {
+		cmd = w.processors.FindCommand("", w.options.DefaultCommand)
+		group = ""
+		params = make(common.ExecuteParams)
+	}
+
+	if cmd == nil {
+		params, cmd = w.processors.FindSuggest(fText)
+		group = ""
+	}
+
+	if cmd == nil {
+		w.logger.Debug("Webhook command not found for text: %s", text)
+		common.UpdateCounters(w.meter, "webhook", "", "", fText, u.id)
+		return
+	}
+
+	common.UpdateCounters(w.meter, "webhook", group, cmd.Name(), fText, u.id)
+
+	groupName := common.GroupName(group, cmd)
+	if !common.Permitted(u, cmd, groupName) {
+		w.logger.Error("Webhook user %s is not permitted to execute %s", u.id, groupName)
+		return
+	}
+
+	m.cmdText = fText
+	m.cmd = cmd
+
+	// args couldn't be parsed, so usage is replied before fields are checked
+	if pErr != nil {
+		w.replyError(m, pErr)
+		return
+	}
+	w.processCommand(m, params)
+}
+
+func (w *Webhook) decodePayload(r *http.Request) (interface{}, error) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Slack compatible tools send forms
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		obj := make(map[string]interface{})
+		for k := range values {
+			obj[k] = values.Get(k)
+		}
+		return obj, nil
+	}
+
+	var obj interface{}
+	err = json.Unmarshal(body, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (w *Webhook) writeReply(rw http.ResponseWriter, text string) {
+
+	if utils.IsEmpty(text) {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+
+	obj := make(map[string]interface{})
+	obj[w.options.ReplyField] = text
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		w.logger.Error("Webhook couldn't encode reply: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if _, err := rw.Write(b); err != nil {
+		w.logger.Error("Webhook couldn't write reply: %s", err)
+	}
+}
+
+func (w *Webhook) webhookHandler(rw http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	obj, err := w.decodePayload(r)
+	if err != nil {
+		w.logger.Error("Webhook couldn't decode payload: %s", err)
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !utils.IsEmpty(w.options.Token) {
+		token := w.valueByPath(obj, w.options.TokenPath)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(w.options.Token)) != 1 {
+			w.logger.Error("Webhook token is invalid")
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	text := w.valueByPath(obj, w.options.TextPath)
+	userID := w.valueByPath(obj, w.options.UserIDPath)
+	userName := w.valueByPath(obj, w.options.UserNamePath)
+
+	if w.options.Debug {
+		w.logger.Debug("Webhook message: [%s] %s", userID, text)
+	}
+
+	u := w.buildWebhookUser(userID, userName)
+	if u == nil || utils.IsEmpty(text) {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+
+	m := &WebhookMessage{
+		webhook:   w,
+		id:        w.valueByPath(obj, w.options.MessageIDPath),
+		threadID:  w.valueByPath(obj, w.options.ThreadPath),
+		channelID: w.valueByPath(obj, w.options.ChannelPath),
+		user:      u,
+		caller:    u,
+		visible:   true,
+		text:      text,
+	}
+	if utils.IsEmpty(m.id) {
+		m.id = common.UUID()
+	}
+
+	if w.options.Async {
+		rw.WriteHeader(http.StatusOK)
+		go w.processText(m, text)
+		return
+	}
+
+	m.result = &WebhookResult{mutex: &sync.Mutex{}}
+	w.processText(m, text)
+	w.writeReply(rw, m.result.close())
+}
+
+func (w *Webhook) parentMessage(parent common.Message) *WebhookMessage {
+
+	if utils.IsEmpty(parent) {
+		return nil
+	}
+	wm, ok := parent.(*WebhookMessage)
+	if !ok {
+		return nil
+	}
+	if mc := w.findMessageInCache(wm.id); mc != nil {
+		return mc
+	}
+	return wm
+}
+
+func (w *Webhook) Command(channel, text string, user common.User, parent common.Message, response common.Response) error {
+
+	r := common.BuildResponse(true, response)
+
+	mOrigin := w.parentMessage(parent)
+	if mOrigin != nil && mOrigin.cmd != nil {
+		r = common.BuildResponse(false, mOrigin.cmd.Response(), response)
+	}
+
+	var mUser *WebhookUser
+	userID := "unknown"
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*WebhookUser)
+		if ok {
+			mUser = u
+		}
+		userID = user.ID()
+	}
+
+	fText := w.prepareInputText(text)
+	params, cmd, group, _, _, _, pErr := w.processors.FindParams(false, fText)
+	if cmd == nil {
+		w.logger.Debug("Webhook command not found for text: %s", text)
+		return nil
+	}
+
+	groupName := common.GroupName(group, cmd)
+	if !utils.IsEmpty(user) && !utils.Contains(user.Commands(), groupName) {
+		w.logger.Debug("Webhook command user %s is not permitted to execute %s", userID, groupName)
+		return nil
+	}
+
+	if pErr != nil {
+		return pErr
+	}
+
+	fields := cmd.Fields(w, parent, params, nil)
+	if len(w.missingFields(fields, params)) > 0 {
+		w.logger.Debug("Webhook command %s has no support for interaction mode", groupName)
+		return nil
+	}
+
+	var m *WebhookMessage
+	if mOrigin != nil {
+		m = w.cloneMessage(mOrigin)
+	} else {
+		m = &WebhookMessage{
+			webhook: w,
+			user:    mUser,
+			caller:  mUser,
+		}
+	}
+	if !utils.IsEmpty(channel) {
+		if channel != m.channelID {
+			m.threadID = ""
+		}
+		m.channelID = channel
+	}
+	m.cmdText = fText
+	m.cmd = cmd
+	m.fields = fields
+	m.params = params
+
+	err := w.cachePostUserCommand(m, params, nil, r, true)
+	if err != nil {
+		w.logger.Error("Webhook command %s couldn't post from %s: %s", groupName, userID, err)
+		return err
+	}
+	return nil
+}
+
+func (w *Webhook) PostMessage(channel string, message string, attachments []*common.Attachment, actions []common.Action,
+	user common.User, parent common.Message, response common.Response) (string, error) {
+
+	r := common.BuildResponse(true, response)
+
+	var mUser *WebhookUser
+	if !utils.IsEmpty(user) {
+		u, ok := user.(*WebhookUser)
+		if ok {
+			mUser = u
+		}
+	}
+
+	var m *WebhookMessage
+	mOrigin := w.parentMessage(parent)
+	if mOrigin != nil {
+		m = w.cloneMessage(mOrigin)
+	} else {
+		m = &WebhookMessage{
+			webhook: w,
+			user:    mUser,
+			caller:  mUser,
+		}
+	}
+	if !utils.IsEmpty(channel) {
+		if channel != m.channelID {
+			m.threadID = ""
+			m.result = nil
+		}
+		m.channelID = channel
+	}
+
+	ID, err := w.send(m, w.buildText(message, attachments))
+	if err != nil {
+		return "", err
+	}
+
+	m.id = ID
+	m.visible = r.Visible()
+	m.text = message
+	m.actions = actions
+	w.putMessageToCache(m)
+
+	return ID, nil
+}
+
+func (w *Webhook) start() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(w.options.Path, w.webhookHandler)
+
+	server := &http.Server{
+		Addr:              w.options.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	w.logger.Info("Webhook is listening on %s%s", w.options.Listen, w.options.Path)
+
+	err := server.ListenAndServe()
+	if err != nil {
+		w.logger.Error("Webhook listen error: %s", err)
+	}
+}
+
+func (w *Webhook) Start(wg *sync.WaitGroup) {
+
+	if wg == nil {
+		w.start()
+		return
+	}
+
+	wg.Add(1)
+
+	go func(wg *sync.WaitGroup) {
+
+		defer wg.Done()
+		w.start()
+	}(wg)
+}
+
+func NewWebhook(options WebhookOptions, observability *common.Observability, processors *common.Processors) *Webhook {
+
+	if utils.IsEmpty(options.Listen) {
+		return nil
+	}
+
+	logger := observability.Logs()
+
+	// user is taken from payload, it can be trusted only when payload has token
+	if utils.IsEmpty(options.Token) {
+		if !utils.IsEmpty(options.UserPermissions) {
+			logger.Error("Webhook couldn't start with user permissions but without token")
+			return nil
+		}
+		logger.Warn("Webhook has no token, anyone reaching %s could run commands", options.Listen)
+	}
+
+	ttl := 1 * 60 * 60 * time.Second
+	if !utils.IsEmpty(options.CacheTTL) {
+		ttl, _ = time.ParseDuration(options.CacheTTL)
+	}
+
+	messages := ttlcache.New[string, *WebhookMessage](ttlcache.WithTTL[string, *WebhookMessage](ttl))
+	go messages.Start()
+
+	return &Webhook{
+		options:    options,
+		processors: processors,
+		client:     utils.NewHttpClient(options.Timeout, options.Insecure),
+		logger:     logger,
+		meter:      observability.Metrics(),
+		messages:   messages,
+	}
+}
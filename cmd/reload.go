@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	"github.com/devopsext/chatops/processor"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	"github.com/fsnotify/fsnotify"
+)
+
+type ReloadOptions struct {
+	Watch  bool
+	Delay  int // seconds to collect file changes before reload
+	Listen string
+	Path   string
+	Token  string
+}
+
+type ReloadDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// own processors of Slack instance which are built from shared ones or own dir
+type reloadInstance struct {
+	instance   SlackInstance
+	processors *common.Processors
+}
+
+type reloader struct {
+	options    ReloadOptions
+	obs        *common.Observability
+	processors *common.Processors
+	remotes    *processor.Remotes
+	bots       *common.Bots
+	instances  []*reloadInstance
+	signatures map[string]string
+	mutex      sync.Mutex
+	logger     sreCommon.Logger
+}
+
+func (d *ReloadDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (r *reloader) addInstance(instance SlackInstance, processors *common.Processors) {
+	r.instances = append(r.instances, &reloadInstance{instance: instance, processors: processors})
+}
+
+func (r *reloader) diff(old, new map[string]string) *ReloadDiff {
+
+	d := &ReloadDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for name, s := range new {
+		o, ok := old[name]
+		if !ok {
+			d.Added = append(d.Added, name)
+		} else if o != s {
+			d.Changed = append(d.Changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
+
+// reload builds all processors on staged lists, the old ones are kept serving if anything fails
+func (r *reloader) reload(reason string) (*ReloadDiff, error) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.logger.Info("Reloading processors on %s...", reason)
+
+	next := common.NewProcessors()
+	err := buildProcessors(r.obs, next)
+	if err != nil {
+		r.logger.Error("Couldn't reload processors, old ones are kept, error: %s", err)
+		return nil, err
+	}
+	if r.remotes != nil {
+		next.AddList(r.remotes.Items())
+	}
+
+	staged := make(map[*common.Processors]*common.Processors)
+	for _, i := range r.instances {
+		ps, err := buildSlackProcessors(i.instance, r.obs, next)
+		if err != nil {
+			r.logger.Error("Couldn't reload Slack instance %s processors, old ones are kept, error: %s", i.instance.Name, err)
+			return nil, err
+		}
+		staged[i.processors] = ps
+	}
+
+	// help, suggest and others keep reference to staged list, it's forwarded to live one on commit
+	signatures := next.Signatures()
+	next.Commit(r.processors)
+	for live, ps := range staged {
+		ps.Commit(live)
+	}
+
+	d := r.diff(r.signatures, signatures)
+	r.signatures = signatures
+
+	if d.Empty() {
+		r.logger.Info("Reloaded processors without command changes")
+	} else {
+		r.logger.Info("Reloaded processors, added: [%s], removed: [%s], changed: [%s]",
+			strings.Join(d.Added, ", "), strings.Join(d.Removed, ", "), strings.Join(d.Changed, ", "))
+	}
+
+	if r.bots != nil {
+		err = r.bots.Reload()
+		if err != nil {
+			r.logger.Error("Couldn't reload bots, error: %s", err)
+		}
+	}
+	return d, nil
+}
+
+// paths are dirs with all subdirs, files are watched by their dirs as editors replace them
+func (r *reloader) paths() []string {
+
+	items := []string{
+		defaultOptions.CommandsDir, defaultOptions.TemplatesDir, defaultOptions.RunbooksDir,
+		httpProcessorOptions.CommandsDir,
+		execProcessorOptions.CommandsDir, execProcessorOptions.TemplatesDir,
+		starlarkProcessorOptions.CommandsDir, starlarkProcessorOptions.TemplatesDir, starlarkProcessorOptions.RunbooksDir,
+		prometheusProcessorOptions.CommandsDir,
+		sqlProcessorOptions.CommandsDir, sqlProcessorOptions.Connections,
+		openapiProcessorOptions.TemplatesDir,
+	}
+	items = append(items, strings.Split(openapiProcessorOptions.Specs, ",")...)
+	for _, i := range r.instances {
+		items = append(items, i.instance.CommandsDir)
+	}
+
+	dirs := []string{}
+	for _, item := range common.RemoveEmptyStrings(items) {
+
+		item = strings.TrimSpace(item)
+		info, err := os.Stat(item)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			item = filepath.Dir(item)
+		}
+
+		filepath.WalkDir(item, func(path string, d os.DirEntry, err error) error {
+			if err == nil && d.IsDir() && !utils.Contains(dirs, path) {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+	}
+	return dirs
+}
+
+func (r *reloader) watch() error {
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	paths := r.paths()
+	for _, path := range paths {
+		err = watcher.Add(path)
+		if err != nil {
+			r.logger.Error("Couldn't watch %s, error: %s", path, err)
+		}
+	}
+	r.logger.Info("Watching %d directories for changes", len(paths))
+
+	delay := time.Duration(r.options.Delay) * time.Second
+	var timer *time.Timer
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// skip editor swap and backup files
+				name := filepath.Base(event.Name)
+				if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+					continue
+				}
+				if event.Has(fsnotify.Create) {
+					info, err := os.Stat(event.Name)
+					if err == nil && info.IsDir() {
+						watcher.Add(event.Name)
+					}
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(delay, func() {
+					r.reload("file changes")
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				r.logger.Error("Watcher error: %s", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *reloader) signal() {
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			r.reload("signal")
+		}
+	}()
+}
+
+func (r *reloader) listen() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(r.options.Path, func(w http.ResponseWriter, req *http.Request) {
+
+		if req.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !utils.IsEmpty(r.options.Token) {
+			auth := req.Header.Get("Authorization")
+			if subtle.ConstantTimeCompare([]byte(auth), []byte(fmt.Sprintf("Bearer %s", r.options.Token))) != 1 {
+				r.logger.Error("Reload token is invalid")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		d, err := r.reload("request")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	})
+
+	if utils.IsEmpty(r.options.Token) {
+		r.logger.Warn("Reload has no token, anyone reaching %s could reload processors", r.options.Listen)
+	}
+
+	go func() {
+		r.logger.Info("Reload is listening on %s%s", r.options.Listen, r.options.Path)
+		err := http.ListenAndServe(r.options.Listen, mux)
+		if err != nil {
+			r.logger.Error("Reload listen error: %s", err)
+		}
+	}()
+}
+
+func (r *reloader) start() {
+
+	r.signatures = r.processors.Signatures()
+	r.signal()
+
+	if r.options.Watch {
+		err := r.watch()
+		if err != nil {
+			r.logger.Error("Couldn't start watcher, error: %s", err)
+		}
+	}
+	if !utils.IsEmpty(r.options.Listen) {
+		r.listen()
+	}
+}
+
+func newReloader(options ReloadOptions, obs *common.Observability, processors *common.Processors, remotes *processor.Remotes, bots *common.Bots) *reloader {
+
+	return &reloader{
+		options:    options,
+		obs:        obs,
+		processors: processors,
+		remotes:    remotes,
+		bots:       bots,
+		logger:     obs.Logs(),
+	}
+}
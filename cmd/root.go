@@ -35,6 +35,20 @@ type RootOptions struct {
 	Metrics []string
 }
 
+// Slack workspace which runs next to the default one
+type SlackInstance struct {
+	Name             string
+	BotToken         string
+	AppToken         string
+	DefaultCommand   string
+	HelpCommand      string
+	GroupPermissions string
+	UserPermissions  string
+	PublicChannel    string
+	CommandsDir      string
+	Processors       []string
+}
+
 var rootOptions = RootOptions{
 	Logs:    strings.Split(envGet("LOGS", "stdout").(string), ","),
 	Metrics: strings.Split(envGet("METRICS", "prometheus").(string), ","),
@@ -60,6 +74,193 @@ var telegramOptions = bot.TelegramOptions{
 	Debug:    envGet("TELEGRAM_DEBUG", false).(bool),
 	Timeout:  envGet("TELEGRAM_TIMEOUT", 60).(int),
 	Offset:   envGet("TELEGRAM_OFFSET", 0).(int),
+
+	DefaultCommand:  envGet("TELEGRAM_DEFAULT_COMMAND", "").(string),
+	UserPermissions: envGet("TELEGRAM_USER_PERMISSIONS", "").(string),
+	ApprovalAny:     envGet("TELEGRAM_APPROVAL_ANY", false).(bool),
+
+	ReactionDoing:    envGet("TELEGRAM_REACTION_DOING", "👀").(string),
+	ReactionDone:     envGet("TELEGRAM_REACTION_DONE", "👍").(string),
+	ReactionFailed:   envGet("TELEGRAM_REACTION_FAILED", "👎").(string),
+	ReactionForm:     envGet("TELEGRAM_REACTION_FORM", "🤔").(string),
+	ReactionApproval: envGet("TELEGRAM_REACTION_APPROVAL", "🙏").(string),
+
+	ButtonSubmitCaption:  envGet("TELEGRAM_BUTTON_SUBMIT_CAPTION", "OK").(string),
+	ButtonCancelCaption:  envGet("TELEGRAM_BUTTON_CANCEL_CAPTION", "Cancel").(string),
+	ButtonApproveCaption: envGet("TELEGRAM_BUTTON_APPROVE_CAPTION", "Approve").(string),
+	ButtonRejectCaption:  envGet("TELEGRAM_BUTTON_REJECT_CAPTION", "Reject").(string),
+
+	FormSelected:  envGet("TELEGRAM_FORM_SELECTED", "✅").(string),
+	FormInvalid:   envGet("TELEGRAM_FORM_INVALID", "Invalid value").(string),
+	FormCancelled: envGet("TELEGRAM_FORM_CANCELLED", "Cancelled").(string),
+
+	CacheTTL: envGet("TELEGRAM_CACHE_TTL", "1h").(string),
+}
+
+var mattermostOptions = bot.MattermostOptions{
+	URL:        envGet("MATTERMOST_URL", "").(string),
+	BotToken:   envGet("MATTERMOST_BOT_TOKEN", "").(string),
+	Debug:      envGet("MATTERMOST_DEBUG", false).(bool),
+	Timeout:    envGet("MATTERMOST_TIMEOUT", 30).(int),
+	Insecure:   envGet("MATTERMOST_INSECURE", false).(bool),
+	Listen:     envGet("MATTERMOST_LISTEN", "").(string),
+	ActionsURL: envGet("MATTERMOST_ACTIONS_URL", "").(string),
+	SlashToken: envGet("MATTERMOST_SLASH_TOKEN", "").(string),
+
+	DefaultCommand:  envGet("MATTERMOST_DEFAULT_COMMAND", "").(string),
+	UserPermissions: envGet("MATTERMOST_USER_PERMISSIONS", "").(string),
+	ApprovalAny:     envGet("MATTERMOST_APPROVAL_ANY", false).(bool),
+
+	ReactionDoing:    envGet("MATTERMOST_REACTION_DOING", "eyes").(string),
+	ReactionDone:     envGet("MATTERMOST_REACTION_DONE", "white_check_mark").(string),
+	ReactionFailed:   envGet("MATTERMOST_REACTION_FAILED", "x").(string),
+	ReactionApproval: envGet("MATTERMOST_REACTION_APPROVAL", "pray").(string),
+
+	ButtonApproveCaption: envGet("MATTERMOST_BUTTON_APPROVE_CAPTION", "Approve").(string),
+	ButtonRejectCaption:  envGet("MATTERMOST_BUTTON_REJECT_CAPTION", "Reject").(string),
+
+	ReconnectInterval: envGet("MATTERMOST_RECONNECT_INTERVAL", 5).(int),
+	CacheTTL:          envGet("MATTERMOST_CACHE_TTL", "1h").(string),
+}
+
+var teamsOptions = bot.TeamsOptions{
+	AppID:       envGet("TEAMS_APP_ID", "").(string),
+	AppPassword: envGet("TEAMS_APP_PASSWORD", "").(string),
+	TenantID:    envGet("TEAMS_TENANT_ID", "").(string),
+	Listen:      envGet("TEAMS_LISTEN", "").(string),
+	Path:        envGet("TEAMS_PATH", "/api/messages").(string),
+	ServiceURL:  envGet("TEAMS_SERVICE_URL", "https://smba.trafficmanager.net/teams/").(string),
+	OpenIDURL:   envGet("TEAMS_OPENID_URL", "https://login.botframework.com/v1/.well-known/openidconfiguration").(string),
+	Timeout:     envGet("TEAMS_TIMEOUT", 30).(int),
+	Insecure:    envGet("TEAMS_INSECURE", false).(bool),
+	Debug:       envGet("TEAMS_DEBUG", false).(bool),
+
+	DefaultCommand:  envGet("TEAMS_DEFAULT_COMMAND", "").(string),
+	UserPermissions: envGet("TEAMS_USER_PERMISSIONS", "").(string),
+	ApprovalAny:     envGet("TEAMS_APPROVAL_ANY", false).(bool),
+
+	ButtonSubmitCaption:  envGet("TEAMS_BUTTON_SUBMIT_CAPTION", "Submit").(string),
+	ButtonCancelCaption:  envGet("TEAMS_BUTTON_CANCEL_CAPTION", "Cancel").(string),
+	ButtonApproveCaption: envGet("TEAMS_BUTTON_APPROVE_CAPTION", "Approve").(string),
+	ButtonRejectCaption:  envGet("TEAMS_BUTTON_REJECT_CAPTION", "Reject").(string),
+
+	FormCancelled: envGet("TEAMS_FORM_CANCELLED", "Cancelled").(string),
+	CacheTTL:      envGet("TEAMS_CACHE_TTL", "1h").(string),
+}
+
+var discordOptions = bot.DiscordOptions{
+	BotToken: envGet("DISCORD_BOT_TOKEN", "").(string),
+	GuildID:  envGet("DISCORD_GUILD_ID", "").(string),
+	Debug:    envGet("DISCORD_DEBUG", false).(bool),
+
+	DefaultCommand:  envGet("DISCORD_DEFAULT_COMMAND", "").(string),
+	UserPermissions: envGet("DISCORD_USER_PERMISSIONS", "").(string),
+	ApprovalAny:     envGet("DISCORD_APPROVAL_ANY", false).(bool),
+
+	ReactionDoing:    envGet("DISCORD_REACTION_DOING", "👀").(string),
+	ReactionDone:     envGet("DISCORD_REACTION_DONE", "✅").(string),
+	ReactionFailed:   envGet("DISCORD_REACTION_FAILED", "❌").(string),
+	ReactionForm:     envGet("DISCORD_REACTION_FORM", "🤔").(string),
+	ReactionApproval: envGet("DISCORD_REACTION_APPROVAL", "🙏").(string),
+
+	ButtonFormCaption:    envGet("DISCORD_BUTTON_FORM_CAPTION", "Fill").(string),
+	ButtonCancelCaption:  envGet("DISCORD_BUTTON_CANCEL_CAPTION", "Cancel").(string),
+	ButtonApproveCaption: envGet("DISCORD_BUTTON_APPROVE_CAPTION", "Approve").(string),
+	ButtonRejectCaption:  envGet("DISCORD_BUTTON_REJECT_CAPTION", "Reject").(string),
+
+	FormCancelled: envGet("DISCORD_FORM_CANCELLED", "Cancelled").(string),
+	CacheTTL:      envGet("DISCORD_CACHE_TTL", "1h").(string),
+}
+
+var matrixOptions = bot.MatrixOptions{
+	URL:         envGet("MATRIX_URL", "").(string),
+	AccessToken: envGet("MATRIX_ACCESS_TOKEN", "").(string),
+	Debug:       envGet("MATRIX_DEBUG", false).(bool),
+	Timeout:     envGet("MATRIX_TIMEOUT", 30).(int),
+	Insecure:    envGet("MATRIX_INSECURE", false).(bool),
+	Prefix:      envGet("MATRIX_PREFIX", "!").(string),
+	AutoJoin:    envGet("MATRIX_AUTO_JOIN", false).(bool),
+
+	DefaultCommand:  envGet("MATRIX_DEFAULT_COMMAND", "").(string),
+	UserPermissions: envGet("MATRIX_USER_PERMISSIONS", "").(string),
+	ApprovalAny:     envGet("MATRIX_APPROVAL_ANY", false).(bool),
+
+	ReactionDoing:    envGet("MATRIX_REACTION_DOING", "👀").(string),
+	ReactionDone:     envGet("MATRIX_REACTION_DONE", "✅").(string),
+	ReactionFailed:   envGet("MATRIX_REACTION_FAILED", "❌").(string),
+	ReactionForm:     envGet("MATRIX_REACTION_FORM", "🤔").(string),
+	ReactionApproval: envGet("MATRIX_REACTION_APPROVAL", "🙏").(string),
+
+	ReplyApprove: envGet("MATRIX_REPLY_APPROVE", "approve").(string),
+	ReplyReject:  envGet("MATRIX_REPLY_REJECT", "reject").(string),
+	ReplyCancel:  envGet("MATRIX_REPLY_CANCEL", "cancel").(string),
+
+	FormInvalid:   envGet("MATRIX_FORM_INVALID", "Invalid value").(string),
+	FormCancelled: envGet("MATRIX_FORM_CANCELLED", "Cancelled").(string),
+
+	SyncTimeout:       envGet("MATRIX_SYNC_TIMEOUT", 30).(int),
+	ReconnectInterval: envGet("MATRIX_RECONNECT_INTERVAL", 5).(int),
+	CacheTTL:          envGet("MATRIX_CACHE_TTL", "1h").(string),
+}
+
+var httpOptions = bot.HTTPOptions{
+	Listen:          envGet("HTTP_LISTEN", "").(string),
+	Cert:            envGet("HTTP_CERT", "").(string),
+	Key:             envGet("HTTP_KEY", "").(string),
+	Tokens:          envGet("HTTP_TOKENS", "").(string),
+	Channel:         envGet("HTTP_CHANNEL", "http").(string),
+	Debug:           envGet("HTTP_DEBUG", false).(bool),
+	UserPermissions: envGet("HTTP_USER_PERMISSIONS", "").(string),
+	CacheTTL:        envGet("HTTP_CACHE_TTL", "1h").(string),
+}
+
+var webhookOptions = bot.WebhookOptions{
+	Listen:          envGet("WEBHOOK_LISTEN", "").(string),
+	Path:            envGet("WEBHOOK_PATH", "/webhook").(string),
+	Token:           envGet("WEBHOOK_TOKEN", "").(string),
+	TokenPath:       envGet("WEBHOOK_TOKEN_PATH", "token").(string),
+	TextPath:        envGet("WEBHOOK_TEXT_PATH", "text").(string),
+	UserIDPath:      envGet("WEBHOOK_USER_ID_PATH", "user_id").(string),
+	UserNamePath:    envGet("WEBHOOK_USER_NAME_PATH", "user_name").(string),
+	ChannelPath:     envGet("WEBHOOK_CHANNEL_PATH", "channel_id").(string),
+	ThreadPath:      envGet("WEBHOOK_THREAD_PATH", "").(string),
+	MessageIDPath:   envGet("WEBHOOK_MESSAGE_ID_PATH", "message_id").(string),
+	Prefix:          envGet("WEBHOOK_PREFIX", "").(string),
+	ReplyField:      envGet("WEBHOOK_REPLY_FIELD", "text").(string),
+	Async:           envGet("WEBHOOK_ASYNC", false).(bool),
+	IncomingURL:     envGet("WEBHOOK_INCOMING_URL", "").(string),
+	ChannelField:    envGet("WEBHOOK_CHANNEL_FIELD", "channel").(string),
+	ThreadField:     envGet("WEBHOOK_THREAD_FIELD", "").(string),
+	Timeout:         envGet("WEBHOOK_TIMEOUT", 30).(int),
+	Insecure:        envGet("WEBHOOK_INSECURE", false).(bool),
+	Debug:           envGet("WEBHOOK_DEBUG", false).(bool),
+	DefaultCommand:  envGet("WEBHOOK_DEFAULT_COMMAND", "").(string),
+	UserPermissions: envGet("WEBHOOK_USER_PERMISSIONS", "").(string),
+	CacheTTL:        envGet("WEBHOOK_CACHE_TTL", "1h").(string),
+}
+
+var googleChatOptions = bot.GoogleChatOptions{
+	CredentialsFile: envGet("GOOGLECHAT_CREDENTIALS_FILE", "").(string),
+	Audience:        envGet("GOOGLECHAT_AUDIENCE", "").(string),
+	Listen:          envGet("GOOGLECHAT_LISTEN", "").(string),
+	Path:            envGet("GOOGLECHAT_PATH", "/googlechat").(string),
+	APIURL:          envGet("GOOGLECHAT_API_URL", "https://chat.googleapis.com/v1").(string),
+	KeysURL:         envGet("GOOGLECHAT_KEYS_URL", "https://www.googleapis.com/service_accounts/v1/jwk/chat@system.gserviceaccount.com").(string),
+	Timeout:         envGet("GOOGLECHAT_TIMEOUT", 30).(int),
+	Insecure:        envGet("GOOGLECHAT_INSECURE", false).(bool),
+	Debug:           envGet("GOOGLECHAT_DEBUG", false).(bool),
+	DefaultCommand:  envGet("GOOGLECHAT_DEFAULT_COMMAND", "").(string),
+	UserPermissions: envGet("GOOGLECHAT_USER_PERMISSIONS", "").(string),
+	ApprovalAny:     envGet("GOOGLECHAT_APPROVAL_ANY", false).(bool),
+
+	ButtonFormCaption:    envGet("GOOGLECHAT_BUTTON_FORM_CAPTION", "Open form").(string),
+	ButtonSubmitCaption:  envGet("GOOGLECHAT_BUTTON_SUBMIT_CAPTION", "Submit").(string),
+	ButtonCancelCaption:  envGet("GOOGLECHAT_BUTTON_CANCEL_CAPTION", "Cancel").(string),
+	ButtonApproveCaption: envGet("GOOGLECHAT_BUTTON_APPROVE_CAPTION", "Approve").(string),
+	ButtonRejectCaption:  envGet("GOOGLECHAT_BUTTON_REJECT_CAPTION", "Reject").(string),
+
+	FormCancelled: envGet("GOOGLECHAT_FORM_CANCELLED", "Cancelled").(string),
+	CacheTTL:      envGet("GOOGLECHAT_CACHE_TTL", "1h").(string),
 }
 
 var slackOptions = bot.SlackOptions{
@@ -108,15 +309,134 @@ var slackOptions = bot.SlackOptions{
 	UserGroupsInterval: envGet("SLACK_USER_GROUPS_INTERVAL", 5).(int),
 }
 
+var slackInstances = envGet("SLACK_INSTANCES", "").(string)
+
+var reloadOptions = ReloadOptions{
+	Watch:  envGet("RELOAD_WATCH", false).(bool),
+	Delay:  envGet("RELOAD_DELAY", 2).(int),
+	Listen: envGet("RELOAD_LISTEN", "").(string),
+	Path:   envGet("RELOAD_PATH", "/reload").(string),
+	Token:  envGet("RELOAD_TOKEN", "").(string),
+}
+
+var shellOptions = bot.ShellOptions{
+	Prompt:         envGet("SHELL_PROMPT", "> ").(string),
+	User:           envGet("SHELL_USER", os.Getenv("USER")).(string),
+	Channel:        envGet("SHELL_CHANNEL", "shell").(string),
+	DefaultCommand: envGet("SHELL_DEFAULT_COMMAND", "").(string),
+	AttachmentsDir: envGet("SHELL_ATTACHMENTS_DIR", "").(string),
+
+	ReactionDoing:    envGet("SHELL_REACTION_DOING", "doing").(string),
+	ReactionDone:     envGet("SHELL_REACTION_DONE", "done").(string),
+	ReactionFailed:   envGet("SHELL_REACTION_FAILED", "failed").(string),
+	ReactionApproval: envGet("SHELL_REACTION_APPROVAL", "approval").(string),
+}
+
 var defaultOptions = processor.DefaultOptions{
 	CommandsDir:  envGet("DEFAULT_COMMANDS_DIR", "").(string),
 	TemplatesDir: envGet("DEFAULT_TEMPLATES_DIR", "").(string),
 	RunbooksDir:  envGet("DEFAULT_RUNBOOKS_DIR", "").(string),
 	CommandExt:   envGet("DEFAULT_COMMAND_EXT", ".tpl").(string),
 	ConfigExt:    envGet("DEFAULT_CONFIG_EXT", ".yml").(string),
+	ManifestExt:  envGet("DEFAULT_MANIFEST_EXT", ".manifest.yml").(string),
 	Error:        envGet("DEFAULT_ERROR", "Couldn't execute command").(string),
 }
 
+var httpProcessorOptions = processor.HTTPOptions{
+	CommandsDir: envGet("HTTP_PROCESSOR_COMMANDS_DIR", "").(string),
+	ConfigExt:   envGet("HTTP_PROCESSOR_CONFIG_EXT", ".yml").(string),
+	Timeout:     envGet("HTTP_PROCESSOR_TIMEOUT", 30).(int),
+	Insecure:    envGet("HTTP_PROCESSOR_INSECURE", false).(bool),
+	Error:       envGet("HTTP_PROCESSOR_ERROR", "Couldn't execute command").(string),
+}
+
+var execProcessorOptions = processor.ExecOptions{
+	CommandsDir:  envGet("EXEC_PROCESSOR_COMMANDS_DIR", "").(string),
+	TemplatesDir: envGet("EXEC_PROCESSOR_TEMPLATES_DIR", "").(string),
+	Extensions:   envGet("EXEC_PROCESSOR_EXTENSIONS", ".sh,.py").(string),
+	ConfigExt:    envGet("EXEC_PROCESSOR_CONFIG_EXT", ".yml").(string),
+	Timeout:      envGet("EXEC_PROCESSOR_TIMEOUT", 60).(int),
+	Error:        envGet("EXEC_PROCESSOR_ERROR", "Couldn't execute command").(string),
+}
+
+var remoteProcessorOptions = processor.RemoteOptions{
+	URLs:     envGet("REMOTE_PROCESSOR_URLS", "").(string),
+	Token:    envGet("REMOTE_PROCESSOR_TOKEN", "").(string),
+	Timeout:  envGet("REMOTE_PROCESSOR_TIMEOUT", 30).(int),
+	Insecure: envGet("REMOTE_PROCESSOR_INSECURE", false).(bool),
+	Refresh:  envGet("REMOTE_PROCESSOR_REFRESH", 60).(int),
+	Error:    envGet("REMOTE_PROCESSOR_ERROR", "Couldn't execute command").(string),
+}
+
+var starlarkProcessorOptions = processor.StarlarkOptions{
+	CommandsDir:  envGet("STARLARK_PROCESSOR_COMMANDS_DIR", "").(string),
+	TemplatesDir: envGet("STARLARK_PROCESSOR_TEMPLATES_DIR", "").(string),
+	RunbooksDir:  envGet("STARLARK_PROCESSOR_RUNBOOKS_DIR", "").(string),
+	CommandExt:   envGet("STARLARK_PROCESSOR_COMMAND_EXT", ".star").(string),
+	ConfigExt:    envGet("STARLARK_PROCESSOR_CONFIG_EXT", ".yml").(string),
+	Steps:        envGet("STARLARK_PROCESSOR_STEPS", 1000000).(int),
+	Timeout:      envGet("STARLARK_PROCESSOR_TIMEOUT", 30).(int),
+	Error:        envGet("STARLARK_PROCESSOR_ERROR", "Couldn't execute command").(string),
+}
+
+var prometheusProcessorOptions = processor.PrometheusOptions{
+	CommandsDir: envGet("PROMETHEUS_PROCESSOR_COMMANDS_DIR", "").(string),
+	ConfigExt:   envGet("PROMETHEUS_PROCESSOR_CONFIG_EXT", ".yml").(string),
+	URLs:        envGet("PROMETHEUS_PROCESSOR_URLS", "").(string),
+	Timeout:     envGet("PROMETHEUS_PROCESSOR_TIMEOUT", 30).(int),
+	Insecure:    envGet("PROMETHEUS_PROCESSOR_INSECURE", false).(bool),
+	Width:       envGet("PROMETHEUS_PROCESSOR_WIDTH", 1024).(int),
+	Height:      envGet("PROMETHEUS_PROCESSOR_HEIGHT", 512).(int),
+	Error:       envGet("PROMETHEUS_PROCESSOR_ERROR", "Couldn't execute command").(string),
+}
+
+var sqlProcessorOptions = processor.SQLOptions{
+	CommandsDir: envGet("SQL_PROCESSOR_COMMANDS_DIR", "").(string),
+	ConfigExt:   envGet("SQL_PROCESSOR_CONFIG_EXT", ".yml").(string),
+	Connections: envGet("SQL_PROCESSOR_CONNECTIONS", "").(string),
+	Timeout:     envGet("SQL_PROCESSOR_TIMEOUT", 30).(int),
+	MaxRows:     envGet("SQL_PROCESSOR_MAX_ROWS", 20).(int),
+	Limit:       envGet("SQL_PROCESSOR_LIMIT", 10000).(int),
+	Error:       envGet("SQL_PROCESSOR_ERROR", "Couldn't execute command").(string),
+}
+
+var openapiProcessorOptions = processor.OpenAPIOptions{
+	Specs:        envGet("OPENAPI_PROCESSOR_SPECS", "").(string),
+	Server:       envGet("OPENAPI_PROCESSOR_SERVER", "").(string),
+	Token:        envGet("OPENAPI_PROCESSOR_TOKEN", "").(string),
+	Timeout:      envGet("OPENAPI_PROCESSOR_TIMEOUT", 30).(int),
+	Insecure:     envGet("OPENAPI_PROCESSOR_INSECURE", false).(bool),
+	TemplatesDir: envGet("OPENAPI_PROCESSOR_TEMPLATES_DIR", "").(string),
+	Error:        envGet("OPENAPI_PROCESSOR_ERROR", "Couldn't execute command").(string),
+}
+
+var helpOptions = processor.HelpOptions{
+	Enabled:     envGet("HELP_ENABLED", true).(bool),
+	Command:     envGet("HELP_COMMAND", "help").(string),
+	Description: envGet("HELP_DESCRIPTION", "Show available commands and their usage").(string),
+}
+
+var suggestOptions = processor.SuggestOptions{
+	Enabled:     envGet("SUGGEST_ENABLED", true).(bool),
+	Command:     envGet("SUGGEST_COMMAND", "suggest").(string),
+	Description: envGet("SUGGEST_DESCRIPTION", "Suggest closest commands to text").(string),
+	Distance:    envGet("SUGGEST_DISTANCE", 2).(int),
+	Limit:       envGet("SUGGEST_LIMIT", 5).(int),
+}
+
+var kubernetesProcessorOptions = processor.KubernetesOptions{
+	Enabled:         envGet("KUBERNETES_PROCESSOR_ENABLED", false).(bool),
+	Group:           envGet("KUBERNETES_PROCESSOR_GROUP", "k8s").(string),
+	Kubeconfig:      envGet("KUBERNETES_PROCESSOR_KUBECONFIG", "").(string),
+	Contexts:        envGet("KUBERNETES_PROCESSOR_CONTEXTS", "").(string),
+	Namespace:       envGet("KUBERNETES_PROCESSOR_NAMESPACE", "default").(string),
+	LogLines:        envGet("KUBERNETES_PROCESSOR_LOG_LINES", 100).(int),
+	Timeout:         envGet("KUBERNETES_PROCESSOR_TIMEOUT", 30).(int),
+	Approval:        envGet("KUBERNETES_PROCESSOR_APPROVAL", true).(bool),
+	ApprovalChannel: envGet("KUBERNETES_PROCESSOR_APPROVAL_CHANNEL", "").(string),
+	Error:           envGet("KUBERNETES_PROCESSOR_ERROR", "Couldn't execute command").(string),
+}
+
 func envGet(s string, def interface{}) interface{} {
 	return utils.EnvGet(fmt.Sprintf("%s_%s", APPNAME, s), def)
 }
@@ -132,6 +452,63 @@ func interceptSyscall() {
 	}()
 }
 
+// nested dirs become nested groups, k8s/prod/restart.tpl is executed as "k8s prod restart",
+// manifests add their commands to the group of their dir
+func buildDefaultGroup(dir, group, commandExt, manifestExt string, options processor.DefaultOptions, obs *common.Observability, processors *common.Processors) error {
+
+	logger := obs.Logs()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Error("Couldn't read default dir %s, error %s", dir, err)
+		return err
+	}
+
+	dirProcessor := processor.NewDefault(group, options, obs, processors)
+	if utils.IsEmpty(dirProcessor) {
+		logger.Error("No default dir processor %s", group)
+		return err
+	}
+
+	for _, de := range entries {
+
+		name := de.Name()
+		path := fmt.Sprintf("%s%c%s", dir, os.PathSeparator, name)
+		if de.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, manifestExt) {
+			err := dirProcessor.AddManifest(path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		ext := filepath.Ext(name)
+		if ext != commandExt {
+			continue
+		}
+
+		err := dirProcessor.AddCommand(strings.TrimSuffix(name, ext), path)
+		if err != nil {
+			return err
+		}
+	}
+	processors.Add(dirProcessor)
+
+	for _, de := range entries {
+
+		if !de.IsDir() {
+			continue
+		}
+		name := de.Name()
+		err := buildDefaultGroup(fmt.Sprintf("%s%c%s", dir, os.PathSeparator, name), fmt.Sprintf("%s/%s", group, name), commandExt, manifestExt, options, obs, processors)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func buildDefaultProcessors(options processor.DefaultOptions, obs *common.Observability, processors *common.Processors) error {
 
 	logger := obs.Logs()
@@ -151,6 +528,11 @@ func buildDefaultProcessors(options processor.DefaultOptions, obs *common.Observ
 		configExt = ".yml"
 	}
 
+	manifestExt := defaultOptions.ManifestExt
+	if utils.IsEmpty(manifestExt) {
+		manifestExt = ".manifest.yml"
+	}
+
 	// scan dirs firstly
 	for _, de1 := range first {
 
@@ -159,36 +541,10 @@ func buildDefaultProcessors(options processor.DefaultOptions, obs *common.Observ
 
 		// dir is there
 		if de1.IsDir() {
-			second, err := os.ReadDir(path1)
+			err := buildDefaultGroup(path1, name1, commandExt, manifestExt, options, obs, processors)
 			if err != nil {
-				logger.Error("Couldn't read default dir %s, error %s", options.CommandsDir, err)
-				return err
-			}
-
-			dirProcessor := processor.NewDefault(name1, options, obs, processors)
-			if utils.IsEmpty(dirProcessor) {
-				logger.Error("No default dir processor %s", name1)
 				return err
 			}
-
-			for _, de2 := range second {
-
-				name2 := de2.Name()
-				path2 := fmt.Sprintf("%s%c%s", path1, os.PathSeparator, name2)
-				if de2.IsDir() {
-					continue
-				}
-				ext := filepath.Ext(name2)
-				if ext != commandExt {
-					continue
-				}
-
-				err := dirProcessor.AddCommand(strings.TrimSuffix(name2, ext), path2)
-				if err != nil {
-					return err
-				}
-			}
-			processors.Add(dirProcessor)
 		}
 	}
 
@@ -207,6 +563,13 @@ func buildDefaultProcessors(options processor.DefaultOptions, obs *common.Observ
 
 		// file is there
 		if !de1.IsDir() {
+			if strings.HasSuffix(name1, manifestExt) {
+				err := rootProcessor.AddManifest(path1)
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			ext := filepath.Ext(name1)
 			if ext != commandExt {
 				continue
@@ -221,6 +584,365 @@ func buildDefaultProcessors(options processor.DefaultOptions, obs *common.Observ
 	return nil
 }
 
+type dirProcessor interface {
+	common.Processor
+	AddCommand(name, path string) error
+}
+
+type dirMatch = func(path string) bool
+
+// nested dirs become nested groups named by their path joined by /
+func buildDirGroup(dir, group string, match dirMatch, create func(name string) dirProcessor, obs *common.Observability, processors *common.Processors) error {
+
+	logger := obs.Logs()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Error("Couldn't read dir %s, error %s", dir, err)
+		return err
+	}
+
+	p := create(group)
+	for _, de := range entries {
+
+		name := de.Name()
+		path := fmt.Sprintf("%s%c%s", dir, os.PathSeparator, name)
+		if de.IsDir() || !match(path) {
+			continue
+		}
+		err := p.AddCommand(strings.TrimSuffix(name, filepath.Ext(name)), path)
+		if err != nil {
+			return err
+		}
+	}
+	processors.Add(p)
+
+	for _, de := range entries {
+
+		if !de.IsDir() {
+			continue
+		}
+		name := de.Name()
+		err := buildDirGroup(fmt.Sprintf("%s%c%s", dir, os.PathSeparator, name), fmt.Sprintf("%s/%s", group, name), match, create, obs, processors)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subdirectories become groups, files in the root become commands without group
+func buildDirProcessors(dir string, match dirMatch, create func(name string) dirProcessor, obs *common.Observability, processors *common.Processors) error {
+
+	logger := obs.Logs()
+	first, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Error("Couldn't read dir %s, error %s", dir, err)
+		return err
+	}
+
+	root := create("")
+	for _, de1 := range first {
+
+		name1 := de1.Name()
+		path1 := fmt.Sprintf("%s%c%s", dir, os.PathSeparator, name1)
+
+		if !de1.IsDir() {
+			if !match(path1) {
+				continue
+			}
+			err := root.AddCommand(strings.TrimSuffix(name1, filepath.Ext(name1)), path1)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := buildDirGroup(path1, name1, match, create, obs, processors)
+		if err != nil {
+			return err
+		}
+	}
+	processors.Add(root)
+	return nil
+}
+
+func buildHTTPProcessors(options processor.HTTPOptions, obs *common.Observability, processors *common.Processors) error {
+
+	if utils.IsEmpty(options.CommandsDir) {
+		return nil
+	}
+
+	configExt := options.ConfigExt
+	if utils.IsEmpty(configExt) {
+		configExt = ".yml"
+	}
+
+	match := func(path string) bool {
+		return filepath.Ext(path) == configExt
+	}
+
+	return buildDirProcessors(options.CommandsDir, match, func(name string) dirProcessor {
+		return processor.NewHTTP(name, options, obs, processors)
+	}, obs, processors)
+}
+
+func buildPrometheusProcessors(options processor.PrometheusOptions, obs *common.Observability, processors *common.Processors) error {
+
+	if utils.IsEmpty(options.CommandsDir) {
+		return nil
+	}
+
+	configExt := options.ConfigExt
+	if utils.IsEmpty(configExt) {
+		configExt = ".yml"
+	}
+
+	match := func(path string) bool {
+		return filepath.Ext(path) == configExt
+	}
+
+	return buildDirProcessors(options.CommandsDir, match, func(name string) dirProcessor {
+		return processor.NewPrometheus(name, options, obs, processors)
+	}, obs, processors)
+}
+
+func buildSQLProcessors(options processor.SQLOptions, obs *common.Observability, processors *common.Processors) error {
+
+	if utils.IsEmpty(options.CommandsDir) {
+		return nil
+	}
+
+	configExt := options.ConfigExt
+	if utils.IsEmpty(configExt) {
+		configExt = ".yml"
+	}
+
+	match := func(path string) bool {
+		return filepath.Ext(path) == configExt
+	}
+
+	return buildDirProcessors(options.CommandsDir, match, func(name string) dirProcessor {
+		return processor.NewSQL(name, options, obs, processors)
+	}, obs, processors)
+}
+
+func buildExecProcessors(options processor.ExecOptions, obs *common.Observability, processors *common.Processors) error {
+
+	if utils.IsEmpty(options.CommandsDir) {
+		return nil
+	}
+
+	if utils.IsEmpty(options.ConfigExt) {
+		options.ConfigExt = ".yml"
+	}
+
+	match := processor.NewExec("", options, obs, processors).Match
+
+	return buildDirProcessors(options.CommandsDir, match, func(name string) dirProcessor {
+		return processor.NewExec(name, options, obs, processors)
+	}, obs, processors)
+}
+
+func buildStarlarkProcessors(options processor.StarlarkOptions, obs *common.Observability, processors *common.Processors) error {
+
+	if utils.IsEmpty(options.CommandsDir) {
+		return nil
+	}
+
+	if utils.IsEmpty(options.CommandExt) {
+		options.CommandExt = ".star"
+	}
+	// scripts share templates and runbooks with default processor unless set
+	if utils.IsEmpty(options.TemplatesDir) {
+		options.TemplatesDir = defaultOptions.TemplatesDir
+	}
+	if utils.IsEmpty(options.RunbooksDir) {
+		options.RunbooksDir = defaultOptions.RunbooksDir
+	}
+
+	match := func(path string) bool {
+		return filepath.Ext(path) == options.CommandExt
+	}
+
+	return buildDirProcessors(options.CommandsDir, match, func(name string) dirProcessor {
+		return processor.NewStarlark(name, options, obs, processors)
+	}, obs, processors)
+}
+
+func buildProcessors(obs *common.Observability, processors *common.Processors) error {
+
+	err := buildDefaultProcessors(defaultOptions, obs, processors)
+	if err != nil {
+		return err
+	}
+
+	err = buildHTTPProcessors(httpProcessorOptions, obs, processors)
+	if err != nil {
+		return err
+	}
+
+	err = buildExecProcessors(execProcessorOptions, obs, processors)
+	if err != nil {
+		return err
+	}
+
+	err = buildStarlarkProcessors(starlarkProcessorOptions, obs, processors)
+	if err != nil {
+		return err
+	}
+
+	err = buildPrometheusProcessors(prometheusProcessorOptions, obs, processors)
+	if err != nil {
+		return err
+	}
+
+	err = buildSQLProcessors(sqlProcessorOptions, obs, processors)
+	if err != nil {
+		return err
+	}
+
+	openapis, err := processor.NewOpenAPIs(openapiProcessorOptions, obs, processors)
+	if err != nil {
+		return err
+	}
+	for _, o := range openapis {
+		processors.Add(o)
+	}
+
+	kubernetes := processor.NewKubernetes(kubernetesProcessorOptions, obs, processors)
+	if kubernetes != nil {
+		processors.Add(kubernetes)
+	}
+
+	buildHelp(obs, processors)
+	buildSuggest(obs, processors)
+	return nil
+}
+
+// help is added lastly and only if there is no own root command with the same name
+func buildHelp(obs *common.Observability, processors *common.Processors) {
+
+	if processors.FindCommand("", helpOptions.Command) != nil {
+		obs.Logs().Debug("Help command %s is already defined", helpOptions.Command)
+		return
+	}
+	help := processor.NewHelp(helpOptions, obs, processors)
+	if help != nil {
+		processors.Add(help)
+	}
+}
+
+// suggest is added lastly like help, bots fall back to it when text matches no command
+func buildSuggest(obs *common.Observability, processors *common.Processors) {
+
+	if processors.FindCommand("", suggestOptions.Command) != nil {
+		obs.Logs().Debug("Suggest command %s is already defined", suggestOptions.Command)
+		return
+	}
+	suggest := processor.NewSuggest(suggestOptions, obs, processors)
+	if suggest != nil {
+		processors.Add(suggest)
+	}
+}
+
+// remotes are built once as they add groups at runtime, so they are kept on reload
+func buildRemotes(obs *common.Observability, processors *common.Processors) *processor.Remotes {
+
+	remotes := processor.NewRemotes(remoteProcessorOptions, obs, processors)
+	if remotes != nil {
+		// unavailable services are fetched again on next refresh
+		err := remotes.Refresh()
+		if err != nil {
+			obs.Logs().Warn(err)
+		}
+		remotes.Start()
+	}
+	return remotes
+}
+
+// instance gets its own commands dir or a subset of shared processors
+func buildSlackProcessors(instance SlackInstance, obs *common.Observability, shared *common.Processors) (*common.Processors, error) {
+
+	if !utils.IsEmpty(instance.CommandsDir) {
+		options := defaultOptions
+		options.CommandsDir = instance.CommandsDir
+
+		processors := common.NewProcessors()
+		err := buildDefaultProcessors(options, obs, processors)
+		if err != nil {
+			return nil, err
+		}
+		buildHelp(obs, processors)
+		buildSuggest(obs, processors)
+		return processors, nil
+	}
+
+	if len(instance.Processors) == 0 {
+		return shared, nil
+	}
+
+	processors := common.NewProcessors()
+	for _, p := range shared.Items() {
+		switch p.(type) {
+		case *processor.Help, *processor.Suggest:
+			continue
+		}
+		if utils.Contains(instance.Processors, p.Name()) {
+			processors.Add(p)
+		}
+	}
+	buildHelp(obs, processors)
+	buildSuggest(obs, processors)
+	return processors, nil
+}
+
+func buildSlackInstances(config string, obs *common.Observability, shared *common.Processors, reload *reloader) ([]*bot.Slack, error) {
+
+	instances := []SlackInstance{}
+	_, err := common.LoadYaml(config, &instances)
+	if err != nil {
+		return nil, err
+	}
+
+	r := []*bot.Slack{}
+	names := []string{}
+	for _, instance := range instances {
+
+		if utils.IsEmpty(instance.Name) {
+			return nil, fmt.Errorf("Slack instance has no name")
+		}
+		if utils.Contains(names, instance.Name) {
+			return nil, fmt.Errorf("Slack instance %s is duplicated", instance.Name)
+		}
+		names = append(names, instance.Name)
+
+		processors, err := buildSlackProcessors(instance, obs, shared)
+		if err != nil {
+			return nil, err
+		}
+		if processors != shared {
+			reload.addInstance(instance, processors)
+		}
+
+		options := slackOptions
+		options.Name = instance.Name
+		options.BotToken = instance.BotToken
+		options.AppToken = instance.AppToken
+		options.UserPermissions = instance.UserPermissions
+		options.GroupPermissions = instance.GroupPermissions
+		options.PublicChannel = instance.PublicChannel
+		if !utils.IsEmpty(instance.DefaultCommand) {
+			options.DefaultCommand = instance.DefaultCommand
+		}
+		if !utils.IsEmpty(instance.HelpCommand) {
+			options.HelpCommand = instance.HelpCommand
+		}
+
+		r = append(r, bot.NewSlack(options, obs, processors))
+	}
+	return r, nil
+}
+
 // /start - show list of commands and simple description
 // /k8s - list of k8s clusters
 // /k8s/cluster1 - type k8s cluster
@@ -263,15 +985,35 @@ func Execute() {
 			obs := common.NewObservability(logs, metrics)
 			processors := common.NewProcessors()
 
-			err := buildDefaultProcessors(defaultOptions, obs, processors)
+			err := buildProcessors(obs, processors)
 			if err != nil {
 				os.Exit(1)
 			}
+			remotes := buildRemotes(obs, processors)
 
 			bots := common.NewBots()
-			//bots.Add(bot.NewTelegram(telegramOptions, obs, processors))
+			bots.Add(bot.NewTelegram(telegramOptions, obs, processors))
+			bots.Add(bot.NewMattermost(mattermostOptions, obs, processors))
+			bots.Add(bot.NewTeams(teamsOptions, obs, processors))
+			bots.Add(bot.NewDiscord(discordOptions, obs, processors))
+			bots.Add(bot.NewMatrix(matrixOptions, obs, processors))
+			bots.Add(bot.NewHTTP(httpOptions, obs, processors))
+			bots.Add(bot.NewWebhook(webhookOptions, obs, processors))
+			bots.Add(bot.NewGoogleChat(googleChatOptions, obs, processors))
 			bots.Add(bot.NewSlack(slackOptions, obs, processors))
 
+			reload := newReloader(reloadOptions, obs, processors, remotes, bots)
+
+			instances, err := buildSlackInstances(slackInstances, obs, processors, reload)
+			if err != nil {
+				logs.Error("Couldn't build Slack instances, error %s", err)
+				os.Exit(1)
+			}
+			for _, instance := range instances {
+				bots.Add(instance)
+			}
+
+			reload.start()
 			bots.Start(&mainWG)
 			mainWG.Wait()
 		},
@@ -296,6 +1038,157 @@ func Execute() {
 	flags.StringVar(&telegramOptions.BotToken, "telegram-bot-token", telegramOptions.BotToken, "Telegram bot token")
 	flags.BoolVar(&telegramOptions.Debug, "telegram-debug", telegramOptions.Debug, "Telegram debug")
 	flags.IntVar(&telegramOptions.Timeout, "telegram-timeout", telegramOptions.Timeout, "Telegram timeout")
+	flags.IntVar(&telegramOptions.Offset, "telegram-offset", telegramOptions.Offset, "Telegram offset")
+	flags.StringVar(&telegramOptions.DefaultCommand, "telegram-default-command", telegramOptions.DefaultCommand, "Telegram default command")
+	flags.StringVar(&telegramOptions.UserPermissions, "telegram-user-permissions", telegramOptions.UserPermissions, "Telegram user permissions")
+	flags.BoolVar(&telegramOptions.ApprovalAny, "telegram-approval-any", telegramOptions.ApprovalAny, "Telegram approval by any user")
+	flags.StringVar(&telegramOptions.ReactionDoing, "telegram-reaction-doing", telegramOptions.ReactionDoing, "Telegram reaction doing emoji")
+	flags.StringVar(&telegramOptions.ReactionDone, "telegram-reaction-done", telegramOptions.ReactionDone, "Telegram reaction done emoji")
+	flags.StringVar(&telegramOptions.ReactionFailed, "telegram-reaction-failed", telegramOptions.ReactionFailed, "Telegram reaction failed emoji")
+	flags.StringVar(&telegramOptions.ReactionForm, "telegram-reaction-form", telegramOptions.ReactionForm, "Telegram reaction form emoji")
+	flags.StringVar(&telegramOptions.ReactionApproval, "telegram-reaction-approval", telegramOptions.ReactionApproval, "Telegram reaction approval emoji")
+	flags.StringVar(&telegramOptions.ButtonSubmitCaption, "telegram-button-submit-caption", telegramOptions.ButtonSubmitCaption, "Telegram button submit caption")
+	flags.StringVar(&telegramOptions.ButtonCancelCaption, "telegram-button-cancel-caption", telegramOptions.ButtonCancelCaption, "Telegram button cancel caption")
+	flags.StringVar(&telegramOptions.ButtonApproveCaption, "telegram-button-approve-caption", telegramOptions.ButtonApproveCaption, "Telegram button approve caption")
+	flags.StringVar(&telegramOptions.ButtonRejectCaption, "telegram-button-reject-caption", telegramOptions.ButtonRejectCaption, "Telegram button reject caption")
+	flags.StringVar(&telegramOptions.FormSelected, "telegram-form-selected", telegramOptions.FormSelected, "Telegram form selected value mark")
+	flags.StringVar(&telegramOptions.FormInvalid, "telegram-form-invalid", telegramOptions.FormInvalid, "Telegram form invalid value text")
+	flags.StringVar(&telegramOptions.FormCancelled, "telegram-form-cancelled", telegramOptions.FormCancelled, "Telegram form cancelled text")
+	flags.StringVar(&telegramOptions.CacheTTL, "telegram-cache-ttl", telegramOptions.CacheTTL, "Telegram cache TTL")
+
+	flags.StringVar(&mattermostOptions.URL, "mattermost-url", mattermostOptions.URL, "Mattermost server URL")
+	flags.StringVar(&mattermostOptions.BotToken, "mattermost-bot-token", mattermostOptions.BotToken, "Mattermost bot token")
+	flags.BoolVar(&mattermostOptions.Debug, "mattermost-debug", mattermostOptions.Debug, "Mattermost debug")
+	flags.IntVar(&mattermostOptions.Timeout, "mattermost-timeout", mattermostOptions.Timeout, "Mattermost timeout")
+	flags.BoolVar(&mattermostOptions.Insecure, "mattermost-insecure", mattermostOptions.Insecure, "Mattermost insecure")
+	flags.StringVar(&mattermostOptions.Listen, "mattermost-listen", mattermostOptions.Listen, "Mattermost listen address for actions and slash commands")
+	flags.StringVar(&mattermostOptions.ActionsURL, "mattermost-actions-url", mattermostOptions.ActionsURL, "Mattermost externally reachable actions URL")
+	flags.StringVar(&mattermostOptions.SlashToken, "mattermost-slash-token", mattermostOptions.SlashToken, "Mattermost slash command token")
+	flags.StringVar(&mattermostOptions.DefaultCommand, "mattermost-default-command", mattermostOptions.DefaultCommand, "Mattermost default command")
+	flags.StringVar(&mattermostOptions.UserPermissions, "mattermost-user-permissions", mattermostOptions.UserPermissions, "Mattermost user permissions")
+	flags.BoolVar(&mattermostOptions.ApprovalAny, "mattermost-approval-any", mattermostOptions.ApprovalAny, "Mattermost approval by any user")
+	flags.StringVar(&mattermostOptions.ReactionDoing, "mattermost-reaction-doing", mattermostOptions.ReactionDoing, "Mattermost reaction doing emoji")
+	flags.StringVar(&mattermostOptions.ReactionDone, "mattermost-reaction-done", mattermostOptions.ReactionDone, "Mattermost reaction done emoji")
+	flags.StringVar(&mattermostOptions.ReactionFailed, "mattermost-reaction-failed", mattermostOptions.ReactionFailed, "Mattermost reaction failed emoji")
+	flags.StringVar(&mattermostOptions.ReactionApproval, "mattermost-reaction-approval", mattermostOptions.ReactionApproval, "Mattermost reaction approval emoji")
+	flags.StringVar(&mattermostOptions.ButtonApproveCaption, "mattermost-button-approve-caption", mattermostOptions.ButtonApproveCaption, "Mattermost button approve caption")
+	flags.StringVar(&mattermostOptions.ButtonRejectCaption, "mattermost-button-reject-caption", mattermostOptions.ButtonRejectCaption, "Mattermost button reject caption")
+	flags.IntVar(&mattermostOptions.ReconnectInterval, "mattermost-reconnect-interval", mattermostOptions.ReconnectInterval, "Mattermost websocket reconnect interval in seconds")
+	flags.StringVar(&mattermostOptions.CacheTTL, "mattermost-cache-ttl", mattermostOptions.CacheTTL, "Mattermost cache TTL")
+
+	flags.StringVar(&teamsOptions.AppID, "teams-app-id", teamsOptions.AppID, "Teams bot app ID, activities are verified against it")
+	flags.StringVar(&teamsOptions.AppPassword, "teams-app-password", teamsOptions.AppPassword, "Teams bot app password")
+	flags.StringVar(&teamsOptions.TenantID, "teams-tenant-id", teamsOptions.TenantID, "Teams tenant ID for single tenant bots")
+	flags.StringVar(&teamsOptions.Listen, "teams-listen", teamsOptions.Listen, "Teams messaging endpoint listen address")
+	flags.StringVar(&teamsOptions.Path, "teams-path", teamsOptions.Path, "Teams messaging endpoint path")
+	flags.StringVar(&teamsOptions.ServiceURL, "teams-service-url", teamsOptions.ServiceURL, "Teams default connector service URL")
+	flags.StringVar(&teamsOptions.OpenIDURL, "teams-openid-url", teamsOptions.OpenIDURL, "Teams OpenID metadata URL")
+	flags.IntVar(&teamsOptions.Timeout, "teams-timeout", teamsOptions.Timeout, "Teams timeout")
+	flags.BoolVar(&teamsOptions.Insecure, "teams-insecure", teamsOptions.Insecure, "Teams insecure")
+	flags.BoolVar(&teamsOptions.Debug, "teams-debug", teamsOptions.Debug, "Teams debug")
+	flags.StringVar(&teamsOptions.DefaultCommand, "teams-default-command", teamsOptions.DefaultCommand, "Teams default command")
+	flags.StringVar(&teamsOptions.UserPermissions, "teams-user-permissions", teamsOptions.UserPermissions, "Teams user permissions")
+	flags.BoolVar(&teamsOptions.ApprovalAny, "teams-approval-any", teamsOptions.ApprovalAny, "Teams approval by any user")
+	flags.StringVar(&teamsOptions.ButtonSubmitCaption, "teams-button-submit-caption", teamsOptions.ButtonSubmitCaption, "Teams button submit caption")
+	flags.StringVar(&teamsOptions.ButtonCancelCaption, "teams-button-cancel-caption", teamsOptions.ButtonCancelCaption, "Teams button cancel caption")
+	flags.StringVar(&teamsOptions.ButtonApproveCaption, "teams-button-approve-caption", teamsOptions.ButtonApproveCaption, "Teams button approve caption")
+	flags.StringVar(&teamsOptions.ButtonRejectCaption, "teams-button-reject-caption", teamsOptions.ButtonRejectCaption, "Teams button reject caption")
+	flags.StringVar(&teamsOptions.FormCancelled, "teams-form-cancelled", teamsOptions.FormCancelled, "Teams form cancelled text")
+	flags.StringVar(&teamsOptions.CacheTTL, "teams-cache-ttl", teamsOptions.CacheTTL, "Teams cache TTL")
+
+	flags.StringVar(&discordOptions.BotToken, "discord-bot-token", discordOptions.BotToken, "Discord bot token")
+	flags.StringVar(&discordOptions.GuildID, "discord-guild-id", discordOptions.GuildID, "Discord guild ID to register commands, empty for global")
+	flags.BoolVar(&discordOptions.Debug, "discord-debug", discordOptions.Debug, "Discord debug")
+	flags.StringVar(&discordOptions.DefaultCommand, "discord-default-command", discordOptions.DefaultCommand, "Discord default command")
+	flags.StringVar(&discordOptions.UserPermissions, "discord-user-permissions", discordOptions.UserPermissions, "Discord user permissions")
+	flags.BoolVar(&discordOptions.ApprovalAny, "discord-approval-any", discordOptions.ApprovalAny, "Discord approval by any user")
+	flags.StringVar(&discordOptions.ReactionDoing, "discord-reaction-doing", discordOptions.ReactionDoing, "Discord reaction doing emoji")
+	flags.StringVar(&discordOptions.ReactionDone, "discord-reaction-done", discordOptions.ReactionDone, "Discord reaction done emoji")
+	flags.StringVar(&discordOptions.ReactionFailed, "discord-reaction-failed", discordOptions.ReactionFailed, "Discord reaction failed emoji")
+	flags.StringVar(&discordOptions.ReactionForm, "discord-reaction-form", discordOptions.ReactionForm, "Discord reaction form emoji")
+	flags.StringVar(&discordOptions.ReactionApproval, "discord-reaction-approval", discordOptions.ReactionApproval, "Discord reaction approval emoji")
+	flags.StringVar(&discordOptions.ButtonFormCaption, "discord-button-form-caption", discordOptions.ButtonFormCaption, "Discord button form caption")
+	flags.StringVar(&discordOptions.ButtonCancelCaption, "discord-button-cancel-caption", discordOptions.ButtonCancelCaption, "Discord button cancel caption")
+	flags.StringVar(&discordOptions.ButtonApproveCaption, "discord-button-approve-caption", discordOptions.ButtonApproveCaption, "Discord button approve caption")
+	flags.StringVar(&discordOptions.ButtonRejectCaption, "discord-button-reject-caption", discordOptions.ButtonRejectCaption, "Discord button reject caption")
+	flags.StringVar(&discordOptions.FormCancelled, "discord-form-cancelled", discordOptions.FormCancelled, "Discord form cancelled text")
+	flags.StringVar(&discordOptions.CacheTTL, "discord-cache-ttl", discordOptions.CacheTTL, "Discord cache TTL")
+
+	flags.StringVar(&matrixOptions.URL, "matrix-url", matrixOptions.URL, "Matrix homeserver URL")
+	flags.StringVar(&matrixOptions.AccessToken, "matrix-access-token", matrixOptions.AccessToken, "Matrix access token")
+	flags.BoolVar(&matrixOptions.Debug, "matrix-debug", matrixOptions.Debug, "Matrix debug")
+	flags.IntVar(&matrixOptions.Timeout, "matrix-timeout", matrixOptions.Timeout, "Matrix timeout")
+	flags.BoolVar(&matrixOptions.Insecure, "matrix-insecure", matrixOptions.Insecure, "Matrix insecure")
+	flags.StringVar(&matrixOptions.Prefix, "matrix-prefix", matrixOptions.Prefix, "Matrix command prefix")
+	flags.BoolVar(&matrixOptions.AutoJoin, "matrix-auto-join", matrixOptions.AutoJoin, "Matrix auto join rooms on invite")
+	flags.StringVar(&matrixOptions.DefaultCommand, "matrix-default-command", matrixOptions.DefaultCommand, "Matrix default command")
+	flags.StringVar(&matrixOptions.UserPermissions, "matrix-user-permissions", matrixOptions.UserPermissions, "Matrix user permissions")
+	flags.BoolVar(&matrixOptions.ApprovalAny, "matrix-approval-any", matrixOptions.ApprovalAny, "Matrix approval by any user")
+	flags.StringVar(&matrixOptions.ReactionDoing, "matrix-reaction-doing", matrixOptions.ReactionDoing, "Matrix reaction doing emoji")
+	flags.StringVar(&matrixOptions.ReactionDone, "matrix-reaction-done", matrixOptions.ReactionDone, "Matrix reaction done emoji")
+	flags.StringVar(&matrixOptions.ReactionFailed, "matrix-reaction-failed", matrixOptions.ReactionFailed, "Matrix reaction failed emoji")
+	flags.StringVar(&matrixOptions.ReactionForm, "matrix-reaction-form", matrixOptions.ReactionForm, "Matrix reaction form emoji")
+	flags.StringVar(&matrixOptions.ReactionApproval, "matrix-reaction-approval", matrixOptions.ReactionApproval, "Matrix reaction approval emoji")
+	flags.StringVar(&matrixOptions.ReplyApprove, "matrix-reply-approve", matrixOptions.ReplyApprove, "Matrix reply to approve")
+	flags.StringVar(&matrixOptions.ReplyReject, "matrix-reply-reject", matrixOptions.ReplyReject, "Matrix reply to reject")
+	flags.StringVar(&matrixOptions.ReplyCancel, "matrix-reply-cancel", matrixOptions.ReplyCancel, "Matrix reply to cancel form")
+	flags.StringVar(&matrixOptions.FormInvalid, "matrix-form-invalid", matrixOptions.FormInvalid, "Matrix form invalid value text")
+	flags.StringVar(&matrixOptions.FormCancelled, "matrix-form-cancelled", matrixOptions.FormCancelled, "Matrix form cancelled text")
+	flags.IntVar(&matrixOptions.SyncTimeout, "matrix-sync-timeout", matrixOptions.SyncTimeout, "Matrix sync long polling timeout in seconds")
+	flags.IntVar(&matrixOptions.ReconnectInterval, "matrix-reconnect-interval", matrixOptions.ReconnectInterval, "Matrix sync retry interval in seconds")
+	flags.StringVar(&matrixOptions.CacheTTL, "matrix-cache-ttl", matrixOptions.CacheTTL, "Matrix cache TTL")
+
+	flags.StringVar(&httpOptions.Listen, "http-listen", httpOptions.Listen, "HTTP API listen address")
+	flags.StringVar(&httpOptions.Cert, "http-cert", httpOptions.Cert, "HTTP API TLS certificate file")
+	flags.StringVar(&httpOptions.Key, "http-key", httpOptions.Key, "HTTP API TLS key file")
+	flags.StringVar(&httpOptions.Tokens, "http-tokens", httpOptions.Tokens, "HTTP API bearer tokens mapped to users: token1=user1,token2=user2")
+	flags.StringVar(&httpOptions.Channel, "http-channel", httpOptions.Channel, "HTTP API default channel")
+	flags.BoolVar(&httpOptions.Debug, "http-debug", httpOptions.Debug, "HTTP API debug")
+	flags.StringVar(&httpOptions.UserPermissions, "http-user-permissions", httpOptions.UserPermissions, "HTTP API user permissions")
+	flags.StringVar(&httpOptions.CacheTTL, "http-cache-ttl", httpOptions.CacheTTL, "HTTP API cache TTL")
+
+	flags.StringVar(&webhookOptions.Listen, "webhook-listen", webhookOptions.Listen, "Webhook listen address")
+	flags.StringVar(&webhookOptions.Path, "webhook-path", webhookOptions.Path, "Webhook URL path")
+	flags.StringVar(&webhookOptions.Token, "webhook-token", webhookOptions.Token, "Webhook token to verify payloads, required with user permissions")
+	flags.StringVar(&webhookOptions.TokenPath, "webhook-token-path", webhookOptions.TokenPath, "Webhook payload path to token")
+	flags.StringVar(&webhookOptions.TextPath, "webhook-text-path", webhookOptions.TextPath, "Webhook payload path to text")
+	flags.StringVar(&webhookOptions.UserIDPath, "webhook-user-id-path", webhookOptions.UserIDPath, "Webhook payload path to user ID")
+	flags.StringVar(&webhookOptions.UserNamePath, "webhook-user-name-path", webhookOptions.UserNamePath, "Webhook payload path to user name")
+	flags.StringVar(&webhookOptions.ChannelPath, "webhook-channel-path", webhookOptions.ChannelPath, "Webhook payload path to channel")
+	flags.StringVar(&webhookOptions.ThreadPath, "webhook-thread-path", webhookOptions.ThreadPath, "Webhook payload path to thread")
+	flags.StringVar(&webhookOptions.MessageIDPath, "webhook-message-id-path", webhookOptions.MessageIDPath, "Webhook payload path to message ID")
+	flags.StringVar(&webhookOptions.Prefix, "webhook-prefix", webhookOptions.Prefix, "Webhook trigger prefix to strip from text")
+	flags.StringVar(&webhookOptions.ReplyField, "webhook-reply-field", webhookOptions.ReplyField, "Webhook reply field for text")
+	flags.BoolVar(&webhookOptions.Async, "webhook-async", webhookOptions.Async, "Webhook replies through incoming URL only")
+	flags.StringVar(&webhookOptions.IncomingURL, "webhook-incoming-url", webhookOptions.IncomingURL, "Webhook incoming URL")
+	flags.StringVar(&webhookOptions.ChannelField, "webhook-channel-field", webhookOptions.ChannelField, "Webhook incoming field for channel")
+	flags.StringVar(&webhookOptions.ThreadField, "webhook-thread-field", webhookOptions.ThreadField, "Webhook incoming field for thread")
+	flags.IntVar(&webhookOptions.Timeout, "webhook-timeout", webhookOptions.Timeout, "Webhook incoming timeout")
+	flags.BoolVar(&webhookOptions.Insecure, "webhook-insecure", webhookOptions.Insecure, "Webhook incoming insecure")
+	flags.BoolVar(&webhookOptions.Debug, "webhook-debug", webhookOptions.Debug, "Webhook debug")
+	flags.StringVar(&webhookOptions.DefaultCommand, "webhook-default-command", webhookOptions.DefaultCommand, "Webhook default command")
+	flags.StringVar(&webhookOptions.UserPermissions, "webhook-user-permissions", webhookOptions.UserPermissions, "Webhook user permissions")
+	flags.StringVar(&webhookOptions.CacheTTL, "webhook-cache-ttl", webhookOptions.CacheTTL, "Webhook cache TTL")
+
+	flags.StringVar(&googleChatOptions.CredentialsFile, "googlechat-credentials-file", googleChatOptions.CredentialsFile, "Google Chat service account credentials file")
+	flags.StringVar(&googleChatOptions.Audience, "googlechat-audience", googleChatOptions.Audience, "Google Chat project number to verify requests, required")
+	flags.StringVar(&googleChatOptions.Listen, "googlechat-listen", googleChatOptions.Listen, "Google Chat endpoint listen address")
+	flags.StringVar(&googleChatOptions.Path, "googlechat-path", googleChatOptions.Path, "Google Chat endpoint path")
+	flags.StringVar(&googleChatOptions.APIURL, "googlechat-api-url", googleChatOptions.APIURL, "Google Chat API URL")
+	flags.StringVar(&googleChatOptions.KeysURL, "googlechat-keys-url", googleChatOptions.KeysURL, "Google Chat signing keys URL")
+	flags.IntVar(&googleChatOptions.Timeout, "googlechat-timeout", googleChatOptions.Timeout, "Google Chat timeout")
+	flags.BoolVar(&googleChatOptions.Insecure, "googlechat-insecure", googleChatOptions.Insecure, "Google Chat insecure")
+	flags.BoolVar(&googleChatOptions.Debug, "googlechat-debug", googleChatOptions.Debug, "Google Chat debug")
+	flags.StringVar(&googleChatOptions.DefaultCommand, "googlechat-default-command", googleChatOptions.DefaultCommand, "Google Chat default command")
+	flags.StringVar(&googleChatOptions.UserPermissions, "googlechat-user-permissions", googleChatOptions.UserPermissions, "Google Chat user permissions")
+	flags.BoolVar(&googleChatOptions.ApprovalAny, "googlechat-approval-any", googleChatOptions.ApprovalAny, "Google Chat approval by any user")
+	flags.StringVar(&googleChatOptions.ButtonFormCaption, "googlechat-button-form-caption", googleChatOptions.ButtonFormCaption, "Google Chat button form caption")
+	flags.StringVar(&googleChatOptions.ButtonSubmitCaption, "googlechat-button-submit-caption", googleChatOptions.ButtonSubmitCaption, "Google Chat button submit caption")
+	flags.StringVar(&googleChatOptions.ButtonCancelCaption, "googlechat-button-cancel-caption", googleChatOptions.ButtonCancelCaption, "Google Chat button cancel caption")
+	flags.StringVar(&googleChatOptions.ButtonApproveCaption, "googlechat-button-approve-caption", googleChatOptions.ButtonApproveCaption, "Google Chat button approve caption")
+	flags.StringVar(&googleChatOptions.ButtonRejectCaption, "googlechat-button-reject-caption", googleChatOptions.ButtonRejectCaption, "Google Chat button reject caption")
+	flags.StringVar(&googleChatOptions.FormCancelled, "googlechat-form-cancelled", googleChatOptions.FormCancelled, "Google Chat form cancelled text")
+	flags.StringVar(&googleChatOptions.CacheTTL, "googlechat-cache-ttl", googleChatOptions.CacheTTL, "Google Chat cache TTL")
 
 	flags.StringVar(&slackOptions.BotToken, "slack-bot-token", slackOptions.BotToken, "Slack bot token")
 	flags.StringVar(&slackOptions.AppToken, "slack-app-token", slackOptions.AppToken, "Slack app token")
@@ -311,15 +1204,131 @@ func Execute() {
 	flags.StringVar(&slackOptions.PublicChannel, "slack-public-channel", slackOptions.PublicChannel, "Slack public channel")
 	flags.StringVar(&slackOptions.AttachmentColor, "slack-attachment-color", slackOptions.AttachmentColor, "Slack attachment color")
 	flags.StringVar(&slackOptions.ErrorColor, "slack-error-color", slackOptions.ErrorColor, "Slack error color")
+	flags.StringVar(&slackInstances, "slack-instances", slackInstances, "Slack instances config file or YAML")
+
+	flags.BoolVar(&reloadOptions.Watch, "reload-watch", reloadOptions.Watch, "Reload processors on changes in commands, templates and runbooks dirs")
+	flags.IntVar(&reloadOptions.Delay, "reload-delay", reloadOptions.Delay, "Reload delay in seconds to collect file changes")
+	flags.StringVar(&reloadOptions.Listen, "reload-listen", reloadOptions.Listen, "Reload endpoint listen address")
+	flags.StringVar(&reloadOptions.Path, "reload-path", reloadOptions.Path, "Reload endpoint path")
+	flags.StringVar(&reloadOptions.Token, "reload-token", reloadOptions.Token, "Reload endpoint bearer token")
 
 	flags.StringVar(&defaultOptions.CommandsDir, "default-commands-dir", defaultOptions.CommandsDir, "Default commands directory")
 	flags.StringVar(&defaultOptions.TemplatesDir, "default-templates-dir", defaultOptions.TemplatesDir, "Default templates directory")
+	flags.StringVar(&defaultOptions.RunbooksDir, "default-runbooks-dir", defaultOptions.RunbooksDir, "Default runbooks directory")
 	flags.StringVar(&defaultOptions.CommandExt, "default-command-ext", defaultOptions.CommandExt, "Default command extension")
 	flags.StringVar(&defaultOptions.ConfigExt, "default-config-ext", defaultOptions.ConfigExt, "Default config extension")
+	flags.StringVar(&defaultOptions.ManifestExt, "default-manifest-ext", defaultOptions.ManifestExt, "Default manifest extension")
 	flags.StringVar(&defaultOptions.Error, "default-error", defaultOptions.Error, "Default error")
 
+	flags.StringVar(&httpProcessorOptions.CommandsDir, "http-processor-commands-dir", httpProcessorOptions.CommandsDir, "HTTP processor commands directory")
+	flags.StringVar(&httpProcessorOptions.ConfigExt, "http-processor-config-ext", httpProcessorOptions.ConfigExt, "HTTP processor config extension")
+	flags.IntVar(&httpProcessorOptions.Timeout, "http-processor-timeout", httpProcessorOptions.Timeout, "HTTP processor timeout")
+	flags.BoolVar(&httpProcessorOptions.Insecure, "http-processor-insecure", httpProcessorOptions.Insecure, "HTTP processor insecure")
+	flags.StringVar(&httpProcessorOptions.Error, "http-processor-error", httpProcessorOptions.Error, "HTTP processor error")
+
+	flags.StringVar(&execProcessorOptions.CommandsDir, "exec-processor-commands-dir", execProcessorOptions.CommandsDir, "Exec processor commands directory")
+	flags.StringVar(&execProcessorOptions.TemplatesDir, "exec-processor-templates-dir", execProcessorOptions.TemplatesDir, "Exec processor templates directory")
+	flags.StringVar(&execProcessorOptions.Extensions, "exec-processor-extensions", execProcessorOptions.Extensions, "Exec processor script extensions")
+	flags.StringVar(&execProcessorOptions.ConfigExt, "exec-processor-config-ext", execProcessorOptions.ConfigExt, "Exec processor config extension")
+	flags.IntVar(&execProcessorOptions.Timeout, "exec-processor-timeout", execProcessorOptions.Timeout, "Exec processor timeout in seconds")
+	flags.StringVar(&execProcessorOptions.Error, "exec-processor-error", execProcessorOptions.Error, "Exec processor error")
+
+	flags.StringVar(&remoteProcessorOptions.URLs, "remote-processor-urls", remoteProcessorOptions.URLs, "Remote processor service urls")
+	flags.StringVar(&remoteProcessorOptions.Token, "remote-processor-token", remoteProcessorOptions.Token, "Remote processor token")
+	flags.IntVar(&remoteProcessorOptions.Timeout, "remote-processor-timeout", remoteProcessorOptions.Timeout, "Remote processor timeout")
+	flags.BoolVar(&remoteProcessorOptions.Insecure, "remote-processor-insecure", remoteProcessorOptions.Insecure, "Remote processor insecure")
+	flags.IntVar(&remoteProcessorOptions.Refresh, "remote-processor-refresh", remoteProcessorOptions.Refresh, "Remote processor catalog refresh interval in seconds")
+	flags.StringVar(&remoteProcessorOptions.Error, "remote-processor-error", remoteProcessorOptions.Error, "Remote processor error")
+
+	flags.StringVar(&starlarkProcessorOptions.CommandsDir, "starlark-processor-commands-dir", starlarkProcessorOptions.CommandsDir, "Starlark processor commands directory")
+	flags.StringVar(&starlarkProcessorOptions.TemplatesDir, "starlark-processor-templates-dir", starlarkProcessorOptions.TemplatesDir, "Starlark processor templates directory")
+	flags.StringVar(&starlarkProcessorOptions.RunbooksDir, "starlark-processor-runbooks-dir", starlarkProcessorOptions.RunbooksDir, "Starlark processor runbooks directory")
+	flags.StringVar(&starlarkProcessorOptions.CommandExt, "starlark-processor-command-ext", starlarkProcessorOptions.CommandExt, "Starlark processor command extension")
+	flags.StringVar(&starlarkProcessorOptions.ConfigExt, "starlark-processor-config-ext", starlarkProcessorOptions.ConfigExt, "Starlark processor config extension")
+	flags.IntVar(&starlarkProcessorOptions.Steps, "starlark-processor-steps", starlarkProcessorOptions.Steps, "Starlark processor max execution steps")
+	flags.IntVar(&starlarkProcessorOptions.Timeout, "starlark-processor-timeout", starlarkProcessorOptions.Timeout, "Starlark processor timeout in seconds")
+	flags.StringVar(&starlarkProcessorOptions.Error, "starlark-processor-error", starlarkProcessorOptions.Error, "Starlark processor error")
+
+	flags.StringVar(&prometheusProcessorOptions.CommandsDir, "prometheus-processor-commands-dir", prometheusProcessorOptions.CommandsDir, "Prometheus processor commands directory")
+	flags.StringVar(&prometheusProcessorOptions.ConfigExt, "prometheus-processor-config-ext", prometheusProcessorOptions.ConfigExt, "Prometheus processor config extension")
+	flags.StringVar(&prometheusProcessorOptions.URLs, "prometheus-processor-urls", prometheusProcessorOptions.URLs, "Prometheus processor endpoints as name=url list")
+	flags.IntVar(&prometheusProcessorOptions.Timeout, "prometheus-processor-timeout", prometheusProcessorOptions.Timeout, "Prometheus processor timeout")
+	flags.BoolVar(&prometheusProcessorOptions.Insecure, "prometheus-processor-insecure", prometheusProcessorOptions.Insecure, "Prometheus processor insecure")
+	flags.IntVar(&prometheusProcessorOptions.Width, "prometheus-processor-width", prometheusProcessorOptions.Width, "Prometheus processor chart width")
+	flags.IntVar(&prometheusProcessorOptions.Height, "prometheus-processor-height", prometheusProcessorOptions.Height, "Prometheus processor chart height")
+	flags.StringVar(&prometheusProcessorOptions.Error, "prometheus-processor-error", prometheusProcessorOptions.Error, "Prometheus processor error")
+
+	flags.StringVar(&sqlProcessorOptions.CommandsDir, "sql-processor-commands-dir", sqlProcessorOptions.CommandsDir, "SQL processor commands directory")
+	flags.StringVar(&sqlProcessorOptions.ConfigExt, "sql-processor-config-ext", sqlProcessorOptions.ConfigExt, "SQL processor config extension")
+	flags.StringVar(&sqlProcessorOptions.Connections, "sql-processor-connections", sqlProcessorOptions.Connections, "SQL processor connections config file or YAML")
+	flags.IntVar(&sqlProcessorOptions.Timeout, "sql-processor-timeout", sqlProcessorOptions.Timeout, "SQL processor timeout")
+	flags.IntVar(&sqlProcessorOptions.MaxRows, "sql-processor-max-rows", sqlProcessorOptions.MaxRows, "SQL processor max rows in table, larger results go as CSV")
+	flags.IntVar(&sqlProcessorOptions.Limit, "sql-processor-limit", sqlProcessorOptions.Limit, "SQL processor rows limit")
+	flags.StringVar(&sqlProcessorOptions.Error, "sql-processor-error", sqlProcessorOptions.Error, "SQL processor error")
+
+	flags.StringVar(&openapiProcessorOptions.Specs, "openapi-processor-specs", openapiProcessorOptions.Specs, "OpenAPI processor spec files or urls")
+	flags.StringVar(&openapiProcessorOptions.Server, "openapi-processor-server", openapiProcessorOptions.Server, "OpenAPI processor server overriding specs")
+	flags.StringVar(&openapiProcessorOptions.Token, "openapi-processor-token", openapiProcessorOptions.Token, "OpenAPI processor token")
+	flags.IntVar(&openapiProcessorOptions.Timeout, "openapi-processor-timeout", openapiProcessorOptions.Timeout, "OpenAPI processor timeout")
+	flags.BoolVar(&openapiProcessorOptions.Insecure, "openapi-processor-insecure", openapiProcessorOptions.Insecure, "OpenAPI processor insecure")
+	flags.StringVar(&openapiProcessorOptions.TemplatesDir, "openapi-processor-templates-dir", openapiProcessorOptions.TemplatesDir, "OpenAPI processor response templates directory")
+	flags.StringVar(&openapiProcessorOptions.Error, "openapi-processor-error", openapiProcessorOptions.Error, "OpenAPI processor error")
+
+	flags.BoolVar(&helpOptions.Enabled, "help-enabled", helpOptions.Enabled, "Help enabled")
+	flags.StringVar(&helpOptions.Command, "help-command", helpOptions.Command, "Help command name")
+	flags.StringVar(&helpOptions.Description, "help-description", helpOptions.Description, "Help command description")
+
+	flags.BoolVar(&suggestOptions.Enabled, "suggest-enabled", suggestOptions.Enabled, "Suggest enabled")
+	flags.StringVar(&suggestOptions.Command, "suggest-command", suggestOptions.Command, "Suggest command name")
+	flags.StringVar(&suggestOptions.Description, "suggest-description", suggestOptions.Description, "Suggest command description")
+	flags.IntVar(&suggestOptions.Distance, "suggest-distance", suggestOptions.Distance, "Suggest max edit distance to command")
+	flags.IntVar(&suggestOptions.Limit, "suggest-limit", suggestOptions.Limit, "Suggest max number of commands")
+
+	flags.BoolVar(&kubernetesProcessorOptions.Enabled, "kubernetes-processor-enabled", kubernetesProcessorOptions.Enabled, "Kubernetes processor enabled")
+	flags.StringVar(&kubernetesProcessorOptions.Group, "kubernetes-processor-group", kubernetesProcessorOptions.Group, "Kubernetes processor group")
+	flags.StringVar(&kubernetesProcessorOptions.Kubeconfig, "kubernetes-processor-kubeconfig", kubernetesProcessorOptions.Kubeconfig, "Kubernetes processor kubeconfig path")
+	flags.StringVar(&kubernetesProcessorOptions.Contexts, "kubernetes-processor-contexts", kubernetesProcessorOptions.Contexts, "Kubernetes processor allowed contexts")
+	flags.StringVar(&kubernetesProcessorOptions.Namespace, "kubernetes-processor-namespace", kubernetesProcessorOptions.Namespace, "Kubernetes processor default namespace")
+	flags.IntVar(&kubernetesProcessorOptions.LogLines, "kubernetes-processor-log-lines", kubernetesProcessorOptions.LogLines, "Kubernetes processor log lines")
+	flags.IntVar(&kubernetesProcessorOptions.Timeout, "kubernetes-processor-timeout", kubernetesProcessorOptions.Timeout, "Kubernetes processor timeout in seconds")
+	flags.BoolVar(&kubernetesProcessorOptions.Approval, "kubernetes-processor-approval", kubernetesProcessorOptions.Approval, "Kubernetes processor approval for write commands")
+	flags.StringVar(&kubernetesProcessorOptions.ApprovalChannel, "kubernetes-processor-approval-channel", kubernetesProcessorOptions.ApprovalChannel, "Kubernetes processor approval channel")
+	flags.StringVar(&kubernetesProcessorOptions.Error, "kubernetes-processor-error", kubernetesProcessorOptions.Error, "Kubernetes processor error")
+
 	interceptSyscall()
 
+	shellCmd := &cobra.Command{
+		Use:   "shell",
+		Short: "Run commands in interactive shell",
+		Run: func(cmd *cobra.Command, args []string) {
+
+			obs := common.NewObservability(logs, metrics)
+			processors := common.NewProcessors()
+
+			err := buildProcessors(obs, processors)
+			if err != nil {
+				os.Exit(1)
+			}
+			remotes := buildRemotes(obs, processors)
+			newReloader(reloadOptions, obs, processors, remotes, nil).start()
+
+			bot.NewShell(shellOptions, obs, processors, os.Stdin, os.Stdout).Start(nil)
+		},
+	}
+
+	shellFlags := shellCmd.Flags()
+	shellFlags.StringVar(&shellOptions.Prompt, "shell-prompt", shellOptions.Prompt, "Shell prompt")
+	shellFlags.StringVar(&shellOptions.User, "shell-user", shellOptions.User, "Shell user")
+	shellFlags.StringVar(&shellOptions.Channel, "shell-channel", shellOptions.Channel, "Shell channel")
+	shellFlags.StringVar(&shellOptions.DefaultCommand, "shell-default-command", shellOptions.DefaultCommand, "Shell default command")
+	shellFlags.StringVar(&shellOptions.AttachmentsDir, "shell-attachments-dir", shellOptions.AttachmentsDir, "Shell directory to save image and file attachments")
+	shellFlags.StringVar(&shellOptions.ReactionDoing, "shell-reaction-doing", shellOptions.ReactionDoing, "Shell reaction doing text")
+	shellFlags.StringVar(&shellOptions.ReactionDone, "shell-reaction-done", shellOptions.ReactionDone, "Shell reaction done text")
+	shellFlags.StringVar(&shellOptions.ReactionFailed, "shell-reaction-failed", shellOptions.ReactionFailed, "Shell reaction failed text")
+	shellFlags.StringVar(&shellOptions.ReactionApproval, "shell-reaction-approval", shellOptions.ReactionApproval, "Shell reaction approval text")
+
+	rootCmd.AddCommand(shellCmd)
+
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "version",
 		Short: "Print the version number",
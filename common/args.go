@@ -0,0 +1,340 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devopsext/utils"
+)
+
+const (
+	ArgTypeString   = "string"
+	ArgTypeInt      = "int"
+	ArgTypeBool     = "bool"
+	ArgTypeDuration = "duration"
+	ArgTypeList     = "list"
+	ArgTypeEnum     = "enum"
+)
+
+type ArgType string
+
+// Arg is declared argument set by position, --name=value, --name value or name=value
+type Arg struct {
+	Name        string
+	Type        ArgType
+	Description string
+	Default     string
+	Required    bool
+	Values      []string
+}
+
+// ArgsCommand is implemented by commands with declared arguments instead of regex params
+type ArgsCommand interface {
+	Args() []Arg
+}
+
+// SplitArgs splits text by spaces like shell does, quotes and backslash keep spaces in value
+func SplitArgs(text string) ([]string, error) {
+
+	r := []string{}
+	var sb strings.Builder
+	quote := rune(0)
+	token := false
+	escape := false
+
+	for _, c := range text {
+
+		switch {
+		case escape:
+			sb.WriteRune(c)
+			escape = false
+		case c == '\\' && quote != '\'':
+			escape = true
+			token = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+				continue
+			}
+			sb.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote = c
+			token = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if token {
+				r = append(r, sb.String())
+				sb.Reset()
+				token = false
+			}
+		default:
+			sb.WriteRune(c)
+			token = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed quote %c", quote)
+	}
+	if escape {
+		return nil, fmt.Errorf("unfinished escape")
+	}
+	if token {
+		r = append(r, sb.String())
+	}
+	return r, nil
+}
+
+func argValue(arg Arg, value string) (interface{}, error) {
+
+	switch arg.Type {
+	case ArgTypeInt:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be integer, got %s", arg.Name, value)
+		}
+		return v, nil
+	case ArgTypeBool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be true or false, got %s", arg.Name, value)
+		}
+		return v, nil
+	case ArgTypeDuration:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be duration like 30s or 5m, got %s", arg.Name, value)
+		}
+		return v, nil
+	case ArgTypeList:
+		return RemoveEmptyStrings(strings.Split(value, ",")), nil
+	case ArgTypeEnum:
+		if !utils.Contains(arg.Values, value) {
+			return nil, fmt.Errorf("%s should be one of %s, got %s", arg.Name, strings.Join(arg.Values, ", "), value)
+		}
+		return value, nil
+	}
+	return value, nil
+}
+
+func findArg(args []Arg, name string) *Arg {
+
+	for i := range args {
+		if args[i].Name == name {
+			return &args[i]
+		}
+	}
+	return nil
+}
+
+// ParseArgs converts text into typed params, named values are taken firstly and positional fill the rest by order
+func ParseArgs(args []Arg, text string) (ExecuteParams, error) {
+
+	r := make(ExecuteParams)
+
+	tokens, err := SplitArgs(text)
+	if err != nil {
+		return r, err
+	}
+
+	set := func(arg *Arg, value string) error {
+		v, err := argValue(*arg, value)
+		if err != nil {
+			return err
+		}
+		// lists could be repeated
+		if old, ok := r[arg.Name].([]string); ok && arg.Type == ArgTypeList {
+			v = append(old, v.([]string)...)
+		}
+		r[arg.Name] = v
+		return nil
+	}
+
+	positional := []string{}
+	for i := 0; i < len(tokens); i++ {
+
+		t := tokens[i]
+		if t == "--" {
+			positional = append(positional, tokens[i+1:]...)
+			break
+		}
+
+		if strings.HasPrefix(t, "--") {
+
+			name, value, found := strings.Cut(strings.TrimPrefix(t, "--"), "=")
+			arg := findArg(args, name)
+			if arg == nil {
+				return r, fmt.Errorf("unknown flag --%s", name)
+			}
+			if !found {
+				if arg.Type == ArgTypeBool {
+					value = "true"
+				} else if i+1 < len(tokens) {
+					i++
+					value = tokens[i]
+				} else {
+					return r, fmt.Errorf("flag --%s needs value", name)
+				}
+			}
+			err := set(arg, value)
+			if err != nil {
+				return r, err
+			}
+			continue
+		}
+
+		name, value, found := strings.Cut(t, "=")
+		if found {
+			arg := findArg(args, name)
+			if arg != nil {
+				err := set(arg, value)
+				if err != nil {
+					return r, err
+				}
+				continue
+			}
+		}
+		positional = append(positional, t)
+	}
+
+	for _, arg := range args {
+
+		if len(positional) == 0 {
+			break
+		}
+		if _, ok := r[arg.Name]; ok {
+			continue
+		}
+		a := arg
+		if arg.Type == ArgTypeList {
+			// list takes the rest
+			err := set(&a, strings.Join(positional, ","))
+			if err != nil {
+				return r, err
+			}
+			positional = nil
+			break
+		}
+		err := set(&a, positional[0])
+		if err != nil {
+			return r, err
+		}
+		positional = positional[1:]
+	}
+	if len(positional) > 0 {
+		return r, fmt.Errorf("unexpected arguments %s", strings.Join(positional, " "))
+	}
+	return r, argsDefaults(args, r)
+}
+
+// argsDefaults sets defaults of absent args and checks required ones
+func argsDefaults(args []Arg, params ExecuteParams) error {
+
+	for _, arg := range args {
+
+		if _, ok := params[arg.Name]; ok {
+			continue
+		}
+		if !utils.IsEmpty(arg.Default) {
+			v, err := argValue(arg, arg.Default)
+			if err != nil {
+				return err
+			}
+			params[arg.Name] = v
+			continue
+		}
+		if arg.Required {
+			return fmt.Errorf("%s is required", arg.Name)
+		}
+	}
+	return nil
+}
+
+// argText turns value like JSON one into text of argument, lists are joined by comma
+func argText(value interface{}) string {
+
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string:
+		return strings.Join(v, ",")
+	case []interface{}:
+		items := []string{}
+		for _, i := range v {
+			items = append(items, argText(i))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// SetArgs puts named values like JSON ones into params, declared args are converted and checked as ParseArgs does,
+// other values like fields are kept as they are
+func SetArgs(args []Arg, params ExecuteParams, values map[string]interface{}) error {
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+
+		value := values[name]
+		arg := findArg(args, name)
+		if arg == nil {
+			params[name] = value
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		v, err := argValue(*arg, argText(value))
+		if err != nil {
+			return err
+		}
+		params[name] = v
+	}
+	return argsDefaults(args, params)
+}
+
+// ArgsUsage describes how command should be called with its arguments
+func ArgsUsage(command string, args []Arg) string {
+
+	line := []string{command}
+	lines := []string{}
+
+	for _, arg := range args {
+
+		typ := string(arg.Type)
+		if typ == "" {
+			typ = ArgTypeString
+		}
+		if arg.Type == ArgTypeEnum {
+			typ = strings.Join(arg.Values, "|")
+		}
+
+		if arg.Required {
+			line = append(line, fmt.Sprintf("<%s>", arg.Name))
+		} else {
+			line = append(line, fmt.Sprintf("[%s]", arg.Name))
+		}
+
+		info := []string{typ}
+		if arg.Required {
+			info = append(info, "required")
+		}
+		if !utils.IsEmpty(arg.Default) {
+			info = append(info, fmt.Sprintf("default %s", arg.Default))
+		}
+		l := fmt.Sprintf("  %s (%s)", arg.Name, strings.Join(info, ", "))
+		if !utils.IsEmpty(arg.Description) {
+			l = fmt.Sprintf("%s %s", l, arg.Description)
+		}
+		lines = append(lines, l)
+	}
+
+	return fmt.Sprintf("Usage: %s\n%s", strings.Join(line, " "), strings.Join(lines, "\n"))
+}
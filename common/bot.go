@@ -1,8 +1,12 @@
 package common
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 
+	sre "github.com/devopsext/sre/common"
 	"github.com/devopsext/utils"
 )
 
@@ -25,10 +29,166 @@ type Bot interface {
 	UpdateMessage(channel, ID, message string) error
 }
 
+// Reloader is implemented by bots which register commands on start
+type Reloader interface {
+	Reload() error
+}
+
 type Bots struct {
 	list []Bot
 }
 
+// BotResponse is a response merged from bot, command and executor ones
+type BotResponse struct {
+	visible  bool
+	original bool
+	duration bool
+	error    bool
+}
+
+func (r *BotResponse) Visible() bool {
+	return r.visible
+}
+
+func (r *BotResponse) Duration() bool {
+	return r.duration
+}
+
+func (r *BotResponse) Original() bool {
+	return r.original
+}
+
+func (r *BotResponse) Error() bool {
+	return r.error
+}
+
+// BuildResponse merges responses, flag once set is kept unless overwrite is true
+func BuildResponse(overwrite bool, list ...Response) *BotResponse {
+
+	r := &BotResponse{}
+
+	for _, response := range list {
+		if utils.IsEmpty(response) {
+			continue
+		}
+		if !r.visible || overwrite {
+			r.visible = response.Visible()
+		}
+		if !r.error || overwrite {
+			r.error = response.Error()
+		}
+		if !r.duration || overwrite {
+			r.duration = response.Duration()
+		}
+		if !r.original || overwrite {
+			r.original = response.Original()
+		}
+	}
+	return r
+}
+
+// UpdateCounters counts requests to bot by group, command, text and user
+func UpdateCounters(meter sre.Meter, bot, group, command, text, userID string) {
+
+	labels := make(map[string]string)
+	if !utils.IsEmpty(group) {
+		labels["group"] = group
+	}
+	if !utils.IsEmpty(command) {
+		labels["command"] = command
+	}
+	if !utils.IsEmpty(text) {
+		labels["text"] = LabelValue(text)
+	}
+	labels["user_id"] = userID
+
+	meter.Counter("processor", "requests", "Count of all requests", labels, bot, "bot").Inc()
+}
+
+// GroupName is command path which permissions are checked against
+func GroupName(group string, cmd Command) string {
+
+	groupName := cmd.Name()
+	if !utils.IsEmpty(group) {
+		groupName = fmt.Sprintf("%s/%s", group, groupName)
+	}
+	return groupName
+}
+
+// Permitted checks command against user commands, command without permissions is allowed to everyone
+func Permitted(user User, cmd Command, groupName string) bool {
+
+	if !cmd.Permissions() {
+		return true
+	}
+	if utils.IsEmpty(user) {
+		return false
+	}
+	commands := user.Commands()
+	return !(len(commands) > 0 && !utils.Contains(commands, groupName))
+}
+
+// FormNeeded is true if any required field has no value
+func FormNeeded(fields []Field, params ExecuteParams) bool {
+
+	for _, f := range fields {
+		if !f.Required {
+			continue
+		}
+		if params == nil || utils.IsEmpty(params[f.Name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldsByType returns names of command fields with one of types
+func FieldsByType(bot Bot, cmd Command, types []string) []string {
+
+	r := []string{}
+	for _, field := range cmd.Fields(bot, nil, nil, nil) {
+		if utils.Contains(types, string(field.Type)) {
+			r = append(r, field.Name)
+		}
+	}
+	return r
+}
+
+// FieldValue converts value of field with multiple values into list
+func FieldValue(field Field, value interface{}) interface{} {
+
+	switch field.Type {
+	case FieldTypeMultiSelect, FieldTypeDynamicMultiSelect, FieldTypeCheckboxes,
+		FieldTypeMultiUser, FieldTypeMultiChannel, FieldTypeMultiGroup:
+		switch v := value.(type) {
+		case []string:
+			return v
+		case []interface{}:
+			r := []string{}
+			for _, s := range v {
+				r = append(r, fmt.Sprintf("%v", s))
+			}
+			return r
+		default:
+			return RemoveEmptyStrings(strings.Split(fmt.Sprintf("%v", value), ","))
+		}
+	}
+	return value
+}
+
+// FieldValues converts values of fields in params as forms and text give them as strings
+func FieldValues(fields []Field, params ExecuteParams) ExecuteParams {
+
+	for _, f := range fields {
+		v := params[f.Name]
+		if v == nil {
+			continue
+		}
+		params[f.Name] = FieldValue(f, v)
+	}
+	return params
+}
+
 func (bs *Bots) Add(b Bot) {
 	if !utils.IsEmpty(b) {
 		bs.list = append(bs.list, b)
@@ -45,6 +205,24 @@ func (bs *Bots) Start(wg *sync.WaitGroup) {
 	}
 }
 
+// Reload lets bots register commands again after processors are replaced
+func (bs *Bots) Reload() error {
+
+	var errs []error
+	for _, i := range bs.list {
+
+		r, ok := i.(Reloader)
+		if !ok {
+			continue
+		}
+		err := r.Reload()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", i.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func NewBots() *Bots {
 	return &Bots{}
 }
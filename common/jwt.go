@@ -0,0 +1,230 @@
+package common
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopsext/utils"
+)
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+type JWTHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+type JWTClaims struct {
+	Iss   string      `json:"iss"`
+	Aud   interface{} `json:"aud"`
+	Scope string      `json:"scope,omitempty"`
+	Iat   int64       `json:"iat,omitempty"`
+	Exp   int64       `json:"exp"`
+	Nbf   int64       `json:"nbf,omitempty"`
+}
+
+type JWTVerifierOptions struct {
+	Name     string
+	Issuer   string
+	Audience string
+	KeysTTL  time.Duration
+	// unknown kid refetches keys not more often than this, so forged tokens can't flood keys URL
+	KeysInterval time.Duration
+}
+
+// JWTVerifier checks RS256 tokens against JWKS returned by fetch, keys are cached for KeysTTL
+type JWTVerifier struct {
+	options JWTVerifierOptions
+	fetch   func() ([]byte, error)
+	mutex   sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	expires time.Time
+	fetched time.Time
+}
+
+func (v *JWTVerifier) parseKeys(b []byte) (map[string]*rsa.PublicKey, error) {
+
+	jwks := &JWKS{}
+	err := json.Unmarshal(b, jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+func (v *JWTVerifier) signingKey(kid string) (*rsa.PublicKey, error) {
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	now := time.Now()
+	if v.keys != nil && now.Before(v.expires) {
+		if key, ok := v.keys[kid]; ok {
+			return key, nil
+		}
+	}
+
+	if !v.fetched.IsZero() && now.Sub(v.fetched) < v.options.KeysInterval {
+		return nil, fmt.Errorf("%s signing key %s not found", v.options.Name, kid)
+	}
+	v.fetched = now
+
+	b, err := v.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := v.parseKeys(b)
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	v.expires = now.Add(v.options.KeysTTL)
+
+	key, ok := v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%s signing key %s not found", v.options.Name, kid)
+	}
+	return key, nil
+}
+
+func (v *JWTVerifier) audienceAllowed(aud interface{}) bool {
+
+	switch a := aud.(type) {
+	case string:
+		return a == v.options.Audience
+	case []interface{}:
+		for _, s := range a {
+			if fmt.Sprintf("%v", s) == v.options.Audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Verify checks signature and standard claims of bearer token, payload is also decoded into extra if it's not nil
+func (v *JWTVerifier) Verify(authorization string, extra interface{}) error {
+
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%s token is malformed", v.options.Name)
+	}
+
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return err
+	}
+	header := &JWTHeader{}
+	err = json.Unmarshal(hb, header)
+	if err != nil {
+		return err
+	}
+	if header.Alg != "RS256" {
+		return fmt.Errorf("%s token algorithm %s is not supported", v.options.Name, header.Alg)
+	}
+
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	claims := &JWTClaims{}
+	err = json.Unmarshal(cb, claims)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+
+	key, err := v.signingKey(header.Kid)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig)
+	if err != nil {
+		return fmt.Errorf("%s token signature is invalid: %s", v.options.Name, err)
+	}
+
+	now := time.Now().Unix()
+	if claims.Iss != v.options.Issuer {
+		return fmt.Errorf("%s token issuer %s is invalid", v.options.Name, claims.Iss)
+	}
+	if !v.audienceAllowed(claims.Aud) {
+		return fmt.Errorf("%s token audience is invalid", v.options.Name)
+	}
+	if claims.Exp == 0 {
+		return fmt.Errorf("%s token has no expiration", v.options.Name)
+	}
+	if now > claims.Exp {
+		return fmt.Errorf("%s token is expired", v.options.Name)
+	}
+	if claims.Nbf > 0 && now < claims.Nbf {
+		return fmt.Errorf("%s token is not valid yet", v.options.Name)
+	}
+
+	if extra != nil {
+		return json.Unmarshal(cb, extra)
+	}
+	return nil
+}
+
+func NewJWTVerifier(options JWTVerifierOptions, fetch func() ([]byte, error)) *JWTVerifier {
+
+	if options.KeysTTL <= 0 {
+		options.KeysTTL = 24 * time.Hour
+	}
+	if options.KeysInterval <= 0 {
+		options.KeysInterval = time.Minute
+	}
+	if utils.IsEmpty(options.Name) {
+		options.Name = "JWT"
+	}
+	return &JWTVerifier{
+		options: options,
+		fetch:   fetch,
+	}
+}
@@ -1,6 +1,17 @@
 package common
 
-import "github.com/devopsext/utils"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/devopsext/utils"
+)
 
 type User interface {
 	ID() string
@@ -96,13 +107,34 @@ type Command interface {
 	Fields(bot Bot, message Message, params ExecuteParams, eval []string) []Field
 }
 
+// FileCommand is implemented by commands loaded from a file
+type FileCommand interface {
+	Path() string
+}
+
+// SuggestCommand is implemented by command which replies to unknown text with closest commands
+type SuggestCommand interface {
+	SuggestParams(text string) ExecuteParams
+}
+
 type Processor interface {
 	Name() string
 	Commands() []Command
 }
 
 type Processors struct {
-	list []Processor
+	list  []Processor
+	live  *Processors
+	mutex sync.RWMutex
+}
+
+// CommandNode is a level of nested groups, commands are nodes without children
+type CommandNode struct {
+	Name        string
+	Path        string
+	Description string
+	Command     Command
+	Nodes       []*CommandNode
 }
 
 const (
@@ -143,23 +175,88 @@ const (
 	FieldTypeMultiGroup         = "multigroup"
 )
 
+// target is live list once staged one is committed
+func (ps *Processors) target() *Processors {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	if ps.live != nil {
+		return ps.live
+	}
+	return ps
+}
+
 func (ps *Processors) Add(p Processor) {
+	if t := ps.target(); t != ps {
+		t.Add(p)
+		return
+	}
 	if !utils.IsEmpty(p) {
+		ps.mutex.Lock()
+		defer ps.mutex.Unlock()
 		ps.list = append(ps.list, p)
 	}
 }
 
 func (ps *Processors) AddList(list []Processor) {
+	if t := ps.target(); t != ps {
+		t.AddList(list)
+		return
+	}
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
 	ps.list = append(ps.list, list...)
 }
 
+// Replace swaps all processors at once, so bots never see a half built list
+func (ps *Processors) Replace(list []Processor) {
+	if t := ps.target(); t != ps {
+		t.Replace(list)
+		return
+	}
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.list = append([]Processor{}, list...)
+}
+
+// Commit replaces live processors with staged ones, then staged list forwards to live one,
+// so processors built on staged list like help keep seeing current commands after remotes refresh
+func (ps *Processors) Commit(live *Processors) {
+	live.Replace(ps.Items())
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.list = nil
+	ps.live = live
+}
+
+// Remove drops processor from the list, used when processor has no commands anymore
+func (ps *Processors) Remove(p Processor) {
+	if t := ps.target(); t != ps {
+		t.Remove(p)
+		return
+	}
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	for i, v := range ps.list {
+		if v == p {
+			ps.list = append(ps.list[:i:i], ps.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// Items returns a copy as processors can be added at runtime
 func (ps *Processors) Items() []Processor {
-	return ps.list
+	if t := ps.target(); t != ps {
+		return t.Items()
+	}
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return append([]Processor{}, ps.list...)
 }
 
 func (ps *Processors) Exists(processor string) bool {
 
-	for _, v := range ps.list {
+	for _, v := range ps.Items() {
 		g := v.Name()
 		if g == processor {
 			return true
@@ -170,7 +267,7 @@ func (ps *Processors) Exists(processor string) bool {
 
 func (ps *Processors) FindCommand(processor, command string) Command {
 
-	for _, v := range ps.list {
+	for _, v := range ps.Items() {
 		g := v.Name()
 		if g == processor {
 			for _, v1 := range v.Commands() {
@@ -186,7 +283,7 @@ func (ps *Processors) FindCommand(processor, command string) Command {
 
 func (ps *Processors) FindCommandByAlias(alias string) (string, Command) {
 
-	for _, v := range ps.list {
+	for _, v := range ps.Items() {
 		for _, v1 := range v.Commands() {
 			als := v1.Aliases()
 			if utils.Contains(als, alias) {
@@ -197,6 +294,497 @@ func (ps *Processors) FindCommandByAlias(alias string) (string, Command) {
 	return "", nil
 }
 
+func (ps *Processors) ListCommands(deny func(groupName string) bool) []string {
+
+	commands := []string{}
+
+	for _, p := range ps.Items() {
+		for _, c := range p.Commands() {
+			groupName := c.Name()
+			if !utils.IsEmpty(p.Name()) {
+				groupName = p.Name() + "/" + groupName
+			}
+			if deny != nil && deny(groupName) {
+				continue
+			}
+			commands = append(commands, groupName)
+		}
+	}
+
+	// add fake command to check by length
+	if len(commands) == 0 {
+		commands = append(commands, UUID())
+	}
+
+	return commands
+}
+
+// UserCommands lists commands permitted to user by permissions, the first permissions error is returned
+func (ps *Processors) UserCommands(permissions, userID, userName string) ([]string, error) {
+
+	var r error
+	commands := ps.ListCommands(func(groupName string) bool {
+		deny, err := DenyUserAccess(permissions, userID, userName, groupName)
+		if err != nil && r == nil {
+			r = err
+		}
+		return deny
+	})
+	return commands, r
+}
+
+// ReplaceAlias turns alias in the first word into command path: alias param1 => group command param1
+func (ps *Processors) ReplaceAlias(text string) string {
+
+	items := strings.SplitN(text, " ", 2)
+	if len(items) == 0 {
+		return text
+	}
+	group, cmd := ps.FindCommandByAlias(items[0])
+	if cmd == nil {
+		return text
+	}
+	words := strings.TrimSpace(fmt.Sprintf("%s %s", strings.ReplaceAll(group, "/", " "), cmd.Name()))
+	return strings.Replace(text, items[0], words, 1)
+}
+
+// Tree builds nested groups from group names split by /, commands absent in allowed list are skipped
+func (ps *Processors) Tree(allowed []string) *CommandNode {
+
+	root := &CommandNode{}
+	for _, p := range ps.Items() {
+
+		group := strings.Split(p.Name(), "/")
+		for _, c := range p.Commands() {
+
+			path := append(append([]string{}, group...), c.Name())
+			path = RemoveEmptyStrings(path)
+			if len(path) == 0 {
+				continue
+			}
+			if len(allowed) > 0 && !utils.Contains(allowed, strings.Join(path, "/")) {
+				continue
+			}
+
+			n := root
+			for i := range path[:len(path)-1] {
+				n = n.node(path[:i+1])
+			}
+			leaf := n.node(path)
+			leaf.Description = c.Description()
+			leaf.Command = c
+		}
+	}
+	root.sort()
+	return root
+}
+
+// FindSuggest returns root command which suggests closest commands with params for unknown text
+func (ps *Processors) FindSuggest(text string) (ExecuteParams, Command) {
+
+	for _, p := range ps.Items() {
+		if !utils.IsEmpty(p.Name()) {
+			continue
+		}
+		for _, c := range p.Commands() {
+			if sc, ok := c.(SuggestCommand); ok {
+				return sc.SuggestParams(text), c
+			}
+		}
+	}
+	return make(ExecuteParams), nil
+}
+
+// suggestDistance is zero if text is prefix of word, otherwise edit distance which is not more than half of word
+func suggestDistance(text, word string) (int, bool) {
+
+	if strings.HasPrefix(word, text) {
+		return 0, true
+	}
+	d := Distance(text, word)
+	if d > len([]rune(word))/2 {
+		return d, false
+	}
+	return d, true
+}
+
+// Suggest returns commands closest to text by prefix or edit distance of group, command names and aliases,
+// rest of text is kept as arguments, commands absent in allowed list are skipped
+func (ps *Processors) Suggest(text string, allowed []string, distance, limit int) []string {
+
+	r := []string{}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return r
+	}
+	words := []string{}
+	for _, f := range fields {
+		words = append(words, strings.ToLower(f))
+	}
+
+	scores := make(map[string]int)
+	for _, p := range ps.Items() {
+
+		group := RemoveEmptyStrings(strings.Split(p.Name(), "/"))
+		for _, c := range p.Commands() {
+
+			if utils.IsEmpty(c.Name()) || c.Wrapper() {
+				continue
+			}
+			if _, ok := c.(SuggestCommand); ok {
+				continue
+			}
+			path := append(append([]string{}, group...), c.Name())
+			if len(allowed) > 0 && !utils.Contains(allowed, strings.Join(path, "/")) {
+				continue
+			}
+
+			for _, name := range append([]string{c.Name()}, c.Aliases()...) {
+
+				candidate := append(append([]string{}, group...), name)
+				score := 0
+				matched := true
+				for i, w := range candidate {
+					// absent words of longer path are counted as one edit each
+					if i >= len(words) {
+						score++
+						continue
+					}
+					d, ok := suggestDistance(words[i], strings.ToLower(w))
+					if !ok {
+						matched = false
+						break
+					}
+					score += d
+				}
+				if !matched || score > distance {
+					continue
+				}
+
+				rest := []string{}
+				if len(fields) > len(candidate) {
+					rest = fields[len(candidate):]
+				}
+				s := strings.Join(append(append([]string{}, path...), rest...), " ")
+				if old, ok := scores[s]; !ok || score < old {
+					scores[s] = score
+				}
+			}
+		}
+	}
+
+	for s := range scores {
+		r = append(r, s)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if scores[r[i]] != scores[r[j]] {
+			return scores[r[i]] < scores[r[j]]
+		}
+		return r[i] < r[j]
+	})
+	if limit > 0 && len(r) > limit {
+		r = r[:limit]
+	}
+	return r
+}
+
+func (ps *Processors) MatchParam(text, param string) (map[string]string, []string) {
+
+	r := make(map[string]string)
+	re := regexp.MustCompile(param)
+	match := re.FindStringSubmatch(text)
+	if len(match) == 0 {
+		return r, []string{}
+	}
+
+	names := re.SubexpNames()
+	for i, name := range names {
+		if i != 0 && name != "" {
+			r[name] = match[i]
+		}
+	}
+	return r, names
+}
+
+// findCommand matches the longest path of words, all words but last are the group joined by /
+func (ps *Processors) findCommand(arr []string, delim string) (string, Command, string) {
+
+	for i := len(arr); i > 0; i-- {
+
+		c := strings.TrimSpace(arr[i-1])
+		if utils.IsEmpty(c) {
+			continue
+		}
+
+		words := []string{}
+		for _, w := range arr[:i-1] {
+			words = append(words, strings.TrimSpace(w))
+		}
+		group := strings.Join(words, "/")
+
+		cmd := ps.FindCommand(group, c)
+		if cmd != nil {
+			return group, cmd, strings.TrimSpace(strings.Join(arr[i:], delim))
+		}
+	}
+	return "", nil, ""
+}
+
+// argsError adds usage of command to args error
+func argsError(group string, cmd Command, args []Arg, err error) error {
+
+	name := strings.TrimSpace(fmt.Sprintf("%s %s", strings.ReplaceAll(group, "/", " "), cmd.Name()))
+	return fmt.Errorf("%s\n%s", err, ArgsUsage(name, args))
+}
+
+// MatchArgs puts named values like JSON ones into params of command, values of declared args are checked
+// the same way as in text and error comes with usage, commands without args take values as they are
+func (ps *Processors) MatchArgs(group string, cmd Command, params ExecuteParams, values map[string]interface{}) (ExecuteParams, error) {
+
+	r := make(ExecuteParams)
+	for k, v := range params {
+		r[k] = v
+	}
+
+	ac, ok := cmd.(ArgsCommand)
+	if !ok || len(ac.Args()) == 0 {
+		for k, v := range values {
+			r[k] = v
+		}
+		return r, nil
+	}
+
+	err := SetArgs(ac.Args(), r, values)
+	if err != nil {
+		return r, argsError(group, cmd, ac.Args(), err)
+	}
+	return r, nil
+}
+
+// matchParams parses declared args or takes first matched regex of params, args error comes with usage
+func (ps *Processors) matchParams(group string, cmd Command, text string) (ExecuteParams, error) {
+
+	r := make(ExecuteParams)
+
+	if ac, ok := cmd.(ArgsCommand); ok && len(ac.Args()) > 0 {
+
+		params, err := ParseArgs(ac.Args(), text)
+		if err != nil {
+			return params, argsError(group, cmd, ac.Args(), err)
+		}
+		return params, nil
+	}
+
+	if utils.IsEmpty(text) {
+		return r, nil
+	}
+	for _, p := range cmd.Params() {
+
+		values, _ := ps.MatchParam(text, p)
+		for k, v := range values {
+			r[k] = v
+		}
+		if len(r) > 0 {
+			break
+		}
+	}
+	return r, nil
+}
+
+// FindParams returns command with its params, error is set when command is found but its args couldn't be parsed
+func (ps *Processors) FindParams(wrapper bool, text string) (ExecuteParams, Command, string, ExecuteParams, Command, string, error) {
+
+	ep := make(ExecuteParams)
+	wp := make(ExecuteParams)
+
+	// group subgroup command param1 param2
+	// group command param1 param2
+	// command param1 param2
+
+	// find group, command, params
+
+	delim := " "
+	arr := strings.Split(text, delim)
+
+	if len(arr) == 0 {
+		return ep, nil, "", wp, nil, "", nil
+	}
+
+	if !wrapper {
+
+		egr, ecm, eps := ps.findCommand(arr, delim)
+		if ecm == nil {
+			return ep, nil, "", wp, nil, "", nil
+		}
+
+		ep, err := ps.matchParams(egr, ecm, eps)
+		return ep, ecm, egr, wp, nil, "", err
+	}
+
+	// wrappergroup wrapper group command param1 param2
+	// wrappergroup wrapper command param1 param2
+	// wrapper command param1 param2
+
+	// find wrapper group, command, params
+
+	egr, ecm, eps := ps.findCommand(arr, delim)
+	if ecm == nil {
+		return ep, ecm, egr, wp, nil, "", nil
+	}
+
+	// find wrapped group, command, params
+
+	arr = strings.Split(eps, delim)
+	wgr, wcm, wps := ps.findCommand(arr, delim)
+
+	if wcm == nil {
+		return ep, ecm, egr, wp, nil, "", nil
+	}
+	if utils.IsEmpty(wgr) {
+		eps = wcm.Name()
+	}
+
+	wp, err := ps.matchParams(wgr, wcm, wps)
+	if err != nil {
+		return ep, ecm, egr, wp, wcm, wgr, err
+	}
+	ep, err = ps.matchParams(egr, ecm, eps)
+	return ep, ecm, egr, wp, wcm, wgr, err
+}
+
+func MergeActions(one []Action, two []Action) []Action {
+
+	actions := []Action{}
+
+	for _, a1 := range one {
+		found := false
+		for _, a2 := range two {
+			if a1.Name() == a2.Name() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			actions = append(actions, a1)
+		}
+	}
+
+	for _, a2 := range two {
+		found := false
+		for _, a1 := range actions {
+			if a2.Name() == a1.Name() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			actions = append(actions, a2)
+		}
+	}
+
+	return actions
+}
+
+// Signatures describes commands by group/name with their file content at the moment of call
+func (ps *Processors) Signatures() map[string]string {
+
+	r := make(map[string]string)
+	for _, p := range ps.Items() {
+		for _, c := range p.Commands() {
+
+			name := c.Name()
+			if !utils.IsEmpty(p.Name()) {
+				name = p.Name() + "/" + name
+			}
+
+			h := sha256.New()
+			fmt.Fprintf(h, "%s|%v|%v|%s|%s|%d|%t|%v", c.Description(), c.Params(), c.Aliases(),
+				c.Schedule(), c.Channel(), c.Priority(), c.Wrapper(), c.Fields(nil, nil, nil, nil))
+
+			if fc, ok := c.(FileCommand); ok {
+				data, err := os.ReadFile(fc.Path())
+				if err == nil {
+					h.Write(data)
+				}
+			}
+			r[name] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	return r
+}
+
+func (n *CommandNode) node(path []string) *CommandNode {
+
+	name := path[len(path)-1]
+	for _, c := range n.Nodes {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &CommandNode{
+		Name: name,
+		Path: strings.Join(path, "/"),
+	}
+	n.Nodes = append(n.Nodes, c)
+	return c
+}
+
+func (n *CommandNode) sort() {
+
+	sort.Slice(n.Nodes, func(i, j int) bool {
+		return n.Nodes[i].Name < n.Nodes[j].Name
+	})
+	for _, c := range n.Nodes {
+		c.sort()
+	}
+}
+
+// Find returns node by path where words are delimited by / or space
+func (n *CommandNode) Find(path string) *CommandNode {
+
+	r := n
+	for _, name := range strings.FieldsFunc(path, func(c rune) bool { return c == '/' || c == ' ' }) {
+
+		var next *CommandNode
+		for _, c := range r.Nodes {
+			if c.Name == name {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		r = next
+	}
+	return r
+}
+
+// String renders node children as indented list, commands with their descriptions
+func (n *CommandNode) String() string {
+
+	var sb strings.Builder
+	var render func(nodes []*CommandNode, indent string)
+
+	render = func(nodes []*CommandNode, indent string) {
+		for _, c := range nodes {
+			sb.WriteString(indent)
+			sb.WriteString(c.Name)
+			if len(c.Nodes) > 0 && c.Command == nil {
+				sb.WriteString("/")
+			}
+			if !utils.IsEmpty(c.Description) {
+				sb.WriteString(" - ")
+				sb.WriteString(c.Description)
+			}
+			sb.WriteString("\n")
+			render(c.Nodes, indent+"  ")
+		}
+	}
+	render(n.Nodes, "")
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
 func NewProcessors() *Processors {
 	return &Processors{}
 }
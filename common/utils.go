@@ -4,9 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 	"text/template"
 	"time"
+	"unicode/utf8"
 
 	toolsRender "github.com/devopsext/tools/render"
 	"github.com/devopsext/utils"
@@ -144,6 +146,99 @@ func Render(def string, obj interface{}, observability *Observability) string {
 	return s
 }
 
+// MatchCommand matches command path or any of its group prefixes, so k8s/prod permits k8s/prod/restart
+func MatchCommand(re *regexp.Regexp, command string) bool {
+
+	path := strings.Split(command, "/")
+	for i := len(path); i > 0; i-- {
+		if re.MatchString(strings.Join(path[:i], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// .*=^(help|news|app|application|catalog)$,some=^(escalate)$
+func DenyUserAccess(permissions, userID, userName, command string) (bool, error) {
+
+	if utils.IsEmpty(permissions) {
+		return true, nil
+	}
+
+	userPermissions := utils.MapGetKeyValues(permissions)
+	for user, value := range userPermissions {
+
+		reCommand, err := regexp.Compile(value)
+		if err != nil {
+			return true, err
+		}
+
+		if !MatchCommand(reCommand, command) {
+			continue
+		}
+
+		reUser, err := regexp.Compile(user)
+		if err != nil {
+			return true, err
+		}
+
+		if reUser.MatchString(userID) || (!utils.IsEmpty(userName) && reUser.MatchString(userName)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// LabelValue escapes text for metric labels, quoted args would break them otherwise
+func LabelValue(text string) string {
+
+	r := strings.ReplaceAll(text, "\\", "\\\\")
+	r = strings.ReplaceAll(r, "\"", "\\\"")
+	return strings.ReplaceAll(r, "\n", "\\n")
+}
+
+// LimitText cuts text to max bytes with trimmed suffix, cut is made on rune boundary to keep text valid UTF-8
+func LimitText(text string, max int, trimmed string) string {
+
+	if len(text) <= max {
+		return text
+	}
+	n := max - len(trimmed)
+	if n < 0 {
+		n = 0
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s%s", text[:n], trimmed)
+}
+
+// Distance is Levenshtein distance between two strings counted by runes
+func Distance(a, b string) int {
+
+	ra := []rune(a)
+	rb := []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
 func UUID() string {
 
 	uuid := uuid.New()
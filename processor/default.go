@@ -105,6 +105,7 @@ type DefaultOptions struct {
 	RunbooksDir  string
 	CommandExt   string
 	ConfigExt    string
+	ManifestExt  string
 	Description  string
 	Error        string
 }
@@ -134,6 +135,7 @@ type DefaultAction struct {
 type DefaultCommandConfig struct {
 	Description  string
 	Params       []string
+	Args         []common.Arg
 	Aliases      []string
 	Response     DefaultReposne
 	Fields       []common.Field
@@ -147,6 +149,17 @@ type DefaultCommandConfig struct {
 	Permissions  *bool
 }
 
+// DefaultManifestCommand is a command with inline template and config in one manifest file
+type DefaultManifestCommand struct {
+	Name                 string
+	Template             string
+	DefaultCommandConfig `yaml:",inline"`
+}
+
+type DefaultManifest struct {
+	Commands []*DefaultManifestCommand
+}
+
 type DefaultCommandResponse struct {
 	command *DefaultCommand
 }
@@ -166,6 +179,7 @@ type DefaultCommandAction struct {
 type DefaultCommand struct {
 	name      string
 	path      string
+	content   string // inline template of manifest
 	config    *DefaultCommandConfig
 	processor *Default
 	logger    sreCommon.Logger
@@ -641,6 +655,20 @@ func (de *DefaultExecutor) fAddRemoveReactionOnMessage(channelID, messageID, fir
 	return ""
 }
 
+// fGetCommands returns nested groups and commands under path which user is permitted to execute
+func (de *DefaultExecutor) fGetCommands(path string) interface{} {
+
+	var allowed []string
+	if de.message != nil && de.message.User() != nil {
+		allowed = de.message.User().Commands()
+	}
+	node := de.command.processor.processors.Tree(allowed).Find(path)
+	if node == nil {
+		return nil
+	}
+	return node
+}
+
 func (de *DefaultExecutor) fSetError() string {
 	e := true
 	de.error = &e
@@ -888,7 +916,8 @@ func (de *DefaultExecutor) After(message common.Message) error {
 	return err
 }
 
-func NewExecutorTemplate(name string, content string, executor *DefaultExecutor, observability *common.Observability) (*toolsRender.TextTemplate, error) {
+// host functions available to commands, shared by templates and scripts
+func executorFuncs(executor *DefaultExecutor) map[string]any {
 
 	funcs := make(map[string]any)
 
@@ -920,15 +949,20 @@ func NewExecutorTemplate(name string, content string, executor *DefaultExecutor,
 	funcs["sendMessageEx"] = executor.fSendMessageEx
 	funcs["setInvisible"] = executor.fSetInvisible
 	funcs["setError"] = executor.fSetError
+	funcs["getCommands"] = executor.fGetCommands
 	funcs["deleteMessage"] = executor.fDeleteMessage
 	funcs["readMessage"] = executor.fReadMessage
 	funcs["updateMessage"] = executor.fUpdateMessage
 	//funcs["disableReaction"] = executor.fDisableReaction
+	return funcs
+}
+
+func NewExecutorTemplate(name string, content string, executor *DefaultExecutor, observability *common.Observability) (*toolsRender.TextTemplate, error) {
 
 	templateOpts := toolsRender.TemplateOptions{
 		Name:    fmt.Sprintf("default-internal-%s", name),
 		Content: string(content),
-		Funcs:   funcs,
+		Funcs:   executorFuncs(executor),
 	}
 	template, err := toolsRender.NewTextTemplate(templateOpts, observability)
 	if err != nil {
@@ -948,6 +982,11 @@ func NewExecutor(name, path string, command *DefaultCommand, bot common.Bot, mes
 	if err != nil {
 		return nil, fmt.Errorf("Default couldn't read template %s, error: %s", path, err)
 	}
+	return newExecutor(name, string(content), command, bot, message, params, action)
+}
+
+func newExecutor(name, content string, command *DefaultCommand, bot common.Bot, message common.Message,
+	params common.ExecuteParams, action common.Action) (*DefaultExecutor, error) {
 
 	executor := &DefaultExecutor{
 		command:     command,
@@ -960,7 +999,7 @@ func NewExecutor(name, path string, command *DefaultCommand, bot common.Bot, mes
 		action:      action,
 	}
 
-	template, err := NewExecutorTemplate(name, string(content), executor, command.processor.observability)
+	template, err := NewExecutorTemplate(name, content, executor, command.processor.observability)
 	if err != nil {
 		return nil, err
 	}
@@ -1427,6 +1466,10 @@ func (dc *DefaultCommand) Name() string {
 	return dc.name
 }
 
+func (dc *DefaultCommand) Path() string {
+	return dc.path
+}
+
 func (dc *DefaultCommand) Group() string {
 	if dc.processor == nil {
 		return dc.processor.name
@@ -1450,6 +1493,23 @@ func (dc *DefaultCommand) Description() string {
 	return dc.config.Description
 }
 
+// positional p0..p9 params for commands without declared params
+func defaultParams() []string {
+
+	s := ""
+	r := []string{}
+	for i := 0; i < 10; i++ {
+		n := fmt.Sprintf("p%d", i)
+		if s == "" {
+			s = fmt.Sprintf("(?P<%s>\\S+)", n)
+		} else {
+			s = fmt.Sprintf("%s\\s+(?P<%s>\\S+)", s, n)
+		}
+		r = append(r, s)
+	}
+	return r
+}
+
 func (dc *DefaultCommand) Params() []string {
 
 	params := []string{}
@@ -1457,22 +1517,18 @@ func (dc *DefaultCommand) Params() []string {
 		params = dc.config.Params
 	}
 	if utils.IsEmpty(params) {
-		s := ""
-		r := []string{}
-		for i := 0; i < 10; i++ {
-			n := fmt.Sprintf("p%d", i)
-			if s == "" {
-				s = fmt.Sprintf("(?P<%s>\\S+)", n)
-			} else {
-				s = fmt.Sprintf("%s\\s+(?P<%s>\\S+)", s, n)
-			}
-			r = append(r, s)
-		}
-		return r
+		return defaultParams()
 	}
 	return params
 }
 
+func (dc *DefaultCommand) Args() []common.Arg {
+	if dc.config == nil {
+		return []common.Arg{}
+	}
+	return dc.config.Args
+}
+
 func (dc *DefaultCommand) Aliases() []string {
 	if dc.config == nil {
 		return []string{}
@@ -1521,7 +1577,8 @@ func (dc *DefaultCommand) Fields(bot common.Bot, message common.Message, params
 			continue
 		}
 
-		skip := utils.IsEmpty(dc.processor.options.TemplatesDir)
+		// manifest fields could have inline templates without templates dir
+		skip := utils.IsEmpty(dc.processor.options.TemplatesDir) && utils.IsEmpty(dc.content)
 		if skip {
 			continue
 		}
@@ -1767,11 +1824,23 @@ func (dc *DefaultCommand) Execute(bot common.Bot, message common.Message, params
 	name := dc.getNameWithGroup("-")
 
 	path := dc.path
+	content := dc.content
 	if action != nil && !utils.IsEmpty(action.Template()) {
 		path = fmt.Sprintf("%s%s%s", dc.processor.options.TemplatesDir, string(os.PathSeparator), action.Template())
+		content = ""
+		// manifest actions could have inline templates
+		if !utils.IsEmpty(dc.content) && !utils.FileExists(path) {
+			content = action.Template()
+		}
 	}
 
-	executor, err := NewExecutor(name, path, dc, bot, message, params, action)
+	var executor *DefaultExecutor
+	var err error
+	if !utils.IsEmpty(content) {
+		executor, err = newExecutor(name, content, dc, bot, message, params, action)
+	} else {
+		executor, err = NewExecutor(name, path, dc, bot, message, params, action)
+	}
 	if err != nil {
 		return nil, "", nil, nil, err
 	}
@@ -1859,10 +1928,27 @@ func (d *Default) createCommand(name, path string) (*DefaultCommand, error) {
 	return dc, nil
 }
 
+// commands of manifests could clash with files
+func (d *Default) exists(name string) bool {
+
+	for _, c := range d.commands {
+		if c.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Default) AddCommand(name, path string) error {
 
 	logger := d.observability.Logs()
 
+	if d.exists(name) {
+		err := fmt.Errorf("Default command %s of %s already exists", name, path)
+		logger.Error(err)
+		return err
+	}
+
 	dc, err := d.createCommand(name, path)
 	if err != nil {
 		logger.Error(err)
@@ -1872,6 +1958,69 @@ func (d *Default) AddCommand(name, path string) error {
 	return nil
 }
 
+// AddManifest adds commands of a manifest, templates are inline unless found in templates dir
+func (d *Default) AddManifest(path string) error {
+
+	logger := d.observability.Logs()
+
+	bytes, err := utils.Content(path)
+	if err != nil {
+		logger.Error("Default couldn't read manifest %s, error: %s", path, err)
+		return err
+	}
+
+	var m DefaultManifest
+	err = yaml.Unmarshal(bytes, &m)
+	if err != nil {
+		logger.Error("Default couldn't parse manifest %s, error: %s", path, err)
+		return err
+	}
+
+	// whole manifest is checked before commands are added, so group is never loaded partly
+	commands := []common.Command{}
+	names := []string{}
+	for _, c := range m.Commands {
+
+		if utils.IsEmpty(c.Name) {
+			err := fmt.Errorf("Default manifest %s has command without name", path)
+			logger.Error(err)
+			return err
+		}
+		if utils.IsEmpty(c.Template) {
+			err := fmt.Errorf("Default manifest %s command %s has no template", path, c.Name)
+			logger.Error(err)
+			return err
+		}
+		if d.exists(c.Name) || utils.Contains(names, c.Name) {
+			err := fmt.Errorf("Default manifest %s command %s already exists", path, c.Name)
+			logger.Error(err)
+			return err
+		}
+
+		config := c.DefaultCommandConfig
+		dc := &DefaultCommand{
+			name:      c.Name,
+			path:      path,
+			content:   c.Template,
+			config:    &config,
+			processor: d,
+			logger:    logger,
+		}
+
+		_, err = NewExecutorTemplate(c.Name, c.Template, &DefaultExecutor{}, d.observability)
+		if err != nil {
+			err = fmt.Errorf("Default manifest %s command %s error: %s", path, c.Name, err)
+			logger.Error(err)
+			return err
+		}
+		commands = append(commands, dc)
+		names = append(names, c.Name)
+	}
+
+	d.commands = append(d.commands, commands...)
+	return nil
+}
+
 func NewDefault(name string, options DefaultOptions, observability *common.Observability, processors *common.Processors) *Default {
 
 	return &Default{
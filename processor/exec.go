@@ -0,0 +1,405 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	"gopkg.in/yaml.v2"
+)
+
+type ExecOptions struct {
+	CommandsDir  string
+	TemplatesDir string
+	Extensions   string
+	ConfigExt    string
+	Timeout      int
+	Error        string
+}
+
+type ExecExecutor struct {
+	command *ExecCommand
+	message common.Message
+	error   bool
+}
+
+type ExecCommand struct {
+	*DefaultCommand
+	exec *Exec
+}
+
+type Exec struct {
+	name          string
+	options       ExecOptions
+	defaults      *Default
+	processors    *common.Processors
+	commands      []common.Command
+	meter         sreCommon.Meter
+	observability *common.Observability
+}
+
+type ExecInputUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ExecInput struct {
+	Bot     string                 `json:"bot"`
+	Group   string                 `json:"group"`
+	Command string                 `json:"command"`
+	Params  map[string]interface{} `json:"params"`
+	User    *ExecInputUser         `json:"user,omitempty"`
+	Caller  *ExecInputUser         `json:"caller,omitempty"`
+	Channel string                 `json:"channel"`
+	Message string                 `json:"message"`
+	Parent  string                 `json:"parent"`
+	Action  string                 `json:"action,omitempty"`
+}
+
+const ExecEnvPrefix = "CHATOPS"
+
+var execEnvName = regexp.MustCompile("[^A-Z0-9_]")
+
+var execInterpreters = map[string]string{
+	".sh": "sh",
+	".py": "python3",
+}
+
+// Exec executor
+// common.Response
+
+func (ee *ExecExecutor) Visible() bool {
+	if !utils.IsEmpty(ee.message) {
+		return ee.message.Visible()
+	}
+	return false
+}
+
+func (ee *ExecExecutor) Error() bool {
+	return ee.error
+}
+
+func (ee *ExecExecutor) Duration() bool {
+	return ee.command.Response().Duration()
+}
+
+func (ee *ExecExecutor) Original() bool {
+	return ee.command.Response().Original()
+}
+
+func (ee *ExecExecutor) Response() common.Response {
+	return ee
+}
+
+func (ee *ExecExecutor) After(message common.Message) error {
+	return nil
+}
+
+// Exec command, the rest comes from the sidecar config via DefaultCommand
+
+func (ec *ExecCommand) Group() string {
+	return ec.exec.name
+}
+
+func (ec *ExecCommand) user(u common.User) *ExecInputUser {
+	if utils.IsEmpty(u) {
+		return nil
+	}
+	return &ExecInputUser{ID: u.ID(), Name: u.Name()}
+}
+
+func (ec *ExecCommand) input(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) *ExecInput {
+
+	input := &ExecInput{
+		Bot:     bot.Name(),
+		Group:   ec.exec.name,
+		Command: ec.name,
+		Params:  params,
+		User:    ec.user(message.User()),
+		Caller:  ec.user(message.Caller()),
+		Message: message.ID(),
+		Parent:  message.ParentID(),
+	}
+	if !utils.IsEmpty(message.Channel()) {
+		input.Channel = message.Channel().ID()
+	}
+	if action != nil {
+		input.Action = action.Name()
+	}
+	return input
+}
+
+func (ec *ExecCommand) env(input *ExecInput) []string {
+
+	vars := map[string]string{
+		"BOT":        input.Bot,
+		"GROUP":      input.Group,
+		"COMMAND":    input.Command,
+		"CHANNEL_ID": input.Channel,
+		"MESSAGE_ID": input.Message,
+		"PARENT_ID":  input.Parent,
+		"ACTION":     input.Action,
+	}
+	if input.User != nil {
+		vars["USER_ID"] = input.User.ID
+		vars["USER_NAME"] = input.User.Name
+	}
+	if input.Caller != nil {
+		vars["CALLER_ID"] = input.Caller.ID
+		vars["CALLER_NAME"] = input.Caller.Name
+	}
+	for k, v := range input.Params {
+		name := execEnvName.ReplaceAllString(strings.ToUpper(k), "_")
+		if l, ok := v.([]string); ok {
+			vars[fmt.Sprintf("PARAM_%s", name)] = strings.Join(l, ",")
+			continue
+		}
+		vars[fmt.Sprintf("PARAM_%s", name)] = fmt.Sprintf("%v", v)
+	}
+
+	env := os.Environ()
+	for k, v := range vars {
+		env = append(env, fmt.Sprintf("%s_%s=%s", ExecEnvPrefix, k, v))
+	}
+	return env
+}
+
+func (ec *ExecCommand) command(ctx context.Context) *exec.Cmd {
+
+	var cmd *exec.Cmd
+
+	info, err := os.Stat(ec.path)
+	interpreter := execInterpreters[filepath.Ext(ec.path)]
+	if (err == nil && info.Mode()&0111 != 0) || utils.IsEmpty(interpreter) {
+		cmd = exec.CommandContext(ctx, ec.path)
+	} else {
+		cmd = exec.CommandContext(ctx, interpreter, ec.path)
+	}
+
+	// run in own process group to kill children on timeout
+	cmd.Dir = filepath.Dir(ec.path)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+func (ec *ExecCommand) execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (string, bool, error) {
+
+	t1 := time.Now()
+
+	e := ec.exec
+
+	labels := make(map[string]string)
+	if !utils.IsEmpty(e.name) {
+		labels["group"] = e.name
+	}
+	labels["command"] = ec.name
+	labels["bot"] = bot.Name()
+
+	user := message.User()
+	if !utils.IsEmpty(user) {
+		labels["user_id"] = user.ID()
+	}
+
+	prefixes := []string{"exec", "processor"}
+
+	requests := e.meter.Counter("processor", "requests", "Count of all executions", labels, prefixes...)
+	requests.Inc()
+
+	errs := e.meter.Counter("processor", "errors", "Count of all errors during executions", labels, prefixes...)
+	timeCounter := e.meter.Counter("processor", "time", "Sum of all time executions", labels, prefixes...)
+
+	name := ec.getNameWithGroup("/")
+	ec.logger.Debug("Exec is executing command %s with params %v...", name, params)
+
+	input := ec.input(bot, message, params, action)
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		errs.Inc()
+		return "", false, err
+	}
+
+	ctx := context.Background()
+	if e.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(e.options.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := ec.command(ctx)
+	cmd.Env = ec.env(input)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		errs.Inc()
+		return "", false, fmt.Errorf("Exec command %s timed out after %ds", name, e.options.Timeout)
+	}
+
+	failed := false
+	var exitErr *exec.ExitError
+	if err != nil {
+		if !errors.As(err, &exitErr) {
+			errs.Inc()
+			return "", false, fmt.Errorf("Exec command %s error: %s", name, err)
+		}
+		failed = true
+	}
+
+	text := strings.TrimSpace(stdout.String())
+	serr := strings.TrimSpace(stderr.String())
+	if !utils.IsEmpty(serr) {
+		failed = true
+		if utils.IsEmpty(text) {
+			text = serr
+		} else {
+			text = fmt.Sprintf("%s\n%s", text, serr)
+		}
+	}
+	if failed {
+		errs.Inc()
+		if utils.IsEmpty(text) && exitErr != nil {
+			text = exitErr.Error()
+		}
+	}
+
+	elapsed := time.Since(t1).Milliseconds()
+	timeCounter.Add(int(elapsed))
+
+	ec.logger.Debug("Exec is executed command %s with params %v in %s", name, params, time.Since(t1))
+
+	return text, failed, nil
+}
+
+func (ec *ExecCommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	text, failed, err := ec.execute(bot, message, params, action)
+	if err != nil {
+		ec.logger.Error(err)
+		err = fmt.Errorf("%s", ec.exec.options.Error)
+		return nil, "", nil, nil, err
+	}
+
+	executor := &ExecExecutor{
+		command: ec,
+		message: message,
+		error:   failed,
+	}
+	return executor, text, nil, nil, nil
+}
+
+// Exec
+
+func (e *Exec) Name() string {
+	return e.name
+}
+
+func (e *Exec) Commands() []common.Command {
+	return e.commands
+}
+
+// Match tells whether file is a script, sidecar configs are skipped
+func (e *Exec) Match(path string) bool {
+
+	ext := filepath.Ext(path)
+	if ext == e.options.ConfigExt {
+		return false
+	}
+
+	exts := common.RemoveEmptyStrings(strings.Split(e.options.Extensions, ","))
+	if utils.Contains(exts, ext) {
+		return true
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return info.Mode()&0111 != 0
+}
+
+func (e *Exec) loadConfig(path string) (*DefaultCommandConfig, error) {
+
+	if !utils.FileExists(path) {
+		return nil, nil
+	}
+
+	bytes, err := utils.Content(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v DefaultCommandConfig
+	err = yaml.Unmarshal(bytes, &v)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (e *Exec) AddCommand(name, path string) error {
+
+	logger := e.observability.Logs()
+
+	var config *DefaultCommandConfig
+	if !utils.IsEmpty(e.options.ConfigExt) {
+
+		pConfig := filepath.Join(filepath.Dir(path), fmt.Sprintf("%s%s", name, e.options.ConfigExt))
+		c, err := e.loadConfig(pConfig)
+		if err != nil {
+			logger.Error("Exec couldn't read config %s, error: %s", pConfig, err)
+			return err
+		}
+		config = c
+	}
+
+	ec := &ExecCommand{
+		DefaultCommand: &DefaultCommand{
+			name:      name,
+			path:      path,
+			config:    config,
+			processor: e.defaults,
+			logger:    logger,
+		},
+		exec: e,
+	}
+	e.commands = append(e.commands, ec)
+	return nil
+}
+
+func NewExec(name string, options ExecOptions, observability *common.Observability, processors *common.Processors) *Exec {
+
+	defaults := DefaultOptions{
+		TemplatesDir: options.TemplatesDir,
+		ConfigExt:    options.ConfigExt,
+		Error:        options.Error,
+	}
+
+	return &Exec{
+		name:          name,
+		options:       options,
+		defaults:      NewDefault(name, defaults, observability, processors),
+		processors:    processors,
+		meter:         observability.Metrics(),
+		observability: observability,
+	}
+}
@@ -0,0 +1,401 @@
+package processor
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+)
+
+type HelpOptions struct {
+	Enabled     bool
+	Command     string
+	Description string
+}
+
+type HelpExecutor struct {
+	message common.Message
+}
+
+type HelpCommand struct {
+	name      string
+	processor *Help
+}
+
+type Help struct {
+	options       HelpOptions
+	processors    *common.Processors
+	commands      []common.Command
+	logger        sreCommon.Logger
+	meter         sreCommon.Meter
+	observability *common.Observability
+}
+
+// Help executor
+// common.Response
+
+func (he *HelpExecutor) Visible() bool {
+	if !utils.IsEmpty(he.message) {
+		return he.message.Visible()
+	}
+	return false
+}
+
+func (he *HelpExecutor) Duration() bool {
+	return false
+}
+
+func (he *HelpExecutor) Original() bool {
+	return false
+}
+
+func (he *HelpExecutor) Error() bool {
+	return false
+}
+
+func (he *HelpExecutor) Response() common.Response {
+	return he
+}
+
+func (he *HelpExecutor) After(message common.Message) error {
+	return nil
+}
+
+// Help command
+
+func (hc *HelpCommand) Name() string {
+	return hc.name
+}
+
+func (hc *HelpCommand) Group() string {
+	return ""
+}
+
+func (hc *HelpCommand) Description() string {
+	return hc.processor.options.Description
+}
+
+func (hc *HelpCommand) Params() []string {
+	return []string{"(?P<path>.+)"}
+}
+
+func (hc *HelpCommand) Aliases() []string {
+	return []string{}
+}
+
+func (hc *HelpCommand) Confirmation(params common.ExecuteParams) string {
+	return ""
+}
+
+func (hc *HelpCommand) Priority() int {
+	return 0
+}
+
+func (hc *HelpCommand) Wrapper() bool {
+	return false
+}
+
+func (hc *HelpCommand) Schedule() string {
+	return ""
+}
+
+func (hc *HelpCommand) Channel() string {
+	return ""
+}
+
+func (hc *HelpCommand) Response() common.Response {
+	return &HelpExecutor{}
+}
+
+func (hc *HelpCommand) Actions() []common.Action {
+	return []common.Action{}
+}
+
+func (hc *HelpCommand) Approval() common.Approval {
+	return nil
+}
+
+// help is allowed to everyone, it lists only permitted commands
+func (hc *HelpCommand) Permissions() bool {
+	return false
+}
+
+func (hc *HelpCommand) Fields(bot common.Bot, message common.Message, params common.ExecuteParams, eval []string) []common.Field {
+	return []common.Field{}
+}
+
+func (hc *HelpCommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	t1 := time.Now()
+
+	h := hc.processor
+
+	labels := make(map[string]string)
+	labels["command"] = hc.name
+	labels["bot"] = bot.Name()
+
+	user := message.User()
+	if !utils.IsEmpty(user) {
+		labels["user_id"] = user.ID()
+	}
+
+	prefixes := []string{"help", "processor"}
+
+	requests := h.meter.Counter("processor", "requests", "Count of all executions", labels, prefixes...)
+	requests.Inc()
+
+	timeCounter := h.meter.Counter("processor", "time", "Sum of all time executions", labels, prefixes...)
+
+	path := ""
+	if v, ok := params["path"]; ok && v != nil {
+		path = strings.TrimSpace(fmt.Sprintf("%v", v))
+	}
+	h.logger.Debug("Help is executing command %s with path %s...", hc.name, path)
+
+	text := ""
+	group, c := h.findCommand(user, path)
+	switch {
+	case c != nil:
+		text = h.details(bot, message, group, c)
+	case utils.IsEmpty(path):
+		text = h.list(user, "")
+	default:
+		text = h.list(user, strings.Join(strings.Fields(path), "/"))
+	}
+
+	elapsed := time.Since(t1).Milliseconds()
+	timeCounter.Add(int(elapsed))
+
+	h.logger.Debug("Help is executed command %s with path %s in %s", hc.name, path, time.Since(t1))
+
+	executor := &HelpExecutor{message: message}
+	return executor, text, nil, nil, nil
+}
+
+// Help
+
+func (h *Help) Name() string {
+	return ""
+}
+
+func (h *Help) Commands() []common.Command {
+	return h.commands
+}
+
+func (h *Help) words(path string) string {
+	return strings.ReplaceAll(path, "/", " ")
+}
+
+// findCommand looks for command by group words and name or by alias, commands not permitted to user are skipped
+func (h *Help) findCommand(user common.User, path string) (string, common.Command) {
+
+	words := strings.Fields(path)
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	group := strings.Join(words[:len(words)-1], "/")
+	c := h.processors.FindCommand(group, words[len(words)-1])
+	if c == nil && len(words) == 1 {
+		group, c = h.processors.FindCommandByAlias(words[0])
+	}
+	if c == nil || !common.Permitted(user, c, common.GroupName(group, c)) {
+		return "", nil
+	}
+	return group, c
+}
+
+// list shows commands permitted to user by processors, group limits them to processor and its subgroups
+func (h *Help) list(user common.User, group string) string {
+
+	names := []string{}
+	commands := make(map[string][]string)
+
+	for _, p := range h.processors.Items() {
+
+		name := p.Name()
+		if !utils.IsEmpty(group) && name != group && !strings.HasPrefix(name, group+"/") {
+			continue
+		}
+
+		for _, c := range p.Commands() {
+			if !common.Permitted(user, c, common.GroupName(name, c)) {
+				continue
+			}
+			l := fmt.Sprintf("  %s", c.Name())
+			if !utils.IsEmpty(c.Description()) {
+				l = fmt.Sprintf("%s - %s", l, c.Description())
+			}
+			if _, ok := commands[name]; !ok {
+				names = append(names, name)
+			}
+			commands[name] = append(commands[name], l)
+		}
+	}
+
+	if len(names) == 0 {
+		if !utils.IsEmpty(group) {
+			return fmt.Sprintf("Command %s is not found", h.words(group))
+		}
+		return "No commands available"
+	}
+	sort.Strings(names)
+
+	sections := []string{}
+	for _, name := range names {
+		title := "Commands:"
+		if !utils.IsEmpty(name) {
+			title = fmt.Sprintf("%s:", h.words(name))
+		}
+		lines := commands[name]
+		sort.Strings(lines)
+		sections = append(sections, fmt.Sprintf("%s\n%s", title, strings.Join(lines, "\n")))
+	}
+	return fmt.Sprintf("%s\n\nType %s <group> <command> for details", strings.Join(sections, "\n\n"), h.options.Command)
+}
+
+// example puts required arguments by position and the first optional one as a flag
+func (h *Help) examples(name string, args []common.Arg) []string {
+
+	value := func(arg common.Arg) string {
+		switch {
+		case !utils.IsEmpty(arg.Default):
+			return arg.Default
+		case len(arg.Values) > 0:
+			return arg.Values[0]
+		}
+		switch arg.Type {
+		case common.ArgTypeInt:
+			return "1"
+		case common.ArgTypeBool:
+			return "true"
+		case common.ArgTypeDuration:
+			return "5m"
+		case common.ArgTypeList:
+			return "a,b"
+		}
+		return fmt.Sprintf("<%s>", arg.Name)
+	}
+
+	required := []string{name}
+	var optional *common.Arg
+	for i, arg := range args {
+		if arg.Required {
+			required = append(required, value(arg))
+			continue
+		}
+		if optional == nil {
+			optional = &args[i]
+		}
+	}
+
+	r := []string{strings.Join(required, " ")}
+	if optional != nil {
+		flag := fmt.Sprintf("--%s=%s", optional.Name, value(*optional))
+		if optional.Type == common.ArgTypeBool {
+			flag = fmt.Sprintf("--%s", optional.Name)
+		}
+		r = append(r, fmt.Sprintf("%s %s", r[0], flag))
+	}
+	return r
+}
+
+func (h *Help) details(bot common.Bot, message common.Message, group string, c common.Command) string {
+
+	name := h.words(common.GroupName(group, c))
+
+	lines := []string{name}
+	if !utils.IsEmpty(c.Description()) {
+		lines[0] = fmt.Sprintf("%s - %s", name, c.Description())
+	}
+
+	var args []common.Arg
+	if ac, ok := c.(common.ArgsCommand); ok {
+		args = ac.Args()
+	}
+
+	if len(args) > 0 {
+		lines = append(lines, common.ArgsUsage(name, args))
+	} else if params := c.Params(); len(params) > 0 && !reflect.DeepEqual(params, defaultParams()) {
+		lines = append(lines, fmt.Sprintf("Usage: %s <params>", name))
+		for _, p := range params {
+			lines = append(lines, fmt.Sprintf("  %s", p))
+		}
+	}
+
+	fields := c.Fields(bot, message, nil, nil)
+	if len(fields) > 0 {
+		lines = append(lines, "Fields:")
+		for _, f := range fields {
+
+			info := []string{}
+			if f.Type != common.FieldTypeUnknown {
+				info = append(info, string(f.Type))
+			}
+			if f.Required {
+				info = append(info, "required")
+			}
+			if !utils.IsEmpty(f.Default) {
+				info = append(info, fmt.Sprintf("default %s", f.Default))
+			}
+			l := fmt.Sprintf("  %s", f.Name)
+			if len(info) > 0 {
+				l = fmt.Sprintf("%s (%s)", l, strings.Join(info, ", "))
+			}
+			if !utils.IsEmpty(f.Label) {
+				l = fmt.Sprintf("%s %s", l, f.Label)
+			}
+			if len(f.Values) > 0 {
+				l = fmt.Sprintf("%s: %s", l, strings.Join(f.Values, ", "))
+			}
+			lines = append(lines, l)
+		}
+	}
+
+	aliases := append([]string{}, c.Aliases()...)
+	if len(aliases) > 0 {
+		sort.Strings(aliases)
+		lines = append(lines, fmt.Sprintf("Aliases: %s", strings.Join(aliases, ", ")))
+	}
+
+	actions := []string{}
+	for _, a := range c.Actions() {
+		label := a.Label()
+		if utils.IsEmpty(label) || label == a.Name() {
+			actions = append(actions, a.Name())
+			continue
+		}
+		actions = append(actions, fmt.Sprintf("%s (%s)", a.Name(), label))
+	}
+	if len(actions) > 0 {
+		lines = append(lines, fmt.Sprintf("Actions: %s", strings.Join(actions, ", ")))
+	}
+
+	lines = append(lines, "Examples:")
+	for _, e := range h.examples(name, args) {
+		lines = append(lines, fmt.Sprintf("  %s", e))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func NewHelp(options HelpOptions, observability *common.Observability, processors *common.Processors) *Help {
+
+	if !options.Enabled || utils.IsEmpty(options.Command) {
+		return nil
+	}
+
+	h := &Help{
+		options:       options,
+		processors:    processors,
+		logger:        observability.Logs(),
+		meter:         observability.Metrics(),
+		observability: observability,
+	}
+	h.commands = append(h.commands, &HelpCommand{name: options.Command, processor: h})
+	return h
+}
@@ -0,0 +1,668 @@
+package processor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blues/jsonata-go"
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	toolsRender "github.com/devopsext/tools/render"
+	"github.com/devopsext/utils"
+	"github.com/ohler55/ojg/jp"
+
+	"gopkg.in/yaml.v2"
+)
+
+type HTTPOptions struct {
+	CommandsDir string
+	ConfigExt   string
+	Timeout     int
+	Insecure    bool
+	Error       string
+}
+
+type HTTPAuth struct {
+	Type     string // basic, bearer, header
+	User     string
+	Password string
+	Token    string
+	Header   string
+}
+
+type HTTPRequest struct {
+	Method   string
+	URL      string
+	Headers  map[string]string
+	Body     string
+	Auth     *HTTPAuth
+	Timeout  int
+	Insecure *bool
+}
+
+type HTTPAttachment struct {
+	Title string
+	Data  string
+	Type  string
+}
+
+type HTTPReply struct {
+	Language    string // jsonpath, jsonata
+	Text        string
+	Attachments []HTTPAttachment
+	Actions     string
+}
+
+type HTTPAction struct {
+	Name    string
+	Label   string
+	Style   string
+	Request *HTTPRequest
+}
+
+type HTTPCommandConfig struct {
+	Description string
+	Params      []string
+	Aliases     []string
+	Response    DefaultReposne
+	Fields      []common.Field
+	Actions     []HTTPAction
+	Priority    int
+	Channel     string
+	Permissions *bool
+	Request     HTTPRequest
+	Reply       HTTPReply
+}
+
+type HTTPCommandAction struct {
+	name  string
+	label string
+	style string
+}
+
+type HTTPExecutor struct {
+	command *HTTPCommand
+	message common.Message
+}
+
+type HTTPCommand struct {
+	name      string
+	path      string
+	config    *HTTPCommandConfig
+	processor *HTTP
+	logger    sreCommon.Logger
+}
+
+type HTTP struct {
+	name          string
+	options       HTTPOptions
+	processors    *common.Processors
+	commands      []common.Command
+	meter         sreCommon.Meter
+	observability *common.Observability
+}
+
+const (
+	HTTPLanguageJSONPath = "jsonpath"
+	HTTPLanguageJSONata  = "jsonata"
+)
+
+const (
+	HTTPAuthBasic  = "basic"
+	HTTPAuthBearer = "bearer"
+	HTTPAuthHeader = "header"
+)
+
+// HTTP executor
+// common.Response
+
+func (he *HTTPExecutor) Visible() bool {
+	if !utils.IsEmpty(he.message) {
+		return he.message.Visible()
+	}
+	return false
+}
+
+func (he *HTTPExecutor) Error() bool {
+	return false
+}
+
+func (he *HTTPExecutor) Duration() bool {
+	d := he.command.config.Response.Duration
+	if d != nil {
+		return *d
+	}
+	return false
+}
+
+func (he *HTTPExecutor) Original() bool {
+	o := he.command.config.Response.Original
+	if o != nil {
+		return *o
+	}
+	return false
+}
+
+func (he *HTTPExecutor) Response() common.Response {
+	return he
+}
+
+func (he *HTTPExecutor) After(message common.Message) error {
+	return nil
+}
+
+// HTTPCommandAction
+
+func (hca *HTTPCommandAction) Name() string {
+	return hca.name
+}
+
+func (hca *HTTPCommandAction) Label() string {
+	return hca.label
+}
+
+func (hca *HTTPCommandAction) Template() string {
+	return ""
+}
+
+func (hca *HTTPCommandAction) Style() string {
+	return hca.style
+}
+
+// HTTP command
+
+func (hc *HTTPCommand) Name() string {
+	return hc.name
+}
+
+func (hc *HTTPCommand) Path() string {
+	return hc.path
+}
+
+func (hc *HTTPCommand) Group() string {
+	return hc.processor.name
+}
+
+func (hc *HTTPCommand) getNameWithGroup(delim string) string {
+
+	name := hc.name
+	if !utils.IsEmpty(hc.processor.name) {
+		name = fmt.Sprintf("%s%s%s", hc.processor.name, delim, hc.name)
+	}
+	return name
+}
+
+func (hc *HTTPCommand) Description() string {
+	return hc.config.Description
+}
+
+func (hc *HTTPCommand) Params() []string {
+
+	params := hc.config.Params
+	if utils.IsEmpty(params) {
+		return defaultParams()
+	}
+	return params
+}
+
+func (hc *HTTPCommand) Aliases() []string {
+	return hc.config.Aliases
+}
+
+func (hc *HTTPCommand) Confirmation(params common.ExecuteParams) string {
+	return ""
+}
+
+func (hc *HTTPCommand) Priority() int {
+	return hc.config.Priority
+}
+
+func (hc *HTTPCommand) Wrapper() bool {
+	return false
+}
+
+func (hc *HTTPCommand) Schedule() string {
+	return ""
+}
+
+func (hc *HTTPCommand) Channel() string {
+	return hc.config.Channel
+}
+
+func (hc *HTTPCommand) Response() common.Response {
+	return &HTTPExecutor{command: hc}
+}
+
+func (hc *HTTPCommand) Actions() []common.Action {
+
+	r := []common.Action{}
+	for _, a := range hc.config.Actions {
+		r = append(r, &HTTPCommandAction{
+			name:  a.Name,
+			label: a.Label,
+			style: a.Style,
+		})
+	}
+	return r
+}
+
+func (hc *HTTPCommand) Approval() common.Approval {
+	return nil
+}
+
+func (hc *HTTPCommand) Permissions() bool {
+	if hc.config.Permissions != nil {
+		return *hc.config.Permissions
+	}
+	return true
+}
+
+func (hc *HTTPCommand) Fields(bot common.Bot, message common.Message, params common.ExecuteParams, eval []string) []common.Field {
+	return hc.config.Fields
+}
+
+func (hc *HTTPCommand) render(name, content string, obj interface{}) (string, error) {
+
+	if !strings.Contains(content, "{{") {
+		return content, nil
+	}
+
+	tOpts := toolsRender.TemplateOptions{
+		Name:    fmt.Sprintf("http-internal-%s-%s", hc.getNameWithGroup("-"), name),
+		Content: content,
+	}
+	t, err := toolsRender.NewTextTemplate(tOpts, hc.processor.observability)
+	if err != nil {
+		return "", err
+	}
+	return common.RenderTemplate(t, "", obj)
+}
+
+func (hc *HTTPCommand) request(r *HTTPRequest, obj interface{}) ([]byte, error) {
+
+	method := strings.ToUpper(r.Method)
+	if utils.IsEmpty(method) {
+		method = "GET"
+	}
+
+	url, err := hc.render("url", r.URL, obj)
+	if err != nil {
+		return nil, err
+	}
+	if utils.IsEmpty(url) {
+		return nil, fmt.Errorf("HTTP command %s has no url", hc.name)
+	}
+
+	headers := make(map[string]string)
+	for k, v := range r.Headers {
+		headers[k], err = hc.render(fmt.Sprintf("header-%s", k), v, obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Auth != nil {
+		switch strings.ToLower(r.Auth.Type) {
+		case HTTPAuthBasic:
+			user, err := hc.render("auth-user", r.Auth.User, obj)
+			if err != nil {
+				return nil, err
+			}
+			password, err := hc.render("auth-password", r.Auth.Password, obj)
+			if err != nil {
+				return nil, err
+			}
+			auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", user, password)))
+			headers["Authorization"] = fmt.Sprintf("Basic %s", auth)
+		case HTTPAuthBearer:
+			token, err := hc.render("auth-token", r.Auth.Token, obj)
+			if err != nil {
+				return nil, err
+			}
+			headers["Authorization"] = fmt.Sprintf("Bearer %s", token)
+		case HTTPAuthHeader:
+			token, err := hc.render("auth-token", r.Auth.Token, obj)
+			if err != nil {
+				return nil, err
+			}
+			header := r.Auth.Header
+			if utils.IsEmpty(header) {
+				header = "Authorization"
+			}
+			headers[header] = token
+		default:
+			return nil, fmt.Errorf("HTTP command %s has unknown auth type %s", hc.name, r.Auth.Type)
+		}
+	}
+
+	var body []byte
+	if !utils.IsEmpty(r.Body) {
+		s, err := hc.render("body", r.Body, obj)
+		if err != nil {
+			return nil, err
+		}
+		body = []byte(s)
+	}
+
+	timeout := hc.processor.options.Timeout
+	if r.Timeout > 0 {
+		timeout = r.Timeout
+	}
+	insecure := hc.processor.options.Insecure
+	if r.Insecure != nil {
+		insecure = *r.Insecure
+	}
+
+	client := utils.NewHttpClient(timeout, insecure)
+	b, code, err := utils.HttpRequestRawWithHeadersOutCode(client, method, url, headers, body)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP command %s %s %s returned %d, error: %s", hc.name, method, url, code, err)
+	}
+	return b, nil
+}
+
+func (hc *HTTPCommand) eval(expr string, data interface{}) (interface{}, error) {
+
+	switch strings.ToLower(hc.config.Reply.Language) {
+	case HTTPLanguageJSONata:
+		e, err := jsonata.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		v, err := e.Eval(data)
+		if err == jsonata.ErrUndefined {
+			return nil, nil
+		}
+		return v, err
+	case "", HTTPLanguageJSONPath:
+		x, err := jp.ParseString(expr)
+		if err != nil {
+			return nil, err
+		}
+		r := x.Get(data)
+		switch len(r) {
+		case 0:
+			return nil, nil
+		case 1:
+			return r[0], nil
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("HTTP command %s has unknown reply language %s", hc.name, hc.config.Reply.Language)
+}
+
+func (hc *HTTPCommand) toString(v interface{}) string {
+
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	case []interface{}:
+		arr := []string{}
+		for _, i := range t {
+			arr = append(arr, hc.toString(i))
+		}
+		return strings.Join(arr, "\n")
+	case map[string]interface{}:
+		b, err := json.MarshalIndent(t, "", "  ")
+		if err != nil {
+			return fmt.Sprintf("%v", t)
+		}
+		return string(b)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func (hc *HTTPCommand) toAction(v interface{}) common.Action {
+
+	switch t := v.(type) {
+	case string:
+		if utils.IsEmpty(t) {
+			return nil
+		}
+		return &HTTPCommandAction{name: t, label: t}
+	case map[string]interface{}:
+		name := hc.toString(t["name"])
+		if utils.IsEmpty(name) {
+			return nil
+		}
+		label := hc.toString(t["label"])
+		if utils.IsEmpty(label) {
+			label = name
+		}
+		return &HTTPCommandAction{
+			name:  name,
+			label: label,
+			style: hc.toString(t["style"]),
+		}
+	}
+	return nil
+}
+
+func (hc *HTTPCommand) reply(body []byte) (string, []*common.Attachment, []common.Action, error) {
+
+	var data interface{}
+	err := json.Unmarshal(body, &data)
+	if err != nil {
+		data = string(body)
+	}
+
+	reply := hc.config.Reply
+
+	text := strings.TrimSpace(string(body))
+	if !utils.IsEmpty(reply.Text) {
+		v, err := hc.eval(reply.Text, data)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		text = hc.toString(v)
+	}
+
+	atts := []*common.Attachment{}
+	for _, a := range reply.Attachments {
+
+		v, err := hc.eval(a.Data, data)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if v == nil {
+			continue
+		}
+
+		s := hc.toString(v)
+		b := []byte(s)
+		if a.Type == common.AttachmentTypeImage {
+			d, err := base64.StdEncoding.DecodeString(s)
+			if err == nil {
+				b = d
+			}
+		}
+
+		atts = append(atts, &common.Attachment{
+			Title: a.Title,
+			Data:  b,
+			Type:  common.AttachmentType(a.Type),
+		})
+	}
+
+	acts := []common.Action{}
+	if !utils.IsEmpty(reply.Actions) {
+
+		v, err := hc.eval(reply.Actions, data)
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		list, ok := v.([]interface{})
+		if !ok {
+			list = []interface{}{v}
+		}
+		for _, item := range list {
+			a := hc.toAction(item)
+			if a != nil {
+				acts = append(acts, a)
+			}
+		}
+	}
+	return text, atts, acts, nil
+}
+
+func (hc *HTTPCommand) findRequest(action common.Action) *HTTPRequest {
+
+	if action != nil {
+		for _, a := range hc.config.Actions {
+			if a.Name == action.Name() && a.Request != nil {
+				return a.Request
+			}
+		}
+	}
+	return &hc.config.Request
+}
+
+func (hc *HTTPCommand) execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (string, []*common.Attachment, []common.Action, error) {
+
+	t1 := time.Now()
+
+	processor := hc.processor
+
+	labels := make(map[string]string)
+	if !utils.IsEmpty(processor.name) {
+		labels["group"] = processor.name
+	}
+	labels["command"] = hc.name
+	labels["bot"] = bot.Name()
+
+	user := message.User()
+	if !utils.IsEmpty(user) {
+		labels["user_id"] = user.ID()
+	}
+
+	prefixes := []string{"http", "processor"}
+
+	requests := processor.meter.Counter("processor", "requests", "Count of all executions", labels, prefixes...)
+	requests.Inc()
+
+	errors := processor.meter.Counter("processor", "errors", "Count of all errors during executions", labels, prefixes...)
+	timeCounter := processor.meter.Counter("processor", "time", "Sum of all time executions", labels, prefixes...)
+
+	name := hc.getNameWithGroup("/")
+
+	m := make(map[string]interface{})
+	m["params"] = params
+	m["bot"] = bot
+	m["message"] = message
+	m["user"] = user
+	m["caller"] = message.Caller()
+	m["channel"] = message.Channel()
+	m["name"] = name
+
+	if action != nil {
+		m["action"] = action
+	}
+
+	hc.logger.Debug("HTTP is executing command %s with params %v...", name, params)
+
+	body, err := hc.request(hc.findRequest(action), m)
+	if err != nil {
+		errors.Inc()
+		return "", nil, nil, err
+	}
+
+	text, atts, acts, err := hc.reply(body)
+	if err != nil {
+		errors.Inc()
+		return "", nil, nil, err
+	}
+
+	elapsed := time.Since(t1).Milliseconds()
+	timeCounter.Add(int(elapsed))
+
+	hc.logger.Debug("HTTP is executed command %s with params %v in %s", name, params, time.Since(t1))
+
+	return text, atts, acts, nil
+}
+
+func (hc *HTTPCommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	executor := &HTTPExecutor{
+		command: hc,
+		message: message,
+	}
+
+	text, atts, acts, err := hc.execute(bot, message, params, action)
+	if err != nil {
+		hc.logger.Error(err)
+		err = fmt.Errorf("%s", hc.processor.options.Error)
+		return nil, "", nil, nil, err
+	}
+	return executor, text, atts, acts, nil
+}
+
+// HTTP
+
+func (h *HTTP) Name() string {
+	return h.name
+}
+
+func (h *HTTP) Commands() []common.Command {
+	return h.commands
+}
+
+func (h *HTTP) loadConfig(path string) (*HTTPCommandConfig, error) {
+
+	bytes, err := utils.Content(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v HTTPCommandConfig
+	err = yaml.Unmarshal(bytes, &v)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (h *HTTP) AddCommand(name, path string) error {
+
+	logger := h.observability.Logs()
+
+	config, err := h.loadConfig(path)
+	if err != nil {
+		logger.Error("HTTP couldn't read config %s, error: %s", path, err)
+		return err
+	}
+
+	if utils.IsEmpty(config.Request.URL) {
+		err = fmt.Errorf("HTTP config %s has no request url", path)
+		logger.Error(err)
+		return err
+	}
+
+	hc := &HTTPCommand{
+		name:      name,
+		path:      path,
+		config:    config,
+		processor: h,
+		logger:    logger,
+	}
+	h.commands = append(h.commands, hc)
+	return nil
+}
+
+func NewHTTP(name string, options HTTPOptions, observability *common.Observability, processors *common.Processors) *HTTP {
+
+	return &HTTP{
+		name:          name,
+		options:       options,
+		processors:    processors,
+		meter:         observability.Metrics(),
+		observability: observability,
+	}
+}
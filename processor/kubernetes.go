@@ -0,0 +1,894 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type KubernetesOptions struct {
+	Enabled         bool
+	Group           string
+	Kubeconfig      string
+	Contexts        string
+	Namespace       string
+	LogLines        int
+	Timeout         int
+	Approval        bool
+	ApprovalChannel string
+	Error           string
+}
+
+type KubernetesRunFunc = func(ctx context.Context, client kubernetes.Interface, params common.ExecuteParams) (string, error)
+
+type KubernetesExecutor struct {
+	message common.Message
+}
+
+type KubernetesApproval struct {
+	command *KubernetesCommand
+}
+
+type KubernetesCommand struct {
+	name        string
+	description string
+	params      []string
+	fields      []common.Field
+	write       bool
+	run         KubernetesRunFunc
+	processor   *Kubernetes
+}
+
+type Kubernetes struct {
+	options        KubernetesOptions
+	commands       []common.Command
+	contexts       []string
+	currentContext string
+	clients        map[string]kubernetes.Interface
+	mutex          sync.Mutex
+	logger         sreCommon.Logger
+	meter          sreCommon.Meter
+	observability  *common.Observability
+}
+
+const (
+	KubernetesFieldContext    = "context"
+	KubernetesFieldNamespace  = "namespace"
+	KubernetesFieldDeployment = "deployment"
+	KubernetesFieldPod        = "pod"
+	KubernetesFieldContainer  = "container"
+	KubernetesFieldLines      = "lines"
+	KubernetesFieldKind       = "kind"
+	KubernetesFieldName       = "name"
+	KubernetesFieldReplicas   = "replicas"
+)
+
+const (
+	KubernetesKindDeployment = "deployment"
+	KubernetesKindPod        = "pod"
+)
+
+const kubernetesInClusterContext = "in-cluster"
+
+// Kubernetes executor
+// common.Response
+
+func (ke *KubernetesExecutor) Visible() bool {
+	if !utils.IsEmpty(ke.message) {
+		return ke.message.Visible()
+	}
+	return false
+}
+
+func (ke *KubernetesExecutor) Duration() bool {
+	return false
+}
+
+func (ke *KubernetesExecutor) Original() bool {
+	return false
+}
+
+func (ke *KubernetesExecutor) Error() bool {
+	return false
+}
+
+func (ke *KubernetesExecutor) Response() common.Response {
+	return ke
+}
+
+func (ke *KubernetesExecutor) After(message common.Message) error {
+	return nil
+}
+
+// Kubernetes approval
+
+func (ka *KubernetesApproval) Channel(bot common.Bot, message common.Message, params common.ExecuteParams) string {
+	return ka.command.processor.options.ApprovalChannel
+}
+
+func (ka *KubernetesApproval) Message(bot common.Bot, message common.Message, params common.ExecuteParams) string {
+
+	k := ka.command.processor
+	caller := ""
+	if !utils.IsEmpty(message) && !utils.IsEmpty(message.Caller()) && !utils.IsEmpty(message.Caller().Name()) {
+		caller = fmt.Sprintf(" requested by %s", message.Caller().Name())
+	}
+
+	keys := []string{}
+	for _, f := range ka.command.fields {
+		v := k.param(params, f.Name)
+		if !utils.IsEmpty(v) {
+			keys = append(keys, fmt.Sprintf("%s=%s", f.Name, v))
+		}
+	}
+	return fmt.Sprintf("Approve %s%s: %s ?", ka.command.getNameWithGroup("/"), caller, strings.Join(keys, " "))
+}
+
+func (ka *KubernetesApproval) Reasons() []string {
+	return []string{}
+}
+
+func (ka *KubernetesApproval) Description() bool {
+	return false
+}
+
+func (ka *KubernetesApproval) Visible() bool {
+	return false
+}
+
+// Kubernetes command
+// common.Response
+
+func (kc *KubernetesCommand) Visible() bool {
+	return false
+}
+
+func (kc *KubernetesCommand) Duration() bool {
+	return false
+}
+
+func (kc *KubernetesCommand) Original() bool {
+	return false
+}
+
+func (kc *KubernetesCommand) Error() bool {
+	return false
+}
+
+func (kc *KubernetesCommand) Name() string {
+	return kc.name
+}
+
+func (kc *KubernetesCommand) Group() string {
+	return kc.processor.Name()
+}
+
+func (kc *KubernetesCommand) getNameWithGroup(delim string) string {
+
+	name := kc.name
+	group := kc.processor.Name()
+	if !utils.IsEmpty(group) {
+		name = fmt.Sprintf("%s%s%s", group, delim, kc.name)
+	}
+	return name
+}
+
+func (kc *KubernetesCommand) Description() string {
+	return kc.description
+}
+
+func (kc *KubernetesCommand) Params() []string {
+	return kc.params
+}
+
+func (kc *KubernetesCommand) Aliases() []string {
+	return []string{}
+}
+
+func (kc *KubernetesCommand) Confirmation(params common.ExecuteParams) string {
+	return ""
+}
+
+func (kc *KubernetesCommand) Priority() int {
+	return 0
+}
+
+func (kc *KubernetesCommand) Wrapper() bool {
+	return false
+}
+
+func (kc *KubernetesCommand) Schedule() string {
+	return ""
+}
+
+func (kc *KubernetesCommand) Channel() string {
+	return ""
+}
+
+func (kc *KubernetesCommand) Response() common.Response {
+	return kc
+}
+
+func (kc *KubernetesCommand) Actions() []common.Action {
+	return []common.Action{}
+}
+
+func (kc *KubernetesCommand) Approval() common.Approval {
+	if kc.write && kc.processor.options.Approval {
+		return &KubernetesApproval{command: kc}
+	}
+	return nil
+}
+
+func (kc *KubernetesCommand) Permissions() bool {
+	return true
+}
+
+// dynamic fields are evaluated against cluster, others are static
+func (kc *KubernetesCommand) Fields(bot common.Bot, message common.Message, params common.ExecuteParams, eval []string) []common.Field {
+
+	k := kc.processor
+	fields := []common.Field{}
+
+	for _, f := range kc.fields {
+
+		if f.Name == KubernetesFieldContext {
+			f.Values = k.contexts
+			f.Default = k.currentContext
+		}
+
+		if !utils.Contains(eval, f.Name) {
+			fields = append(fields, f)
+			continue
+		}
+
+		values, err := k.values(f.Name, params)
+		if err != nil {
+			k.logger.Error("Kubernetes couldn't load %s values, error: %s", f.Name, err)
+		} else {
+			f.Values = values
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func (kc *KubernetesCommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	t1 := time.Now()
+
+	k := kc.processor
+
+	labels := make(map[string]string)
+	labels["group"] = k.Name()
+	labels["command"] = kc.name
+	labels["bot"] = bot.Name()
+
+	user := message.User()
+	if !utils.IsEmpty(user) {
+		labels["user_id"] = user.ID()
+	}
+
+	prefixes := []string{"kubernetes", "processor"}
+
+	requests := k.meter.Counter("processor", "requests", "Count of all executions", labels, prefixes...)
+	requests.Inc()
+
+	errors := k.meter.Counter("processor", "errors", "Count of all errors during executions", labels, prefixes...)
+	timeCounter := k.meter.Counter("processor", "time", "Sum of all time executions", labels, prefixes...)
+
+	name := kc.getNameWithGroup("/")
+	k.logger.Debug("Kubernetes is executing command %s with params %v...", name, params)
+
+	text, err := k.execute(params, kc.run)
+	if err != nil {
+		errors.Inc()
+		k.logger.Error("Kubernetes command %s error: %s", name, err)
+		return nil, "", nil, nil, fmt.Errorf("%s", k.options.Error)
+	}
+
+	elapsed := time.Since(t1).Milliseconds()
+	timeCounter.Add(int(elapsed))
+
+	k.logger.Debug("Kubernetes is executed command %s with params %v in %s", name, params, time.Since(t1))
+
+	executor := &KubernetesExecutor{message: message}
+	return executor, text, nil, nil, nil
+}
+
+// Kubernetes
+
+func (k *Kubernetes) Name() string {
+	return k.options.Group
+}
+
+func (k *Kubernetes) Commands() []common.Command {
+	return k.commands
+}
+
+func (k *Kubernetes) param(params common.ExecuteParams, name string) string {
+
+	if params == nil {
+		return ""
+	}
+	v, ok := params[name]
+	if !ok || v == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprintf("%v", v))
+}
+
+func (k *Kubernetes) namespace(params common.ExecuteParams) string {
+
+	ns := k.param(params, KubernetesFieldNamespace)
+	if utils.IsEmpty(ns) {
+		ns = k.options.Namespace
+	}
+	return ns
+}
+
+func (k *Kubernetes) loadingRules() *clientcmd.ClientConfigLoadingRules {
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if !utils.IsEmpty(k.options.Kubeconfig) {
+		rules.ExplicitPath = k.options.Kubeconfig
+	}
+	return rules
+}
+
+func (k *Kubernetes) client(name string) (kubernetes.Interface, error) {
+
+	if utils.IsEmpty(name) {
+		name = k.currentContext
+	}
+	if !utils.Contains(k.contexts, name) {
+		return nil, fmt.Errorf("unknown context %s", name)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	c, ok := k.clients[name]
+	if ok {
+		return c, nil
+	}
+
+	var config *rest.Config
+	var err error
+
+	if name == kubernetesInClusterContext {
+		config, err = rest.InClusterConfig()
+	} else {
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: name}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(k.loadingRules(), overrides).ClientConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = time.Duration(k.options.Timeout) * time.Second
+
+	c, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	k.clients[name] = c
+	return c, nil
+}
+
+func (k *Kubernetes) execute(params common.ExecuteParams, run KubernetesRunFunc) (string, error) {
+
+	client, err := k.client(k.param(params, KubernetesFieldContext))
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(k.options.Timeout)*time.Second)
+	defer cancel()
+
+	return run(ctx, client, params)
+}
+
+func (k *Kubernetes) values(field string, params common.ExecuteParams) ([]string, error) {
+
+	var names []string
+
+	_, err := k.execute(params, func(ctx context.Context, client kubernetes.Interface, params common.ExecuteParams) (string, error) {
+
+		ns := k.namespace(params)
+		kind := field
+		if field == KubernetesFieldName {
+			kind = k.param(params, KubernetesFieldKind)
+		}
+
+		switch kind {
+		case KubernetesFieldNamespace:
+			list, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return "", err
+			}
+			for _, i := range list.Items {
+				names = append(names, i.Name)
+			}
+		case KubernetesKindDeployment:
+			list, err := client.AppsV1().Deployments(ns).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return "", err
+			}
+			for _, i := range list.Items {
+				names = append(names, i.Name)
+			}
+		case KubernetesKindPod:
+			list, err := client.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return "", err
+			}
+			for _, i := range list.Items {
+				names = append(names, i.Name)
+			}
+		}
+		return "", nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+func (k *Kubernetes) table(header []string, rows [][]string) string {
+
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(w, strings.Join(r, "\t"))
+	}
+	w.Flush()
+	return fmt.Sprintf("```\n%s```", b.String())
+}
+
+func (k *Kubernetes) age(t metav1.Time) string {
+
+	d := time.Since(t.Time).Round(time.Second)
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%ds", int(d.Seconds()))
+}
+
+func (k *Kubernetes) events(ctx context.Context, client kubernetes.Interface, ns, kind, name string) string {
+
+	selector := fmt.Sprintf("involvedObject.kind=%s,involvedObject.name=%s", kind, name)
+	list, err := client.CoreV1().Events(ns).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil || len(list.Items) == 0 {
+		return ""
+	}
+
+	rows := [][]string{}
+	for _, e := range list.Items {
+		rows = append(rows, []string{e.Type, e.Reason, k.age(e.LastTimestamp), e.Message})
+	}
+	return fmt.Sprintf("\nEvents:\n%s", k.table([]string{"TYPE", "REASON", "AGE", "MESSAGE"}, rows))
+}
+
+func (k *Kubernetes) pods(ctx context.Context, client kubernetes.Interface, params common.ExecuteParams) (string, error) {
+
+	ns := k.namespace(params)
+	list, err := client.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	if len(list.Items) == 0 {
+		return fmt.Sprintf("No pods found in %s namespace", ns), nil
+	}
+
+	rows := [][]string{}
+	for _, p := range list.Items {
+		ready := 0
+		restarts := int32(0)
+		for _, s := range p.Status.ContainerStatuses {
+			if s.Ready {
+				ready++
+			}
+			restarts += s.RestartCount
+		}
+		rows = append(rows, []string{
+			p.Name,
+			fmt.Sprintf("%d/%d", ready, len(p.Spec.Containers)),
+			string(p.Status.Phase),
+			strconv.Itoa(int(restarts)),
+			k.age(p.CreationTimestamp),
+		})
+	}
+	return k.table([]string{"NAME", "READY", "STATUS", "RESTARTS", "AGE"}, rows), nil
+}
+
+func (k *Kubernetes) logs(ctx context.Context, client kubernetes.Interface, params common.ExecuteParams) (string, error) {
+
+	ns := k.namespace(params)
+	pod := k.param(params, KubernetesFieldPod)
+	if utils.IsEmpty(pod) {
+		return "", fmt.Errorf("no pod")
+	}
+
+	lines := int64(k.options.LogLines)
+	l := k.param(params, KubernetesFieldLines)
+	if !utils.IsEmpty(l) {
+		n, err := strconv.Atoi(l)
+		if err != nil {
+			return "", fmt.Errorf("invalid lines %s", l)
+		}
+		lines = int64(n)
+	}
+
+	opts := &corev1.PodLogOptions{
+		Container: k.param(params, KubernetesFieldContainer),
+		TailLines: &lines,
+	}
+
+	b, err := client.CoreV1().Pods(ns).GetLogs(pod, opts).Do(ctx).Raw()
+	if err != nil {
+		return "", err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return fmt.Sprintf("No logs for %s/%s", ns, pod), nil
+	}
+	return fmt.Sprintf("```\n%s\n```", strings.TrimRight(string(b), "\n")), nil
+}
+
+func (k *Kubernetes) describePod(ctx context.Context, client kubernetes.Interface, ns, name string) (string, error) {
+
+	p, err := client.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	r := []string{
+		fmt.Sprintf("Name: %s", p.Name),
+		fmt.Sprintf("Namespace: %s", p.Namespace),
+		fmt.Sprintf("Node: %s", p.Spec.NodeName),
+		fmt.Sprintf("Status: %s", p.Status.Phase),
+		fmt.Sprintf("IP: %s", p.Status.PodIP),
+		fmt.Sprintf("Age: %s", k.age(p.CreationTimestamp)),
+	}
+
+	rows := [][]string{}
+	for _, c := range p.Spec.Containers {
+		ready := "false"
+		restarts := "0"
+		for _, s := range p.Status.ContainerStatuses {
+			if s.Name == c.Name {
+				ready = strconv.FormatBool(s.Ready)
+				restarts = strconv.Itoa(int(s.RestartCount))
+			}
+		}
+		rows = append(rows, []string{c.Name, c.Image, ready, restarts})
+	}
+	s := fmt.Sprintf("%s\nContainers:\n%s", strings.Join(r, "\n"), k.table([]string{"NAME", "IMAGE", "READY", "RESTARTS"}, rows))
+	return s + k.events(ctx, client, ns, "Pod", name), nil
+}
+
+func (k *Kubernetes) describeDeployment(ctx context.Context, client kubernetes.Interface, ns, name string) (string, error) {
+
+	d, err := client.AppsV1().Deployments(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+
+	images := []string{}
+	for _, c := range d.Spec.Template.Spec.Containers {
+		images = append(images, fmt.Sprintf("%s=%s", c.Name, c.Image))
+	}
+
+	r := []string{
+		fmt.Sprintf("Name: %s", d.Name),
+		fmt.Sprintf("Namespace: %s", d.Namespace),
+		fmt.Sprintf("Replicas: %d desired | %d updated | %d ready | %d available", replicas, d.Status.UpdatedReplicas, d.Status.ReadyReplicas, d.Status.AvailableReplicas),
+		fmt.Sprintf("Strategy: %s", d.Spec.Strategy.Type),
+		fmt.Sprintf("Images: %s", strings.Join(images, ", ")),
+		fmt.Sprintf("Age: %s", k.age(d.CreationTimestamp)),
+	}
+
+	rows := [][]string{}
+	for _, c := range d.Status.Conditions {
+		rows = append(rows, []string{string(c.Type), string(c.Status), c.Reason})
+	}
+	s := fmt.Sprintf("%s\nConditions:\n%s", strings.Join(r, "\n"), k.table([]string{"TYPE", "STATUS", "REASON"}, rows))
+	return s + k.events(ctx, client, ns, "Deployment", name), nil
+}
+
+func (k *Kubernetes) describe(ctx context.Context, client kubernetes.Interface, params common.ExecuteParams) (string, error) {
+
+	ns := k.namespace(params)
+	name := k.param(params, KubernetesFieldName)
+	if utils.IsEmpty(name) {
+		return "", fmt.Errorf("no name")
+	}
+
+	kind := k.param(params, KubernetesFieldKind)
+	switch kind {
+	case KubernetesKindPod:
+		return k.describePod(ctx, client, ns, name)
+	case KubernetesKindDeployment, "":
+		return k.describeDeployment(ctx, client, ns, name)
+	}
+	return "", fmt.Errorf("unsupported kind %s", kind)
+}
+
+func (k *Kubernetes) deployment(params common.ExecuteParams) (string, string, error) {
+
+	name := k.param(params, KubernetesFieldDeployment)
+	if utils.IsEmpty(name) {
+		return "", "", fmt.Errorf("no deployment")
+	}
+	return k.namespace(params), name, nil
+}
+
+func (k *Kubernetes) restart(ctx context.Context, client kubernetes.Interface, params common.ExecuteParams) (string, error) {
+
+	ns, name, err := k.deployment(params)
+	if err != nil {
+		return "", err
+	}
+
+	// the same way kubectl rollout restart does
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"%s"}}}}}`, time.Now().Format(time.RFC3339))
+	_, err = client.AppsV1().Deployments(ns).Patch(ctx, name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Deployment %s/%s restarted", ns, name), nil
+}
+
+func (k *Kubernetes) rolloutStatus(d *appsv1.Deployment) string {
+
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+
+	switch {
+	case d.Generation > d.Status.ObservedGeneration:
+		return "Waiting for deployment spec update to be observed..."
+	case d.Status.UpdatedReplicas < replicas:
+		return fmt.Sprintf("Waiting for rollout to finish: %d out of %d new replicas have been updated...", d.Status.UpdatedReplicas, replicas)
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return fmt.Sprintf("Waiting for rollout to finish: %d old replicas are pending termination...", d.Status.Replicas-d.Status.UpdatedReplicas)
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return fmt.Sprintf("Waiting for rollout to finish: %d of %d updated replicas are available...", d.Status.AvailableReplicas, d.Status.UpdatedReplicas)
+	}
+	return fmt.Sprintf("Deployment %s/%s successfully rolled out", d.Namespace, d.Name)
+}
+
+func (k *Kubernetes) status(ctx context.Context, client kubernetes.Interface, params common.ExecuteParams) (string, error) {
+
+	ns, name, err := k.deployment(params)
+	if err != nil {
+		return "", err
+	}
+
+	d, err := client.AppsV1().Deployments(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return k.rolloutStatus(d), nil
+}
+
+func (k *Kubernetes) scale(ctx context.Context, client kubernetes.Interface, params common.ExecuteParams) (string, error) {
+
+	ns, name, err := k.deployment(params)
+	if err != nil {
+		return "", err
+	}
+
+	r := k.param(params, KubernetesFieldReplicas)
+	replicas, err := strconv.Atoi(r)
+	if err != nil || replicas < 0 {
+		return "", fmt.Errorf("invalid replicas %s", r)
+	}
+
+	scale := &autoscalingv1.Scale{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+		Spec:       autoscalingv1.ScaleSpec{Replicas: int32(replicas)},
+	}
+	_, err = client.AppsV1().Deployments(ns).UpdateScale(ctx, name, scale, metav1.UpdateOptions{})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Deployment %s/%s scaled to %d", ns, name, replicas), nil
+}
+
+func (k *Kubernetes) addCommand(name, description string, params []string, fields []string, write bool, run KubernetesRunFunc) {
+
+	all := map[string]common.Field{
+		KubernetesFieldContext: {
+			Name:  KubernetesFieldContext,
+			Type:  common.FieldTypeSelect,
+			Label: "Context",
+		},
+		KubernetesFieldNamespace: {
+			Name:     KubernetesFieldNamespace,
+			Type:     common.FieldTypeDynamicSelect,
+			Label:    "Namespace",
+			Default:  k.options.Namespace,
+			Required: true,
+		},
+		KubernetesFieldDeployment: {
+			Name:         KubernetesFieldDeployment,
+			Type:         common.FieldTypeDynamicSelect,
+			Label:        "Deployment",
+			Required:     true,
+			Dependencies: []string{KubernetesFieldContext, KubernetesFieldNamespace},
+		},
+		KubernetesFieldPod: {
+			Name:         KubernetesFieldPod,
+			Type:         common.FieldTypeDynamicSelect,
+			Label:        "Pod",
+			Required:     true,
+			Dependencies: []string{KubernetesFieldContext, KubernetesFieldNamespace},
+		},
+		KubernetesFieldContainer: {
+			Name:  KubernetesFieldContainer,
+			Type:  common.FieldTypeEdit,
+			Label: "Container",
+		},
+		KubernetesFieldLines: {
+			Name:    KubernetesFieldLines,
+			Type:    common.FieldTypeInteger,
+			Label:   "Lines",
+			Default: strconv.Itoa(k.options.LogLines),
+		},
+		KubernetesFieldKind: {
+			Name:     KubernetesFieldKind,
+			Type:     common.FieldTypeSelect,
+			Label:    "Kind",
+			Default:  KubernetesKindDeployment,
+			Required: true,
+			Values:   []string{KubernetesKindDeployment, KubernetesKindPod},
+		},
+		KubernetesFieldName: {
+			Name:         KubernetesFieldName,
+			Type:         common.FieldTypeDynamicSelect,
+			Label:        "Name",
+			Required:     true,
+			Dependencies: []string{KubernetesFieldContext, KubernetesFieldNamespace, KubernetesFieldKind},
+		},
+		KubernetesFieldReplicas: {
+			Name:     KubernetesFieldReplicas,
+			Type:     common.FieldTypeInteger,
+			Label:    "Replicas",
+			Required: true,
+		},
+	}
+
+	list := []common.Field{}
+	for _, f := range append(fields, KubernetesFieldContext) {
+		list = append(list, all[f])
+	}
+
+	k.commands = append(k.commands, &KubernetesCommand{
+		name:        name,
+		description: description,
+		params:      params,
+		fields:      list,
+		write:       write,
+		run:         run,
+		processor:   k,
+	})
+}
+
+func (k *Kubernetes) loadContexts() error {
+
+	config, err := k.loadingRules().Load()
+	if err == nil && len(config.Contexts) > 0 {
+		for name := range config.Contexts {
+			k.contexts = append(k.contexts, name)
+		}
+		k.currentContext = config.CurrentContext
+	} else {
+		_, err = rest.InClusterConfig()
+		if err != nil {
+			return fmt.Errorf("no kubeconfig contexts and not in cluster")
+		}
+		k.contexts = []string{kubernetesInClusterContext}
+		k.currentContext = kubernetesInClusterContext
+	}
+
+	allowed := common.RemoveEmptyStrings(strings.Split(k.options.Contexts, ","))
+	if len(allowed) > 0 {
+		contexts := []string{}
+		for _, c := range k.contexts {
+			if utils.Contains(allowed, c) {
+				contexts = append(contexts, c)
+			}
+		}
+		k.contexts = contexts
+	}
+	sort.Strings(k.contexts)
+
+	if len(k.contexts) == 0 {
+		return fmt.Errorf("no allowed contexts")
+	}
+	if !utils.Contains(k.contexts, k.currentContext) {
+		k.currentContext = k.contexts[0]
+	}
+	return nil
+}
+
+func NewKubernetes(options KubernetesOptions, observability *common.Observability, processors *common.Processors) *Kubernetes {
+
+	if !options.Enabled {
+		return nil
+	}
+
+	logger := observability.Logs()
+
+	k := &Kubernetes{
+		options:       options,
+		clients:       make(map[string]kubernetes.Interface),
+		logger:        logger,
+		meter:         observability.Metrics(),
+		observability: observability,
+	}
+
+	err := k.loadContexts()
+	if err != nil {
+		logger.Error("Kubernetes couldn't load contexts, error: %s", err)
+		return nil
+	}
+
+	ns := "(?P<namespace>\\S+)"
+	ctx := "\\s+(?P<context>\\S+)"
+
+	k.addCommand("pods", "List pods in namespace",
+		[]string{ns + ctx, ns},
+		[]string{KubernetesFieldNamespace}, false, k.pods)
+
+	k.addCommand("logs", "Show pod logs",
+		[]string{ns + "\\s+(?P<pod>\\S+)\\s+(?P<container>\\S+)" + ctx, ns + "\\s+(?P<pod>\\S+)\\s+(?P<container>\\S+)", ns + "\\s+(?P<pod>\\S+)"},
+		[]string{KubernetesFieldNamespace, KubernetesFieldPod, KubernetesFieldContainer, KubernetesFieldLines}, false, k.logs)
+
+	k.addCommand("describe", "Describe deployment or pod",
+		[]string{"(?P<kind>deployment|pod)\\s+" + ns + "\\s+(?P<name>\\S+)" + ctx, "(?P<kind>deployment|pod)\\s+" + ns + "\\s+(?P<name>\\S+)"},
+		[]string{KubernetesFieldKind, KubernetesFieldNamespace, KubernetesFieldName}, false, k.describe)
+
+	k.addCommand("status", "Show deployment rollout status",
+		[]string{ns + "\\s+(?P<deployment>\\S+)" + ctx, ns + "\\s+(?P<deployment>\\S+)"},
+		[]string{KubernetesFieldNamespace, KubernetesFieldDeployment}, false, k.status)
+
+	k.addCommand("restart", "Rollout restart deployment",
+		[]string{ns + "\\s+(?P<deployment>\\S+)" + ctx, ns + "\\s+(?P<deployment>\\S+)"},
+		[]string{KubernetesFieldNamespace, KubernetesFieldDeployment}, true, k.restart)
+
+	k.addCommand("scale", "Scale deployment replicas",
+		[]string{ns + "\\s+(?P<deployment>\\S+)\\s+(?P<replicas>\\d+)" + ctx, ns + "\\s+(?P<deployment>\\S+)\\s+(?P<replicas>\\d+)"},
+		[]string{KubernetesFieldNamespace, KubernetesFieldDeployment, KubernetesFieldReplicas}, true, k.scale)
+
+	logger.Debug("Kubernetes loaded contexts %s, current %s", strings.Join(k.contexts, ","), k.currentContext)
+	return k
+}
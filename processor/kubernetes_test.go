@@ -0,0 +1,249 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/devopsext/chatops/common"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: local
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: dev
+  context:
+    cluster: local
+- name: prod
+  context:
+    cluster: local
+- name: stage
+  context:
+    cluster: local
+users: []
+`
+
+// testKubernetes loads contexts from kubeconfig, prod is served by fake clientset with objects
+func testKubernetes(t *testing.T, approval bool, objects ...runtime.Object) (*Kubernetes, *fake.Clientset) {
+
+	t.Helper()
+	k := NewKubernetes(KubernetesOptions{
+		Enabled:    true,
+		Group:      "k8s",
+		Kubeconfig: testFile(t, "config", testKubeconfig),
+		Contexts:   "dev,prod",
+		Namespace:  "default",
+		LogLines:   10,
+		Timeout:    5,
+		Approval:   approval,
+		Error:      "Kubernetes failed",
+	}, testObservability(), common.NewProcessors())
+	if k == nil {
+		t.Fatal("expected Kubernetes processor")
+	}
+
+	client := fake.NewSimpleClientset(objects...)
+	k.clients["prod"] = client
+	return k, client
+}
+
+func testKubernetesCommand(t *testing.T, k *Kubernetes, name string) common.Command {
+
+	t.Helper()
+	for _, c := range k.Commands() {
+		if c.Name() == name {
+			return c
+		}
+	}
+	t.Fatalf("command %s is not found", name)
+	return nil
+}
+
+func testDeployment(name string, replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Generation: 1},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:1"}}},
+			},
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 1,
+			Replicas:           replicas,
+			UpdatedReplicas:    replicas,
+			ReadyReplicas:      replicas,
+			AvailableReplicas:  replicas,
+		},
+	}
+}
+
+func TestKubernetesContexts(t *testing.T) {
+
+	k, _ := testKubernetes(t, false)
+	if strings.Join(k.contexts, ",") != "dev,prod" || k.currentContext != "dev" {
+		t.Fatalf("unexpected contexts %v, current %s", k.contexts, k.currentContext)
+	}
+
+	_, err := k.client("stage")
+	if err == nil {
+		t.Fatal("expected not allowed context to be rejected")
+	}
+}
+
+func TestKubernetesPods(t *testing.T) {
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "proxy"}}},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", Ready: true, RestartCount: 2},
+				{Name: "proxy", Ready: false, RestartCount: 1},
+			},
+		},
+	}
+	k, _ := testKubernetes(t, false, pod)
+	c := testKubernetesCommand(t, k, "pods")
+
+	_, text, _, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"context": "prod"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) < 3 || !strings.HasPrefix(strings.Join(strings.Fields(lines[2]), " "), "api-1 1/2 Running 3") {
+		t.Fatalf("unexpected pods: %s", text)
+	}
+
+	_, text, _, _, err = c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"context": "prod", "namespace": "kube-system"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "No pods found in kube-system namespace" {
+		t.Fatalf("unexpected text: %s", text)
+	}
+}
+
+func TestKubernetesRestart(t *testing.T) {
+
+	k, client := testKubernetes(t, false, testDeployment("api", 2))
+	c := testKubernetesCommand(t, k, "restart")
+
+	_, text, _, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"context": "prod", "deployment": "api"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Deployment default/api restarted" {
+		t.Fatalf("unexpected text: %s", text)
+	}
+
+	var patch string
+	for _, a := range client.Actions() {
+		if p, ok := a.(k8sTesting.PatchAction); ok && a.GetResource().Resource == "deployments" {
+			patch = string(p.GetPatch())
+		}
+	}
+	if !strings.Contains(patch, "kubectl.kubernetes.io/restartedAt") {
+		t.Fatalf("expected restart annotation patch, got %s", patch)
+	}
+}
+
+func TestKubernetesScale(t *testing.T) {
+
+	k, client := testKubernetes(t, false, testDeployment("api", 2))
+
+	var scaled int32 = -1
+	client.PrependReactor("update", "deployments", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		scale := action.(k8sTesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+		scaled = scale.Spec.Replicas
+		return true, scale, nil
+	})
+
+	c := testKubernetesCommand(t, k, "scale")
+	_, text, _, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"context": "prod", "deployment": "api", "replicas": "5"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scaled != 5 || text != "Deployment default/api scaled to 5" {
+		t.Fatalf("unexpected scale %d: %s", scaled, text)
+	}
+
+	_, _, _, _, err = c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"context": "prod", "deployment": "api", "replicas": "-1"}, nil)
+	if err == nil || err.Error() != "Kubernetes failed" {
+		t.Fatalf("expected processor error, got %v", err)
+	}
+}
+
+func TestKubernetesStatus(t *testing.T) {
+
+	d := testDeployment("api", 3)
+	d.Status.UpdatedReplicas = 1
+	k, _ := testKubernetes(t, false, d, testDeployment("web", 1))
+	c := testKubernetesCommand(t, k, "status")
+
+	_, text, _, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"context": "prod", "deployment": "api"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Waiting for rollout to finish: 1 out of 3 new replicas have been updated..." {
+		t.Fatalf("unexpected status: %s", text)
+	}
+
+	_, text, _, _, err = c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"context": "prod", "deployment": "web"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Deployment default/web successfully rolled out" {
+		t.Fatalf("unexpected status: %s", text)
+	}
+}
+
+func TestKubernetesFields(t *testing.T) {
+
+	k, _ := testKubernetes(t, false, testDeployment("web", 1), testDeployment("api", 1))
+	c := testKubernetesCommand(t, k, "restart")
+
+	fields := c.Fields(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"context": "prod"}, []string{KubernetesFieldDeployment})
+	values := map[string][]string{}
+	for _, f := range fields {
+		values[f.Name] = f.Values
+	}
+	if strings.Join(values[KubernetesFieldDeployment], ",") != "api,web" {
+		t.Fatalf("unexpected deployments %v", values[KubernetesFieldDeployment])
+	}
+	if strings.Join(values[KubernetesFieldContext], ",") != "dev,prod" {
+		t.Fatalf("unexpected contexts %v", values[KubernetesFieldContext])
+	}
+}
+
+func TestKubernetesApproval(t *testing.T) {
+
+	k, _ := testKubernetes(t, true)
+	if testKubernetesCommand(t, k, "pods").Approval() != nil {
+		t.Fatal("expected read command without approval")
+	}
+
+	approval := testKubernetesCommand(t, k, "restart").Approval()
+	if approval == nil {
+		t.Fatal("expected write command with approval")
+	}
+	text := approval.Message(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"namespace": "default", "deployment": "api"})
+	if text != "Approve k8s/restart requested by u1: namespace=default deployment=api ?" {
+		t.Fatalf("unexpected approval: %s", text)
+	}
+}
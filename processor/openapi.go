@@ -0,0 +1,682 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	toolsRender "github.com/devopsext/tools/render"
+	"github.com/devopsext/utils"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+type OpenAPIOptions struct {
+	Specs        string // files or urls
+	Server       string // overrides servers of specs
+	Token        string
+	Timeout      int
+	Insecure     bool
+	TemplatesDir string // <tag>/<operationId>.tpl formats response
+	Error        string
+}
+
+type OpenAPIExecutor struct {
+	command *OpenAPICommand
+	message common.Message
+}
+
+type OpenAPIParameter struct {
+	name   string
+	in     string
+	schema *openapi3.Schema
+}
+
+type OpenAPICommand struct {
+	name        string
+	description string
+	method      string
+	path        string
+	server      string
+	params      []string
+	fields      []common.Field
+	parameters  []*OpenAPIParameter
+	template    *toolsRender.TextTemplate
+	processor   *OpenAPI
+	logger      sreCommon.Logger
+}
+
+type OpenAPI struct {
+	name          string
+	options       OpenAPIOptions
+	processors    *common.Processors
+	commands      []common.Command
+	meter         sreCommon.Meter
+	observability *common.Observability
+}
+
+const OpenAPIBody = "body"
+
+// OpenAPI executor
+// common.Response
+
+func (oe *OpenAPIExecutor) Visible() bool {
+	if !utils.IsEmpty(oe.message) {
+		return oe.message.Visible()
+	}
+	return false
+}
+
+func (oe *OpenAPIExecutor) Error() bool {
+	return false
+}
+
+func (oe *OpenAPIExecutor) Duration() bool {
+	return false
+}
+
+func (oe *OpenAPIExecutor) Original() bool {
+	return false
+}
+
+func (oe *OpenAPIExecutor) Response() common.Response {
+	return oe
+}
+
+func (oe *OpenAPIExecutor) After(message common.Message) error {
+	return nil
+}
+
+// OpenAPI command
+
+func (oc *OpenAPICommand) Name() string {
+	return oc.name
+}
+
+func (oc *OpenAPICommand) Group() string {
+	return oc.processor.name
+}
+
+func (oc *OpenAPICommand) getNameWithGroup(delim string) string {
+
+	name := oc.name
+	if !utils.IsEmpty(oc.processor.name) {
+		name = fmt.Sprintf("%s%s%s", oc.processor.name, delim, oc.name)
+	}
+	return name
+}
+
+func (oc *OpenAPICommand) Description() string {
+	return oc.description
+}
+
+func (oc *OpenAPICommand) Params() []string {
+	return oc.params
+}
+
+func (oc *OpenAPICommand) Aliases() []string {
+	return []string{}
+}
+
+func (oc *OpenAPICommand) Confirmation(params common.ExecuteParams) string {
+	return ""
+}
+
+func (oc *OpenAPICommand) Priority() int {
+	return 0
+}
+
+func (oc *OpenAPICommand) Wrapper() bool {
+	return false
+}
+
+func (oc *OpenAPICommand) Schedule() string {
+	return ""
+}
+
+func (oc *OpenAPICommand) Channel() string {
+	return ""
+}
+
+func (oc *OpenAPICommand) Response() common.Response {
+	return &OpenAPIExecutor{command: oc}
+}
+
+func (oc *OpenAPICommand) Actions() []common.Action {
+	return []common.Action{}
+}
+
+func (oc *OpenAPICommand) Approval() common.Approval {
+	return nil
+}
+
+func (oc *OpenAPICommand) Permissions() bool {
+	return true
+}
+
+func (oc *OpenAPICommand) Fields(bot common.Bot, message common.Message, params common.ExecuteParams, eval []string) []common.Field {
+	return oc.fields
+}
+
+// value converts param to schema type, bots pass most of values as strings
+func (oc *OpenAPICommand) value(schema *openapi3.Schema, v interface{}) (interface{}, error) {
+
+	s, ok := v.(string)
+	if !ok || schema == nil || schema.Type == nil {
+		return v, nil
+	}
+
+	switch {
+	case schema.Type.Is(openapi3.TypeInteger):
+		return strconv.ParseInt(s, 10, 64)
+	case schema.Type.Is(openapi3.TypeNumber):
+		return strconv.ParseFloat(s, 64)
+	case schema.Type.Is(openapi3.TypeBoolean):
+		return strconv.ParseBool(s)
+	case schema.Type.Is(openapi3.TypeArray):
+		items := []interface{}{}
+		for _, item := range common.RemoveEmptyStrings(strings.Split(s, ",")) {
+			var is *openapi3.Schema
+			if schema.Items != nil {
+				is = schema.Items.Value
+			}
+			iv, err := oc.value(is, strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, iv)
+		}
+		return items, nil
+	case schema.Type.Is(openapi3.TypeObject):
+		var m interface{}
+		err := json.Unmarshal([]byte(s), &m)
+		return m, err
+	}
+	return s, nil
+}
+
+func (oc *OpenAPICommand) toString(v interface{}) string {
+
+	switch t := v.(type) {
+	case []interface{}:
+		items := []string{}
+		for _, i := range t {
+			items = append(items, oc.toString(i))
+		}
+		return strings.Join(items, ",")
+	case []string:
+		return strings.Join(t, ",")
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func (oc *OpenAPICommand) request(params common.ExecuteParams) ([]byte, error) {
+
+	p := oc.processor
+
+	path := oc.path
+	query := url.Values{}
+	headers := map[string]string{}
+	body := map[string]interface{}{}
+
+	for _, param := range oc.parameters {
+
+		v, ok := params[param.name]
+		if !ok || v == nil || v == "" {
+			continue
+		}
+		tv, err := oc.value(param.schema, v)
+		if err != nil {
+			return nil, fmt.Errorf("OpenAPI command %s has invalid %s, error: %s", oc.name, param.name, err)
+		}
+
+		switch param.in {
+		case openapi3.ParameterInPath:
+			path = strings.ReplaceAll(path, fmt.Sprintf("{%s}", param.name), url.PathEscape(oc.toString(tv)))
+		case openapi3.ParameterInQuery:
+			query.Set(param.name, oc.toString(tv))
+		case openapi3.ParameterInHeader:
+			headers[param.name] = oc.toString(tv)
+		case OpenAPIBody:
+			body[strings.TrimPrefix(param.name, "body_")] = tv
+		}
+	}
+
+	u := fmt.Sprintf("%s%s", strings.TrimRight(oc.server, "/"), path)
+	if len(query) > 0 {
+		u = fmt.Sprintf("%s?%s", u, query.Encode())
+	}
+
+	var data []byte
+	if len(body) > 0 {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		data = b
+		headers["Content-Type"] = "application/json"
+	}
+	headers["Accept"] = "application/json"
+
+	if !utils.IsEmpty(p.options.Token) {
+		headers["Authorization"] = fmt.Sprintf("Bearer %s", p.options.Token)
+	}
+
+	client := utils.NewHttpClient(p.options.Timeout, p.options.Insecure)
+	b, code, err := utils.HttpRequestRawWithHeadersOutCode(client, oc.method, u, headers, data)
+	if err != nil {
+		return nil, fmt.Errorf("OpenAPI command %s %s %s returned %d, error: %s", oc.name, oc.method, u, code, err)
+	}
+	return b, nil
+}
+
+func (oc *OpenAPICommand) reply(body []byte, obj map[string]interface{}) (string, error) {
+
+	var v interface{}
+	err := json.Unmarshal(body, &v)
+	if err != nil {
+		v = string(body)
+	}
+
+	if oc.template != nil {
+		obj["response"] = v
+		return common.RenderTemplate(oc.template, "", obj)
+	}
+
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	var b bytes.Buffer
+	err = json.Indent(&b, body, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("```\n%s\n```", b.String()), nil
+}
+
+func (oc *OpenAPICommand) execute(bot common.Bot, message common.Message, params common.ExecuteParams) (string, error) {
+
+	t1 := time.Now()
+
+	processor := oc.processor
+
+	labels := make(map[string]string)
+	if !utils.IsEmpty(processor.name) {
+		labels["group"] = processor.name
+	}
+	labels["command"] = oc.name
+	labels["bot"] = bot.Name()
+
+	user := message.User()
+	if !utils.IsEmpty(user) {
+		labels["user_id"] = user.ID()
+	}
+
+	prefixes := []string{"openapi", "processor"}
+
+	requests := processor.meter.Counter("processor", "requests", "Count of all executions", labels, prefixes...)
+	requests.Inc()
+
+	errors := processor.meter.Counter("processor", "errors", "Count of all errors during executions", labels, prefixes...)
+	timeCounter := processor.meter.Counter("processor", "time", "Sum of all time executions", labels, prefixes...)
+
+	name := oc.getNameWithGroup("/")
+	oc.logger.Debug("OpenAPI is executing command %s with params %v...", name, params)
+
+	body, err := oc.request(params)
+	if err != nil {
+		errors.Inc()
+		return "", err
+	}
+
+	m := make(map[string]interface{})
+	m["params"] = params
+	m["bot"] = bot
+	m["message"] = message
+	m["user"] = user
+	m["caller"] = message.Caller()
+	m["channel"] = message.Channel()
+	m["name"] = name
+
+	text, err := oc.reply(body, m)
+	if err != nil {
+		errors.Inc()
+		return "", err
+	}
+
+	elapsed := time.Since(t1).Milliseconds()
+	timeCounter.Add(int(elapsed))
+
+	oc.logger.Debug("OpenAPI is executed command %s with params %v in %s", name, params, time.Since(t1))
+
+	return text, nil
+}
+
+func (oc *OpenAPICommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	executor := &OpenAPIExecutor{
+		command: oc,
+		message: message,
+	}
+
+	text, err := oc.execute(bot, message, params)
+	if err != nil {
+		oc.logger.Error(err)
+		err = fmt.Errorf("%s", oc.processor.options.Error)
+		return nil, "", nil, nil, err
+	}
+	return executor, text, nil, nil, nil
+}
+
+// OpenAPI
+
+func (o *OpenAPI) Name() string {
+	return o.name
+}
+
+func (o *OpenAPI) Commands() []common.Command {
+	return o.commands
+}
+
+func (o *OpenAPI) field(name, label string, schema *openapi3.Schema, required bool) common.Field {
+
+	f := common.Field{
+		Name:     name,
+		Type:     common.FieldTypeEdit,
+		Label:    label,
+		Required: required,
+	}
+	if schema == nil {
+		return f
+	}
+	f.Hint = schema.Description
+	if schema.Default != nil {
+		f.Default = fmt.Sprintf("%v", schema.Default)
+	}
+
+	enum := func(s *openapi3.Schema) []string {
+		r := []string{}
+		for _, e := range s.Enum {
+			r = append(r, fmt.Sprintf("%v", e))
+		}
+		return r
+	}
+
+	switch {
+	case len(schema.Enum) > 0:
+		f.Type = common.FieldTypeSelect
+		f.Values = enum(schema)
+	case schema.Type == nil:
+	case schema.Type.Is(openapi3.TypeInteger):
+		f.Type = common.FieldTypeInteger
+	case schema.Type.Is(openapi3.TypeNumber):
+		f.Type = common.FieldTypeFloat
+	case schema.Type.Is(openapi3.TypeBoolean):
+		f.Type = common.FieldTypeBool
+	case schema.Type.Is(openapi3.TypeArray):
+		if schema.Items != nil && schema.Items.Value != nil && len(schema.Items.Value.Enum) > 0 {
+			f.Type = common.FieldTypeMultiSelect
+			f.Values = enum(schema.Items.Value)
+		}
+	case schema.Type.Is(openapi3.TypeString):
+		switch schema.Format {
+		case "date":
+			f.Type = common.FieldTypeDate
+		case "uri", "url":
+			f.Type = common.FieldTypeURL
+		}
+	case schema.Type.Is(openapi3.TypeObject):
+		f.Type = common.FieldTypeMultiEdit
+	}
+	return f
+}
+
+func (o *OpenAPI) template(tag, operationID string) (*toolsRender.TextTemplate, error) {
+
+	if utils.IsEmpty(o.options.TemplatesDir) {
+		return nil, nil
+	}
+
+	file := fmt.Sprintf("%s.tpl", operationID)
+	for _, path := range []string{filepath.Join(o.options.TemplatesDir, tag, file), filepath.Join(o.options.TemplatesDir, file)} {
+		if !utils.FileExists(path) {
+			continue
+		}
+		content, err := utils.Content(path)
+		if err != nil {
+			return nil, err
+		}
+		tOpts := toolsRender.TemplateOptions{
+			Name:    fmt.Sprintf("openapi-template-%s-%s", tag, operationID),
+			Content: string(content),
+		}
+		return toolsRender.NewTextTemplate(tOpts, o.observability)
+	}
+	return nil, nil
+}
+
+// AddOperation registers operation as command, path params become positional command params
+func (o *OpenAPI) AddOperation(server, method, path string, operation *openapi3.Operation) error {
+
+	logger := o.observability.Logs()
+
+	oc := &OpenAPICommand{
+		name:        operation.OperationID,
+		description: operation.Summary,
+		method:      strings.ToUpper(method),
+		path:        path,
+		server:      server,
+		params:      []string{},
+		processor:   o,
+		logger:      logger,
+	}
+	if utils.IsEmpty(oc.description) {
+		oc.description = operation.Description
+	}
+
+	positional := []string{}
+	for _, ref := range operation.Parameters {
+
+		p := ref.Value
+		if p == nil || p.In == openapi3.ParameterInCookie {
+			continue
+		}
+		var schema *openapi3.Schema
+		if p.Schema != nil {
+			schema = p.Schema.Value
+		}
+		label := p.Name
+		if !utils.IsEmpty(p.Description) {
+			label = p.Description
+		}
+
+		oc.fields = append(oc.fields, o.field(p.Name, label, schema, p.Required))
+		oc.parameters = append(oc.parameters, &OpenAPIParameter{name: p.Name, in: p.In, schema: schema})
+
+		if p.In == openapi3.ParameterInPath {
+			positional = append(positional, fmt.Sprintf("(?P<%s>\\S+)", p.Name))
+		}
+	}
+	if len(positional) > 0 {
+		oc.params = append(oc.params, strings.Join(positional, "\\s+"))
+	}
+
+	if operation.RequestBody != nil && operation.RequestBody.Value != nil {
+
+		media := operation.RequestBody.Value.Content.Get("application/json")
+		if media != nil && media.Schema != nil && media.Schema.Value != nil {
+
+			schema := media.Schema.Value
+			names := []string{}
+			for name := range schema.Properties {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				prop := schema.Properties[name].Value
+				if prop == nil || prop.ReadOnly {
+					continue
+				}
+				// body properties must not hide path or query params
+				fname := name
+				for _, p := range oc.parameters {
+					if p.name == name {
+						fname = fmt.Sprintf("body_%s", name)
+					}
+				}
+				required := utils.Contains(schema.Required, name)
+				oc.fields = append(oc.fields, o.field(fname, name, prop, required))
+				oc.parameters = append(oc.parameters, &OpenAPIParameter{name: fname, in: OpenAPIBody, schema: prop})
+			}
+		}
+	}
+
+	tag := ""
+	if len(operation.Tags) > 0 {
+		tag = operation.Tags[0]
+	}
+	t, err := o.template(tag, operation.OperationID)
+	if err != nil {
+		logger.Error("OpenAPI couldn't read template for %s, error: %s", operation.OperationID, err)
+		return err
+	}
+	oc.template = t
+
+	o.commands = append(o.commands, oc)
+	return nil
+}
+
+func NewOpenAPI(name string, options OpenAPIOptions, observability *common.Observability, processors *common.Processors) *OpenAPI {
+
+	return &OpenAPI{
+		name:          name,
+		options:       options,
+		processors:    processors,
+		meter:         observability.Metrics(),
+		observability: observability,
+	}
+}
+
+func loadOpenAPISpec(location string) (*openapi3.T, *url.URL, error) {
+
+	loader := openapi3.NewLoader()
+	loader.IsExternalRefsAllowed = true
+
+	u, err := url.Parse(location)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		doc, err := loader.LoadFromURI(u)
+		return doc, u, err
+	}
+	doc, err := loader.LoadFromFile(location)
+	return doc, nil, err
+}
+
+// openAPIServer resolves relative server against spec url
+func openAPIServer(options OpenAPIOptions, doc *openapi3.T, base *url.URL) string {
+
+	if !utils.IsEmpty(options.Server) {
+		return options.Server
+	}
+	if len(doc.Servers) == 0 {
+		if base != nil {
+			return fmt.Sprintf("%s://%s", base.Scheme, base.Host)
+		}
+		return ""
+	}
+
+	server := doc.Servers[0].URL
+	for k, v := range doc.Servers[0].Variables {
+		server = strings.ReplaceAll(server, fmt.Sprintf("{%s}", k), v.Default)
+	}
+	if base != nil {
+		ref, err := url.Parse(server)
+		if err == nil {
+			server = base.ResolveReference(ref).String()
+		}
+	}
+	return server
+}
+
+// NewOpenAPIs makes processor per tag of all specs, untagged operations are skipped
+func NewOpenAPIs(options OpenAPIOptions, observability *common.Observability, processors *common.Processors) ([]*OpenAPI, error) {
+
+	logger := observability.Logs()
+
+	groups := make(map[string]*OpenAPI)
+	names := []string{}
+
+	for _, spec := range common.RemoveEmptyStrings(strings.Split(options.Specs, ",")) {
+
+		spec = strings.TrimSpace(spec)
+		doc, base, err := loadOpenAPISpec(spec)
+		if err != nil {
+			logger.Error("OpenAPI couldn't load spec %s, error: %s", spec, err)
+			return nil, err
+		}
+
+		server := openAPIServer(options, doc, base)
+		if utils.IsEmpty(server) {
+			err = fmt.Errorf("OpenAPI spec %s has no server", spec)
+			logger.Error(err)
+			return nil, err
+		}
+
+		for _, path := range doc.Paths.InMatchingOrder() {
+
+			item := doc.Paths.Value(path)
+			methods := []string{}
+			for method := range item.Operations() {
+				methods = append(methods, method)
+			}
+			sort.Strings(methods)
+
+			for _, method := range methods {
+
+				operation := item.GetOperation(method)
+				if len(operation.Tags) == 0 || utils.IsEmpty(operation.OperationID) {
+					logger.Debug("OpenAPI skipped %s %s without tag or operationId", method, path)
+					continue
+				}
+
+				// path level params are shared by operations
+				op := *operation
+				op.Parameters = openapi3.Parameters{}
+				for _, p := range item.Parameters {
+					if p.Value != nil && operation.Parameters.GetByInAndName(p.Value.In, p.Value.Name) != nil {
+						continue
+					}
+					op.Parameters = append(op.Parameters, p)
+				}
+				op.Parameters = append(op.Parameters, operation.Parameters...)
+
+				tag := operation.Tags[0]
+				o, ok := groups[tag]
+				if !ok {
+					o = NewOpenAPI(tag, options, observability, processors)
+					groups[tag] = o
+					names = append(names, tag)
+				}
+
+				err = o.AddOperation(server, method, path, &op)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	r := []*OpenAPI{}
+	for _, name := range names {
+		r = append(r, groups[name])
+	}
+	return r, nil
+}
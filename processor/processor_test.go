@@ -0,0 +1,150 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+)
+
+type testUser struct {
+	id       string
+	commands []string
+}
+
+type testChannel struct {
+	id string
+}
+
+type testMessage struct {
+	id      string
+	user    *testUser
+	channel *testChannel
+}
+
+type testBot struct{}
+
+func (u *testUser) ID() string {
+	return u.id
+}
+
+func (u *testUser) Name() string {
+	return u.id
+}
+
+func (u *testUser) TimeZone() string {
+	return ""
+}
+
+func (u *testUser) Commands() []string {
+	return u.commands
+}
+
+func (c *testChannel) ID() string {
+	return c.id
+}
+
+func (m *testMessage) ID() string {
+	return m.id
+}
+
+func (m *testMessage) Visible() bool {
+	return true
+}
+
+func (m *testMessage) User() common.User {
+	return m.user
+}
+
+func (m *testMessage) Caller() common.User {
+	return m.user
+}
+
+func (m *testMessage) Channel() common.Channel {
+	return m.channel
+}
+
+func (m *testMessage) ParentID() string {
+	return ""
+}
+
+func (m *testMessage) SetParentID(threadTS string) {
+}
+
+func (b *testBot) Start(wg *sync.WaitGroup) {
+}
+
+func (b *testBot) Name() string {
+	return "test"
+}
+
+func (b *testBot) Command(channel, text string, user common.User, parent common.Message, response common.Response) error {
+	return nil
+}
+
+func (b *testBot) AddReaction(channel, ID, name string) error {
+	return nil
+}
+
+func (b *testBot) RemoveReaction(channel, ID, name string) error {
+	return nil
+}
+
+func (b *testBot) AddAction(channel, ID string, action common.Action) error {
+	return nil
+}
+
+func (b *testBot) AddActions(channel, ID string, actions []common.Action) error {
+	return nil
+}
+
+func (b *testBot) RemoveAction(channel, ID, name string) error {
+	return nil
+}
+
+func (b *testBot) ClearActions(channel, ID string) error {
+	return nil
+}
+
+func (b *testBot) PostMessage(channel string, message string, attachments []*common.Attachment, actions []common.Action, user common.User, parent common.Message, response common.Response) (string, error) {
+	return "", nil
+}
+
+func (b *testBot) DeleteMessage(channel, ID string) error {
+	return nil
+}
+
+func (b *testBot) ReadMessage(channel, ID string) (string, error) {
+	return "", nil
+}
+
+func (b *testBot) UpdateMessage(channel, ID, message string) error {
+	return nil
+}
+
+func testObservability() *common.Observability {
+	return common.NewObservability(sreCommon.NewLogs(), sreCommon.NewMetrics())
+}
+
+func testMessageFrom(userID string) *testMessage {
+	return &testMessage{
+		id:      "1",
+		user:    &testUser{id: userID},
+		channel: &testChannel{id: "test"},
+	}
+}
+
+// testFile writes content into temporary dir and returns its path
+func testFile(t *testing.T, name, content string) string {
+
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
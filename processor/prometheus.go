@@ -0,0 +1,756 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	toolsRender "github.com/devopsext/tools/render"
+	"github.com/devopsext/utils"
+	"github.com/wcharczuk/go-chart/v2"
+	"gopkg.in/yaml.v2"
+)
+
+type PrometheusOptions struct {
+	CommandsDir string
+	ConfigExt   string
+	URLs        string // name=url list, the first one is default
+	Timeout     int
+	Insecure    bool
+	Width       int
+	Height      int
+	Error       string
+}
+
+type PrometheusQuery struct {
+	Endpoint string
+	Query    string
+	Type     string // instant, range
+	Time     string
+	Range    string
+	Step     string
+	Legend   string // {{label}} placeholders as in Grafana
+	Output   string // table, chart
+	Title    string
+}
+
+type PrometheusCommandConfig struct {
+	Description string
+	Params      []string
+	Aliases     []string
+	Response    DefaultReposne
+	Fields      []common.Field
+	Priority    int
+	Channel     string
+	Permissions *bool
+	Query       PrometheusQuery
+}
+
+type PrometheusSample struct {
+	Time  time.Time
+	Value float64
+}
+
+type PrometheusSeries struct {
+	Metric  map[string]string
+	Samples []PrometheusSample
+}
+
+type PrometheusExecutor struct {
+	command *PrometheusCommand
+	message common.Message
+}
+
+type PrometheusCommand struct {
+	name      string
+	path      string
+	config    *PrometheusCommandConfig
+	processor *Prometheus
+	logger    sreCommon.Logger
+}
+
+type Prometheus struct {
+	name          string
+	options       PrometheusOptions
+	endpoints     map[string]string
+	endpoint      string
+	processors    *common.Processors
+	commands      []common.Command
+	meter         sreCommon.Meter
+	observability *common.Observability
+}
+
+type prometheusResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+type prometheusResult struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+	Values [][]interface{}   `json:"values"`
+}
+
+const (
+	PrometheusQueryInstant = "instant"
+	PrometheusQueryRange   = "range"
+)
+
+const (
+	PrometheusOutputTable = "table"
+	PrometheusOutputChart = "chart"
+)
+
+const (
+	prometheusDefaultRange = time.Hour
+	prometheusMaxPoints    = 250
+)
+
+var prometheusLegend = regexp.MustCompile(`\{\{\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\}\}`)
+
+// Prometheus executor
+// common.Response
+
+func (pe *PrometheusExecutor) Visible() bool {
+	if !utils.IsEmpty(pe.message) {
+		return pe.message.Visible()
+	}
+	return false
+}
+
+func (pe *PrometheusExecutor) Error() bool {
+	return false
+}
+
+func (pe *PrometheusExecutor) Duration() bool {
+	d := pe.command.config.Response.Duration
+	if d != nil {
+		return *d
+	}
+	return false
+}
+
+func (pe *PrometheusExecutor) Original() bool {
+	o := pe.command.config.Response.Original
+	if o != nil {
+		return *o
+	}
+	return false
+}
+
+func (pe *PrometheusExecutor) Response() common.Response {
+	return pe
+}
+
+func (pe *PrometheusExecutor) After(message common.Message) error {
+	return nil
+}
+
+// Prometheus command
+
+func (pc *PrometheusCommand) Name() string {
+	return pc.name
+}
+
+func (pc *PrometheusCommand) Path() string {
+	return pc.path
+}
+
+func (pc *PrometheusCommand) Group() string {
+	return pc.processor.name
+}
+
+func (pc *PrometheusCommand) getNameWithGroup(delim string) string {
+
+	name := pc.name
+	if !utils.IsEmpty(pc.processor.name) {
+		name = fmt.Sprintf("%s%s%s", pc.processor.name, delim, pc.name)
+	}
+	return name
+}
+
+func (pc *PrometheusCommand) Description() string {
+	return pc.config.Description
+}
+
+func (pc *PrometheusCommand) Params() []string {
+
+	params := pc.config.Params
+	if utils.IsEmpty(params) {
+		return defaultParams()
+	}
+	return params
+}
+
+func (pc *PrometheusCommand) Aliases() []string {
+	return pc.config.Aliases
+}
+
+func (pc *PrometheusCommand) Confirmation(params common.ExecuteParams) string {
+	return ""
+}
+
+func (pc *PrometheusCommand) Priority() int {
+	return pc.config.Priority
+}
+
+func (pc *PrometheusCommand) Wrapper() bool {
+	return false
+}
+
+func (pc *PrometheusCommand) Schedule() string {
+	return ""
+}
+
+func (pc *PrometheusCommand) Channel() string {
+	return pc.config.Channel
+}
+
+func (pc *PrometheusCommand) Response() common.Response {
+	return &PrometheusExecutor{command: pc}
+}
+
+func (pc *PrometheusCommand) Actions() []common.Action {
+	return []common.Action{}
+}
+
+func (pc *PrometheusCommand) Approval() common.Approval {
+	return nil
+}
+
+func (pc *PrometheusCommand) Permissions() bool {
+	if pc.config.Permissions != nil {
+		return *pc.config.Permissions
+	}
+	return true
+}
+
+func (pc *PrometheusCommand) Fields(bot common.Bot, message common.Message, params common.ExecuteParams, eval []string) []common.Field {
+	return pc.config.Fields
+}
+
+func (pc *PrometheusCommand) render(name, content string, obj interface{}) (string, error) {
+
+	if !strings.Contains(content, "{{") {
+		return content, nil
+	}
+
+	tOpts := toolsRender.TemplateOptions{
+		Name:    fmt.Sprintf("prometheus-internal-%s-%s", pc.getNameWithGroup("-"), name),
+		Content: content,
+	}
+	t, err := toolsRender.NewTextTemplate(tOpts, pc.processor.observability)
+	if err != nil {
+		return "", err
+	}
+	s, err := common.RenderTemplate(t, "", obj)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// duration also understands days and weeks as Prometheus does
+func (pc *PrometheusCommand) duration(s string, def time.Duration) (time.Duration, error) {
+
+	if utils.IsEmpty(s) {
+		return def, nil
+	}
+
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil {
+				return 0, fmt.Errorf("Prometheus command %s has invalid duration %s", pc.name, s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("Prometheus command %s has invalid duration %s", pc.name, s)
+	}
+	return d, nil
+}
+
+func (pc *PrometheusCommand) parseSample(v []interface{}) (PrometheusSample, error) {
+
+	if len(v) != 2 {
+		return PrometheusSample{}, fmt.Errorf("invalid sample %v", v)
+	}
+	ts, ok := v[0].(float64)
+	if !ok {
+		return PrometheusSample{}, fmt.Errorf("invalid sample time %v", v[0])
+	}
+	f, err := strconv.ParseFloat(fmt.Sprintf("%v", v[1]), 64)
+	if err != nil {
+		return PrometheusSample{}, err
+	}
+	sec, dec := math.Modf(ts)
+	return PrometheusSample{Time: time.Unix(int64(sec), int64(dec*1e9)), Value: f}, nil
+}
+
+func (pc *PrometheusCommand) parse(body []byte) ([]PrometheusSeries, error) {
+
+	var r prometheusResponse
+	err := json.Unmarshal(body, &r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != "success" {
+		return nil, fmt.Errorf("Prometheus command %s query failed, %s: %s", pc.name, r.ErrorType, r.Error)
+	}
+
+	series := []PrometheusSeries{}
+
+	switch r.Data.ResultType {
+	case "scalar", "string":
+		var v []interface{}
+		err = json.Unmarshal(r.Data.Result, &v)
+		if err != nil {
+			return nil, err
+		}
+		s, err := pc.parseSample(v)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, PrometheusSeries{Metric: map[string]string{}, Samples: []PrometheusSample{s}})
+	case "vector", "matrix":
+		var results []prometheusResult
+		err = json.Unmarshal(r.Data.Result, &results)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range results {
+			item := PrometheusSeries{Metric: res.Metric}
+			values := res.Values
+			if res.Value != nil {
+				values = [][]interface{}{res.Value}
+			}
+			for _, v := range values {
+				s, err := pc.parseSample(v)
+				if err != nil {
+					return nil, err
+				}
+				item.Samples = append(item.Samples, s)
+			}
+			series = append(series, item)
+		}
+	default:
+		return nil, fmt.Errorf("Prometheus command %s has unknown result type %s", pc.name, r.Data.ResultType)
+	}
+	return series, nil
+}
+
+func (pc *PrometheusCommand) legend(s PrometheusSeries) string {
+
+	legend := pc.config.Query.Legend
+	if !utils.IsEmpty(legend) {
+		return prometheusLegend.ReplaceAllStringFunc(legend, func(m string) string {
+			return s.Metric[prometheusLegend.FindStringSubmatch(m)[1]]
+		})
+	}
+
+	name := s.Metric["__name__"]
+	keys := []string{}
+	for k := range s.Metric {
+		if k != "__name__" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return name
+	}
+	sort.Strings(keys)
+
+	labels := []string{}
+	for _, k := range keys {
+		labels = append(labels, fmt.Sprintf("%s=%q", k, s.Metric[k]))
+	}
+	return fmt.Sprintf("%s{%s}", name, strings.Join(labels, ", "))
+}
+
+func (pc *PrometheusCommand) format(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func (pc *PrometheusCommand) table(series []PrometheusSeries, ranged bool) string {
+
+	if len(series) == 0 {
+		return "No data"
+	}
+
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	if ranged {
+		fmt.Fprintln(w, "SERIES\tMIN\tMAX\tLAST")
+	} else {
+		fmt.Fprintln(w, "SERIES\tVALUE")
+	}
+
+	for _, s := range series {
+		if len(s.Samples) == 0 {
+			continue
+		}
+		last := s.Samples[len(s.Samples)-1].Value
+		if !ranged {
+			fmt.Fprintf(w, "%s\t%s\n", pc.legend(s), pc.format(last))
+			continue
+		}
+		min, max := math.Inf(1), math.Inf(-1)
+		for _, v := range s.Samples {
+			min = math.Min(min, v.Value)
+			max = math.Max(max, v.Value)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", pc.legend(s), pc.format(min), pc.format(max), pc.format(last))
+	}
+	w.Flush()
+	return fmt.Sprintf("```\n%s```", b.String())
+}
+
+func (pc *PrometheusCommand) chart(title string, series []PrometheusSeries) ([]byte, error) {
+
+	options := pc.processor.options
+	graph := chart.Chart{
+		Title:  title,
+		Width:  options.Width,
+		Height: options.Height,
+		Background: chart.Style{
+			Padding: chart.Box{Top: 40, Left: 10, Right: 10, Bottom: 10},
+		},
+		XAxis: chart.XAxis{
+			ValueFormatter: chart.TimeMinuteValueFormatter,
+		},
+	}
+
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		ts := chart.TimeSeries{Name: pc.legend(s)}
+		for _, v := range s.Samples {
+			if math.IsNaN(v.Value) || math.IsInf(v.Value, 0) {
+				continue
+			}
+			ts.XValues = append(ts.XValues, v.Time)
+			ts.YValues = append(ts.YValues, v.Value)
+			min = math.Min(min, v.Value)
+			max = math.Max(max, v.Value)
+		}
+		if len(ts.XValues) > 1 {
+			graph.Series = append(graph.Series, ts)
+		}
+	}
+	if len(graph.Series) == 0 {
+		return nil, nil
+	}
+
+	// flat lines have no range to draw
+	if min == max {
+		graph.YAxis.Range = &chart.ContinuousRange{Min: min - 1, Max: max + 1}
+	}
+	graph.Elements = []chart.Renderable{chart.LegendLeft(&graph)}
+
+	var b bytes.Buffer
+	err := graph.Render(chart.PNG, &b)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (pc *PrometheusCommand) query(obj interface{}) ([]PrometheusSeries, bool, error) {
+
+	q := pc.config.Query
+	p := pc.processor
+
+	endpoint, err := pc.render("endpoint", q.Endpoint, obj)
+	if err != nil {
+		return nil, false, err
+	}
+	if utils.IsEmpty(endpoint) {
+		endpoint = p.endpoint
+	}
+	base, ok := p.endpoints[endpoint]
+	if !ok {
+		return nil, false, fmt.Errorf("Prometheus command %s has unknown endpoint %s", pc.name, endpoint)
+	}
+
+	expr, err := pc.render("query", q.Query, obj)
+	if err != nil {
+		return nil, false, err
+	}
+
+	values := url.Values{}
+	values.Add("query", expr)
+
+	now := time.Now()
+	t, err := pc.render("time", q.Time, obj)
+	if err != nil {
+		return nil, false, err
+	}
+	offset, err := pc.duration(t, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	now = now.Add(-offset)
+
+	path := "/api/v1/query"
+	ranged := strings.ToLower(q.Type) == PrometheusQueryRange
+	if ranged {
+		r, err := pc.render("range", q.Range, obj)
+		if err != nil {
+			return nil, false, err
+		}
+		period, err := pc.duration(r, prometheusDefaultRange)
+		if err != nil {
+			return nil, false, err
+		}
+
+		s, err := pc.render("step", q.Step, obj)
+		if err != nil {
+			return nil, false, err
+		}
+		step, err := pc.duration(s, period/prometheusMaxPoints)
+		if err != nil {
+			return nil, false, err
+		}
+		if step < time.Second {
+			step = time.Second
+		}
+
+		path = "/api/v1/query_range"
+		values.Add("start", strconv.FormatInt(now.Add(-period).Unix(), 10))
+		values.Add("end", strconv.FormatInt(now.Unix(), 10))
+		values.Add("step", strconv.FormatInt(int64(step.Seconds()), 10))
+	} else {
+		values.Add("time", strconv.FormatInt(now.Unix(), 10))
+	}
+
+	u := fmt.Sprintf("%s%s?%s", strings.TrimRight(base, "/"), path, values.Encode())
+
+	client := utils.NewHttpClient(p.options.Timeout, p.options.Insecure)
+	b, code, err := utils.HttpRequestRawWithHeadersOutCode(client, "GET", u, nil, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("Prometheus command %s query %s returned %d, error: %s", pc.name, u, code, err)
+	}
+
+	series, err := pc.parse(b)
+	if err != nil {
+		return nil, false, err
+	}
+	return series, ranged, nil
+}
+
+func (pc *PrometheusCommand) execute(bot common.Bot, message common.Message, params common.ExecuteParams) (string, []*common.Attachment, error) {
+
+	t1 := time.Now()
+
+	processor := pc.processor
+
+	labels := make(map[string]string)
+	if !utils.IsEmpty(processor.name) {
+		labels["group"] = processor.name
+	}
+	labels["command"] = pc.name
+	labels["bot"] = bot.Name()
+
+	user := message.User()
+	if !utils.IsEmpty(user) {
+		labels["user_id"] = user.ID()
+	}
+
+	prefixes := []string{"prometheus", "processor"}
+
+	requests := processor.meter.Counter("processor", "requests", "Count of all executions", labels, prefixes...)
+	requests.Inc()
+
+	errors := processor.meter.Counter("processor", "errors", "Count of all errors during executions", labels, prefixes...)
+	timeCounter := processor.meter.Counter("processor", "time", "Sum of all time executions", labels, prefixes...)
+
+	name := pc.getNameWithGroup("/")
+
+	m := make(map[string]interface{})
+	m["params"] = params
+	m["bot"] = bot
+	m["message"] = message
+	m["user"] = user
+	m["caller"] = message.Caller()
+	m["channel"] = message.Channel()
+	m["name"] = name
+
+	pc.logger.Debug("Prometheus is executing command %s with params %v...", name, params)
+
+	series, ranged, err := pc.query(m)
+	if err != nil {
+		errors.Inc()
+		return "", nil, err
+	}
+
+	output := strings.ToLower(pc.config.Query.Output)
+	if utils.IsEmpty(output) {
+		output = PrometheusOutputTable
+		if ranged {
+			output = PrometheusOutputChart
+		}
+	}
+
+	text := ""
+	var atts []*common.Attachment
+
+	switch output {
+	case PrometheusOutputChart:
+		title, err := pc.render("title", pc.config.Query.Title, m)
+		if err != nil {
+			errors.Inc()
+			return "", nil, err
+		}
+		if utils.IsEmpty(title) {
+			title = name
+		}
+		data, err := pc.chart(title, series)
+		if err != nil {
+			errors.Inc()
+			return "", nil, err
+		}
+		if data == nil {
+			text = "No data"
+			break
+		}
+		atts = append(atts, &common.Attachment{
+			Title: title,
+			Data:  data,
+			Type:  common.AttachmentTypeImage,
+		})
+	case PrometheusOutputTable:
+		text = pc.table(series, ranged)
+	default:
+		errors.Inc()
+		return "", nil, fmt.Errorf("Prometheus command %s has unknown output %s", pc.name, output)
+	}
+
+	elapsed := time.Since(t1).Milliseconds()
+	timeCounter.Add(int(elapsed))
+
+	pc.logger.Debug("Prometheus is executed command %s with params %v in %s", name, params, time.Since(t1))
+
+	return text, atts, nil
+}
+
+func (pc *PrometheusCommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	executor := &PrometheusExecutor{
+		command: pc,
+		message: message,
+	}
+
+	text, atts, err := pc.execute(bot, message, params)
+	if err != nil {
+		pc.logger.Error(err)
+		err = fmt.Errorf("%s", pc.processor.options.Error)
+		return nil, "", nil, nil, err
+	}
+	return executor, text, atts, nil, nil
+}
+
+// Prometheus
+
+func (p *Prometheus) Name() string {
+	return p.name
+}
+
+func (p *Prometheus) Commands() []common.Command {
+	return p.commands
+}
+
+func (p *Prometheus) loadConfig(path string) (*PrometheusCommandConfig, error) {
+
+	bytes, err := utils.Content(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v PrometheusCommandConfig
+	err = yaml.Unmarshal(bytes, &v)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (p *Prometheus) AddCommand(name, path string) error {
+
+	logger := p.observability.Logs()
+
+	config, err := p.loadConfig(path)
+	if err != nil {
+		logger.Error("Prometheus couldn't read config %s, error: %s", path, err)
+		return err
+	}
+
+	if utils.IsEmpty(config.Query.Query) {
+		err = fmt.Errorf("Prometheus config %s has no query", path)
+		logger.Error(err)
+		return err
+	}
+
+	pc := &PrometheusCommand{
+		name:      name,
+		path:      path,
+		config:    config,
+		processor: p,
+		logger:    logger,
+	}
+	p.commands = append(p.commands, pc)
+	return nil
+}
+
+// endpoints are name=url pairs, url without name is called by its position
+func prometheusEndpoints(urls string) (map[string]string, string) {
+
+	endpoints := make(map[string]string)
+	first := ""
+
+	for i, item := range common.RemoveEmptyStrings(strings.Split(urls, ",")) {
+		name := strconv.Itoa(i)
+		u := strings.TrimSpace(item)
+		if k, v, ok := strings.Cut(u, "="); ok && !strings.Contains(k, "/") {
+			name = strings.TrimSpace(k)
+			u = strings.TrimSpace(v)
+		}
+		endpoints[name] = u
+		if utils.IsEmpty(first) {
+			first = name
+		}
+	}
+	return endpoints, first
+}
+
+func NewPrometheus(name string, options PrometheusOptions, observability *common.Observability, processors *common.Processors) *Prometheus {
+
+	endpoints, endpoint := prometheusEndpoints(options.URLs)
+
+	return &Prometheus{
+		name:          name,
+		options:       options,
+		endpoints:     endpoints,
+		endpoint:      endpoint,
+		processors:    processors,
+		meter:         observability.Metrics(),
+		observability: observability,
+	}
+}
@@ -0,0 +1,192 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+)
+
+// testPrometheusServer answers query and query_range with body and records last request
+type testPrometheusServer struct {
+	*httptest.Server
+	mutex sync.Mutex
+	path  string
+	query url.Values
+}
+
+func (s *testPrometheusServer) last() (string, url.Values) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.path, s.query
+}
+
+func newTestPrometheusServer(t *testing.T, body string) *testPrometheusServer {
+
+	s := &testPrometheusServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.path = r.URL.Path
+		s.query = r.URL.Query()
+		s.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func testPrometheusCommand(t *testing.T, urls, config string) common.Command {
+
+	t.Helper()
+	p := NewPrometheus("metrics", PrometheusOptions{
+		URLs:    urls,
+		Timeout: 5,
+		Width:   640,
+		Height:  320,
+		Error:   "Prometheus failed",
+	}, testObservability(), common.NewProcessors())
+
+	err := p.AddCommand("cpu", testFile(t, "cpu.yml", config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Commands()[0]
+}
+
+func testPrometheusMatrix(start int64) string {
+	return fmt.Sprintf(`{"status":"success","data":{"resultType":"matrix","result":[
+{"metric":{"__name__":"cpu","instance":"a"},"values":[[%d,"1"],[%d,"3"],[%d,"2"]]},
+{"metric":{"__name__":"cpu","instance":"b"},"values":[[%d,"5"],[%d,"4"]]}]}}`,
+		start, start+60, start+120, start, start+60)
+}
+
+func TestPrometheusRangeTable(t *testing.T) {
+
+	s := newTestPrometheusServer(t, testPrometheusMatrix(time.Now().Unix()-120))
+	c := testPrometheusCommand(t, s.URL, `
+query:
+  query: rate(cpu[5m])
+  type: range
+  range: 2h
+  step: 1m
+  legend: "{{ instance }}"
+  output: table
+`)
+
+	_, text, atts, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atts) != 0 {
+		t.Fatalf("expected no attachments, got %d", len(atts))
+	}
+
+	path, query := s.last()
+	if path != "/api/v1/query_range" {
+		t.Fatalf("expected query_range, got %s", path)
+	}
+	if query.Get("query") != "rate(cpu[5m])" || query.Get("step") != "60" {
+		t.Fatalf("unexpected query %v", query)
+	}
+	start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(query.Get("end"), 10, 64)
+	if end-start != int64((2 * time.Hour).Seconds()) {
+		t.Fatalf("expected 2h range, got %ds", end-start)
+	}
+
+	lines := strings.Split(text, "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[1], "SERIES") {
+		t.Fatalf("unexpected table: %s", text)
+	}
+	if strings.Join(strings.Fields(lines[2]), " ") != "a 1 3 2" || strings.Join(strings.Fields(lines[3]), " ") != "b 4 5 4" {
+		t.Fatalf("unexpected rows: %s", text)
+	}
+}
+
+func TestPrometheusRangeChart(t *testing.T) {
+
+	s := newTestPrometheusServer(t, testPrometheusMatrix(time.Now().Unix()-120))
+	c := testPrometheusCommand(t, s.URL, `
+query:
+  query: cpu
+  type: range
+  range: 10m
+  title: CPU
+`)
+
+	_, text, atts, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "" || len(atts) != 1 {
+		t.Fatalf("expected chart only, got %q and %d attachments", text, len(atts))
+	}
+	if atts[0].Type != common.AttachmentTypeImage || atts[0].Title != "CPU" {
+		t.Fatalf("unexpected attachment %s %s", atts[0].Type, atts[0].Title)
+	}
+	if !bytes.HasPrefix(atts[0].Data, []byte("\x89PNG")) {
+		t.Fatal("expected PNG chart")
+	}
+
+	// default step keeps points under the limit
+	_, query := s.last()
+	if query.Get("step") != strconv.Itoa(int((10 * time.Minute / prometheusMaxPoints).Seconds())) {
+		t.Fatalf("unexpected step %s", query.Get("step"))
+	}
+}
+
+func TestPrometheusInstant(t *testing.T) {
+
+	s := newTestPrometheusServer(t, `{"status":"success","data":{"resultType":"vector","result":[
+{"metric":{"__name__":"up","job":"api"},"value":[1700000000,"1"]}]}}`)
+	c := testPrometheusCommand(t, fmt.Sprintf("main=%s", s.URL), `
+query:
+  endpoint: main
+  query: up
+`)
+
+	_, text, _, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, query := s.last()
+	if path != "/api/v1/query" || query.Get("time") == "" {
+		t.Fatalf("unexpected request %s %v", path, query)
+	}
+	if !strings.Contains(text, `up{job="api"}  1`) {
+		t.Fatalf("unexpected table: %s", text)
+	}
+}
+
+func TestPrometheusError(t *testing.T) {
+
+	s := newTestPrometheusServer(t, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+	c := testPrometheusCommand(t, s.URL, `
+query:
+  query: up{
+`)
+
+	_, _, _, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{}, nil)
+	if err == nil || err.Error() != "Prometheus failed" {
+		t.Fatalf("expected processor error, got %v", err)
+	}
+}
+
+func TestPrometheusEndpoints(t *testing.T) {
+
+	endpoints, first := prometheusEndpoints("http://a:9090, b=http://b:9090/")
+	if first != "0" || endpoints["0"] != "http://a:9090" || endpoints["b"] != "http://b:9090/" {
+		t.Fatalf("unexpected endpoints %v, first %s", endpoints, first)
+	}
+}
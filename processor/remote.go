@@ -0,0 +1,696 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+)
+
+// Remote processor routes commands to external services over HTTP+JSON.
+//
+// Each service exposes three endpoints relative to its base url:
+//
+//	GET  /commands  => {"commands": [RemoteCommandConfig...]}
+//	POST /execute   <= RemoteRequest   => RemoteReply
+//	POST /fields    <= RemoteRequest   => {"fields": [common.Field...]}
+//
+// If a token is configured it's sent as "Authorization: Bearer <token>".
+// Non 2xx responses are treated as errors. Catalog is fetched at startup and
+// every refresh interval, so services can add or change commands on the fly.
+type RemoteOptions struct {
+	URLs     string
+	Token    string
+	Timeout  int
+	Insecure bool
+	Refresh  int
+	Error    string
+}
+
+type RemoteResponse struct {
+	Visible  *bool `json:"visible,omitempty"`
+	Duration *bool `json:"duration,omitempty"`
+	Original *bool `json:"original,omitempty"`
+}
+
+type RemoteAction struct {
+	Name     string `json:"name"`
+	Label    string `json:"label,omitempty"`
+	Template string `json:"template,omitempty"`
+	Style    string `json:"style,omitempty"`
+}
+
+type RemoteApproval struct {
+	Channel     string   `json:"channel,omitempty"`
+	Template    string   `json:"template,omitempty"`
+	Reasons     []string `json:"reasons,omitempty"`
+	Description bool     `json:"description,omitempty"`
+	Visible     bool     `json:"visible,omitempty"`
+}
+
+type RemoteCommandConfig struct {
+	Name         string          `json:"name"`
+	Group        string          `json:"group,omitempty"`
+	Description  string          `json:"description,omitempty"`
+	Params       []string        `json:"params,omitempty"`
+	Aliases      []string        `json:"aliases,omitempty"`
+	Fields       []common.Field  `json:"fields,omitempty"`
+	Actions      []RemoteAction  `json:"actions,omitempty"`
+	Approval     *RemoteApproval `json:"approval,omitempty"`
+	Schedule     string          `json:"schedule,omitempty"`
+	Channel      string          `json:"channel,omitempty"`
+	Priority     int             `json:"priority,omitempty"`
+	Wrapper      bool            `json:"wrapper,omitempty"`
+	Confirmation string          `json:"confirmation,omitempty"`
+	Permissions  *bool           `json:"permissions,omitempty"`
+	Response     RemoteResponse  `json:"response,omitempty"`
+}
+
+type RemoteCatalog struct {
+	Commands []*RemoteCommandConfig `json:"commands"`
+}
+
+type RemoteUser struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	TimeZone string `json:"timezone,omitempty"`
+}
+
+type RemoteMessage struct {
+	ID       string      `json:"id"`
+	Visible  bool        `json:"visible"`
+	ParentID string      `json:"parent_id,omitempty"`
+	Channel  string      `json:"channel,omitempty"`
+	User     *RemoteUser `json:"user,omitempty"`
+	Caller   *RemoteUser `json:"caller,omitempty"`
+}
+
+type RemoteRequest struct {
+	Bot     string               `json:"bot"`
+	Group   string               `json:"group,omitempty"`
+	Command string               `json:"command"`
+	Params  common.ExecuteParams `json:"params"`
+	Message *RemoteMessage       `json:"message,omitempty"`
+	Action  *RemoteAction        `json:"action,omitempty"`
+	Eval    []string             `json:"eval,omitempty"`
+}
+
+type RemoteAttachment struct {
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+type RemoteReply struct {
+	Text        string             `json:"text"`
+	Error       bool               `json:"error,omitempty"`
+	Visible     *bool              `json:"visible,omitempty"`
+	Attachments []RemoteAttachment `json:"attachments,omitempty"`
+	Actions     []RemoteAction     `json:"actions,omitempty"`
+}
+
+type RemoteFieldsReply struct {
+	Fields []common.Field `json:"fields"`
+}
+
+type RemoteExecutor struct {
+	command *RemoteCommand
+	message common.Message
+	reply   *RemoteReply
+}
+
+type RemoteCommandAction struct {
+	action RemoteAction
+}
+
+type RemoteCommandApproval struct {
+	command *RemoteCommand
+}
+
+type RemoteCommand struct {
+	config    *RemoteCommandConfig
+	url       string
+	processor *Remote
+	logger    sreCommon.Logger
+}
+
+type Remote struct {
+	name          string
+	remotes       *Remotes
+	commands      []common.Command
+	mutex         sync.RWMutex
+	meter         sreCommon.Meter
+	observability *common.Observability
+}
+
+type Remotes struct {
+	options       RemoteOptions
+	groups        map[string]*Remote
+	mutex         sync.Mutex
+	processors    *common.Processors
+	logger        sreCommon.Logger
+	observability *common.Observability
+}
+
+// Remote executor
+// common.Response
+
+func (re *RemoteExecutor) Visible() bool {
+	if re.reply.Visible != nil {
+		return *re.reply.Visible
+	}
+	if !utils.IsEmpty(re.message) {
+		return re.message.Visible()
+	}
+	return false
+}
+
+func (re *RemoteExecutor) Error() bool {
+	return re.reply.Error
+}
+
+func (re *RemoteExecutor) Duration() bool {
+	return re.command.Response().Duration()
+}
+
+func (re *RemoteExecutor) Original() bool {
+	return re.command.Response().Original()
+}
+
+func (re *RemoteExecutor) Response() common.Response {
+	return re
+}
+
+func (re *RemoteExecutor) After(message common.Message) error {
+	return nil
+}
+
+// RemoteCommandAction
+
+func (rca *RemoteCommandAction) Name() string {
+	return rca.action.Name
+}
+
+func (rca *RemoteCommandAction) Label() string {
+	return rca.action.Label
+}
+
+func (rca *RemoteCommandAction) Template() string {
+	return rca.action.Template
+}
+
+func (rca *RemoteCommandAction) Style() string {
+	return rca.action.Style
+}
+
+// RemoteCommandApproval
+
+func (rca *RemoteCommandApproval) render(def string, bot common.Bot, message common.Message, params common.ExecuteParams) string {
+
+	if utils.IsEmpty(def) {
+		return ""
+	}
+
+	m := make(map[string]interface{})
+	m["bot"] = bot
+	m["message"] = message
+	m["channel"] = message.Channel()
+	m["user"] = message.User()
+	m["caller"] = message.Caller()
+	m["params"] = params
+
+	return common.Render(def, m, rca.command.processor.observability)
+}
+
+func (rca *RemoteCommandApproval) Channel(bot common.Bot, message common.Message, params common.ExecuteParams) string {
+	return rca.render(rca.command.config.Approval.Channel, bot, message, params)
+}
+
+func (rca *RemoteCommandApproval) Message(bot common.Bot, message common.Message, params common.ExecuteParams) string {
+	return rca.render(rca.command.config.Approval.Template, bot, message, params)
+}
+
+func (rca *RemoteCommandApproval) Reasons() []string {
+	return rca.command.config.Approval.Reasons
+}
+
+func (rca *RemoteCommandApproval) Description() bool {
+	return rca.command.config.Approval.Description
+}
+
+func (rca *RemoteCommandApproval) Visible() bool {
+	return rca.command.config.Approval.Visible
+}
+
+// Remote command
+// common.Response
+
+func (rc *RemoteCommand) Visible() bool {
+	v := rc.config.Response.Visible
+	if v != nil {
+		return *v
+	}
+	return false
+}
+
+func (rc *RemoteCommand) Duration() bool {
+	d := rc.config.Response.Duration
+	if d != nil {
+		return *d
+	}
+	return false
+}
+
+func (rc *RemoteCommand) Original() bool {
+	o := rc.config.Response.Original
+	if o != nil {
+		return *o
+	}
+	return false
+}
+
+func (rc *RemoteCommand) Error() bool {
+	return false
+}
+
+func (rc *RemoteCommand) Name() string {
+	return rc.config.Name
+}
+
+func (rc *RemoteCommand) Group() string {
+	return rc.processor.name
+}
+
+func (rc *RemoteCommand) getNameWithGroup(delim string) string {
+
+	name := rc.config.Name
+	if !utils.IsEmpty(rc.processor.name) {
+		name = fmt.Sprintf("%s%s%s", rc.processor.name, delim, name)
+	}
+	return name
+}
+
+func (rc *RemoteCommand) Description() string {
+	return rc.config.Description
+}
+
+func (rc *RemoteCommand) Params() []string {
+	if utils.IsEmpty(rc.config.Params) {
+		return defaultParams()
+	}
+	return rc.config.Params
+}
+
+func (rc *RemoteCommand) Aliases() []string {
+	return rc.config.Aliases
+}
+
+func (rc *RemoteCommand) Confirmation(params common.ExecuteParams) string {
+	if utils.IsEmpty(rc.config.Confirmation) {
+		return ""
+	}
+	return common.Render(rc.config.Confirmation, params, rc.processor.observability)
+}
+
+func (rc *RemoteCommand) Priority() int {
+	return rc.config.Priority
+}
+
+func (rc *RemoteCommand) Wrapper() bool {
+	return rc.config.Wrapper
+}
+
+func (rc *RemoteCommand) Schedule() string {
+	return rc.config.Schedule
+}
+
+func (rc *RemoteCommand) Channel() string {
+	return rc.config.Channel
+}
+
+func (rc *RemoteCommand) Response() common.Response {
+	return rc
+}
+
+func (rc *RemoteCommand) actions(list []RemoteAction) []common.Action {
+
+	r := []common.Action{}
+	for _, a := range list {
+		if utils.IsEmpty(a.Name) {
+			continue
+		}
+		r = append(r, &RemoteCommandAction{action: a})
+	}
+	return r
+}
+
+func (rc *RemoteCommand) Actions() []common.Action {
+	return rc.actions(rc.config.Actions)
+}
+
+func (rc *RemoteCommand) Approval() common.Approval {
+	if rc.config.Approval == nil {
+		return nil
+	}
+	return &RemoteCommandApproval{command: rc}
+}
+
+func (rc *RemoteCommand) Permissions() bool {
+	if rc.config.Permissions != nil {
+		return *rc.config.Permissions
+	}
+	return true
+}
+
+func (rc *RemoteCommand) user(u common.User) *RemoteUser {
+	if utils.IsEmpty(u) {
+		return nil
+	}
+	return &RemoteUser{ID: u.ID(), Name: u.Name(), TimeZone: u.TimeZone()}
+}
+
+func (rc *RemoteCommand) request(bot common.Bot, message common.Message, params common.ExecuteParams) *RemoteRequest {
+
+	r := &RemoteRequest{
+		Group:   rc.processor.name,
+		Command: rc.config.Name,
+		Params:  params,
+	}
+	if !utils.IsEmpty(bot) {
+		r.Bot = bot.Name()
+	}
+	if !utils.IsEmpty(message) {
+		r.Message = &RemoteMessage{
+			ID:       message.ID(),
+			Visible:  message.Visible(),
+			ParentID: message.ParentID(),
+			User:     rc.user(message.User()),
+			Caller:   rc.user(message.Caller()),
+		}
+		if !utils.IsEmpty(message.Channel()) {
+			r.Message.Channel = message.Channel().ID()
+		}
+	}
+	return r
+}
+
+func (rc *RemoteCommand) Fields(bot common.Bot, message common.Message, params common.ExecuteParams, eval []string) []common.Field {
+
+	if utils.IsEmpty(message) || len(eval) == 0 {
+		return rc.config.Fields
+	}
+
+	req := rc.request(bot, message, params)
+	req.Eval = eval
+
+	var reply RemoteFieldsReply
+	err := rc.processor.remotes.post(rc.url, "fields", req, &reply)
+	if err != nil {
+		rc.logger.Error("Remote command %s fields error: %s", rc.getNameWithGroup("/"), err)
+		return rc.config.Fields
+	}
+	return reply.Fields
+}
+
+func (rc *RemoteCommand) execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (*RemoteReply, error) {
+
+	t1 := time.Now()
+
+	processor := rc.processor
+
+	labels := make(map[string]string)
+	if !utils.IsEmpty(processor.name) {
+		labels["group"] = processor.name
+	}
+	labels["command"] = rc.config.Name
+	labels["bot"] = bot.Name()
+
+	user := message.User()
+	if !utils.IsEmpty(user) {
+		labels["user_id"] = user.ID()
+	}
+
+	prefixes := []string{"remote", "processor"}
+
+	requests := processor.meter.Counter("processor", "requests", "Count of all executions", labels, prefixes...)
+	requests.Inc()
+
+	errors := processor.meter.Counter("processor", "errors", "Count of all errors during executions", labels, prefixes...)
+	timeCounter := processor.meter.Counter("processor", "time", "Sum of all time executions", labels, prefixes...)
+
+	name := rc.getNameWithGroup("/")
+	rc.logger.Debug("Remote is executing command %s with params %v on %s...", name, params, rc.url)
+
+	req := rc.request(bot, message, params)
+	if action != nil {
+		req.Action = &RemoteAction{
+			Name:     action.Name(),
+			Label:    action.Label(),
+			Template: action.Template(),
+			Style:    action.Style(),
+		}
+	}
+
+	var reply RemoteReply
+	err := processor.remotes.post(rc.url, "execute", req, &reply)
+	if err != nil {
+		errors.Inc()
+		return nil, fmt.Errorf("Remote command %s error: %s", name, err)
+	}
+	if reply.Error {
+		errors.Inc()
+	}
+
+	elapsed := time.Since(t1).Milliseconds()
+	timeCounter.Add(int(elapsed))
+
+	rc.logger.Debug("Remote is executed command %s with params %v in %s", name, params, time.Since(t1))
+
+	return &reply, nil
+}
+
+func (rc *RemoteCommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	reply, err := rc.execute(bot, message, params, action)
+	if err != nil {
+		rc.logger.Error(err)
+		err = fmt.Errorf("%s", rc.processor.remotes.options.Error)
+		return nil, "", nil, nil, err
+	}
+
+	atts := []*common.Attachment{}
+	for _, a := range reply.Attachments {
+		atts = append(atts, &common.Attachment{
+			Title: a.Title,
+			Text:  a.Text,
+			Data:  a.Data,
+			Type:  common.AttachmentType(a.Type),
+		})
+	}
+
+	executor := &RemoteExecutor{
+		command: rc,
+		message: message,
+		reply:   reply,
+	}
+	return executor, reply.Text, atts, rc.actions(reply.Actions), nil
+}
+
+// Remote
+
+func (r *Remote) Name() string {
+	return r.name
+}
+
+func (r *Remote) Commands() []common.Command {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.commands
+}
+
+func (r *Remote) setCommands(commands []common.Command) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.commands = commands
+}
+
+// Remotes
+
+func (rs *Remotes) headers() map[string]string {
+
+	headers := make(map[string]string)
+	headers["Content-Type"] = "application/json"
+	if !utils.IsEmpty(rs.options.Token) {
+		headers["Authorization"] = fmt.Sprintf("Bearer %s", rs.options.Token)
+	}
+	return headers
+}
+
+func (rs *Remotes) endpoint(url, path string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(url, "/"), path)
+}
+
+func (rs *Remotes) post(url, path string, req interface{}, reply interface{}) error {
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	client := utils.NewHttpClient(rs.options.Timeout, rs.options.Insecure)
+	b, _, err := utils.HttpRequestRawWithHeadersOutCode(client, "POST", rs.endpoint(url, path), rs.headers(), data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, reply)
+}
+
+func (rs *Remotes) catalog(url string) (*RemoteCatalog, error) {
+
+	client := utils.NewHttpClient(rs.options.Timeout, rs.options.Insecure)
+	b, _, err := utils.HttpRequestRawWithHeadersOutCode(client, "GET", rs.endpoint(url, "commands"), rs.headers(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var c RemoteCatalog
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (rs *Remotes) group(name string) *Remote {
+
+	r, ok := rs.groups[name]
+	if ok {
+		return r
+	}
+
+	r = &Remote{
+		name:          name,
+		remotes:       rs,
+		meter:         rs.observability.Metrics(),
+		observability: rs.observability,
+	}
+	rs.groups[name] = r
+	rs.processors.Add(r)
+	return r
+}
+
+// Refresh fetches catalogs of all services, failed services keep their previous commands
+func (rs *Remotes) Refresh() error {
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	urls := common.RemoveEmptyStrings(strings.Split(rs.options.URLs, ","))
+
+	commands := make(map[string][]common.Command)
+	failed := []string{}
+
+	for _, url := range urls {
+
+		url = strings.TrimSpace(url)
+		c, err := rs.catalog(url)
+		if err != nil {
+			rs.logger.Error("Remote couldn't fetch commands from %s, error: %s", url, err)
+			failed = append(failed, url)
+			continue
+		}
+
+		for _, config := range c.Commands {
+			if config == nil || utils.IsEmpty(config.Name) {
+				continue
+			}
+			r := rs.group(config.Group)
+			commands[config.Group] = append(commands[config.Group], &RemoteCommand{
+				config:    config,
+				url:       url,
+				processor: r,
+				logger:    rs.logger,
+			})
+		}
+		rs.logger.Debug("Remote fetched %d commands from %s", len(c.Commands), url)
+	}
+
+	for name, r := range rs.groups {
+
+		list := commands[name]
+		for _, c := range r.Commands() {
+			rc, ok := c.(*RemoteCommand)
+			if ok && utils.Contains(failed, rc.url) {
+				list = append(list, rc)
+			}
+		}
+		// services dropped all commands of the group, so bots shouldn't list it anymore
+		if len(list) == 0 {
+			delete(rs.groups, name)
+			rs.processors.Remove(r)
+			continue
+		}
+		r.setCommands(list)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Remote couldn't fetch commands from %s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// Items returns group processors, so they are kept when the rest is reloaded
+func (rs *Remotes) Items() []common.Processor {
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	names := []string{}
+	for name := range rs.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r := []common.Processor{}
+	for _, name := range names {
+		r = append(r, rs.groups[name])
+	}
+	return r
+}
+
+func (rs *Remotes) Start() {
+
+	if rs.options.Refresh <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(rs.options.Refresh) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			rs.Refresh()
+		}
+	}()
+}
+
+func NewRemotes(options RemoteOptions, observability *common.Observability, processors *common.Processors) *Remotes {
+
+	if utils.IsEmpty(options.URLs) {
+		return nil
+	}
+
+	return &Remotes{
+		options:       options,
+		groups:        make(map[string]*Remote),
+		processors:    processors,
+		logger:        observability.Logs(),
+		observability: observability,
+	}
+}
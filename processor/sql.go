@@ -0,0 +1,614 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"gopkg.in/yaml.v2"
+	_ "modernc.org/sqlite"
+)
+
+type SQLOptions struct {
+	CommandsDir string
+	ConfigExt   string
+	Connections string // config file or YAML
+	Timeout     int
+	MaxRows     int // larger results go as CSV attachment
+	Limit       int
+	Error       string
+}
+
+type SQLConnection struct {
+	Name   string
+	Driver string // postgres, mysql, sqlite
+	DSN    string
+}
+
+type SQLCommandConfig struct {
+	Description string
+	Params      []string
+	Aliases     []string
+	Response    DefaultReposne
+	Fields      []common.Field
+	Priority    int
+	Channel     string
+	Permissions *bool
+	Connection  string
+	Query       string
+	Args        []string // param names bound to query placeholders
+	Timeout     int
+}
+
+type SQLExecutor struct {
+	command *SQLCommand
+	message common.Message
+}
+
+type SQLCommand struct {
+	name      string
+	path      string
+	config    *SQLCommandConfig
+	processor *SQL
+	logger    sreCommon.Logger
+}
+
+type SQL struct {
+	name          string
+	options       SQLOptions
+	connections   map[string]*SQLConnection
+	processors    *common.Processors
+	commands      []common.Command
+	meter         sreCommon.Meter
+	observability *common.Observability
+}
+
+const (
+	SQLDriverPostgres = "postgres"
+	SQLDriverMySQL    = "mysql"
+	SQLDriverSQLite   = "sqlite"
+)
+
+var sqlDriverAliases = map[string]string{
+	"postgres":   SQLDriverPostgres,
+	"postgresql": SQLDriverPostgres,
+	"pgsql":      SQLDriverPostgres,
+	"mysql":      SQLDriverMySQL,
+	"mariadb":    SQLDriverMySQL,
+	"sqlite":     SQLDriverSQLite,
+	"sqlite3":    SQLDriverSQLite,
+}
+
+var sqlReadOnlyStatements = []string{"select", "with", "show", "explain", "describe", "desc", "values", "table"}
+
+// databases are shared by all groups which use the same connection
+var sqlDatabases = make(map[string]*sql.DB)
+var sqlDatabasesMutex sync.Mutex
+
+// SQL executor
+// common.Response
+
+func (se *SQLExecutor) Visible() bool {
+	if !utils.IsEmpty(se.message) {
+		return se.message.Visible()
+	}
+	return false
+}
+
+func (se *SQLExecutor) Error() bool {
+	return false
+}
+
+func (se *SQLExecutor) Duration() bool {
+	d := se.command.config.Response.Duration
+	if d != nil {
+		return *d
+	}
+	return false
+}
+
+func (se *SQLExecutor) Original() bool {
+	o := se.command.config.Response.Original
+	if o != nil {
+		return *o
+	}
+	return false
+}
+
+func (se *SQLExecutor) Response() common.Response {
+	return se
+}
+
+func (se *SQLExecutor) After(message common.Message) error {
+	return nil
+}
+
+// SQL command
+
+func (sc *SQLCommand) Name() string {
+	return sc.name
+}
+
+func (sc *SQLCommand) Path() string {
+	return sc.path
+}
+
+func (sc *SQLCommand) Group() string {
+	return sc.processor.name
+}
+
+func (sc *SQLCommand) getNameWithGroup(delim string) string {
+
+	name := sc.name
+	if !utils.IsEmpty(sc.processor.name) {
+		name = fmt.Sprintf("%s%s%s", sc.processor.name, delim, sc.name)
+	}
+	return name
+}
+
+func (sc *SQLCommand) Description() string {
+	return sc.config.Description
+}
+
+func (sc *SQLCommand) Params() []string {
+
+	params := sc.config.Params
+	if utils.IsEmpty(params) {
+		return defaultParams()
+	}
+	return params
+}
+
+func (sc *SQLCommand) Aliases() []string {
+	return sc.config.Aliases
+}
+
+func (sc *SQLCommand) Confirmation(params common.ExecuteParams) string {
+	return ""
+}
+
+func (sc *SQLCommand) Priority() int {
+	return sc.config.Priority
+}
+
+func (sc *SQLCommand) Wrapper() bool {
+	return false
+}
+
+func (sc *SQLCommand) Schedule() string {
+	return ""
+}
+
+func (sc *SQLCommand) Channel() string {
+	return sc.config.Channel
+}
+
+func (sc *SQLCommand) Response() common.Response {
+	return &SQLExecutor{command: sc}
+}
+
+func (sc *SQLCommand) Actions() []common.Action {
+	return []common.Action{}
+}
+
+func (sc *SQLCommand) Approval() common.Approval {
+	return nil
+}
+
+func (sc *SQLCommand) Permissions() bool {
+	if sc.config.Permissions != nil {
+		return *sc.config.Permissions
+	}
+	return true
+}
+
+func (sc *SQLCommand) Fields(bot common.Bot, message common.Message, params common.ExecuteParams, eval []string) []common.Field {
+	return sc.config.Fields
+}
+
+// args are taken from params as is and passed to driver, query is never rendered
+func (sc *SQLCommand) args(params common.ExecuteParams) ([]interface{}, error) {
+
+	r := []interface{}{}
+	for _, name := range sc.config.Args {
+		v, ok := params[name]
+		if !ok {
+			return nil, fmt.Errorf("SQL command %s has no param %s", sc.name, name)
+		}
+		r = append(r, v)
+	}
+	return r, nil
+}
+
+func (sc *SQLCommand) value(v interface{}) string {
+
+	switch t := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(t)
+	case time.Time:
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func (sc *SQLCommand) query(params common.ExecuteParams) ([]string, [][]string, bool, error) {
+
+	p := sc.processor
+
+	conn := sc.config.Connection
+	if utils.IsEmpty(conn) && len(p.connections) == 1 {
+		for k := range p.connections {
+			conn = k
+		}
+	}
+	c, ok := p.connections[conn]
+	if !ok {
+		return nil, nil, false, fmt.Errorf("SQL command %s has unknown connection %s", sc.name, conn)
+	}
+
+	db, err := sqlOpen(c)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	args, err := sc.args(params)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	timeout := p.options.Timeout
+	if sc.config.Timeout > 0 {
+		timeout = sc.config.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	// read only transaction is the second line after statement check
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, sc.config.Query, args...)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	limited := false
+	data := [][]string{}
+	for rows.Next() {
+		if p.options.Limit > 0 && len(data) >= p.options.Limit {
+			limited = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		row := []string{}
+		for _, v := range values {
+			row = append(row, sc.value(v))
+		}
+		data = append(data, row)
+	}
+	return columns, data, limited, rows.Err()
+}
+
+func (sc *SQLCommand) table(columns []string, rows [][]string) string {
+
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	for _, r := range rows {
+		cells := []string{}
+		for _, v := range r {
+			cells = append(cells, strings.ReplaceAll(strings.ReplaceAll(v, "\t", " "), "\n", " "))
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	w.Flush()
+	return fmt.Sprintf("```\n%s```", b.String())
+}
+
+func (sc *SQLCommand) csv(columns []string, rows [][]string) ([]byte, error) {
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	err := w.Write(columns)
+	if err != nil {
+		return nil, err
+	}
+	err = w.WriteAll(rows)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (sc *SQLCommand) execute(bot common.Bot, message common.Message, params common.ExecuteParams) (string, []*common.Attachment, error) {
+
+	t1 := time.Now()
+
+	processor := sc.processor
+
+	labels := make(map[string]string)
+	if !utils.IsEmpty(processor.name) {
+		labels["group"] = processor.name
+	}
+	labels["command"] = sc.name
+	labels["bot"] = bot.Name()
+
+	user := message.User()
+	if !utils.IsEmpty(user) {
+		labels["user_id"] = user.ID()
+	}
+
+	prefixes := []string{"sql", "processor"}
+
+	requests := processor.meter.Counter("processor", "requests", "Count of all executions", labels, prefixes...)
+	requests.Inc()
+
+	errors := processor.meter.Counter("processor", "errors", "Count of all errors during executions", labels, prefixes...)
+	timeCounter := processor.meter.Counter("processor", "time", "Sum of all time executions", labels, prefixes...)
+
+	name := sc.getNameWithGroup("/")
+	sc.logger.Debug("SQL is executing command %s with params %v...", name, params)
+
+	columns, rows, limited, err := sc.query(params)
+	if err != nil {
+		errors.Inc()
+		return "", nil, err
+	}
+
+	text := ""
+	var atts []*common.Attachment
+
+	switch {
+	case len(rows) == 0:
+		text = "No rows"
+	case len(rows) <= processor.options.MaxRows:
+		text = sc.table(columns, rows)
+	default:
+		data, err := sc.csv(columns, rows)
+		if err != nil {
+			errors.Inc()
+			return "", nil, err
+		}
+		text = fmt.Sprintf("%d rows", len(rows))
+		atts = append(atts, &common.Attachment{
+			Title: fmt.Sprintf("%s.csv", sc.name),
+			Data:  data,
+			Type:  common.AttachmentTypeFile,
+		})
+	}
+	if limited {
+		text = fmt.Sprintf("%s\nResult is limited to %d rows", text, processor.options.Limit)
+	}
+
+	elapsed := time.Since(t1).Milliseconds()
+	timeCounter.Add(int(elapsed))
+
+	sc.logger.Debug("SQL is executed command %s with params %v in %s", name, params, time.Since(t1))
+
+	return text, atts, nil
+}
+
+func (sc *SQLCommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	executor := &SQLExecutor{
+		command: sc,
+		message: message,
+	}
+
+	text, atts, err := sc.execute(bot, message, params)
+	if err != nil {
+		sc.logger.Error(err)
+		err = fmt.Errorf("%s", sc.processor.options.Error)
+		return nil, "", nil, nil, err
+	}
+	return executor, text, atts, nil, nil
+}
+
+// SQL
+
+func (s *SQL) Name() string {
+	return s.name
+}
+
+func (s *SQL) Commands() []common.Command {
+	return s.commands
+}
+
+func (s *SQL) loadConfig(path string) (*SQLCommandConfig, error) {
+
+	bytes, err := utils.Content(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v SQLCommandConfig
+	err = yaml.Unmarshal(bytes, &v)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *SQL) AddCommand(name, path string) error {
+
+	logger := s.observability.Logs()
+
+	config, err := s.loadConfig(path)
+	if err != nil {
+		logger.Error("SQL couldn't read config %s, error: %s", path, err)
+		return err
+	}
+
+	err = sqlCheckReadOnly(config.Query)
+	if err != nil {
+		err = fmt.Errorf("SQL config %s %s", path, err)
+		logger.Error(err)
+		return err
+	}
+
+	sc := &SQLCommand{
+		name:      name,
+		path:      path,
+		config:    config,
+		processor: s,
+		logger:    logger,
+	}
+	s.commands = append(s.commands, sc)
+	return nil
+}
+
+// sqlStatements splits query by semicolons outside of quotes and comments
+func sqlStatements(query string) []string {
+
+	r := []string{}
+	var b strings.Builder
+	var quote rune
+
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			b.WriteRune(' ')
+			continue
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i+1 < len(runes) && (runes[i] != '*' || runes[i+1] != '/') {
+				i++
+			}
+			i++
+			b.WriteRune(' ')
+			continue
+		case c == ';':
+			r = append(r, b.String())
+			b.Reset()
+			continue
+		}
+		b.WriteRune(c)
+	}
+	r = append(r, b.String())
+	return common.RemoveEmptyStrings(r)
+}
+
+func sqlCheckReadOnly(query string) error {
+
+	statements := sqlStatements(query)
+	if len(statements) == 0 {
+		return fmt.Errorf("has no query")
+	}
+	if len(statements) > 1 {
+		return fmt.Errorf("has more than one statement")
+	}
+
+	first := strings.FieldsFunc(statements[0], func(r rune) bool {
+		return unicode.IsSpace(r) || r == '('
+	})
+	if len(first) == 0 || !utils.Contains(sqlReadOnlyStatements, strings.ToLower(first[0])) {
+		return fmt.Errorf("has not read only statement")
+	}
+	return nil
+}
+
+// sqlite has no read only transactions, so connection is limited to queries
+func sqlDSN(driver, dsn string) string {
+
+	if driver != SQLDriverSQLite {
+		return dsn
+	}
+	delim := "?"
+	if strings.Contains(dsn, "?") {
+		delim = "&"
+	}
+	return fmt.Sprintf("%s%s_pragma=query_only(1)", dsn, delim)
+}
+
+func sqlOpen(c *SQLConnection) (*sql.DB, error) {
+
+	driver, ok := sqlDriverAliases[strings.ToLower(c.Driver)]
+	if !ok {
+		return nil, fmt.Errorf("SQL connection %s has unknown driver %s", c.Name, c.Driver)
+	}
+	dsn := sqlDSN(driver, c.DSN)
+
+	sqlDatabasesMutex.Lock()
+	defer sqlDatabasesMutex.Unlock()
+
+	key := fmt.Sprintf("%s:%s", driver, dsn)
+	db, ok := sqlDatabases[key]
+	if ok {
+		return db, nil
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	sqlDatabases[key] = db
+	return db, nil
+}
+
+func NewSQL(name string, options SQLOptions, observability *common.Observability, processors *common.Processors) *SQL {
+
+	logger := observability.Logs()
+
+	list := []SQLConnection{}
+	_, err := common.LoadYaml(options.Connections, &list)
+	if err != nil {
+		logger.Error("SQL couldn't load connections, error: %s", err)
+	}
+
+	connections := make(map[string]*SQLConnection)
+	for i := range list {
+		c := &list[i]
+		connections[c.Name] = c
+	}
+
+	return &SQL{
+		name:          name,
+		options:       options,
+		connections:   connections,
+		processors:    processors,
+		meter:         observability.Metrics(),
+		observability: observability,
+	}
+}
@@ -0,0 +1,169 @@
+package processor
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/devopsext/chatops/common"
+)
+
+// testSQL creates sqlite database with users table and processor connected to it
+func testSQL(t *testing.T, options SQLOptions) *SQL {
+
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := sql.Open(SQLDriverSQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`create table users (id integer primary key, name text, team text)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"alice", "bob", "carol", "dave"} {
+		team := "ops"
+		if i%2 == 1 {
+			team = "dev"
+		}
+		_, err = db.Exec(`insert into users (name, team) values (?, ?)`, name, team)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	options.Connections = fmt.Sprintf("- name: main\n  driver: sqlite\n  dsn: %s\n", path)
+	options.Timeout = 5
+	options.Error = "SQL failed"
+	return NewSQL("db", options, testObservability(), common.NewProcessors())
+}
+
+func testSQLCommand(t *testing.T, s *SQL, name, config string) common.Command {
+
+	t.Helper()
+	err := s.AddCommand(name, testFile(t, name+".yml", config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Commands()[len(s.Commands())-1]
+}
+
+func TestSQLTable(t *testing.T) {
+
+	s := testSQL(t, SQLOptions{MaxRows: 10})
+	c := testSQLCommand(t, s, "team", `
+query: select name from users where team = ? order by name
+args: [team]
+`)
+
+	_, text, atts, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"team": "ops"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atts) != 0 {
+		t.Fatalf("expected no attachments, got %d", len(atts))
+	}
+	if !strings.Contains(text, "alice") || !strings.Contains(text, "carol") || strings.Contains(text, "bob") {
+		t.Fatalf("unexpected table: %s", text)
+	}
+}
+
+func TestSQLNoRows(t *testing.T) {
+
+	s := testSQL(t, SQLOptions{MaxRows: 10})
+	c := testSQLCommand(t, s, "team", `
+query: select name from users where team = ?
+args: [team]
+`)
+
+	_, text, _, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{"team": "qa"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "No rows" {
+		t.Fatalf("expected no rows, got %s", text)
+	}
+}
+
+func TestSQLAttachmentAndLimit(t *testing.T) {
+
+	s := testSQL(t, SQLOptions{MaxRows: 2, Limit: 3})
+	c := testSQLCommand(t, s, "all", `query: select id, name from users order by id`)
+
+	_, text, atts, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atts) != 1 || atts[0].Type != common.AttachmentTypeFile {
+		t.Fatalf("expected CSV attachment, got %v", atts)
+	}
+	csv := string(atts[0].Data)
+	if !strings.HasPrefix(csv, "id,name\n1,alice\n") || strings.Contains(csv, "dave") {
+		t.Fatalf("unexpected CSV: %s", csv)
+	}
+	if !strings.Contains(text, "3 rows") || !strings.Contains(text, "limited to 3 rows") {
+		t.Fatalf("unexpected text: %s", text)
+	}
+}
+
+func TestSQLMissingParam(t *testing.T) {
+
+	s := testSQL(t, SQLOptions{MaxRows: 10})
+	c := testSQLCommand(t, s, "team", `
+query: select name from users where team = ?
+args: [team]
+`)
+
+	_, _, _, _, err := c.Execute(&testBot{}, testMessageFrom("u1"), common.ExecuteParams{}, nil)
+	if err == nil || err.Error() != "SQL failed" {
+		t.Fatalf("expected processor error, got %v", err)
+	}
+}
+
+func TestSQLReadOnly(t *testing.T) {
+
+	s := testSQL(t, SQLOptions{MaxRows: 10})
+
+	for _, query := range []string{
+		"delete from users",
+		"select 1; delete from users",
+		"/* select */ update users set name = 'x'",
+		"-- select\ninsert into users (name) values ('x')",
+	} {
+		err := s.AddCommand("bad", testFile(t, "bad.yml", fmt.Sprintf("query: %q\n", query)))
+		if err == nil {
+			t.Fatalf("expected query %q to be rejected", query)
+		}
+	}
+
+	for _, query := range []string{
+		"select 1",
+		"with t as (select 1) select * from t",
+		"select ';' as x -- ; delete",
+	} {
+		err := sqlCheckReadOnly(query)
+		if err != nil {
+			t.Fatalf("expected query %q to be accepted, got %s", query, err)
+		}
+	}
+}
+
+// sqlite connections are opened with query_only, so writes fail even if statement check is passed
+func TestSQLQueryOnlyConnection(t *testing.T) {
+
+	s := testSQL(t, SQLOptions{MaxRows: 10})
+
+	db, err := sqlOpen(s.connections["main"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`delete from users`)
+	if err == nil {
+		t.Fatal("expected write to fail on query only connection")
+	}
+}
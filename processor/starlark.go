@@ -0,0 +1,533 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+	starlarkJSON "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+
+	"gopkg.in/yaml.v2"
+)
+
+type StarlarkOptions struct {
+	CommandsDir  string
+	TemplatesDir string
+	RunbooksDir  string
+	CommandExt   string
+	ConfigExt    string
+	Steps        int
+	Timeout      int
+	Error        string
+}
+
+type StarlarkCommandConfig struct {
+	DefaultCommandConfig `yaml:",inline"`
+	Steps                int
+	Timeout              int
+}
+
+// wraps Go values which have no Starlark representation, like attachments or actions
+type StarlarkValue struct {
+	value interface{}
+}
+
+type StarlarkCommand struct {
+	*DefaultCommand
+	starlark *Starlark
+	program  *starlark.Program
+	steps    int
+	timeout  int
+}
+
+type Starlark struct {
+	name          string
+	options       StarlarkOptions
+	defaults      *Default
+	processors    *common.Processors
+	commands      []common.Command
+	meter         sreCommon.Meter
+	observability *common.Observability
+}
+
+var starlarkFileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+var starlarkContext = []string{"json", "params", "bot", "name", "user", "caller", "channel", "message", "action"}
+
+// StarlarkValue
+
+func (sv *StarlarkValue) String() string {
+	return fmt.Sprintf("%v", sv.value)
+}
+
+func (sv *StarlarkValue) Type() string {
+	return fmt.Sprintf("%T", sv.value)
+}
+
+func (sv *StarlarkValue) Freeze() {
+}
+
+func (sv *StarlarkValue) Truth() starlark.Bool {
+	return sv.value != nil
+}
+
+func (sv *StarlarkValue) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: %s", sv.Type())
+}
+
+func starlarkToValue(v interface{}) starlark.Value {
+
+	switch t := v.(type) {
+	case nil:
+		return starlark.None
+	case starlark.Value:
+		return t
+	case string:
+		return starlark.String(t)
+	case bool:
+		return starlark.Bool(t)
+	case int:
+		return starlark.MakeInt(t)
+	case int64:
+		return starlark.MakeInt64(t)
+	case time.Duration:
+		return starlark.String(t.String())
+	case float64:
+		return starlark.Float(t)
+	case []byte:
+		return starlark.Bytes(t)
+	case []string:
+		list := []starlark.Value{}
+		for _, s := range t {
+			list = append(list, starlark.String(s))
+		}
+		return starlark.NewList(list)
+	case []interface{}:
+		list := []starlark.Value{}
+		for _, i := range t {
+			list = append(list, starlarkToValue(i))
+		}
+		return starlark.NewList(list)
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d := starlark.NewDict(len(keys))
+		for _, k := range keys {
+			d.SetKey(starlark.String(k), starlarkToValue(t[k]))
+		}
+		return d
+	}
+	return &StarlarkValue{value: v}
+}
+
+func starlarkFromValue(v starlark.Value) interface{} {
+
+	switch t := v.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.String:
+		return string(t)
+	case starlark.Bool:
+		return bool(t)
+	case starlark.Int:
+		i, ok := t.Int64()
+		if ok {
+			return int(i)
+		}
+		return t.String()
+	case starlark.Float:
+		return float64(t)
+	case starlark.Bytes:
+		return []byte(t)
+	case *starlark.List:
+		r := []interface{}{}
+		for i := 0; i < t.Len(); i++ {
+			r = append(r, starlarkFromValue(t.Index(i)))
+		}
+		return r
+	case starlark.Tuple:
+		r := []interface{}{}
+		for _, i := range t {
+			r = append(r, starlarkFromValue(i))
+		}
+		return r
+	case *starlark.Dict:
+		r := make(map[string]interface{})
+		for _, item := range t.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				r[item[0].String()] = starlarkFromValue(item[1])
+				continue
+			}
+			r[string(k)] = starlarkFromValue(item[1])
+		}
+		return r
+	case *StarlarkValue:
+		return t.value
+	}
+	return v
+}
+
+func starlarkArg(v starlark.Value, t reflect.Type) (reflect.Value, error) {
+
+	g := starlarkFromValue(v)
+	if g == nil {
+		return reflect.Zero(t), nil
+	}
+
+	rv := reflect.ValueOf(g)
+	if rv.Type().AssignableTo(t) {
+		return rv, nil
+	}
+	// avoid int => string conversion
+	if rv.Type().ConvertibleTo(t) && (t.Kind() != reflect.String || rv.Kind() == reflect.String) {
+		return rv.Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("expected %s, got %s", t, v.Type())
+}
+
+// Starlark command
+
+func (sc *StarlarkCommand) Group() string {
+	return sc.starlark.name
+}
+
+func (sc *StarlarkCommand) builtin(name string, fn any) *starlark.Builtin {
+
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+		if len(kwargs) > 0 {
+			return nil, fmt.Errorf("%s: unexpected keyword arguments", name)
+		}
+		if len(args) != t.NumIn() {
+			return nil, fmt.Errorf("%s: got %d arguments, want %d", name, len(args), t.NumIn())
+		}
+
+		in := []reflect.Value{}
+		for i, a := range args {
+			arg, err := starlarkArg(a, t.In(i))
+			if err != nil {
+				return nil, fmt.Errorf("%s: argument %d %s", name, i+1, err)
+			}
+			in = append(in, arg)
+		}
+
+		out := v.Call(in)
+		if len(out) == 0 {
+			return starlark.None, nil
+		}
+		if len(out) > 1 && !out[1].IsNil() {
+			return nil, out[1].Interface().(error)
+		}
+		return starlarkToValue(out[0].Interface()), nil
+	})
+}
+
+func (sc *StarlarkCommand) user(u common.User) starlark.Value {
+	if utils.IsEmpty(u) {
+		return starlark.None
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"id":       starlark.String(u.ID()),
+		"name":     starlark.String(u.Name()),
+		"timezone": starlark.String(u.TimeZone()),
+	})
+}
+
+func (sc *StarlarkCommand) predeclared(executor *DefaultExecutor) starlark.StringDict {
+
+	d := starlark.StringDict{}
+	for k, fn := range executorFuncs(executor) {
+		d[k] = sc.builtin(k, fn)
+	}
+
+	message := executor.message
+	channel := starlark.Value(starlark.None)
+	if !utils.IsEmpty(message.Channel()) {
+		channel = starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"id": starlark.String(message.Channel().ID()),
+		})
+	}
+
+	action := starlark.Value(starlark.None)
+	if executor.action != nil {
+		action = starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"name":  starlark.String(executor.action.Name()),
+			"label": starlark.String(executor.action.Label()),
+			"style": starlark.String(executor.action.Style()),
+		})
+	}
+
+	params := make(map[string]interface{})
+	for k, v := range executor.params {
+		params[k] = v
+	}
+
+	d["json"] = starlarkJSON.Module
+	d["params"] = starlarkToValue(params)
+	d["bot"] = starlark.String(executor.bot.Name())
+	d["name"] = starlark.String(sc.getNameWithGroup("/"))
+	d["user"] = sc.user(message.User())
+	d["caller"] = sc.user(message.Caller())
+	d["channel"] = channel
+	d["message"] = starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"id":        starlark.String(message.ID()),
+		"parent_id": starlark.String(message.ParentID()),
+		"visible":   starlark.Bool(message.Visible()),
+	})
+	d["action"] = action
+	return d
+}
+
+func (sc *StarlarkCommand) run(executor *DefaultExecutor) (string, []*common.Attachment, []common.Action, error) {
+
+	gid := utils.GoRoutineID()
+
+	var out strings.Builder
+	thread := &starlark.Thread{
+		Name: sc.getNameWithGroup("/"),
+		Print: func(thread *starlark.Thread, msg string) {
+			out.WriteString(msg)
+			out.WriteString("\n")
+		},
+	}
+
+	steps := sc.steps
+	if steps <= 0 {
+		steps = sc.starlark.options.Steps
+	}
+	if steps > 0 {
+		thread.SetMaxExecutionSteps(uint64(steps))
+	}
+
+	timeout := sc.timeout
+	if timeout <= 0 {
+		timeout = sc.starlark.options.Timeout
+	}
+	if timeout > 0 {
+		timer := time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+			thread.Cancel(fmt.Sprintf("timed out after %ds", timeout))
+		})
+		defer timer.Stop()
+	}
+
+	_, err := sc.program.Init(thread, sc.predeclared(executor))
+
+	var atts []*common.Attachment
+	var acts []common.Action
+
+	at, ok := executor.attachments.LoadAndDelete(gid)
+	if ok {
+		atts = at.([]*common.Attachment)
+	}
+
+	ac, ok := executor.actions.LoadAndDelete(gid)
+	if ok {
+		for _, ca := range ac.([]*DefaultCommandAction) {
+			acts = append(acts, ca)
+		}
+	}
+
+	if err != nil {
+		executor.posts.Delete(gid) // cleanup posts
+		evalErr, ok := err.(*starlark.EvalError)
+		if ok {
+			return "", nil, nil, fmt.Errorf("%s", evalErr.Backtrace())
+		}
+		return "", nil, nil, err
+	}
+	return strings.TrimSpace(out.String()), atts, acts, nil
+}
+
+func (sc *StarlarkCommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	t1 := time.Now()
+
+	s := sc.starlark
+
+	labels := make(map[string]string)
+	if !utils.IsEmpty(s.name) {
+		labels["group"] = s.name
+	}
+	labels["command"] = sc.name
+	labels["bot"] = bot.Name()
+
+	user := message.User()
+	if !utils.IsEmpty(user) {
+		labels["user_id"] = user.ID()
+	}
+
+	prefixes := []string{"starlark", "processor"}
+
+	requests := s.meter.Counter("processor", "requests", "Count of all executions", labels, prefixes...)
+	requests.Inc()
+
+	errors := s.meter.Counter("processor", "errors", "Count of all errors during executions", labels, prefixes...)
+	timeCounter := s.meter.Counter("processor", "time", "Sum of all time executions", labels, prefixes...)
+
+	name := sc.getNameWithGroup("/")
+	sc.logger.Debug("Starlark is executing command %s with params %v...", name, params)
+
+	executor := &DefaultExecutor{
+		command:     sc.DefaultCommand,
+		attachments: &sync.Map{},
+		actions:     &sync.Map{},
+		posts:       &sync.Map{},
+		bot:         bot,
+		message:     message,
+		params:      params,
+		action:      action,
+	}
+
+	// runFile and runTemplate render files with their own content
+	template, err := NewExecutorTemplate(sc.getNameWithGroup("-"), "", executor, s.observability)
+	if err != nil {
+		errors.Inc()
+		sc.logger.Error(err)
+		return nil, "", nil, nil, fmt.Errorf("%s", s.options.Error)
+	}
+	executor.template = template
+
+	text, atts, acts, err := sc.run(executor)
+	if err != nil {
+		errors.Inc()
+		sc.logger.Error("Starlark command %s error: %s", name, err)
+		return nil, "", nil, nil, fmt.Errorf("%s", s.options.Error)
+	}
+
+	elapsed := time.Since(t1).Milliseconds()
+	timeCounter.Add(int(elapsed))
+
+	sc.logger.Debug("Starlark is executed command %s with params %v in %s", name, params, time.Since(t1))
+
+	return executor, text, atts, acts, nil
+}
+
+// Starlark
+
+func (s *Starlark) Name() string {
+	return s.name
+}
+
+func (s *Starlark) Commands() []common.Command {
+	return s.commands
+}
+
+func (s *Starlark) loadConfig(path string) (*StarlarkCommandConfig, error) {
+
+	if !utils.FileExists(path) {
+		return nil, nil
+	}
+
+	bytes, err := utils.Content(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v StarlarkCommandConfig
+	err = yaml.Unmarshal(bytes, &v)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *Starlark) isPredeclared(name string) bool {
+
+	if utils.Contains(starlarkContext, name) {
+		return true
+	}
+	_, ok := executorFuncs(&DefaultExecutor{})[name]
+	return ok
+}
+
+func (s *Starlark) AddCommand(name, path string) error {
+
+	logger := s.observability.Logs()
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		logger.Error("Starlark couldn't read script %s, error: %s", path, err)
+		return err
+	}
+
+	_, program, err := starlark.SourceProgramOptions(starlarkFileOptions, path, src, s.isPredeclared)
+	if err != nil {
+		logger.Error("Starlark couldn't compile script %s, error: %s", path, err)
+		return err
+	}
+
+	sc := &StarlarkCommand{
+		starlark: s,
+		program:  program,
+	}
+
+	var config *DefaultCommandConfig
+	if !utils.IsEmpty(s.options.ConfigExt) {
+
+		pConfig := filepath.Join(filepath.Dir(path), fmt.Sprintf("%s%s", name, s.options.ConfigExt))
+		c, err := s.loadConfig(pConfig)
+		if err != nil {
+			logger.Error("Starlark couldn't read config %s, error: %s", pConfig, err)
+			return err
+		}
+		if c != nil {
+			config = &c.DefaultCommandConfig
+			sc.steps = c.Steps
+			sc.timeout = c.Timeout
+		}
+	}
+
+	sc.DefaultCommand = &DefaultCommand{
+		name:      name,
+		path:      path,
+		config:    config,
+		processor: s.defaults,
+		logger:    logger,
+	}
+	s.commands = append(s.commands, sc)
+	return nil
+}
+
+func NewStarlark(name string, options StarlarkOptions, observability *common.Observability, processors *common.Processors) *Starlark {
+
+	defaults := DefaultOptions{
+		CommandsDir:  options.CommandsDir,
+		TemplatesDir: options.TemplatesDir,
+		RunbooksDir:  options.RunbooksDir,
+		CommandExt:   options.CommandExt,
+		ConfigExt:    options.ConfigExt,
+		Error:        options.Error,
+	}
+
+	return &Starlark{
+		name:          name,
+		options:       options,
+		defaults:      NewDefault(name, defaults, observability, processors),
+		processors:    processors,
+		meter:         observability.Metrics(),
+		observability: observability,
+	}
+}
@@ -0,0 +1,281 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/devopsext/chatops/common"
+	sreCommon "github.com/devopsext/sre/common"
+	"github.com/devopsext/utils"
+)
+
+type SuggestOptions struct {
+	Enabled     bool
+	Command     string
+	Description string
+	Distance    int
+	Limit       int
+}
+
+type SuggestExecutor struct {
+	message common.Message
+}
+
+type SuggestCommand struct {
+	name      string
+	processor *Suggest
+}
+
+type SuggestCommandAction struct {
+	text string
+}
+
+type Suggest struct {
+	options       SuggestOptions
+	processors    *common.Processors
+	commands      []common.Command
+	logger        sreCommon.Logger
+	meter         sreCommon.Meter
+	observability *common.Observability
+}
+
+// Suggest executor
+// common.Response
+
+func (se *SuggestExecutor) Visible() bool {
+	if !utils.IsEmpty(se.message) {
+		return se.message.Visible()
+	}
+	return false
+}
+
+func (se *SuggestExecutor) Duration() bool {
+	return false
+}
+
+func (se *SuggestExecutor) Original() bool {
+	return false
+}
+
+func (se *SuggestExecutor) Error() bool {
+	return false
+}
+
+func (se *SuggestExecutor) Response() common.Response {
+	return se
+}
+
+func (se *SuggestExecutor) After(message common.Message) error {
+	return nil
+}
+
+// Suggest command action
+
+func (sca *SuggestCommandAction) Name() string {
+	return sca.text
+}
+
+func (sca *SuggestCommandAction) Label() string {
+	return sca.text
+}
+
+func (sca *SuggestCommandAction) Template() string {
+	return sca.text
+}
+
+func (sca *SuggestCommandAction) Style() string {
+	return ""
+}
+
+// Suggest command
+
+func (sc *SuggestCommand) Name() string {
+	return sc.name
+}
+
+func (sc *SuggestCommand) Group() string {
+	return ""
+}
+
+func (sc *SuggestCommand) Description() string {
+	return sc.processor.options.Description
+}
+
+func (sc *SuggestCommand) Params() []string {
+	return []string{"(?P<text>.+)"}
+}
+
+func (sc *SuggestCommand) Aliases() []string {
+	return []string{}
+}
+
+func (sc *SuggestCommand) Confirmation(params common.ExecuteParams) string {
+	return ""
+}
+
+func (sc *SuggestCommand) Priority() int {
+	return 0
+}
+
+func (sc *SuggestCommand) Wrapper() bool {
+	return false
+}
+
+func (sc *SuggestCommand) Schedule() string {
+	return ""
+}
+
+func (sc *SuggestCommand) Channel() string {
+	return ""
+}
+
+func (sc *SuggestCommand) Response() common.Response {
+	return &SuggestExecutor{}
+}
+
+func (sc *SuggestCommand) Actions() []common.Action {
+	return []common.Action{}
+}
+
+func (sc *SuggestCommand) Approval() common.Approval {
+	return nil
+}
+
+// suggest is allowed to everyone, it offers only permitted commands
+func (sc *SuggestCommand) Permissions() bool {
+	return false
+}
+
+func (sc *SuggestCommand) Fields(bot common.Bot, message common.Message, params common.ExecuteParams, eval []string) []common.Field {
+	return []common.Field{}
+}
+
+func (sc *SuggestCommand) SuggestParams(text string) common.ExecuteParams {
+
+	r := make(common.ExecuteParams)
+	r["text"] = text
+	return r
+}
+
+// run passes chosen suggestion to bot.Command, which checks permissions but neither asks fields nor approval,
+// so only commands passing runnable are offered as actions
+func (sc *SuggestCommand) run(bot common.Bot, message common.Message, action common.Action) error {
+
+	if utils.IsEmpty(message) {
+		return nil
+	}
+	channel := message.Channel()
+	if utils.IsEmpty(channel) {
+		return nil
+	}
+	user := message.Caller()
+	if utils.IsEmpty(user) {
+		user = message.User()
+	}
+	return bot.Command(channel.ID(), action.Template(), user, message, nil)
+}
+
+// runnable is true when suggestion can run as is, without args, fields or approval
+func (sc *SuggestCommand) runnable(bot common.Bot, message common.Message, text string) bool {
+
+	params, c, _, _, _, _, err := sc.processor.processors.FindParams(false, text)
+	if c == nil || err != nil || c.Approval() != nil {
+		return false
+	}
+	return !common.FormNeeded(c.Fields(bot, message, params, nil), params)
+}
+
+func (sc *SuggestCommand) Execute(bot common.Bot, message common.Message, params common.ExecuteParams, action common.Action) (common.Executor, string, []*common.Attachment, []common.Action, error) {
+
+	t1 := time.Now()
+
+	s := sc.processor
+
+	labels := make(map[string]string)
+	labels["command"] = sc.name
+	labels["bot"] = bot.Name()
+
+	var allowed []string
+	user := message.User()
+	if !utils.IsEmpty(user) {
+		labels["user_id"] = user.ID()
+		allowed = user.Commands()
+	}
+
+	prefixes := []string{"suggest", "processor"}
+
+	requests := s.meter.Counter("processor", "requests", "Count of all executions", labels, prefixes...)
+	requests.Inc()
+
+	errors := s.meter.Counter("processor", "errors", "Count of all errors during executions", labels, prefixes...)
+	timeCounter := s.meter.Counter("processor", "time", "Sum of all time executions", labels, prefixes...)
+
+	executor := &SuggestExecutor{message: message}
+
+	if action != nil {
+		s.logger.Debug("Suggest is running %s...", action.Template())
+		err := sc.run(bot, message, action)
+		if err != nil {
+			errors.Inc()
+			return executor, "", nil, nil, err
+		}
+		timeCounter.Add(int(time.Since(t1).Milliseconds()))
+		return executor, "", nil, nil, nil
+	}
+
+	text := ""
+	if v, ok := params["text"]; ok && v != nil {
+		text = strings.TrimSpace(fmt.Sprintf("%v", v))
+	}
+	s.logger.Debug("Suggest is executing command %s with text %s...", sc.name, text)
+
+	suggestions := s.processors.Suggest(text, allowed, s.options.Distance, s.options.Limit)
+
+	reply := fmt.Sprintf("Command %s is not found", text)
+	actions := []common.Action{}
+	if len(suggestions) > 0 {
+		lines := []string{fmt.Sprintf("%s, did you mean:", reply)}
+		for _, v := range suggestions {
+			lines = append(lines, fmt.Sprintf("  %s", v))
+			if sc.runnable(bot, message, v) {
+				actions = append(actions, &SuggestCommandAction{text: v})
+			}
+		}
+		reply = strings.Join(lines, "\n")
+	}
+
+	elapsed := time.Since(t1).Milliseconds()
+	timeCounter.Add(int(elapsed))
+
+	s.logger.Debug("Suggest is executed command %s with text %s in %s", sc.name, text, time.Since(t1))
+
+	return executor, reply, nil, actions, nil
+}
+
+// Suggest
+
+func (s *Suggest) Name() string {
+	return ""
+}
+
+func (s *Suggest) Commands() []common.Command {
+	return s.commands
+}
+
+func NewSuggest(options SuggestOptions, observability *common.Observability, processors *common.Processors) *Suggest {
+
+	if !options.Enabled || utils.IsEmpty(options.Command) {
+		return nil
+	}
+
+	s := &Suggest{
+		options:       options,
+		processors:    processors,
+		logger:        observability.Logs(),
+		meter:         observability.Metrics(),
+		observability: observability,
+	}
+	s.commands = append(s.commands, &SuggestCommand{name: options.Command, processor: s})
+	return s
+}